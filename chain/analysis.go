@@ -0,0 +1,131 @@
+package chain
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+)
+
+// DistinctTransition is a transition scored by how over-represented it is in
+// a corpus relative to a background chain
+type DistinctTransition struct {
+	Transition
+	BackgroundCount int
+	// Score is the smoothed log ratio of the transition's relative frequency
+	// in the corpus to its relative frequency in the background
+	Score float64
+}
+
+// DistinctivenessOptions configures Distinctiveness
+type DistinctivenessOptions struct {
+	// MinCount excludes transitions that occurred fewer times in the corpus
+	MinCount int
+
+	// Smoothing is the additive smoothing applied to counts, defaults to 1
+	Smoothing float64
+
+	// Limit caps the number of transitions returned, zero means no limit
+	Limit int
+
+	// IncludeBoundaries includes transitions to and from the empty sequence
+	// boundary token
+	IncludeBoundaries bool
+}
+
+// chainTotals counts the total and distinct transitions in a chain
+func chainTotals(chain IterableChain) (total int, distinct int, err error) {
+	err = forEachTransition(chain, func(t Transition) error {
+		total += t.Count
+		distinct++
+		return nil
+	})
+	return total, distinct, err
+}
+
+// Distinctiveness scores every transition in corpus by how much more
+// frequent it is than in background, most distinctive first, which surfaces
+// the characteristic phrases of a corpus
+func Distinctiveness(corpus MarkovChain, background MarkovChain, opts DistinctivenessOptions) ([]DistinctTransition, error) {
+	corpusIter, ok := corpus.(IterableChain)
+	if !ok {
+		return nil, ErrUncountableChain
+	}
+	backgroundIter, ok := background.(IterableChain)
+	if !ok {
+		return nil, ErrUncountableChain
+	}
+
+	smoothing := opts.Smoothing
+	if smoothing <= 0 {
+		smoothing = 1
+	}
+
+	corpusTotal, corpusDistinct, totalErr := chainTotals(corpusIter)
+	if totalErr != nil {
+		return nil, totalErr
+	}
+	backgroundTotal, backgroundDistinct, totalErr := chainTotals(backgroundIter)
+	if totalErr != nil {
+		return nil, totalErr
+	}
+
+	// the union of both chains' transitions is approximated by their sum
+	space := smoothing * float64(corpusDistinct+backgroundDistinct)
+	corpusDenominator := float64(corpusTotal) + space
+	backgroundDenominator := float64(backgroundTotal) + space
+
+	scored := make([]DistinctTransition, 0)
+	scoreErr := forEachTransition(corpusIter, func(t Transition) error {
+		if t.Count < opts.MinCount {
+			return nil
+		}
+		if !opts.IncludeBoundaries && (t.Prev == "" || t.Next == "") {
+			return nil
+		}
+
+		backgroundCount, countErr := occurrencesIn(background, t.Prev, t.Next)
+		if countErr != nil {
+			return countErr
+		}
+
+		corpusRate := (float64(t.Count) + smoothing) / corpusDenominator
+		backgroundRate := (float64(backgroundCount) + smoothing) / backgroundDenominator
+		scored = append(scored, DistinctTransition{
+			Transition:      t,
+			BackgroundCount: backgroundCount,
+			Score:           math.Log(corpusRate / backgroundRate),
+		})
+		return nil
+	})
+	if scoreErr != nil {
+		return nil, scoreErr
+	}
+
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].Score != scored[j].Score {
+			return scored[i].Score > scored[j].Score
+		}
+		if scored[i].Prev != scored[j].Prev {
+			return scored[i].Prev < scored[j].Prev
+		}
+		return scored[i].Next < scored[j].Next
+	})
+	if opts.Limit > 0 && len(scored) > opts.Limit {
+		scored = scored[:opts.Limit]
+	}
+
+	return scored, nil
+}
+
+// WriteDistinctivenessReport writes scored transitions as an aligned table
+func WriteDistinctivenessReport(w io.Writer, transitions []DistinctTransition) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHRASE\tCOUNT\tBACKGROUND\tSCORE")
+	for _, t := range transitions {
+		fmt.Fprintf(tw, "%s %s\t%d\t%d\t%.3f\n", t.Prev, t.Next, t.Count, t.BackgroundCount, t.Score)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,102 @@
+package chain
+
+// Block sizes of a linkArena, blocks grow so small chains stay small while
+// large ones need few allocations
+const (
+	minArenaBlock = 64
+	maxArenaBlock = 4096
+)
+
+// linkArena allocates links in blocks rather than individually, so building
+// a chain of millions of links makes thousands of allocations for them
+// rather than millions, relieving the garbage collector. A link's block is
+// kept alive while any link in it is, so arenas are only used while a chain
+// is being built, where links are rarely removed, and are released by
+// compact once building is complete
+type linkArena struct {
+	block []singleTokenLink
+	next  int
+}
+
+func newLinkArena() *linkArena {
+	return &linkArena{}
+}
+
+// alloc allocates a link for token whose map is sized for successors
+func (a *linkArena) alloc(token string, successors int) *singleTokenLink {
+	if a.next == len(a.block) {
+		size := 2 * len(a.block)
+		if size < minArenaBlock {
+			size = minArenaBlock
+		} else if size > maxArenaBlock {
+			size = maxArenaBlock
+		}
+		a.block = make([]singleTokenLink, size)
+		a.next = 0
+	}
+
+	link := &a.block[a.next]
+	a.next++
+	link.Token[0] = token
+	link.NextTokenOccurrences = make(map[string]int, successors)
+	return link
+}
+
+// newBuildChain creates an empty chain that allocates its links from an
+// arena, compact must be called once it has been built
+func newBuildChain(links int) *singleKeyChain {
+	return &singleKeyChain{
+		Links:   make(map[string]*singleTokenLink, links),
+		Lengths: NewLengthDistribution(),
+		arena:   newLinkArena(),
+	}
+}
+
+// newLink creates a link for token whose map is sized for successors,
+// allocated from the chain's arena if it has one
+func (c *singleKeyChain) newLink(token string, successors int) *singleTokenLink {
+	if c.arena != nil {
+		return c.arena.alloc(token, successors)
+	}
+	return &singleTokenLink{
+		Token:                [1]string{token},
+		NextTokenOccurrences: make(map[string]int, successors),
+	}
+}
+
+// deleteLink removes the link of a key. A link allocated from the arena is
+// kept alive by its block, so its successors are released so evicting
+// links while building frees their memory
+func (c *singleKeyChain) deleteLink(key string) {
+	if link, ok := c.Links[key]; ok && c.arena != nil {
+		link.NextTokenOccurrences = nil
+		link.Cumulative = nil
+	}
+	delete(c.Links, key)
+}
+
+// compact releases the chain's arena once it has been built, moving the
+// links of the arena's last block, which is likely mostly unused, into a
+// block of their own so the unused space can be collected
+func (c *singleKeyChain) compact() {
+	arena := c.arena
+	if arena == nil {
+		return
+	}
+	c.arena = nil
+	if arena.next == len(arena.block) {
+		return
+	}
+
+	tail := arena.block[:arena.next]
+	moved := make([]singleTokenLink, 0, len(tail))
+	for i := range tail {
+		key := tail[i].Token[0]
+		if c.Links[key] != &tail[i] {
+			// the link was removed or replaced
+			continue
+		}
+		moved = append(moved, tail[i])
+		c.Links[key] = &moved[len(moved)-1]
+	}
+}
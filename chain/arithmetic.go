@@ -0,0 +1,43 @@
+package chain
+
+// occurrencesIn retrieves how many times next followed prev in chain
+func occurrencesIn(chain MarkovChain, prev string, next string) (int, error) {
+	link, present := chain.RetrieveMarkovLink(prev)
+	if !present {
+		return 0, nil
+	}
+	counted, ok := link.(CountedLink)
+	if !ok {
+		return 0, ErrUncountableChain
+	}
+
+	occurrences, _ := counted.GetOccurrencesOfToken(next)
+	return occurrences, nil
+}
+
+// Subtract produces a new chain holding a's counts minus b's, floored at
+// zero, e.g. to remove a general language baseline from a topic corpus so
+// only its distinctive transitions remain
+func Subtract(a MarkovChain, b MarkovChain) (WritableChain, error) {
+	iterable, ok := a.(IterableChain)
+	if !ok {
+		return nil, ErrUncountableChain
+	}
+
+	result := NewWritableChain()
+	subtractErr := forEachTransition(iterable, func(t Transition) error {
+		occurrences, countErr := occurrencesIn(b, t.Prev, t.Next)
+		if countErr != nil {
+			return countErr
+		}
+		if remaining := t.Count - occurrences; remaining > 0 {
+			return result.Increment(t.Prev, t.Next, remaining)
+		}
+		return nil
+	})
+	if subtractErr != nil {
+		return nil, subtractErr
+	}
+
+	return result, nil
+}
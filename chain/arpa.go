@@ -0,0 +1,190 @@
+package chain
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ARPA sentence boundary markers, both map to the empty token
+const (
+	arpaStart = "<s>"
+	arpaEnd   = "</s>"
+	// arpaNever is the conventional log probability of impossible events
+	arpaNever = -99
+)
+
+// DefaultARPAResolution is the count given to a bigram with probability one
+// when importing an ARPA model
+const DefaultARPAResolution = 1000000
+
+func fromARPA(token string) string {
+	if token == arpaStart || token == arpaEnd {
+		return ""
+	}
+	return token
+}
+
+// ReadARPA imports the bigrams of an ARPA n-gram language model, as written
+// by KenLM or SRILM, into dst. Chains store counts rather than
+// probabilities, so each bigram's probability is scaled by resolution and
+// rounded, dropping bigrams that round to zero. Backoff weights and other
+// orders are ignored, as chains don't back off
+func ReadARPA(r io.Reader, dst WritableChain, resolution int) error {
+	if resolution <= 0 {
+		resolution = DefaultARPAResolution
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	section := ""
+	declared, read := 0, 0
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "":
+			continue
+		case text == `\data\`:
+			section = "data"
+			continue
+		case text == `\end\`:
+			if read != declared {
+				return malformed("ARPA model declares %d bigrams but has %d", declared, read)
+			}
+			if declared == 0 {
+				return malformed("ARPA model has no bigrams")
+			}
+			return nil
+		case strings.HasPrefix(text, `\`) && strings.HasSuffix(text, "-grams:"):
+			section = strings.TrimSuffix(strings.TrimPrefix(text, `\`), "-grams:")
+			continue
+		}
+
+		if section == "data" {
+			if strings.HasPrefix(text, "ngram 2=") {
+				n, parseErr := strconv.Atoi(strings.TrimPrefix(text, "ngram 2="))
+				if parseErr != nil || n < 0 {
+					return malformed("line %d: invalid bigram count", line)
+				}
+				declared = n
+			}
+			continue
+		}
+		if section != "2" {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 3 && len(fields) != 4 {
+			return malformed("line %d: expected a bigram", line)
+		}
+		logProb, parseErr := strconv.ParseFloat(fields[0], 64)
+		if parseErr != nil || logProb > 0 {
+			return malformed("line %d: invalid log probability", line)
+		}
+		read++
+
+		count := int(math.Round(math.Pow(10, logProb) * float64(resolution)))
+		if count <= 0 {
+			continue
+		}
+		if incErr := dst.Increment(fromARPA(fields[1]), fromARPA(fields[2]), count); incErr != nil {
+			return incErr
+		}
+	}
+	if scanErr := scanner.Err(); scanErr != nil {
+		return scanErr
+	}
+
+	return malformed("ARPA model is missing \\end\\")
+}
+
+func toARPA(token string, start bool) (string, error) {
+	if token == "" {
+		if start {
+			return arpaStart, nil
+		}
+		return arpaEnd, nil
+	}
+	if strings.IndexFunc(token, unicode.IsSpace) >= 0 {
+		return "", fmt.Errorf("chain: token %q contains whitespace and can't be written as ARPA", token)
+	}
+	return token, nil
+}
+
+// WriteARPA exports a chain as an ARPA bigram language model. Unigram
+// probabilities are estimated from how often each token follows another,
+// and no backoff weights are written. Tokens containing whitespace can't be
+// represented and cause an error
+func WriteARPA(w io.Writer, chain MarkovChain) error {
+	iterable, ok := chain.(IterableChain)
+	if !ok {
+		return ErrUncountableChain
+	}
+
+	unigrams := make(map[string]int)
+	bigrams := make([]Transition, 0)
+	total := 0
+	iterateErr := forEachTransition(iterable, func(t Transition) error {
+		unigrams[t.Next] += t.Count
+		total += t.Count
+		bigrams = append(bigrams, t)
+		return nil
+	})
+	if iterateErr != nil {
+		return iterateErr
+	}
+	if _, ok := unigrams[""]; !ok {
+		unigrams[""] = 0
+	}
+
+	tokens := make([]string, 0, len(unigrams))
+	for token := range unigrams {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	sort.Slice(bigrams, func(i, j int) bool {
+		if bigrams[i].Prev != bigrams[j].Prev {
+			return bigrams[i].Prev < bigrams[j].Prev
+		}
+		return bigrams[i].Next < bigrams[j].Next
+	})
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "\\data\\\nngram 1=%d\nngram 2=%d\n\n\\1-grams:\n", len(tokens)+1, len(bigrams))
+	fmt.Fprintf(out, "%d\t%s\n", arpaNever, arpaStart)
+	for _, token := range tokens {
+		name, nameErr := toARPA(token, false)
+		if nameErr != nil {
+			return nameErr
+		}
+		logProb := float64(arpaNever)
+		if unigrams[token] > 0 {
+			logProb = math.Log10(float64(unigrams[token]) / float64(total))
+		}
+		fmt.Fprintf(out, "%.6f\t%s\n", logProb, name)
+	}
+
+	fmt.Fprint(out, "\n\\2-grams:\n")
+	for _, t := range bigrams {
+		link, _ := chain.RetrieveMarkovLink(t.Prev)
+		probability, _ := link.GetProbabilityOfToken(t.Next)
+		prev, prevErr := toARPA(t.Prev, true)
+		if prevErr != nil {
+			return prevErr
+		}
+		next, nextErr := toARPA(t.Next, false)
+		if nextErr != nil {
+			return nextErr
+		}
+		fmt.Fprintf(out, "%.6f\t%s %s\n", math.Log10(probability), prev, next)
+	}
+	fmt.Fprint(out, "\n\\end\\\n")
+
+	return out.Flush()
+}
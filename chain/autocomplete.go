@@ -0,0 +1,140 @@
+package chain
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// keySeparator joins the tokens of a multi-token key, it is a control
+// character that doesn't occur in text
+const keySeparator = "\x1f"
+
+func joinKey(tokens []string) string {
+	return strings.Join(tokens, keySeparator)
+}
+
+// backoffPenalty scales the scores of suggestions made from shorter contexts
+const backoffPenalty = 0.4
+
+// Suggestion is a candidate next token
+type Suggestion struct {
+	Token string
+	// Score ranks suggestions, it is the token's probability discounted for
+	// each token of context that had to be dropped to find it
+	Score float64
+	// Order is the number of context tokens the suggestion was based on
+	Order int
+}
+
+// Autocompleter suggests the next word of a partially typed sequence. It
+// keeps chains keyed on the previous 1 to order tokens and backs off to
+// shorter contexts when a longer one wasn't seen during training. An
+// Autocompleter is safe for concurrent use
+type Autocompleter struct {
+	order   int
+	autoTex sync.RWMutex
+	// chains[k] is keyed on the previous k+1 tokens
+	chains []*singleKeyChain
+}
+
+// NewAutocompleter creates an empty Autocompleter using up to order tokens
+// of context
+func NewAutocompleter(order int) *Autocompleter {
+	if order < 1 {
+		order = 1
+	}
+
+	chains := make([]*singleKeyChain, order)
+	for i := range chains {
+		chains[i] = newSingleKeyChain()
+	}
+
+	return &Autocompleter{
+		order:  order,
+		chains: chains,
+	}
+}
+
+// historyContext returns the last k tokens of history, padded at the front with
+// sequence boundary tokens if history is too short
+func historyContext(history []string, k int) []string {
+	padded := make([]string, k)
+	for i := 1; i <= k && i <= len(history); i++ {
+		padded[k-i] = history[len(history)-i]
+	}
+
+	return padded
+}
+
+// AddSource reads the source until it is exhausted and adds its tokens to
+// the Autocompleter as a single sequence, or several if it emits
+// SegmentBreak
+func (a *Autocompleter) AddSource(source TokenSource) error {
+	tokens, collectErr := drainSource(source)
+	if collectErr != nil {
+		return collectErr
+	}
+
+	a.autoTex.Lock()
+	defer a.autoTex.Unlock()
+	for _, segment := range splitSegments(tokens) {
+		if len(segment) == 0 {
+			continue
+		}
+		for i := 0; i <= len(segment); i++ {
+			next := ""
+			if i < len(segment) {
+				next = segment[i]
+			}
+			for k := 1; k <= a.order; k++ {
+				a.chains[k-1].increment(joinKey(historyContext(segment[:i], k)), next, 1)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Autocomplete ranks up to n suggestions for the token following
+// prefixTokens
+func (a *Autocompleter) Autocomplete(prefixTokens []string, n int) []Suggestion {
+	a.autoTex.RLock()
+	defer a.autoTex.RUnlock()
+
+	suggested := make(map[string]bool)
+	suggestions := make([]Suggestion, 0)
+	penalty := 1.0
+	for k := a.order; k >= 1; k-- {
+		link, ok := a.chains[k-1].Links[joinKey(historyContext(prefixTokens, k))]
+		if !ok {
+			penalty *= backoffPenalty
+			continue
+		}
+
+		for next, count := range link.NextTokenOccurrences {
+			if next == "" || suggested[next] {
+				continue
+			}
+			suggested[next] = true
+			suggestions = append(suggestions, Suggestion{
+				Token: next,
+				Score: penalty * float64(count) / float64(link.Total),
+				Order: k,
+			})
+		}
+		penalty *= backoffPenalty
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Token < suggestions[j].Token
+	})
+	if n >= 0 && len(suggestions) > n {
+		suggestions = suggestions[:n]
+	}
+
+	return suggestions
+}
@@ -0,0 +1,41 @@
+package chain
+
+import "math/rand"
+
+// BatchChain is a MarkovChain that can look up many tokens at once, e.g. to
+// amortize round trips to a remote chain or acquisitions of a lock
+type BatchChain interface {
+	MarkovChain
+
+	// RetrieveMarkovLinks retrieves the link of each token, and whether each
+	// token was found, in the order the tokens were given
+	RetrieveMarkovLinks(tokens []string) (links []MarkovChainLink, keysPresent []bool)
+}
+
+// RetrieveMarkovLinks retrieves the link of each token in the order given,
+// in one call if the chain is a BatchChain
+func RetrieveMarkovLinks(chain MarkovChain, tokens []string) ([]MarkovChainLink, []bool) {
+	if batch, ok := chain.(BatchChain); ok {
+		return batch.RetrieveMarkovLinks(tokens)
+	}
+
+	links := make([]MarkovChainLink, len(tokens))
+	present := make([]bool, len(tokens))
+	for i, token := range tokens {
+		links[i], present[i] = chain.RetrieveMarkovLink(token)
+	}
+	return links, present
+}
+
+// CalculateNextTokens samples the token following each token in the order
+// given, looking the tokens up as RetrieveMarkovLinks does
+func CalculateNextTokens(chain MarkovChain, tokens []string, rand *rand.Rand) ([]string, []bool) {
+	links, present := RetrieveMarkovLinks(chain, tokens)
+	next := make([]string, len(tokens))
+	for i, link := range links {
+		if present[i] {
+			next[i] = link.GetNextToken(rand)
+		}
+	}
+	return next, present
+}
@@ -0,0 +1,175 @@
+package chain
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// bloomMagic identifies an encoded BloomFilter
+const bloomMagic = "MKVB\x01"
+
+// BloomFilter is a probabilistic set of keys that never reports a key
+// that was added as missing, but may report a key that wasn't as present.
+// A BloomFilter is safe for concurrent use
+type BloomFilter struct {
+	bloomTex sync.RWMutex
+	bits     []uint64
+	hashes   int
+}
+
+// NewBloomFilter creates a BloomFilter sized to hold expectedKeys with the
+// given false positive rate
+func NewBloomFilter(expectedKeys int, falsePositiveRate float64) *BloomFilter {
+	if expectedKeys < 1 {
+		expectedKeys = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+
+	bits := math.Ceil(-float64(expectedKeys) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Round(bits / float64(expectedKeys) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &BloomFilter{
+		bits:   make([]uint64, (int(bits)+63)/64),
+		hashes: hashes,
+	}
+}
+
+// BloomFilterOf creates a BloomFilter holding every key token of a chain
+func BloomFilterOf(chain IterableChain, falsePositiveRate float64) *BloomFilter {
+	tokens := chain.RetrieveTokens()
+	filter := NewBloomFilter(len(tokens), falsePositiveRate)
+	for _, token := range tokens {
+		filter.Add(token)
+	}
+	return filter
+}
+
+// positions calls fn with each bit position of a key, derived from two
+// hashes by double hashing
+func (f *BloomFilter) positions(key string, fn func(bit uint64)) {
+	h1 := hashTokens([]string{key})
+	h2 := mixHash(h1, 1) | 1
+	size := uint64(len(f.bits) * 64)
+	for i := 0; i < f.hashes; i++ {
+		fn((h1 + uint64(i)*h2) % size)
+	}
+}
+
+// Add adds a key to the filter
+func (f *BloomFilter) Add(key string) {
+	f.bloomTex.Lock()
+	defer f.bloomTex.Unlock()
+	f.positions(key, func(bit uint64) {
+		f.bits[bit/64] |= 1 << (bit % 64)
+	})
+}
+
+// MayContain reports whether a key may have been added, it is false only if
+// the key definitely wasn't
+func (f *BloomFilter) MayContain(key string) bool {
+	f.bloomTex.RLock()
+	defer f.bloomTex.RUnlock()
+	present := true
+	f.positions(key, func(bit uint64) {
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			present = false
+		}
+	})
+	return present
+}
+
+// WriteTo encodes the filter so it can be stored alongside its chain
+func (f *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	f.bloomTex.RLock()
+	defer f.bloomTex.RUnlock()
+
+	buf := make([]byte, len(bloomMagic)+2*binary.MaxVarintLen64+8*len(f.bits))
+	n := copy(buf, bloomMagic)
+	n += binary.PutUvarint(buf[n:], uint64(f.hashes))
+	n += binary.PutUvarint(buf[n:], uint64(len(f.bits)))
+	for _, word := range f.bits {
+		binary.LittleEndian.PutUint64(buf[n:], word)
+		n += 8
+	}
+	written, writeErr := w.Write(buf[:n])
+	return int64(written), writeErr
+}
+
+// maxBloomWords bounds the size of a decoded filter to 1GiB
+const maxBloomWords = 1 << 27
+
+// ReadBloomFilter decodes a filter encoded by WriteTo, ErrMalformedChain is
+// returned if it is corrupt
+func ReadBloomFilter(r io.Reader) (*BloomFilter, error) {
+	reader := bufio.NewReader(r)
+	magic := make([]byte, len(bloomMagic))
+	if _, readErr := io.ReadFull(reader, magic); readErr != nil {
+		return nil, readErr
+	}
+	if string(magic) != bloomMagic {
+		return nil, malformed("not a bloom filter")
+	}
+
+	hashes, hashesErr := binary.ReadUvarint(reader)
+	if hashesErr != nil {
+		return nil, hashesErr
+	}
+	words, wordsErr := binary.ReadUvarint(reader)
+	if wordsErr != nil {
+		return nil, wordsErr
+	}
+	if hashes < 1 || hashes > 64 || words < 1 || words > maxBloomWords {
+		return nil, malformed("bloom filter with %d hashes and %d words", hashes, words)
+	}
+
+	// the words are read before they're allocated, so a corrupt header
+	// can't claim more memory than the data it's followed by
+	data, readErr := readShard(reader, int64(words)*8)
+	if readErr != nil {
+		return nil, readErr
+	}
+	filter := &BloomFilter{bits: make([]uint64, words), hashes: int(hashes)}
+	for i := range filter.bits {
+		filter.bits[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return filter, nil
+}
+
+// bloomChain answers lookups of keys missing from its filter without
+// consulting its chain
+type bloomChain struct {
+	chain  MarkovChain
+	filter *BloomFilter
+}
+
+// MakeBloomChain wraps a chain whose lookups are expensive, such as one
+// backed by disk or a remote service, so lookups of keys the filter
+// doesn't contain are answered without consulting it. Keys added to the
+// chain must also be added to the filter
+func MakeBloomChain(chain MarkovChain, filter *BloomFilter) MarkovChain {
+	return &bloomChain{chain: chain, filter: filter}
+}
+
+func (c *bloomChain) CalculateNextToken(token string, rand *rand.Rand) (string, bool) {
+	if !c.filter.MayContain(token) {
+		countEvent(counterLookupMisses, token)
+		return "", false
+	}
+	return c.chain.CalculateNextToken(token, rand)
+}
+
+func (c *bloomChain) RetrieveMarkovLink(token string) (MarkovChainLink, bool) {
+	if !c.filter.MayContain(token) {
+		countEvent(counterLookupMisses, token)
+		return nil, false
+	}
+	return c.chain.RetrieveMarkovLink(token)
+}
@@ -0,0 +1,44 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestReadBloomFilterRoundTrip(t *testing.T) {
+	filter := NewBloomFilter(100, 0.01)
+	filter.Add("cat")
+	encoded := &bytes.Buffer{}
+	if _, writeErr := filter.WriteTo(encoded); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	read, readErr := ReadBloomFilter(encoded)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if !read.MayContain("cat") || read.hashes != filter.hashes || len(read.bits) != len(filter.bits) {
+		t.Fatal("filter changed by round trip")
+	}
+}
+
+func TestReadBloomFilterTruncated(t *testing.T) {
+	header := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(header, 3)
+	n += binary.PutUvarint(header[n:], maxBloomWords)
+	encoded := append([]byte(bloomMagic), header[:n]...)
+	encoded = append(encoded, make([]byte, 16)...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, readErr := ReadBloomFilter(bytes.NewReader(encoded))
+	runtime.ReadMemStats(&after)
+	if !errors.Is(readErr, ErrMalformedChain) {
+		t.Fatalf("got %v, want ErrMalformedChain", readErr)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("allocated %d bytes for a truncated filter", allocated)
+	}
+}
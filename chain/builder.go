@@ -1,6 +1,7 @@
 package chain
 
 import (
+	"context"
 	"io"
 )
 
@@ -9,38 +10,219 @@ type TokenSource interface {
 	NextToken() (string, error)
 }
 
+// BuildOptions configures how a chain is built
+type BuildOptions struct {
+	// Order, if above one, keys links on that many preceding tokens rather
+	// than one, so the chain built is a MultiKeyChain
+	Order int
+
+	// MaxVocabulary caps the number of distinct tokens retained while
+	// building, zero means unlimited. When exceeded the lowest frequency
+	// tokens are dropped, both as keys and as successors
+	MaxVocabulary int
+
+	// MaxStates caps the number of links retained while building, zero means
+	// unlimited. When exceeded the links with the fewest occurrences are
+	// dropped
+	MaxStates int
+
+	// OverflowBuckets, if set with MaxStates, folds the links MaxStates
+	// would drop into this many buckets shared by hash of their key rather
+	// than dropping them. A token whose link was folded is looked up in its
+	// bucket, so its successors are approximated by those of every token
+	// sharing the bucket. Tokens that never had a link aren't found
+	OverflowBuckets int
+
+	// Deduplicator, if set, skips sequences that repeat earlier ones, each
+	// sequence of a source separated by SegmentBreak is checked on its own.
+	// Each source is buffered in full so it can be checked before it is
+	// counted
+	Deduplicator *Deduplicator
+
+	// NGramIndex, if set, is populated with the n-grams of every source so
+	// generated output can later be checked for verbatim copying
+	NGramIndex *NGramIndex
+
+	// Examples, if set, retains example snippets of the source text each
+	// transition was learned from
+	Examples *ExampleStore
+
+	// SequenceIndex, if set, is populated with a hash of every sequence so
+	// generation can reject verbatim copies of training sequences
+	SequenceIndex *SequenceIndex
+
+	// Filters are applied, in order, to every source
+	Filters []SourceFilter
+
+	// Concurrency caps the number of sources read at once, zero means all
+	// sources are read concurrently
+	Concurrency int
+
+	// Progress, if set, is called periodically as tokens are read. Calls are
+	// never made concurrently
+	Progress func(BuildProgress)
+
+	// Context, if set, cancels the build when done. Sources implementing
+	// ContextTokenSource are cancelled mid-read
+	Context context.Context
+
+	// Report, if set, is filled in with a summary once the build completes
+	Report *BuildReport
+
+	// Warn, if set, is called with warnings about suspicious training data,
+	// such as binary input or empty sources, as it is read. Warnings don't
+	// stop the build and are also included in Report. Calls are never made
+	// concurrently
+	Warn func(BuildWarning)
+
+	// EmptyTokens decides what happens to empty tokens, which are kept by
+	// default
+	EmptyTokens EmptyTokenPolicy
+
+	// EmptyPlaceholder replaces empty tokens under EmptyTokenPlaceholder,
+	// defaults to DefaultEmptyPlaceholder
+	EmptyPlaceholder string
+}
+
 // BuildChainFromSources builds a Markov chain from sources providing
 // tokens
 func BuildChainFromSources(tokenSources ...TokenSource) (MarkovChain, error) {
-	tokChans := make([]chan string, 0, len(tokenSources))
-	chainChan := make(chan MarkovChain)
-	errorChan := make(chan error)
+	return BuildChainFromSourcesWithOptions(BuildOptions{}, tokenSources...)
+}
+
+// BuildChainFromSourcesWithOptions builds a Markov chain from sources
+// providing tokens, applying the supplied options
+func BuildChainFromSourcesWithOptions(opts BuildOptions, tokenSources ...TokenSource) (MarkovChain, error) {
+	return buildChainFromSources(opts, nil, tokenSources...)
+}
+
+// BuildChainFromSourcesContext builds a Markov chain from sources providing
+// tokens, returning ctx.Err() if ctx is done first. Every source is closed
+// and no goroutines are left running once the sources' pending reads return
+func BuildChainFromSourcesContext(ctx context.Context, tokenSources ...TokenSource) (MarkovChain, error) {
+	return buildChainFromSources(BuildOptions{Context: ctx}, nil, tokenSources...)
+}
+
+// WeightedSource pairs a TokenSource with a factor its contribution to a
+// chain is scaled by
+type WeightedSource struct {
+	Source TokenSource
+	Weight float64
+}
+
+// BuildChainFromWeightedSources builds a Markov chain from sources where the
+// counts contributed by each source are multiplied by its weight, e.g. to
+// stop a large but low quality corpus from dominating a small curated one.
+// Scaled counts are rounded to the nearest integer and transitions that
+// round to zero are dropped
+func BuildChainFromWeightedSources(opts BuildOptions, tokenSources ...WeightedSource) (MarkovChain, error) {
+	sources := make([]TokenSource, 0, len(tokenSources))
+	weights := make([]float64, 0, len(tokenSources))
 	for _, v := range tokenSources {
-		localVal := v
+		sources = append(sources, v.Source)
+		weights = append(weights, v.Weight)
+	}
+
+	return buildChainFromSources(opts, weights, sources...)
+}
+
+// buildChainFromSources reads each source concurrently and builds a chain,
+// if weights is non-nil it holds a weight for each source
+func buildChainFromSources(opts BuildOptions, weights []float64, tokenSources ...TokenSource) (MarkovChain, error) {
+	tokChans := make([]chan string, 0, len(tokenSources))
+	// both channels are buffered so no goroutine blocks sending a result
+	// once the build has returned
+	chainChan := make(chan MarkovChain, 1)
+	errorChan := make(chan error, len(tokenSources)+1)
+	progress := newProgressTracker(opts.Progress, len(tokenSources))
+	report := newReportBuilder(opts.Report, len(tokenSources), len(opts.Filters))
+	warnings := newWarningTracker(opts.Warn, opts.Report, len(tokenSources))
+	parent := opts.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	// cancelled when the build returns, so readers still running after an
+	// error stop rather than leak
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var slots chan struct{}
+	if opts.Concurrency > 0 {
+		slots = make(chan struct{}, opts.Concurrency)
+	}
+
+	for i, v := range tokenSources {
+		index, localVal := i, v
+		if len(opts.Filters) > 0 {
+			localVal = ApplyFiltersToSource(localVal, report.wrapFilters(index, opts.Filters)...)
+		}
 
 		tokChan := make(chan string, 20)
 		tokChans = append(tokChans, tokChan)
 		go func() {
+			// the channel is always closed so the chain built from it is
+			// finished, even if it is then discarded
+			defer close(tokChan)
+			defer recoverPanic(func(err error) {
+				errorChan <- &SourceError{SourceIndex: index, Err: err}
+			})
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-ctx.Done():
+					closeSource(localVal)
+					return
+				}
+			}
+
 			for {
-				token, tokenErr := localVal.NextToken()
+				token, tokenErr := nextTokenContext(ctx, localVal)
 				if tokenErr == io.EOF {
-					close(tokChan)
+					if closeErr := closeSource(localVal); closeErr != nil {
+						errorChan <- &SourceError{SourceIndex: index, Err: closeErr}
+						return
+					}
+					progress.sourceDone()
+					warnings.sourceDone(index)
 					return
 				} else if tokenErr != nil {
-					errorChan <- tokenErr
-				} else {
-					tokChan <- token
+					closeSource(localVal)
+					errorChan <- &SourceError{SourceIndex: index, Err: tokenErr}
+					return
+				}
+
+				select {
+				case tokChan <- token:
+				case <-ctx.Done():
+					closeSource(localVal)
+					return
 				}
+				progress.token()
+				report.token(index)
+				warnings.token(index, token)
 			}
 		}()
 	}
 
-	go BuildSingleLinkChain(chainChan, tokChans...)
+	go buildSingleLinkChain(opts, weights, chainChan, errorChan, tokChans...)
 
 	select {
 	case chain := <-chainChan:
-		return chain, nil
+		// a source that failed still completes its part of the chain, its
+		// error is sent before then so takes precedence
+		select {
+		case e := <-errorChan:
+			return nil, e
+		default:
+		}
+		progress.report()
+		built := chain.(*singleKeyChain)
+		report.finish(built, warnings.finish(built))
+		return opts.withOrder(built), nil
 	case e := <-errorChan:
 		return nil, e
+	case <-parent.Done():
+		return nil, parent.Err()
 	}
 }
@@ -0,0 +1,170 @@
+package chain
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+)
+
+// CacheStats reports the effectiveness of a CachedChain
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+type cachedLink struct {
+	token   string
+	link    MarkovChainLink
+	present bool
+}
+
+// CachedChain wraps a MarkovChain with slow lookups (e.g. one backed by disk
+// or a remote service) and keeps the most recently used links in memory.
+// Lookups of missing tokens are cached as well. A CachedChain is safe for
+// concurrent use if the wrapped chain is
+type CachedChain struct {
+	chain    MarkovChain
+	capacity int
+	cacheTex sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	stats    CacheStats
+}
+
+// MakeCachedChain wraps a chain with an LRU cache holding up to capacity links
+func MakeCachedChain(chain MarkovChain, capacity int) *CachedChain {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &CachedChain{
+		chain:    chain,
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *CachedChain) CalculateNextToken(token string, rand *rand.Rand) (nextToken string, keyPresent bool) {
+	link, ok := c.RetrieveMarkovLink(token)
+	if !ok {
+		return "", false
+	}
+
+	return link.GetNextToken(rand), true
+}
+
+func (c *CachedChain) RetrieveMarkovLink(token string) (link MarkovChainLink, keyPresent bool) {
+	c.cacheTex.Lock()
+	if element, ok := c.entries[token]; ok {
+		c.lru.MoveToFront(element)
+		c.stats.Hits++
+		entry := element.Value.(*cachedLink)
+		c.cacheTex.Unlock()
+		return entry.link, entry.present
+	}
+	c.stats.Misses++
+	c.cacheTex.Unlock()
+
+	// the lookup is performed without holding the lock so slow lookups
+	// don't serialize access to the cache
+	link, keyPresent = c.chain.RetrieveMarkovLink(token)
+
+	c.cacheTex.Lock()
+	defer c.cacheTex.Unlock()
+	if _, ok := c.entries[token]; !ok {
+		c.entries[token] = c.lru.PushFront(&cachedLink{
+			token:   token,
+			link:    link,
+			present: keyPresent,
+		})
+		for c.lru.Len() > c.capacity {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cachedLink).token)
+			c.stats.Evictions++
+		}
+	}
+
+	return link, keyPresent
+}
+
+// RetrieveMarkovLinks retrieves the links of many tokens, the tokens that
+// aren't cached are looked up in the wrapped chain in a single batch
+func (c *CachedChain) RetrieveMarkovLinks(tokens []string) ([]MarkovChainLink, []bool) {
+	links := make([]MarkovChainLink, len(tokens))
+	present := make([]bool, len(tokens))
+	missing := make([]string, 0)
+	missingIndices := make([]int, 0)
+
+	c.cacheTex.Lock()
+	for i, token := range tokens {
+		if element, ok := c.entries[token]; ok {
+			c.lru.MoveToFront(element)
+			c.stats.Hits++
+			entry := element.Value.(*cachedLink)
+			links[i], present[i] = entry.link, entry.present
+			continue
+		}
+		c.stats.Misses++
+		missing = append(missing, token)
+		missingIndices = append(missingIndices, i)
+	}
+	c.cacheTex.Unlock()
+	if len(missing) == 0 {
+		return links, present
+	}
+
+	missingLinks, missingPresent := RetrieveMarkovLinks(c.chain, missing)
+
+	c.cacheTex.Lock()
+	defer c.cacheTex.Unlock()
+	for j, token := range missing {
+		links[missingIndices[j]], present[missingIndices[j]] = missingLinks[j], missingPresent[j]
+		if _, ok := c.entries[token]; !ok {
+			c.entries[token] = c.lru.PushFront(&cachedLink{
+				token:   token,
+				link:    missingLinks[j],
+				present: missingPresent[j],
+			})
+		}
+	}
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedLink).token)
+		c.stats.Evictions++
+	}
+
+	return links, present
+}
+
+// Invalidate drops any cached link for the specified token, it should be
+// called when the wrapped chain is modified
+func (c *CachedChain) Invalidate(token string) {
+	c.cacheTex.Lock()
+	defer c.cacheTex.Unlock()
+	if element, ok := c.entries[token]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, token)
+	}
+}
+
+// Purge drops all cached links
+func (c *CachedChain) Purge() {
+	c.cacheTex.Lock()
+	defer c.cacheTex.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// Stats returns a snapshot of the cache's hit and miss counts
+func (c *CachedChain) Stats() CacheStats {
+	c.cacheTex.Lock()
+	defer c.cacheTex.Unlock()
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
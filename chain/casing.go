@@ -0,0 +1,147 @@
+package chain
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CaseRestoringChain trains on lowercased tokens to reduce sparsity, but
+// records how each token was originally cased so generated tokens can be
+// emitted with a plausible casing, e.g. "I", "NASA" or a capitalized first
+// word. A CaseRestoringChain is safe for concurrent use
+type CaseRestoringChain struct {
+	chainTex sync.RWMutex
+	chain    NormalizedChain
+	// casings maps a lowercased token to the occurrences of each of its
+	// original forms, initialCasings only counts sequence-initial occurrences
+	casings        map[string]map[string]int
+	initialCasings map[string]map[string]int
+}
+
+// NewCaseRestoringChain creates an empty CaseRestoringChain
+func NewCaseRestoringChain() *CaseRestoringChain {
+	return &CaseRestoringChain{
+		chain:          MakeNormalizedChain(NewWritableChain(), CaseFoldNormalizer()),
+		casings:        make(map[string]map[string]int),
+		initialCasings: make(map[string]map[string]int),
+	}
+}
+
+func recordCasing(casings map[string]map[string]int, token string) {
+	key := strings.ToLower(token)
+	forms, ok := casings[key]
+	if !ok {
+		forms = make(map[string]int)
+		casings[key] = forms
+	}
+	forms[token]++
+}
+
+// AddSource reads the source until it is exhausted and adds its tokens to
+// the chain as a single sequence, or several if it emits SegmentBreak.
+// Empty sequences are skipped
+func (c *CaseRestoringChain) AddSource(source TokenSource) error {
+	tokens, collectErr := drainSource(source)
+	if collectErr != nil {
+		return collectErr
+	}
+
+	c.chainTex.Lock()
+	defer c.chainTex.Unlock()
+	for _, segment := range splitSegments(tokens) {
+		if len(segment) == 0 {
+			continue
+		}
+		prev := ""
+		for i, token := range segment {
+			c.chain.Increment(prev, token, 1)
+			recordCasing(c.casings, token)
+			if i == 0 {
+				recordCasing(c.initialCasings, token)
+			}
+			prev = token
+		}
+		c.chain.Increment(prev, "", 1)
+	}
+
+	return nil
+}
+
+// pickCasing samples an original form of a lowercased token
+func pickCasing(forms map[string]int, rand *rand.Rand) string {
+	// sort forms so sampling is reproducible for a given rand
+	keys := make([]string, 0, len(forms))
+	total := 0
+	for k, v := range forms {
+		keys = append(keys, k)
+		total += v
+	}
+	sort.Strings(keys)
+
+	goal := rand.Intn(total)
+	for _, k := range keys {
+		goal -= forms[k]
+		if goal < 0 {
+			return k
+		}
+	}
+
+	return keys[len(keys)-1]
+}
+
+// RestoreCase chooses an original casing for a lowercased token, prev is the
+// token that preceded it and is used to detect the start of a sequence
+func (c *CaseRestoringChain) RestoreCase(prev string, token string, rand *rand.Rand) string {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+	return c.restoreCase(prev, token, rand)
+}
+
+func (c *CaseRestoringChain) restoreCase(prev string, token string, rand *rand.Rand) string {
+	if prev == "" {
+		if forms, ok := c.initialCasings[token]; ok {
+			return pickCasing(forms, rand)
+		}
+	}
+	if forms, ok := c.casings[token]; ok {
+		return pickCasing(forms, rand)
+	}
+
+	return token
+}
+
+// CalculateNextToken calculates the next token in its original casing, the
+// supplied token may be in any casing
+func (c *CaseRestoringChain) CalculateNextToken(token string, rand *rand.Rand) (nextToken string, keyPresent bool) {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+	next, ok := c.chain.CalculateNextToken(token, rand)
+	if !ok {
+		return "", false
+	}
+
+	return c.restoreCase(token, next, rand), true
+}
+
+// RetrieveMarkovLink retrieves the link for a token, the link's tokens are
+// lowercased
+func (c *CaseRestoringChain) RetrieveMarkovLink(token string) (link MarkovChainLink, keyPresent bool) {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+	return c.chain.RetrieveMarkovLink(token)
+}
+
+// Casings retrieves the number of times each original form of a token was
+// seen during training
+func (c *CaseRestoringChain) Casings(token string) map[string]int {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+	forms := make(map[string]int)
+	for k, v := range c.casings[strings.ToLower(token)] {
+		forms[k] = v
+	}
+
+	return forms
+}
@@ -3,6 +3,7 @@ package chain
 import (
 	"fmt"
 	"math/rand"
+	"strings"
 	"sync"
 )
 
@@ -32,10 +33,60 @@ type MarkovChain interface {
 	RetrieveMarkovLink(token string) (link MarkovChainLink, keyPresent bool)
 }
 
+// CountedLink is a MarkovChainLink that exposes the raw occurrence counts
+// its probabilities are calculated from
+type CountedLink interface {
+	MarkovChainLink
+
+	// GetOccurrencesOfToken retrieves the number of times the given token followed
+	// the link's key token, and a boolean indicating if it was present
+	GetOccurrencesOfToken(nextToken string) (occurrences int, tokenPresent bool)
+
+	// GetTotalOccurrences retrieves the sum of occurrences of all next tokens
+	GetTotalOccurrences() int
+}
+
+// IterableChain is a MarkovChain whose key tokens can be enumerated
+type IterableChain interface {
+	MarkovChain
+
+	// RetrieveTokens retrieves every key token that has a link in the chain
+	RetrieveTokens() []string
+
+	// IsEmpty reports whether the chain holds no transitions
+	IsEmpty() bool
+}
+
+// WritableChain is an IterableChain that can be modified after it has been
+// built
+type WritableChain interface {
+	IterableChain
+
+	// Increment records n additional occurrences of next following prev,
+	// ErrInvalidCount is returned if n isn't positive
+	Increment(prev string, next string, n int) error
+
+	// RemoveSuccessor removes next as a possibility following prev, the link
+	// for prev is removed entirely if it has no remaining possibilities
+	RemoveSuccessor(prev string, next string) error
+
+	// SetCount sets the number of occurrences of next following prev, a
+	// count of zero or less removes the successor
+	SetCount(prev string, next string, n int) error
+}
+
+// NewWritableChain creates an empty in-memory WritableChain
+func NewWritableChain() WritableChain {
+	return newSingleKeyChain()
+}
+
 type singleTokenLink struct {
-	Token                [1]string      `json:"token",xml:"token"`
-	NextTokenOccurrences map[string]int `json:"next_token_occurrences",xml:"nextTokenOccurrences"`
-	Total                int            `json:"total",xml:"total"`
+	Token                [1]string      `json:"token" xml:"token"`
+	NextTokenOccurrences map[string]int `json:"next_token_occurrences" xml:"nextTokenOccurrences"`
+	Total                int            `json:"total" xml:"total"`
+	// Cumulative is an optional precomputed sampling table, it is dropped
+	// whenever the link is modified
+	Cumulative *cumulativeTable `json:"cumulative,omitempty" xml:"-"`
 }
 
 func (l *singleTokenLink) String() string {
@@ -43,116 +94,469 @@ func (l *singleTokenLink) String() string {
 }
 
 type singleKeyChain struct {
-	Links map[string]*singleTokenLink `json:"links"`
+	Links   map[string]*singleTokenLink `json:"links"`
+	Lengths *LengthDistribution         `json:"lengths,omitempty"`
+	// OverflowBuckets is the number of overflow buckets states beyond
+	// BuildOptions.MaxStates were folded into, the tokens in Folded are
+	// looked up in their bucket
+	OverflowBuckets int `json:"overflow_buckets,omitempty"`
+	// Folded holds the keys whose links were folded into overflow buckets
+	Folded map[string]bool `json:"folded,omitempty"`
+	// arena allocates links while the chain is being built, if set
+	arena *linkArena
+}
+
+// link looks up the link of a token, falling back to its overflow bucket
+// if its link was folded into one
+func (c *singleKeyChain) link(token string) (*singleTokenLink, bool) {
+	link, ok := c.Links[token]
+	if !ok && c.OverflowBuckets > 0 && c.Folded[token] {
+		link, ok = c.Links[overflowKey(token, c.OverflowBuckets)]
+	}
+	return link, ok
 }
 
 func (c *singleKeyChain) CalculateNextToken(token string, rand *rand.Rand) (nextToken string, keyPresent bool) {
-	if link, ok := c.Links[token]; !ok {
+	if link, ok := c.link(token); !ok {
+		countEvent(counterLookupMisses, token)
 		return "", false
 	} else {
+		countEvent(counterLookupHits, token)
 		return link.GetNextToken(rand), true
 	}
 }
 
 func (c *singleKeyChain) RetrieveMarkovLink(token string) (link MarkovChainLink, keyPresent bool) {
-	link, ok := c.Links[token]
+	link, ok := c.link(token)
+	if ok {
+		countEvent(counterLookupHits, token)
+	} else {
+		countEvent(counterLookupMisses, token)
+	}
 	return link, ok
 }
 
-func buildChain(tokenChannel <-chan string) *singleKeyChain {
-	links := make(map[string]*singleTokenLink)
+func (c *singleKeyChain) RetrieveTokens() []string {
+	tokens := make([]string, 0, len(c.Links))
+	for k := range c.Links {
+		tokens = append(tokens, k)
+	}
+
+	return tokens
+}
+
+func (c *singleKeyChain) IsEmpty() bool {
+	for key, link := range c.Links {
+		if link.Total <= 0 {
+			continue
+		}
+		// a lone boundary to boundary transition is an empty sequence
+		if key == "" && len(link.NextTokenOccurrences) == 1 && link.NextTokenOccurrences[""] > 0 {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
+
+func (c *singleKeyChain) Increment(prev string, next string, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidCount, n)
+	}
+	c.increment(prev, next, n)
+	return nil
+}
+
+func (c *singleKeyChain) RemoveSuccessor(prev string, next string) error {
+	link, ok := c.Links[prev]
+	if !ok {
+		return nil
+	}
+
+	link.Total -= link.NextTokenOccurrences[next]
+	delete(link.NextTokenOccurrences, next)
+	link.Cumulative = nil
+	if len(link.NextTokenOccurrences) == 0 {
+		c.deleteLink(prev)
+	}
 
-	appendToChain := func(prev string, next string) {
-		var link *singleTokenLink
-		if extantLink, ok := links[prev]; !ok {
-			link = &singleTokenLink{
-				Token:                [1]string{prev},
-				NextTokenOccurrences: make(map[string]int),
+	return nil
+}
+
+func (c *singleKeyChain) SetCount(prev string, next string, n int) error {
+	if n <= 0 {
+		return c.RemoveSuccessor(prev, next)
+	}
+
+	current := 0
+	if link, ok := c.Links[prev]; ok {
+		current = link.NextTokenOccurrences[next]
+	}
+	c.increment(prev, next, n-current)
+
+	return nil
+}
+
+func newSingleKeyChain() *singleKeyChain {
+	return &singleKeyChain{
+		Links:   make(map[string]*singleTokenLink),
+		Lengths: NewLengthDistribution(),
+	}
+}
+
+func (c *singleKeyChain) SequenceLengths() *LengthDistribution {
+	return c.Lengths
+}
+
+// increment records n occurrences of next following prev
+func (c *singleKeyChain) increment(prev string, next string, n int) {
+	var link *singleTokenLink
+	if extantLink, ok := c.Links[prev]; !ok {
+		link = c.newLink(prev, 0)
+		c.Links[prev] = link
+	} else {
+		link = extantLink
+	}
+
+	link.NextTokenOccurrences[next] = link.NextTokenOccurrences[next] + n
+	link.Total += n
+	link.Cumulative = nil
+}
+
+// mergeFrom adds all of the counts in other to the chain
+func (c *singleKeyChain) mergeFrom(other *singleKeyChain) {
+	for key, link := range other.Links {
+		if _, ok := c.Links[key]; !ok && len(link.NextTokenOccurrences) > 0 {
+			c.Links[key] = c.newLink(key, len(link.NextTokenOccurrences))
+		}
+		for next, count := range link.NextTokenOccurrences {
+			c.increment(key, next, count)
+		}
+	}
+	if other.OverflowBuckets > c.OverflowBuckets {
+		c.OverflowBuckets = other.OverflowBuckets
+	}
+	for key := range other.Folded {
+		c.fold(key)
+	}
+	if other.Lengths != nil {
+		if c.Lengths == nil {
+			c.Lengths = NewLengthDistribution()
+		}
+		c.Lengths.merge(other.Lengths)
+	}
+}
+
+// scale multiplies every count by factor, rounding to the nearest integer
+// and dropping transitions that round to zero
+func (c *singleKeyChain) scale(factor float64) {
+	for key, link := range c.Links {
+		link.Total = 0
+		link.Cumulative = nil
+		for next, count := range link.NextTokenOccurrences {
+			if scaled := scaleCount(count, factor); scaled > 0 {
+				link.NextTokenOccurrences[next] = scaled
+				link.Total += scaled
+			} else {
+				delete(link.NextTokenOccurrences, next)
 			}
-		} else {
-			link = extantLink
 		}
+		if len(link.NextTokenOccurrences) == 0 {
+			c.deleteLink(key)
+		}
+	}
+}
+
+// rough per-entry overheads used when estimating the memory used by a chain
+const (
+	linkOverhead      = 128
+	successorOverhead = 48
+)
 
-		link.NextTokenOccurrences[next] = link.NextTokenOccurrences[next] + 1
-		link.Total++
-		links[prev] = link
+// approximateSize estimates the number of bytes occupied by the chain
+func (c *singleKeyChain) approximateSize() int64 {
+	size := int64(0)
+	for key, link := range c.Links {
+		size += int64(linkOverhead + len(key))
+		for next := range link.NextTokenOccurrences {
+			size += int64(successorOverhead + len(next))
+		}
 	}
 
-	lastVal := ""
+	return size
+}
+
+// sequenceBuilder adds a single sequence of tokens to a chain, applying the
+// hooks configured in BuildOptions as it goes
+type sequenceBuilder struct {
+	opts     BuildOptions
+	chain    *singleKeyChain
+	limiter  *chainLimiter
+	window   *ngramWindow
+	examples *exampleRecorder
+	hasher   *sequenceHasher
+	source   int
+	lastVal  string
+	// history holds the last tokens of the sequence when links are keyed on
+	// more than one, see BuildOptions.Order
+	history []string
+	length  int
+}
+
+// newSequenceBuilder creates a sequenceBuilder, the limiter is shared by all
+// sequences added to the same chain
+func newSequenceBuilder(opts BuildOptions, chain *singleKeyChain, limiter *chainLimiter, source int) *sequenceBuilder {
+	builder := &sequenceBuilder{
+		opts:    opts,
+		chain:   chain,
+		limiter: limiter,
+		source:  source,
+	}
+	builder.restart()
+
+	return builder
+}
+
+// restart resets the builder to the start of a new sequence
+func (b *sequenceBuilder) restart() {
+	opts := b.opts
+	b.lastVal, b.history, b.length = "", nil, 0
+	if opts.NGramIndex != nil {
+		b.window = opts.NGramIndex.newWindow()
+	}
+	if opts.Examples != nil {
+		b.examples = opts.Examples.newRecorder(b.source)
+	}
+	if opts.SequenceIndex != nil {
+		b.hasher = opts.SequenceIndex.newHasher()
+	}
+}
+
+func (b *sequenceBuilder) add(token string) {
+	if token == SegmentBreak {
+		b.finish()
+		b.restart()
+		return
+	}
+	token, ok := b.opts.emptyToken(token)
+	if !ok {
+		return
+	}
+
+	b.chain.increment(b.key(), token, 1)
+	if b.opts.Order > 1 {
+		b.history = append(b.history, token)
+		if len(b.history) > b.opts.Order {
+			b.history = b.history[1:]
+		}
+	}
+	if b.window != nil {
+		b.window.add(token)
+	}
+	if b.examples != nil {
+		b.examples.add(token)
+	}
+	if b.hasher != nil {
+		b.hasher.add(token)
+	}
+	if b.limiter.limited() {
+		b.limiter.observe(b.chain, token)
+	}
+	b.lastVal = token
+	b.length++
+}
+
+// key retrieves the key of the link the next token follows
+func (b *sequenceBuilder) key() string {
+	if b.opts.Order > 1 {
+		return joinKey(historyContext(b.history, b.opts.Order))
+	}
+	return b.lastVal
+}
+
+// position retrieves the key the sequence has reached and its length, so
+// it can be continued by resume
+func (b *sequenceBuilder) position() (string, int) {
+	return b.key(), b.length
+}
+
+// resume continues a sequence from a position
+func (b *sequenceBuilder) resume(key string, length int) {
+	b.lastVal, b.length = key, length
+	if b.opts.Order > 1 && length > 0 {
+		b.history = strings.Split(key, keySeparator)
+		b.lastVal = b.history[len(b.history)-1]
+	}
+}
+
+func (b *sequenceBuilder) finish() {
+	// empty sequences add nothing to the chain
+	if b.length == 0 {
+		return
+	}
+
+	b.chain.increment(b.key(), "", 1)
+	b.chain.Lengths.Observe(b.length)
+	if b.examples != nil {
+		b.examples.finish()
+	}
+	if b.hasher != nil {
+		b.hasher.finish()
+	}
+}
+
+func buildChain(opts BuildOptions, source int, tokenChannel <-chan string) *singleKeyChain {
+	chain := newBuildChain(0)
+	builder := newSequenceBuilder(opts, chain, newChainLimiter(opts), source)
+	if opts.Deduplicator == nil {
+		for val := range tokenChannel {
+			builder.add(val)
+		}
+		builder.finish()
+		return chain
+	}
+
+	// only the segment being read is held, it's checked for duplicates once
+	// the SegmentBreak or the end of the tokens completes it
+	segment := make([]string, 0)
+	kept := false
+	keep := func() {
+		if len(segment) > 0 && !opts.Deduplicator.IsDuplicate(segment) {
+			if kept {
+				builder.add(SegmentBreak)
+			}
+			for _, val := range segment {
+				builder.add(val)
+			}
+			kept = true
+		}
+		segment = segment[:0]
+	}
 	for val := range tokenChannel {
-		appendToChain(lastVal, val)
-		lastVal = val
+		if val == SegmentBreak {
+			keep()
+			continue
+		}
+		segment = append(segment, val)
 	}
-	appendToChain(lastVal, "")
+	keep()
+	builder.finish()
 
-	return &singleKeyChain{
-		Links: links,
+	return chain
+}
+
+// buildChainFromSource synchronously reads a TokenSource until it is
+// exhausted and builds a chain from the tokens
+func buildChainFromSource(source TokenSource) (*singleKeyChain, error) {
+	tokens, drainErr := drainSource(source)
+	if drainErr != nil {
+		return nil, &SourceError{SourceIndex: 0, Err: drainErr}
+	}
+
+	chain := newBuildChain(0)
+	builder := newSequenceBuilder(BuildOptions{}, chain, newChainLimiter(BuildOptions{}), 0)
+	for _, token := range tokens {
+		builder.add(token)
 	}
+	builder.finish()
+	chain.compact()
+
+	return chain, nil
 }
 
 // BuildSingleLinkChain builds a Markov chain from a series of keys provided
 // by the tokenChannels and emits the result on the Markov chain channel when complete
 func BuildSingleLinkChain(chainChannel chan<- MarkovChain, tokenChannels ...chan string) {
+	buildSingleLinkChain(BuildOptions{}, nil, chainChannel, nil, tokenChannels...)
+}
+
+// buildSingleLinkChain builds a chain from each token channel concurrently
+// and emits the merged result on chainChannel. If building panics the panic
+// is reported on errorChannel, or if errorChannel is nil chainChannel is
+// closed without a chain being sent
+func buildSingleLinkChain(opts BuildOptions, weights []float64, chainChannel chan<- MarkovChain, errorChannel chan<- error, tokenChannels ...chan string) {
 	chainSlice := make([]*singleKeyChain, 0, len(tokenChannels))
 	wg := sync.WaitGroup{}
 	chainTex := sync.Mutex{}
-	for _, channel := range tokenChannels {
-		channel := channel
+	var panicErr error
+	for i, channel := range tokenChannels {
+		source, channel := i, channel
 		wg.Add(1)
 		go func() {
-			resultingChain := buildChain(channel)
+			defer wg.Done()
+			defer recoverPanic(func(err error) {
+				chainTex.Lock()
+				panicErr = err
+				chainTex.Unlock()
+				// keep draining so the source's reader isn't blocked forever
+				for range channel {
+				}
+			})
+
+			resultingChain := buildChain(opts, source, channel)
+			if weights != nil {
+				resultingChain.scale(weights[source])
+			}
 			chainTex.Lock()
 			chainSlice = append(chainSlice, resultingChain)
 			chainTex.Unlock()
-			wg.Done()
 		}()
 	}
 	wg.Wait()
 
-	chainChannel <- mergeChains(chainSlice...)
-	close(chainChannel)
+	defer close(chainChannel)
+	if panicErr != nil {
+		if errorChannel != nil {
+			errorChannel <- panicErr
+		}
+		return
+	}
+
+	merged := mergeChains(chainSlice...)
+	if limiter := newMergeLimiter(opts, merged); limiter.limited() {
+		limiter.enforce(merged)
+	}
+
+	chainChannel <- merged
 }
 
 func mergeChains(chains ...*singleKeyChain) *singleKeyChain {
-	mergedLinks := make(map[string]*singleTokenLink)
-
+	// the largest chain's links are a lower bound on the merged links
+	links := 0
 	for _, chain := range chains {
-		for _, link := range chain.Links {
-			key := link.Token[0]
-			var mergedLink *singleTokenLink
-			if extantLink, ok := mergedLinks[key]; !ok {
-				mergedLink = &singleTokenLink{
-					Token:                [1]string{key},
-					NextTokenOccurrences: make(map[string]int),
-				}
-			} else {
-				mergedLink = extantLink
-			}
-
-			mergedLink.Total += link.Total
-			for k, v := range link.NextTokenOccurrences {
-				mergedLink.NextTokenOccurrences[k] += v
-			}
-
-			mergedLinks[key] = mergedLink
+		if len(chain.Links) > links {
+			links = len(chain.Links)
 		}
 	}
-
-	return &singleKeyChain{
-		Links: mergedLinks,
+	merged := newBuildChain(links)
+	for _, chain := range chains {
+		merged.mergeFrom(chain)
 	}
+	merged.compact()
+
+	return merged
 }
 
 func (l *singleTokenLink) GetNextToken(rand *rand.Rand) string {
+	countEvent(counterSamplesDrawn, l.Token[0])
+	// a link without occurrences can only end the sequence
+	if l.Total <= 0 {
+		return ""
+	}
+	if l.Cumulative != nil {
+		return l.Cumulative.sample(rand)
+	}
 	goalSum := rand.Intn(l.Total)
 
+	// without a table the counts are scanned in map order, which is random,
+	// so even if the goal sum is the same the resulting value may not be.
+	// Each token is chosen when the goal falls within its count, so it is
+	// chosen in proportion to its count whatever the order
 	sum := 0
-	// note that ranging over a map is a random operation,
-	// so even if the goal sum is the same, the resulting
-	// value may not be
 	for k, v := range l.NextTokenOccurrences {
 		sum += v
-		if sum >= goalSum {
+		if sum > goalSum {
 			return k
 		}
 	}
@@ -174,7 +578,18 @@ func (l *singleTokenLink) RetrieveNextTokenPossibilities() (nextTokens []string)
 func (l *singleTokenLink) GetProbabilityOfToken(nextToken string) (nextTokenProbability float64, tokenPresent bool) {
 	if occurrences, ok := l.NextTokenOccurrences[nextToken]; !ok {
 		return 0.0, false
+	} else if l.Total <= 0 {
+		return 0.0, true
 	} else {
 		return float64(occurrences) / float64(l.Total), true
 	}
 }
+
+func (l *singleTokenLink) GetOccurrencesOfToken(nextToken string) (occurrences int, tokenPresent bool) {
+	occurrences, tokenPresent = l.NextTokenOccurrences[nextToken]
+	return occurrences, tokenPresent
+}
+
+func (l *singleTokenLink) GetTotalOccurrences() int {
+	return l.Total
+}
@@ -0,0 +1,30 @@
+package chain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIncrementRejectsInvalidCounts(t *testing.T) {
+	chain := NewWritableChain()
+	if incErr := chain.Increment("a", "b", 2); incErr != nil {
+		t.Fatal(incErr)
+	}
+	for _, n := range []int{0, -5} {
+		if incErr := chain.Increment("a", "c", n); !errors.Is(incErr, ErrInvalidCount) {
+			t.Fatalf("incrementing by %d returned %v", n, incErr)
+		}
+	}
+	link, _ := chain.RetrieveMarkovLink("a")
+	if total := link.(CountedLink).GetTotalOccurrences(); total != 2 {
+		t.Fatalf("total %d after rejected increments, want 2", total)
+	}
+	if _, ok := link.GetProbabilityOfToken("c"); ok {
+		t.Fatal("rejected increment added a successor")
+	}
+
+	sketch := NewSketchChain(SketchOptions{})
+	if incErr := sketch.Increment("a", "c", -5); !errors.Is(incErr, ErrInvalidCount) {
+		t.Fatalf("sketch incrementing by -5 returned %v", incErr)
+	}
+}
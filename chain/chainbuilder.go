@@ -0,0 +1,173 @@
+package chain
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ChainBuilder incrementally builds a chain, so a long running service can
+// keep training its model as new data arrives rather than rebuilding it.
+// BuildOptions are applied to every sequence added, except those that only
+// concern reading sources concurrently. A ChainBuilder is safe for
+// concurrent use
+type ChainBuilder struct {
+	opts       BuildOptions
+	builderTex sync.Mutex
+	chain      *singleKeyChain
+	limiter    *chainLimiter
+	pending    []string
+	sequences  int
+	// stream is the sequence being added token by token, if any
+	stream *sequenceBuilder
+}
+
+// NewChainBuilder creates a ChainBuilder starting from an empty chain
+func NewChainBuilder(opts ...BuildOption) *ChainBuilder {
+	options := applyBuildOptions(opts)
+	return &ChainBuilder{
+		opts:    options,
+		chain:   newSingleKeyChain(),
+		limiter: newChainLimiter(options),
+	}
+}
+
+// NewChainBuilderFrom creates a ChainBuilder that continues training a copy
+// of an existing chain, which must expose its tokens and counts
+func NewChainBuilderFrom(chain MarkovChain, opts ...BuildOption) (*ChainBuilder, error) {
+	options := applyBuildOptions(opts)
+	if order := chainOrder(chain); order != options.order() {
+		return nil, fmt.Errorf("chain: can't continue a chain of order %d with order %d", order, options.order())
+	}
+	start := newSingleKeyChain()
+	if copyErr := Copy(start, chain); copyErr != nil {
+		return nil, copyErr
+	}
+	if lengths, ok := chain.(LengthModel); ok && lengths.SequenceLengths() != nil {
+		start.Lengths.merge(lengths.SequenceLengths())
+	}
+
+	return &ChainBuilder{
+		opts:    options,
+		chain:   start,
+		limiter: newMergeLimiter(options, start),
+	}, nil
+}
+
+// AddToken appends a token to the pending sequence, which is added to the
+// chain by EndSequence
+func (b *ChainBuilder) AddToken(token string) {
+	b.builderTex.Lock()
+	defer b.builderTex.Unlock()
+	b.pending = append(b.pending, token)
+}
+
+// EndSequence adds the pending sequence to the chain and starts a new one
+func (b *ChainBuilder) EndSequence() error {
+	b.builderTex.Lock()
+	defer b.builderTex.Unlock()
+	return b.endSequence()
+}
+
+// endSequence adds the pending sequence. The caller must hold the lock
+func (b *ChainBuilder) endSequence() error {
+	tokens := b.pending
+	b.pending = nil
+	if len(b.opts.Filters) > 0 {
+		filtered, filterErr := CollectTokens(ApplyFiltersToSource(NewSliceSource(tokens), b.opts.Filters...))
+		if filterErr != nil {
+			return filterErr
+		}
+		tokens = filtered
+	}
+	if b.opts.Deduplicator != nil {
+		tokens = b.opts.Deduplicator.dropDuplicates(tokens)
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	countEvent(counterTrainerAdds, tokens[0])
+	builder := newSequenceBuilder(b.opts, b.chain, b.limiter, b.sequences)
+	for _, token := range tokens {
+		builder.add(token)
+	}
+	builder.finish()
+	b.sequences++
+	return nil
+}
+
+// AddSource reads the source until it is exhausted and adds its tokens to
+// the chain as a single sequence, separate from the pending sequence
+func (b *ChainBuilder) AddSource(source TokenSource) error {
+	tokens, drainErr := drainSource(source)
+	if drainErr != nil {
+		return drainErr
+	}
+	return b.Train(tokens)
+}
+
+// Train adds a sequence of tokens to the chain, separate from the pending
+// sequence, so a ChainBuilder can be used as a Trainer
+func (b *ChainBuilder) Train(tokens []string) error {
+	b.builderTex.Lock()
+	defer b.builderTex.Unlock()
+
+	pending := b.pending
+	b.pending = append([]string(nil), tokens...)
+	trainErr := b.endSequence()
+	b.pending = pending
+	return trainErr
+}
+
+// Finish adds the pending sequence and returns a copy of the chain built so
+// far. Training may continue afterwards, e.g. to periodically publish an
+// updated model while the previous one is still being queried
+func (b *ChainBuilder) Finish() (MarkovChain, error) {
+	b.builderTex.Lock()
+	defer b.builderTex.Unlock()
+
+	if endErr := b.endSequence(); endErr != nil {
+		return nil, endErr
+	}
+	return b.opts.withOrder(mergeChains(b.chain)), nil
+}
+
+// streamSequence starts adding a sequence token by token rather than
+// buffering it, for sequences too long to hold in memory. The sequence may
+// continue one started earlier, from the key last after length tokens.
+// Filters and the Deduplicator aren't applied
+func (b *ChainBuilder) streamSequence(last string, length int) {
+	b.builderTex.Lock()
+	defer b.builderTex.Unlock()
+	b.stream = newSequenceBuilder(b.opts, b.chain, b.limiter, b.sequences)
+	b.stream.resume(last, length)
+}
+
+// streamTokens adds tokens to the streamed sequence
+func (b *ChainBuilder) streamTokens(tokens []string) {
+	b.builderTex.Lock()
+	defer b.builderTex.Unlock()
+	for _, token := range tokens {
+		b.stream.add(token)
+	}
+}
+
+// streamPosition retrieves the key the streamed sequence has reached and
+// its length, so it can be continued later
+func (b *ChainBuilder) streamPosition() (string, int) {
+	b.builderTex.Lock()
+	defer b.builderTex.Unlock()
+	return b.stream.position()
+}
+
+// endStream finishes the streamed sequence
+func (b *ChainBuilder) endStream() {
+	b.builderTex.Lock()
+	defer b.builderTex.Unlock()
+	if b.stream.length > 0 {
+		countEvent(counterTrainerAdds, b.stream.lastVal)
+	}
+	b.stream.finish()
+	b.stream = nil
+	b.sequences++
+}
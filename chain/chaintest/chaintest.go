@@ -0,0 +1,222 @@
+// Package chaintest provides helpers for testing code built on the chain
+// package: assertions over chains and distributions, scripted sources and
+// filters for exercising error handling, and fakes of the core interfaces
+// that record the calls made to them
+package chaintest
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/lvanoort/markov/chain"
+)
+
+// transitionCounts collects the counts of every transition of a chain
+func transitionCounts(c chain.MarkovChain) (map[[2]string]int, error) {
+	iterable, ok := c.(chain.IterableChain)
+	if !ok {
+		return nil, chain.ErrUncountableChain
+	}
+
+	counts := make(map[[2]string]int)
+	for _, token := range iterable.RetrieveTokens() {
+		link, ok := c.RetrieveMarkovLink(token)
+		if !ok {
+			continue
+		}
+		counted, ok := link.(chain.CountedLink)
+		if !ok {
+			return nil, chain.ErrUncountableChain
+		}
+		for _, next := range counted.RetrieveNextTokenPossibilities() {
+			count, _ := counted.GetOccurrencesOfToken(next)
+			counts[[2]string{token, next}] = count
+		}
+	}
+
+	return counts, nil
+}
+
+// ChainDiff describes how two chains differ, one line per transition whose
+// counts don't match, in sorted order. It is empty if they are equal
+func ChainDiff(want chain.MarkovChain, got chain.MarkovChain) ([]string, error) {
+	wantCounts, wantErr := transitionCounts(want)
+	if wantErr != nil {
+		return nil, wantErr
+	}
+	gotCounts, gotErr := transitionCounts(got)
+	if gotErr != nil {
+		return nil, gotErr
+	}
+
+	diffs := make([]string, 0)
+	for transition, count := range wantCounts {
+		if gotCounts[transition] != count {
+			diffs = append(diffs, fmt.Sprintf("%q -> %q: want %d, got %d", transition[0], transition[1], count, gotCounts[transition]))
+		}
+	}
+	for transition, count := range gotCounts {
+		if _, ok := wantCounts[transition]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%q -> %q: want 0, got %d", transition[0], transition[1], count))
+		}
+	}
+	sort.Strings(diffs)
+
+	return diffs, nil
+}
+
+// AssertChainsEqual fails the test unless both chains hold exactly the same
+// transitions with the same counts
+func AssertChainsEqual(t testing.TB, want chain.MarkovChain, got chain.MarkovChain) {
+	t.Helper()
+	diffs, diffErr := ChainDiff(want, got)
+	if diffErr != nil {
+		t.Fatalf("comparing chains: %v", diffErr)
+	}
+	for _, diff := range diffs {
+		t.Errorf("chains differ: %s", diff)
+	}
+}
+
+// AssertDistributionClose fails the test unless the probability of each
+// token following token is within tolerance of want. Tokens missing from
+// want are expected to have a probability of zero
+func AssertDistributionClose(t testing.TB, c chain.MarkovChain, token string, want map[string]float64, tolerance float64) {
+	t.Helper()
+	link, ok := c.RetrieveMarkovLink(token)
+	if !ok {
+		t.Fatalf("token %q not in chain", token)
+	}
+
+	got := make(map[string]float64)
+	for _, next := range link.RetrieveNextTokenPossibilities() {
+		got[next], _ = link.GetProbabilityOfToken(next)
+	}
+	assertClose(t, token, want, got, tolerance)
+}
+
+// AssertSamplesClose samples the token following token n times and fails
+// the test unless the observed frequencies are within tolerance of want,
+// which checks a sampler rather than the stored probabilities
+func AssertSamplesClose(t testing.TB, c chain.MarkovChain, token string, want map[string]float64, tolerance float64, n int, rand *rand.Rand) {
+	t.Helper()
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		next, ok := c.CalculateNextToken(token, rand)
+		if !ok {
+			t.Fatalf("token %q not in chain", token)
+		}
+		counts[next]++
+	}
+
+	got := make(map[string]float64, len(counts))
+	for next, count := range counts {
+		got[next] = float64(count) / float64(n)
+	}
+	assertClose(t, token, want, got, tolerance)
+}
+
+func assertClose(t testing.TB, token string, want map[string]float64, got map[string]float64, tolerance float64) {
+	t.Helper()
+	keys := make([]string, 0, len(want)+len(got))
+	for next := range want {
+		keys = append(keys, next)
+	}
+	for next := range got {
+		if _, ok := want[next]; !ok {
+			keys = append(keys, next)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, next := range keys {
+		if math.Abs(want[next]-got[next]) > tolerance {
+			t.Errorf("%q -> %q: want probability %.4f, got %.4f", token, next, want[next], got[next])
+		}
+	}
+}
+
+// Step is a single result of a ScriptedSource, either a token or an error
+type Step struct {
+	Token string
+	Err   error
+}
+
+// Tokens creates steps returning each of the tokens
+func Tokens(tokens ...string) []Step {
+	steps := make([]Step, 0, len(tokens))
+	for _, token := range tokens {
+		steps = append(steps, Step{Token: token})
+	}
+	return steps
+}
+
+// ScriptedSource is a chain.TokenSource returning a scripted sequence of
+// tokens and errors, then io.EOF. It is safe for concurrent use
+type ScriptedSource struct {
+	sourceTex sync.Mutex
+	steps     []Step
+	calls     int
+}
+
+// NewScriptedSource creates a ScriptedSource following the steps
+func NewScriptedSource(steps ...Step) *ScriptedSource {
+	return &ScriptedSource{steps: steps}
+}
+
+func (s *ScriptedSource) NextToken() (string, error) {
+	s.sourceTex.Lock()
+	defer s.sourceTex.Unlock()
+	s.calls++
+	if len(s.steps) == 0 {
+		return "", io.EOF
+	}
+
+	step := s.steps[0]
+	s.steps = s.steps[1:]
+	return step.Token, step.Err
+}
+
+// Calls retrieves the number of times NextToken was called
+func (s *ScriptedSource) Calls() int {
+	s.sourceTex.Lock()
+	defer s.sourceTex.Unlock()
+	return s.calls
+}
+
+// ScriptedFilter is a chain.SourceFilter that fails for the candidates in
+// Errors, replaces the candidates in Outputs and passes through everything
+// else. Its maps must not be modified once it is in use. It is safe for
+// concurrent use
+type ScriptedFilter struct {
+	Outputs   map[string][]string
+	Errors    map[string]error
+	filterTex sync.Mutex
+	seen      []string
+}
+
+func (f *ScriptedFilter) FilterToken(candidate string) ([]string, error) {
+	f.filterTex.Lock()
+	f.seen = append(f.seen, candidate)
+	f.filterTex.Unlock()
+
+	if err, ok := f.Errors[candidate]; ok {
+		return nil, err
+	}
+	if output, ok := f.Outputs[candidate]; ok {
+		return output, nil
+	}
+	return []string{candidate}, nil
+}
+
+// Seen retrieves the candidates the filter was called with, in order
+func (f *ScriptedFilter) Seen() []string {
+	f.filterTex.Lock()
+	defer f.filterTex.Unlock()
+	return append([]string(nil), f.seen...)
+}
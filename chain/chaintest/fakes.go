@@ -0,0 +1,140 @@
+package chaintest
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+
+	"github.com/lvanoort/markov/chain"
+)
+
+// Call is a method call recorded by a fake
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Recorder records the calls made to a fake, it is safe for concurrent use
+type Recorder struct {
+	recorderTex sync.Mutex
+	calls       []Call
+}
+
+func (r *Recorder) record(method string, args ...interface{}) {
+	r.recorderTex.Lock()
+	defer r.recorderTex.Unlock()
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+}
+
+// Calls retrieves the recorded calls in the order they were made
+func (r *Recorder) Calls() []Call {
+	r.recorderTex.Lock()
+	defer r.recorderTex.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// CallCount counts the recorded calls of a method
+func (r *Recorder) CallCount(method string) int {
+	r.recorderTex.Lock()
+	defer r.recorderTex.Unlock()
+	count := 0
+	for _, call := range r.calls {
+		if call.Method == method {
+			count++
+		}
+	}
+	return count
+}
+
+// FakeSource is a chain.TokenSource that delegates to NextTokenFunc, it
+// returns io.EOF if NextTokenFunc is nil
+type FakeSource struct {
+	Recorder
+	NextTokenFunc func() (string, error)
+}
+
+func (s *FakeSource) NextToken() (string, error) {
+	s.record("NextToken")
+	if s.NextTokenFunc == nil {
+		return "", io.EOF
+	}
+	return s.NextTokenFunc()
+}
+
+// FakeFilter is a chain.SourceFilter that delegates to FilterTokenFunc, it
+// passes candidates through if FilterTokenFunc is nil
+type FakeFilter struct {
+	Recorder
+	FilterTokenFunc func(candidate string) ([]string, error)
+}
+
+func (f *FakeFilter) FilterToken(candidate string) ([]string, error) {
+	f.record("FilterToken", candidate)
+	if f.FilterTokenFunc == nil {
+		return []string{candidate}, nil
+	}
+	return f.FilterTokenFunc(candidate)
+}
+
+// FakeLink is a chain.MarkovChainLink with canned results. GetNextToken
+// always returns Next, and probabilities are looked up in Probabilities
+type FakeLink struct {
+	Recorder
+	Next          string
+	Probabilities map[string]float64
+}
+
+func (l *FakeLink) GetNextToken(rand *rand.Rand) string {
+	l.record("GetNextToken")
+	return l.Next
+}
+
+func (l *FakeLink) RetrieveNextTokenPossibilities() (nextTokens []string) {
+	l.record("RetrieveNextTokenPossibilities")
+	nextTokens = make([]string, 0, len(l.Probabilities))
+	for next := range l.Probabilities {
+		nextTokens = append(nextTokens, next)
+	}
+	return nextTokens
+}
+
+func (l *FakeLink) GetProbabilityOfToken(nextToken string) (nextTokenProbability float64, tokenPresent bool) {
+	l.record("GetProbabilityOfToken", nextToken)
+	nextTokenProbability, tokenPresent = l.Probabilities[nextToken]
+	return nextTokenProbability, tokenPresent
+}
+
+// FakeChain is a chain.MarkovChain whose links are the FakeLinks in Links.
+// Links must not be modified once the chain is in use
+type FakeChain struct {
+	Recorder
+	Links map[string]*FakeLink
+}
+
+func (c *FakeChain) CalculateNextToken(token string, rand *rand.Rand) (nextToken string, keyPresent bool) {
+	c.record("CalculateNextToken", token)
+	link, ok := c.Links[token]
+	if !ok {
+		return "", false
+	}
+	return link.GetNextToken(rand), true
+}
+
+func (c *FakeChain) RetrieveMarkovLink(token string) (link chain.MarkovChainLink, keyPresent bool) {
+	c.record("RetrieveMarkovLink", token)
+	fake, ok := c.Links[token]
+	if !ok {
+		return nil, false
+	}
+	return fake, true
+}
+
+// compile time checks that the fakes implement their interfaces
+var (
+	_ chain.TokenSource     = (*FakeSource)(nil)
+	_ chain.TokenSource     = (*ScriptedSource)(nil)
+	_ chain.SourceFilter    = (*FakeFilter)(nil)
+	_ chain.SourceFilter    = (*ScriptedFilter)(nil)
+	_ chain.MarkovChainLink = (*FakeLink)(nil)
+	_ chain.MarkovChain     = (*FakeChain)(nil)
+)
@@ -0,0 +1,217 @@
+// Package client accesses a chain served by the server package, it
+// implements chain.MarkovChain and chain.Trainer so a remote chain can be
+// used in place of a local one
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/lvanoort/markov/chain"
+	"github.com/lvanoort/markov/chain/server"
+)
+
+// APIError is returned when the server responds with an error
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("client: server responded %d: %s", e.StatusCode, e.Message)
+}
+
+// Client accesses a remote chain. Methods of chain.MarkovChain can't return
+// errors, so they report a missing key when a request fails and record the
+// error to be retrieved with Err
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	errTex     sync.Mutex
+	lastErr    error
+}
+
+// New creates a Client for the server at baseURL, http.DefaultClient is used
+// if httpClient is nil
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// Err retrieves and clears the error from the last failed chain.MarkovChain
+// method call
+func (c *Client) Err() error {
+	c.errTex.Lock()
+	defer c.errTex.Unlock()
+	err := c.lastErr
+	c.lastErr = nil
+	return err
+}
+
+func (c *Client) recordErr(err error) {
+	c.errTex.Lock()
+	defer c.errTex.Unlock()
+	c.lastErr = err
+}
+
+// call makes a request to an endpoint, decoding the response into resp
+func (c *Client) call(ctx context.Context, method string, path string, req interface{}, resp interface{}) error {
+	var body bytes.Buffer
+	if req != nil {
+		if encodeErr := json.NewEncoder(&body).Encode(req); encodeErr != nil {
+			return encodeErr
+		}
+	}
+
+	httpReq, reqErr := http.NewRequestWithContext(ctx, method, c.baseURL+path, &body)
+	if reqErr != nil {
+		return reqErr
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, doErr := c.httpClient.Do(httpReq)
+	if doErr != nil {
+		return doErr
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		apiErr := &APIError{StatusCode: httpResp.StatusCode}
+		errorBody := struct {
+			Error string `json:"error"`
+		}{}
+		if json.NewDecoder(httpResp.Body).Decode(&errorBody) == nil {
+			apiErr.Message = errorBody.Error
+		}
+		return apiErr
+	}
+
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// Link retrieves the tokens following a token from the server
+func (c *Client) Link(ctx context.Context, token string) (link chain.MarkovChainLink, keyPresent bool, err error) {
+	resp := server.LinkResponse{}
+	if callErr := c.call(ctx, http.MethodPost, "/link", server.LinkRequest{Token: token}, &resp); callErr != nil {
+		return nil, false, callErr
+	}
+	return localLink(token, resp)
+}
+
+// localLink builds a local single link chain from a link response so
+// sampling matches a local chain
+func localLink(token string, resp server.LinkResponse) (chain.MarkovChainLink, bool, error) {
+	if !resp.Present {
+		return nil, false, nil
+	}
+
+	local := chain.NewWritableChain()
+	for next, count := range resp.Occurrences {
+		if incErr := local.Increment(token, next, count); incErr != nil {
+			return nil, false, incErr
+		}
+	}
+	link, keyPresent := local.RetrieveMarkovLink(token)
+	return link, keyPresent, nil
+}
+
+// Links retrieves the tokens following each of many tokens from the server
+// in a single request
+func (c *Client) Links(ctx context.Context, tokens []string) ([]chain.MarkovChainLink, []bool, error) {
+	resp := server.LinksResponse{}
+	if callErr := c.call(ctx, http.MethodPost, "/links", server.LinksRequest{Tokens: tokens}, &resp); callErr != nil {
+		return nil, nil, callErr
+	}
+	if len(resp.Links) != len(tokens) {
+		return nil, nil, fmt.Errorf("client: %d links returned for %d tokens", len(resp.Links), len(tokens))
+	}
+
+	links := make([]chain.MarkovChainLink, len(tokens))
+	present := make([]bool, len(tokens))
+	for i, token := range tokens {
+		var linkErr error
+		if links[i], present[i], linkErr = localLink(token, resp.Links[i]); linkErr != nil {
+			return nil, nil, linkErr
+		}
+	}
+	return links, present, nil
+}
+
+// RetrieveMarkovLinks retrieves the links for many tokens from the server
+// in a single request
+func (c *Client) RetrieveMarkovLinks(tokens []string) ([]chain.MarkovChainLink, []bool) {
+	links, present, linksErr := c.Links(context.Background(), tokens)
+	if linksErr != nil {
+		c.recordErr(linksErr)
+		return make([]chain.MarkovChainLink, len(tokens)), make([]bool, len(tokens))
+	}
+	return links, present
+}
+
+// RetrieveMarkovLink retrieves the link for a token from the server
+func (c *Client) RetrieveMarkovLink(token string) (link chain.MarkovChainLink, keyPresent bool) {
+	link, keyPresent, linkErr := c.Link(context.Background(), token)
+	if linkErr != nil {
+		c.recordErr(linkErr)
+		return nil, false
+	}
+	return link, keyPresent
+}
+
+// CalculateNextToken retrieves the link for a token from the server and
+// samples it locally with rand
+func (c *Client) CalculateNextToken(token string, rand *rand.Rand) (nextToken string, keyPresent bool) {
+	link, keyPresent := c.RetrieveMarkovLink(token)
+	if !keyPresent {
+		return "", false
+	}
+	return link.GetNextToken(rand), true
+}
+
+// Train adds a sequence of tokens to the remote chain
+func (c *Client) Train(tokens []string) error {
+	return c.TrainContext(context.Background(), tokens)
+}
+
+// TrainContext adds sequences of tokens to the remote chain
+func (c *Client) TrainContext(ctx context.Context, sequences ...[]string) error {
+	resp := server.TrainResponse{}
+	return c.call(ctx, http.MethodPost, "/train", server.TrainRequest{Sequences: sequences}, &resp)
+}
+
+// Generate generates a sequence on the server, which is cheaper than
+// generating locally from a Client as it needs a single request
+func (c *Client) Generate(ctx context.Context, start string, maxTokens int) ([]string, error) {
+	resp := server.GenerateResponse{}
+	req := server.GenerateRequest{Start: start, MaxTokens: maxTokens}
+	if callErr := c.call(ctx, http.MethodPost, "/generate", req, &resp); callErr != nil {
+		return nil, callErr
+	}
+	return resp.Tokens, nil
+}
+
+// Score scores a sequence of tokens on the server
+func (c *Client) Score(ctx context.Context, tokens []string) (server.ScoreResponse, error) {
+	resp := server.ScoreResponse{}
+	callErr := c.call(ctx, http.MethodPost, "/score", server.ScoreRequest{Tokens: tokens}, &resp)
+	return resp, callErr
+}
+
+// Stats retrieves statistics about the remote chain
+func (c *Client) Stats(ctx context.Context) (server.StatsResponse, error) {
+	resp := server.StatsResponse{}
+	callErr := c.call(ctx, http.MethodGet, "/stats", nil, &resp)
+	return resp, callErr
+}
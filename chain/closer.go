@@ -0,0 +1,90 @@
+package chain
+
+import (
+	"context"
+	"io"
+)
+
+// Sources that implement io.Closer, e.g. those reading from files or HTTP
+// responses, are closed by the package's builders once they have been
+// exhausted or have failed, so callers don't need to track them. Wrapping
+// sources, such as filtered and combined sources, close the sources they
+// wrap
+
+// closeSource closes a source if it implements io.Closer
+func closeSource(source TokenSource) error {
+	if closer, ok := source.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// closeSources closes every source that implements io.Closer, returning the
+// first error
+func closeSources(sources []TokenSource) error {
+	var firstErr error
+	for _, v := range sources {
+		if closeErr := closeSource(v); closeErr != nil && firstErr == nil {
+			firstErr = closeErr
+		}
+	}
+	return firstErr
+}
+
+// drainSource reads a source until it is exhausted and then closes it
+func drainSource(source TokenSource) ([]string, error) {
+	tokens, collectErr := CollectTokens(source)
+	closeErr := closeSource(source)
+	if collectErr != nil {
+		return nil, collectErr
+	}
+
+	return tokens, closeErr
+}
+
+// drainSourceContext reads a source until it is exhausted or ctx is done
+// and then closes it
+func drainSourceContext(ctx context.Context, source TokenSource) ([]string, error) {
+	tokens := make([]string, 0)
+	for {
+		token, readErr := nextTokenContext(ctx, source)
+		if readErr == io.EOF {
+			return tokens, closeSource(source)
+		} else if readErr != nil {
+			closeSource(source)
+			return nil, readErr
+		}
+		tokens = append(tokens, token)
+	}
+}
+
+type closingSource struct {
+	src    TokenSource
+	closer io.Closer
+}
+
+func (s *closingSource) NextToken() (string, error) {
+	return s.src.NextToken()
+}
+
+func (s *closingSource) NextTokenContext(ctx context.Context) (string, error) {
+	return nextTokenContext(ctx, s.src)
+}
+
+func (s *closingSource) Close() error {
+	srcErr := closeSource(s.src)
+	closeErr := s.closer.Close()
+	if srcErr != nil {
+		return srcErr
+	}
+	return closeErr
+}
+
+// MakeClosingSource attaches a closer to a source, e.g. the file a scanner
+// source reads from, so it is closed along with the source
+func MakeClosingSource(source TokenSource, closer io.Closer) TokenSource {
+	return &closingSource{
+		src:    source,
+		closer: closer,
+	}
+}
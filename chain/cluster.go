@@ -0,0 +1,195 @@
+package chain
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// ClusterOptions configures ClusterStates
+type ClusterOptions struct {
+	// K is the number of clusters
+	K int
+
+	// Measure compares successor distributions
+	Measure SimilarityMeasure
+
+	// MaxIterations bounds the k-means iterations, defaults to 20
+	MaxIterations int
+
+	// MinOccurrences leaves out states that occurred fewer times, as their
+	// distributions are too sparse to cluster meaningfully
+	MinOccurrences int
+}
+
+// StateCluster is a group of states with similar successor distributions
+type StateCluster struct {
+	// Representative is the most frequent member
+	Representative string
+	// Members are sorted and include the representative
+	Members []string
+}
+
+type clusterState struct {
+	token        string
+	total        int
+	distribution stateDistribution
+}
+
+// centroidOf averages the distributions of states
+func centroidOf(states []*clusterState) stateDistribution {
+	centroid := make(stateDistribution)
+	for _, state := range states {
+		for token, p := range state.distribution {
+			centroid[token] += p / float64(len(states))
+		}
+	}
+	return centroid
+}
+
+// ClusterStates groups the states of a chain by the similarity of their
+// successor distributions using k-means, seeded from rand with k-means++.
+// The boundary state is never clustered
+func ClusterStates(chain MarkovChain, opts ClusterOptions, rand *rand.Rand) ([]StateCluster, error) {
+	iterable, ok := chain.(IterableChain)
+	if !ok {
+		return nil, ErrUncountableChain
+	}
+	maxIterations := opts.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = 20
+	}
+
+	tokens := iterable.RetrieveTokens()
+	sort.Strings(tokens)
+	states := make([]*clusterState, 0, len(tokens))
+	for _, token := range tokens {
+		link, ok := chain.RetrieveMarkovLink(token)
+		if token == "" || !ok {
+			continue
+		}
+		distribution, distributionErr := distributionOf(link)
+		if distributionErr != nil {
+			return nil, distributionErr
+		}
+		total := link.(CountedLink).GetTotalOccurrences()
+		if total < opts.MinOccurrences || total <= 0 {
+			continue
+		}
+		states = append(states, &clusterState{token: token, total: total, distribution: distribution})
+	}
+	if len(states) == 0 || opts.K <= 0 {
+		return []StateCluster{}, nil
+	}
+
+	k := opts.K
+	if k > len(states) {
+		k = len(states)
+	}
+
+	// k-means++ chooses each further centroid with probability proportional
+	// to its squared distance from the nearest centroid chosen so far
+	centroids := []stateDistribution{states[rand.Intn(len(states))].distribution}
+	for len(centroids) < k {
+		distances := make([]float64, len(states))
+		total := 0.0
+		for i, state := range states {
+			nearest := 1.0
+			for _, centroid := range centroids {
+				if d := 1 - opts.Measure.compare(state.distribution, centroid); d < nearest {
+					nearest = d
+				}
+			}
+			distances[i] = nearest * nearest
+			total += distances[i]
+		}
+		if total <= 0 {
+			break
+		}
+
+		goal := rand.Float64() * total
+		chosen := len(states) - 1
+		for i, d := range distances {
+			goal -= d
+			if goal < 0 {
+				chosen = i
+				break
+			}
+		}
+		centroids = append(centroids, states[chosen].distribution)
+	}
+
+	assignments := make([]int, len(states))
+	for i := range assignments {
+		assignments[i] = -1
+	}
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		changed := false
+		for i, state := range states {
+			best, bestSimilarity := 0, -1.0
+			for c, centroid := range centroids {
+				if similarity := opts.Measure.compare(state.distribution, centroid); similarity > bestSimilarity {
+					best, bestSimilarity = c, similarity
+				}
+			}
+			if assignments[i] != best {
+				assignments[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		members := make([][]*clusterState, len(centroids))
+		for i, state := range states {
+			members[assignments[i]] = append(members[assignments[i]], state)
+		}
+		for c := range centroids {
+			if len(members[c]) > 0 {
+				centroids[c] = centroidOf(members[c])
+			}
+		}
+	}
+
+	grouped := make(map[int]*StateCluster)
+	representativeTotals := make(map[int]int)
+	for i, state := range states {
+		cluster, ok := grouped[assignments[i]]
+		if !ok {
+			cluster = &StateCluster{}
+			grouped[assignments[i]] = cluster
+		}
+		cluster.Members = append(cluster.Members, state.token)
+		// states are sorted, so ties keep the first token
+		if state.total > representativeTotals[assignments[i]] {
+			cluster.Representative = state.token
+			representativeTotals[assignments[i]] = state.total
+		}
+	}
+
+	clusters := make([]StateCluster, 0, len(grouped))
+	for _, cluster := range grouped {
+		clusters = append(clusters, *cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Representative < clusters[j].Representative
+	})
+
+	return clusters, nil
+}
+
+// CollapseClusters merges the members of each cluster into its
+// representative, both as states and as successors, shrinking the chain at
+// the cost of emitting the representative in place of its members
+func CollapseClusters(chain WritableChain, clusters []StateCluster) error {
+	aliases := make(map[string]string)
+	for _, cluster := range clusters {
+		for _, member := range cluster.Members {
+			if member != cluster.Representative {
+				aliases[member] = cluster.Representative
+			}
+		}
+	}
+
+	return MergeStates(chain, aliases)
+}
@@ -0,0 +1,130 @@
+package chain
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Codec encodes and decodes chains in a particular format, codecs are
+// registered by name so formats can be added without changes to this
+// package and selected by name, e.g. from a command line flag
+type Codec interface {
+	// Encode writes the chain to w
+	Encode(chain MarkovChain, w io.Writer) error
+
+	// Decode reads a chain written by Encode
+	Decode(r io.Reader) (MarkovChain, error)
+}
+
+type funcCodec struct {
+	encode func(MarkovChain, io.Writer) error
+	decode func(io.Reader) (MarkovChain, error)
+}
+
+func (c *funcCodec) Encode(chain MarkovChain, w io.Writer) error {
+	return c.encode(chain, w)
+}
+
+func (c *funcCodec) Decode(r io.Reader) (MarkovChain, error) {
+	return c.decode(r)
+}
+
+// MakeFuncCodec converts a pair of functions into a Codec
+func MakeFuncCodec(encode func(MarkovChain, io.Writer) error, decode func(io.Reader) (MarkovChain, error)) Codec {
+	return &funcCodec{
+		encode: encode,
+		decode: decode,
+	}
+}
+
+// envelopeCodec encodes chains with WriteChain, decoding them with the
+// default limits as the input may be untrusted
+func envelopeCodec(opts EncodeOptions) Codec {
+	return MakeFuncCodec(func(chain MarkovChain, w io.Writer) error {
+		return WriteChain(w, chain, opts)
+	}, func(r io.Reader) (MarkovChain, error) {
+		return LoadChain(r, DefaultDecodeOptions())
+	})
+}
+
+// importCodec decodes chains by importing them into an empty chain
+func importCodec(encode func(io.Writer, MarkovChain) error, decode func(io.Reader, WritableChain) error) Codec {
+	return MakeFuncCodec(func(chain MarkovChain, w io.Writer) error {
+		return encode(w, chain)
+	}, func(r io.Reader) (MarkovChain, error) {
+		chain := NewWritableChain()
+		if decodeErr := decode(r, chain); decodeErr != nil {
+			return nil, decodeErr
+		}
+		return chain, nil
+	})
+}
+
+// streamCodec encodes chains with WriteChainStream. A stream records only
+// links, so chains of a higher order or with normalized keys, which couldn't
+// be restored from one, are refused
+func streamCodec() Codec {
+	return MakeFuncCodec(func(chain MarkovChain, w io.Writer) error {
+		if _, ok := chain.(*normalizedChain); ok || chainOrder(chain) > 1 {
+			return fmt.Errorf("chain: the stream format holds only single token chains")
+		}
+		return WriteChainStream(w, chain)
+	}, func(r io.Reader) (MarkovChain, error) {
+		return LoadChain(r, DefaultDecodeOptions())
+	})
+}
+
+// defaultShards is the number of shards written by the sharded codec, enough
+// to keep most machines' cores busy while decoding
+const defaultShards = 16
+
+var (
+	codecTex      sync.RWMutex
+	codecRegistry = map[string]Codec{
+		"json":    envelopeCodec(EncodeOptions{}),
+		"compact": envelopeCodec(EncodeOptions{Compact: true}),
+		"gob":     envelopeCodec(EncodeOptions{Gob: true}),
+		"sharded": envelopeCodec(EncodeOptions{Gob: true, Shards: defaultShards}),
+		"stream":  streamCodec(),
+		"arpa": importCodec(WriteARPA, func(r io.Reader, dst WritableChain) error {
+			return ReadARPA(r, dst, DefaultARPAResolution)
+		}),
+		"gomarkov": importCodec(WriteGomarkovJSON, ReadGomarkovJSON),
+	}
+)
+
+// RegisterCodec makes a codec available to LookupCodec under the specified
+// name, replacing any codec already registered under it
+func RegisterCodec(name string, codec Codec) {
+	codecTex.Lock()
+	defer codecTex.Unlock()
+	codecRegistry[name] = codec
+}
+
+// LookupCodec retrieves the codec registered under name
+func LookupCodec(name string) (Codec, error) {
+	codecTex.RLock()
+	defer codecTex.RUnlock()
+
+	codec, ok := codecRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: codec %q", ErrUnknownName, name)
+	}
+	return codec, nil
+}
+
+// Codecs retrieves the names of every registered codec in sorted order, e.g.
+// to list the formats a command accepts
+func Codecs() []string {
+	codecTex.RLock()
+	defer codecTex.RUnlock()
+
+	names := make([]string, 0, len(codecRegistry))
+	for name := range codecRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
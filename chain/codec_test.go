@@ -0,0 +1,112 @@
+package chain
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// lossyCodecs import into a new chain from a format that records
+// probabilities rather than counts and sequence lengths
+var lossyCodecs = map[string]bool{"arpa": true, "gomarkov": true}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	want := testChain(t)
+	for _, name := range Codecs() {
+		codec, lookupErr := LookupCodec(name)
+		if lookupErr != nil {
+			t.Fatal(lookupErr)
+		}
+		encoded := &bytes.Buffer{}
+		if encodeErr := codec.Encode(want, encoded); encodeErr != nil {
+			t.Fatalf("%s: %v", name, encodeErr)
+		}
+		got, decodeErr := codec.Decode(encoded)
+		if decodeErr != nil {
+			t.Fatalf("%s: %v", name, decodeErr)
+		}
+		if !lossyCodecs[name] {
+			assertSameChain(t, want, got)
+			continue
+		}
+
+		for key, link := range want.Links {
+			gotLink, ok := got.RetrieveMarkovLink(key)
+			if !ok {
+				t.Fatalf("%s: link %q missing", name, key)
+			}
+			for next := range link.NextTokenOccurrences {
+				wantProbability, _ := link.GetProbabilityOfToken(next)
+				gotProbability, _ := gotLink.GetProbabilityOfToken(next)
+				if math.Abs(gotProbability-wantProbability) > 1e-3 {
+					t.Fatalf("%s: %q to %q has probability %v, want %v", name, key, next, gotProbability, wantProbability)
+				}
+			}
+		}
+	}
+}
+
+func TestCodecsRoundTripWithOrder(t *testing.T) {
+	want := trainedOrderChain(2)
+	for _, name := range Codecs() {
+		if lossyCodecs[name] || name == "stream" {
+			continue
+		}
+		codec, lookupErr := LookupCodec(name)
+		if lookupErr != nil {
+			t.Fatal(lookupErr)
+		}
+		encoded := &bytes.Buffer{}
+		if encodeErr := codec.Encode(want, encoded); encodeErr != nil {
+			t.Fatalf("%s: %v", name, encodeErr)
+		}
+		got, decodeErr := codec.Decode(encoded)
+		if decodeErr != nil {
+			t.Fatalf("%s: %v", name, decodeErr)
+		}
+		assertSameMultiKeyChain(t, want, got)
+	}
+}
+
+func TestStreamCodecRefusesUnrepresentableChains(t *testing.T) {
+	codec, lookupErr := LookupCodec("stream")
+	if lookupErr != nil {
+		t.Fatal(lookupErr)
+	}
+	normalized, buildErr := BuildNormalizedChainFromSources(CaseFoldNormalizer(), NewSliceSource(strings.Fields("The cat saw THE dog")))
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+
+	for _, chain := range []MarkovChain{trainedOrderChain(2), normalized} {
+		if encodeErr := codec.Encode(chain, &bytes.Buffer{}); encodeErr == nil {
+			t.Fatalf("%T encoded as a stream", chain)
+		}
+	}
+}
+
+func TestCodecsRoundTripLargeCounts(t *testing.T) {
+	want := testChain(t)
+	if incrementErr := want.Increment("cat", "sat", math.MaxInt32); incrementErr != nil {
+		t.Fatal(incrementErr)
+	}
+	for _, name := range Codecs() {
+		if lossyCodecs[name] {
+			continue
+		}
+		codec, lookupErr := LookupCodec(name)
+		if lookupErr != nil {
+			t.Fatal(lookupErr)
+		}
+		encoded := &bytes.Buffer{}
+		if encodeErr := codec.Encode(want, encoded); encodeErr != nil {
+			t.Fatalf("%s: %v", name, encodeErr)
+		}
+		got, decodeErr := codec.Decode(encoded)
+		if decodeErr != nil {
+			t.Fatalf("%s: %v", name, decodeErr)
+		}
+		assertSameChain(t, want, got)
+	}
+}
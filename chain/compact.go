@@ -0,0 +1,178 @@
+package chain
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// compactFormat identifies chains encoded in the compact representation
+const compactFormat = "compact"
+
+// compactChain is the compact representation of a chain. Every token is
+// stored once in the sorted vocabulary, and each link is an array holding
+// the index of its key followed by pairs of successor index and count, both
+// sorted by index
+type compactChain struct {
+	chainEnvelope
+	Format     string              `json:"format"`
+	Vocabulary []string            `json:"vocabulary"`
+	Links      [][]int             `json:"links"`
+	Lengths    *LengthDistribution `json:"lengths,omitempty"`
+	// Cumulative requests that sampling tables are built when loading,
+	// which is cheap as successors are already sorted
+	Cumulative bool `json:"cumulative,omitempty"`
+	Overflow   int  `json:"overflow_buckets,omitempty"`
+	// Folded holds the keys folded into overflow buckets
+	Folded map[string]bool `json:"folded,omitempty"`
+}
+
+func newCompactChain(c *singleKeyChain, opts EncodeOptions) *compactChain {
+	vocabularySet := make(map[string]bool)
+	for key, link := range c.Links {
+		vocabularySet[key] = true
+		for next := range link.NextTokenOccurrences {
+			vocabularySet[next] = true
+		}
+	}
+
+	compact := &compactChain{
+		Format:     compactFormat,
+		Vocabulary: make([]string, 0, len(vocabularySet)),
+		Links:      make([][]int, 0, len(c.Links)),
+		Lengths:    c.Lengths,
+		Cumulative: opts.IncludeCumulative,
+		Overflow:   c.OverflowBuckets,
+		Folded:     c.Folded,
+	}
+	for token := range vocabularySet {
+		compact.Vocabulary = append(compact.Vocabulary, token)
+	}
+	sort.Strings(compact.Vocabulary)
+	index := make(map[string]int, len(compact.Vocabulary))
+	for i, token := range compact.Vocabulary {
+		index[token] = i
+	}
+
+	for _, key := range compact.Vocabulary {
+		link, ok := c.Links[key]
+		if !ok {
+			continue
+		}
+
+		successors := make([]int, 0, len(link.NextTokenOccurrences))
+		for next := range link.NextTokenOccurrences {
+			successors = append(successors, index[next])
+		}
+		sort.Ints(successors)
+
+		encoded := make([]int, 1, 1+2*len(successors))
+		encoded[0] = index[key]
+		for _, next := range successors {
+			encoded = append(encoded, next, link.NextTokenOccurrences[compact.Vocabulary[next]])
+		}
+		compact.Links = append(compact.Links, encoded)
+	}
+
+	return compact
+}
+
+// expand converts the compact representation back into a chain, tokens are
+// shared with the vocabulary so each is only held in memory once
+func (compact *compactChain) expand() (*singleKeyChain, error) {
+	c := &singleKeyChain{
+		Links:           make(map[string]*singleTokenLink, len(compact.Links)),
+		Lengths:         compact.Lengths,
+		OverflowBuckets: compact.Overflow,
+		Folded:          compact.Folded,
+	}
+	vocabularySize := len(compact.Vocabulary)
+	for _, encoded := range compact.Links {
+		if len(encoded)%2 != 1 {
+			return nil, malformed("compact link has unpaired successor")
+		}
+		if encoded[0] < 0 || encoded[0] >= vocabularySize {
+			return nil, malformed("compact link key %d out of range", encoded[0])
+		}
+
+		key := compact.Vocabulary[encoded[0]]
+		if _, ok := c.Links[key]; ok {
+			return nil, malformed("link %q appears more than once", key)
+		}
+		link := &singleTokenLink{
+			Token:                [1]string{key},
+			NextTokenOccurrences: make(map[string]int, len(encoded)/2),
+		}
+		for i := 1; i < len(encoded); i += 2 {
+			next, count := encoded[i], encoded[i+1]
+			if next < 0 || next >= vocabularySize {
+				return nil, malformed("successor %d of link %q out of range", next, key)
+			}
+			if _, ok := link.NextTokenOccurrences[compact.Vocabulary[next]]; ok {
+				return nil, malformed("link %q repeats a successor", key)
+			}
+			if count <= 0 || link.Total > math.MaxInt-count {
+				return nil, malformed("link %q has an invalid count", key)
+			}
+			link.NextTokenOccurrences[compact.Vocabulary[next]] = count
+			link.Total += count
+		}
+		c.Links[key] = link
+	}
+
+	return c, nil
+}
+
+// CompactOptions configures CompactFile
+type CompactOptions struct {
+	// MinCount, if above one, prunes transitions that occurred fewer times
+	MinCount int
+
+	// DecodeOptions limits what is accepted when reading the input
+	DecodeOptions DecodeOptions
+
+	// IncludeCumulative builds sampling tables when the output is loaded
+	IncludeCumulative bool
+}
+
+// CompactFile loads a chain file, prunes it and rewrites it in the compact
+// representation, which stores each token once and sorts links and
+// successors. out is replaced atomically, so it may be the same file as in
+func CompactFile(in string, out string, opts CompactOptions) error {
+	inFile, openErr := os.Open(in)
+	if openErr != nil {
+		return openErr
+	}
+	chain, readErr := ReadChain(inFile, opts.DecodeOptions)
+	inFile.Close()
+	if readErr != nil {
+		return readErr
+	}
+
+	if opts.MinCount > 1 {
+		if pruneErr := Prune(chain, opts.MinCount); pruneErr != nil {
+			return pruneErr
+		}
+	}
+
+	outFile, createErr := ioutil.TempFile(filepath.Dir(out), filepath.Base(out)+".*.tmp")
+	if createErr != nil {
+		return createErr
+	}
+	writeErr := WriteChain(outFile, chain, EncodeOptions{
+		Compact:           true,
+		IncludeCumulative: opts.IncludeCumulative,
+	})
+	closeErr := outFile.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		os.Remove(outFile.Name())
+		return writeErr
+	}
+
+	return os.Rename(outFile.Name(), out)
+}
@@ -0,0 +1,30 @@
+package chain
+
+import (
+	"context"
+)
+
+// ContextTokenSource is a TokenSource whose reads can be cancelled, e.g. one
+// reading from the network or a database. Builders prefer NextTokenContext
+// over NextToken when a source implements it
+type ContextTokenSource interface {
+	TokenSource
+
+	// NextTokenContext behaves as NextToken, but returns ctx.Err() if ctx is
+	// done before a token is available
+	NextTokenContext(ctx context.Context) (string, error)
+}
+
+// nextTokenContext reads the next token from a source, honoring ctx. Sources
+// that don't support cancellation are checked for cancellation between
+// reads only
+func nextTokenContext(ctx context.Context, source TokenSource) (string, error) {
+	if contextSource, ok := source.(ContextTokenSource); ok {
+		return contextSource.NextTokenContext(ctx)
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return "", ctxErr
+	}
+	return source.NextToken()
+}
@@ -0,0 +1,44 @@
+package chain
+
+import "math/rand"
+
+// Continuation is the result of continuing a prompt
+type Continuation struct {
+	// Tokens are the generated tokens following the prompt
+	Tokens []string
+
+	// Used is the number of prompt tokens generation followed on from, the
+	// tokens after prompt[Used-1] weren't in the chain so were bridged over.
+	// It is zero if no prompt token was in the chain
+	Used int
+}
+
+// ContinuePrompt generates tokens continuing a prompt. If the last token of
+// the prompt isn't in the chain, the prompt is searched right to left for
+// the last token that is and generation starts from it, so a prompt ending
+// in an unseen word can still be continued. If no prompt token is in the
+// chain generation starts a new sequence. A HistoryChain is searched for
+// the last prompt token whose history in the prompt is in the chain.
+// opts.Start is ignored
+func ContinuePrompt(chain MarkovChain, prompt []string, rand *rand.Rand, opts GenerateOptions) (Continuation, error) {
+	continuation := Continuation{}
+	historyChain, hasHistory := chain.(HistoryChain)
+	opts.Start = ""
+	for i := len(prompt) - 1; i >= 0; i-- {
+		if prompt[i] == "" {
+			continue
+		}
+		if _, ok := retrieveLink(chain, prompt[:i+1]); ok {
+			opts.Start = prompt[i]
+			if hasHistory {
+				opts.Start = historyChain.Key(prompt[:i+1])
+			}
+			continuation.Used = i + 1
+			break
+		}
+	}
+
+	tokens, generateErr := Generate(chain, rand, opts)
+	continuation.Tokens = tokens
+	return continuation, generateErr
+}
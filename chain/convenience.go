@@ -0,0 +1,72 @@
+package chain
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+)
+
+// BuildFromString builds a chain from text, treating each line as a separate
+// sequence of whitespace separated words
+func BuildFromString(s string, opts ...BuildOption) (MarkovChain, error) {
+	return BuildFromReader(strings.NewReader(s), opts...)
+}
+
+// BuildFromReader builds a chain from a reader of text, treating each line
+// as a separate sequence of whitespace separated words. Blank lines are
+// skipped. The build stops before the next line once the build's Context is
+// done, returning its error
+func BuildFromReader(r io.Reader, opts ...BuildOption) (MarkovChain, error) {
+	options := applyBuildOptions(opts)
+	ctx := options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	chain := newSingleKeyChain()
+	limiter := newChainLimiter(options)
+	progress := newProgressTracker(options.Progress, 0)
+	// lines are reported as a single source
+	report := newReportBuilder(options.Report, 1, len(options.Filters))
+	filters := report.wrapFilters(0, options.Filters)
+	warnings := newWarningTracker(options.Warn, options.Report, 1)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 16*bufio.MaxScanTokenSize)
+	for line := 0; scanner.Scan(); line++ {
+		if contextDone(ctx) {
+			return nil, ctx.Err()
+		}
+		tokens := strings.Fields(scanner.Text())
+		if len(options.Filters) > 0 {
+			filtered, filterErr := CollectTokens(ApplyFiltersToSource(NewSliceSource(tokens), filters...))
+			if filterErr != nil {
+				return nil, filterErr
+			}
+			tokens = filtered
+		}
+		if options.Deduplicator != nil {
+			tokens = options.Deduplicator.dropDuplicates(tokens)
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		builder := newSequenceBuilder(options, chain, limiter, line)
+		for _, token := range tokens {
+			builder.add(token)
+			progress.token()
+			report.token(0)
+			warnings.token(0, token)
+		}
+		builder.finish()
+	}
+	if scanErr := scanner.Err(); scanErr != nil {
+		return nil, scanErr
+	}
+	progress.report()
+	warnings.sourceDone(0)
+	report.finish(chain, warnings.finish(chain))
+
+	return options.withOrder(chain), nil
+}
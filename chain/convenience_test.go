@@ -0,0 +1,29 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// cancellingReader cancels a context once it has been read from
+type cancellingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancellingReader) Read(p []byte) (int, error) {
+	r.cancel()
+	return r.r.Read(p)
+}
+
+func TestBuildFromReaderCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &cancellingReader{r: strings.NewReader(strings.Repeat("the cat sat\n", 1000)), cancel: cancel}
+	if _, buildErr := BuildFromReader(r, WithContext(ctx)); !errors.Is(buildErr, context.Canceled) {
+		t.Fatalf("cancelled build returned %v", buildErr)
+	}
+}
@@ -0,0 +1,113 @@
+package chain
+
+// Transition is a number of occurrences of one token following another
+type Transition struct {
+	Prev  string
+	Next  string
+	Count int
+}
+
+// BatchWriter may be implemented by a WritableChain that can apply many
+// increments more efficiently than one at a time, e.g. in a single
+// database transaction
+type BatchWriter interface {
+	IncrementBatch(transitions []Transition) error
+}
+
+// CopyProgress reports how far a Copy has progressed
+type CopyProgress struct {
+	LinksCopied       int
+	LinksTotal        int
+	TransitionsCopied int
+}
+
+// CopyOptions configures a Copy
+type CopyOptions struct {
+	// BatchSize is the number of transitions written to the destination at
+	// once, defaults to 1000
+	BatchSize int
+
+	// Progress, if set, is called after every batch has been written
+	Progress func(CopyProgress)
+}
+
+// Copy adds every transition in src to dst without going through an
+// intermediate serialized form
+func Copy(dst WritableChain, src MarkovChain) error {
+	return CopyWithOptions(dst, src, CopyOptions{})
+}
+
+// CopyWithOptions adds every transition in src to dst, writing in batches
+// and reporting progress as configured
+func CopyWithOptions(dst WritableChain, src MarkovChain, opts CopyOptions) error {
+	iterable, ok := src.(IterableChain)
+	if !ok {
+		return ErrUncountableChain
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	tokens := iterable.RetrieveTokens()
+	progress := CopyProgress{LinksTotal: len(tokens)}
+	batch := make([]Transition, 0, batchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if writeErr := writeTransitions(dst, batch); writeErr != nil {
+			return writeErr
+		}
+		progress.TransitionsCopied += len(batch)
+		batch = batch[:0]
+
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		return nil
+	}
+
+	for _, token := range tokens {
+		link, present := src.RetrieveMarkovLink(token)
+		if !present {
+			continue
+		}
+		counted, ok := link.(CountedLink)
+		if !ok {
+			return ErrUncountableChain
+		}
+
+		for _, next := range counted.RetrieveNextTokenPossibilities() {
+			count, _ := counted.GetOccurrencesOfToken(next)
+			batch = append(batch, Transition{Prev: token, Next: next, Count: count})
+			if len(batch) >= batchSize {
+				if flushErr := flush(); flushErr != nil {
+					return flushErr
+				}
+			}
+		}
+		progress.LinksCopied++
+	}
+
+	return flush()
+}
+
+// writeTransitions applies transitions to a chain, in a single batch if the
+// chain supports it
+func writeTransitions(dst WritableChain, transitions []Transition) error {
+	if batcher, ok := dst.(BatchWriter); ok {
+		return batcher.IncrementBatch(transitions)
+	}
+
+	for _, t := range transitions {
+		if incErr := dst.Increment(t.Prev, t.Next, t.Count); incErr != nil {
+			return incErr
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package chain
+
+import "sync/atomic"
+
+// RuntimeCounters is a snapshot of the package's runtime counters
+type RuntimeCounters struct {
+	// LookupHits and LookupMisses count lookups of tokens in chains built by
+	// this package that did and didn't find a link
+	LookupHits   uint64 `json:"lookup_hits"`
+	LookupMisses uint64 `json:"lookup_misses"`
+
+	// SamplesDrawn counts next tokens sampled from links
+	SamplesDrawn uint64 `json:"samples_drawn"`
+
+	// TrainerAdds counts sequences added by Trainers
+	TrainerAdds uint64 `json:"trainer_adds"`
+}
+
+const (
+	counterLookupHits = iota
+	counterLookupMisses
+	counterSamplesDrawn
+	counterTrainerAdds
+	counterCount
+)
+
+// counterShards spreads each counter over several cache lines so
+// concurrent updates rarely contend, the shards are summed when read
+const counterShards = 16
+
+type counterShard struct {
+	values [counterCount]uint64
+	// pad the shard to its own cache line
+	_ [64 - (counterCount*8)%64]byte
+}
+
+var counterTable [counterShards]counterShard
+
+// shardFor picks a shard from a token, so goroutines working on different
+// tokens tend to update different shards
+func shardFor(token string) *counterShard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(token) && i < 8; i++ {
+		hash = (hash ^ uint32(token[i])) * 16777619
+	}
+	return &counterTable[hash%counterShards]
+}
+
+func countEvent(counter int, token string) {
+	atomic.AddUint64(&shardFor(token).values[counter], 1)
+}
+
+// Counters retrieves a snapshot of the package's runtime counters, which
+// are always maintained and cheap to read, for quick introspection of a
+// running process
+func Counters() RuntimeCounters {
+	var totals [counterCount]uint64
+	for i := range counterTable {
+		for counter := range totals {
+			totals[counter] += atomic.LoadUint64(&counterTable[i].values[counter])
+		}
+	}
+
+	return RuntimeCounters{
+		LookupHits:   totals[counterLookupHits],
+		LookupMisses: totals[counterLookupMisses],
+		SamplesDrawn: totals[counterSamplesDrawn],
+		TrainerAdds:  totals[counterTrainerAdds],
+	}
+}
+
+// ResetCounters sets every runtime counter to zero
+func ResetCounters() {
+	for i := range counterTable {
+		for counter := range counterTable[i].values {
+			atomic.StoreUint64(&counterTable[i].values[counter], 0)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package chain
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// cumulativeTable holds a link's successors in sorted order with the running
+// total of their counts, so a successor can be sampled by binary search
+type cumulativeTable struct {
+	Tokens  []string `json:"tokens"`
+	Weights []int    `json:"weights"`
+}
+
+func newCumulativeTable(link *singleTokenLink) *cumulativeTable {
+	table := &cumulativeTable{
+		Tokens:  make([]string, 0, len(link.NextTokenOccurrences)),
+		Weights: make([]int, 0, len(link.NextTokenOccurrences)),
+	}
+	for next := range link.NextTokenOccurrences {
+		table.Tokens = append(table.Tokens, next)
+	}
+	sort.Strings(table.Tokens)
+
+	total := 0
+	for _, next := range table.Tokens {
+		total += link.NextTokenOccurrences[next]
+		table.Weights = append(table.Weights, total)
+	}
+
+	return table
+}
+
+func (t *cumulativeTable) sample(rand *rand.Rand) string {
+	goal := rand.Intn(t.Weights[len(t.Weights)-1])
+	i := sort.Search(len(t.Weights), func(i int) bool { return t.Weights[i] > goal })
+	return t.Tokens[i]
+}
+
+// validate checks that the table agrees with the link's counts
+func (t *cumulativeTable) validate(link *singleTokenLink) error {
+	key := link.Token[0]
+	if len(t.Tokens) != len(t.Weights) || len(t.Tokens) != len(link.NextTokenOccurrences) {
+		return malformed("cumulative table of link %q does not match its successors", key)
+	}
+
+	prev := 0
+	for i, next := range t.Tokens {
+		count, ok := link.NextTokenOccurrences[next]
+		if !ok || t.Weights[i]-prev != count || (i > 0 && t.Tokens[i-1] >= next) {
+			return malformed("cumulative table of link %q does not match its counts", key)
+		}
+		prev = t.Weights[i]
+	}
+
+	return nil
+}
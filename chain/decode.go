@@ -0,0 +1,310 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+// DecodeOptions limits what is accepted when loading a persisted chain, so
+// chains can safely be loaded from untrusted storage. Zero values mean no
+// limit
+type DecodeOptions struct {
+	// MaxBytes caps the size of the encoded chain
+	MaxBytes int64
+
+	// MaxLinks caps the number of links in the chain
+	MaxLinks int
+
+	// MaxSuccessors caps the number of next tokens of any one link
+	MaxSuccessors int
+
+	// MaxTokenLength caps the length in bytes of any token
+	MaxTokenLength int
+
+	// AllowInvalidUTF8 accepts tokens that aren't valid UTF-8
+	AllowInvalidUTF8 bool
+}
+
+// DefaultDecodeOptions returns conservative limits suitable for loading
+// chains from untrusted storage
+func DefaultDecodeOptions() DecodeOptions {
+	return DecodeOptions{
+		MaxBytes:       1 << 30,
+		MaxLinks:       10000000,
+		MaxSuccessors:  1000000,
+		MaxTokenLength: 4096,
+	}
+}
+
+// malformed creates an error describing why a chain was rejected
+func malformed(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrMalformedChain}, args...)...)
+}
+
+// limitedReader fails, rather than silently truncating, once more than its
+// limit has been read
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, malformed("encoded chain exceeds size limit")
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, malformed("encoded chain exceeds size limit")
+	}
+	return n, err
+}
+
+func limitReader(r io.Reader, opts DecodeOptions) io.Reader {
+	if opts.MaxBytes <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, remaining: opts.MaxBytes}
+}
+
+func (opts DecodeOptions) checkToken(token string) error {
+	if opts.MaxTokenLength > 0 && len(token) > opts.MaxTokenLength {
+		return malformed("token of %d bytes exceeds limit", len(token))
+	}
+	if !opts.AllowInvalidUTF8 && !utf8.ValidString(token) {
+		return malformed("token %q is not valid UTF-8", token)
+	}
+	return nil
+}
+
+// validate checks the structure and counts of a decoded chain
+func (opts DecodeOptions) validate(c *singleKeyChain) error {
+	if c.Links == nil {
+		return malformed("missing links")
+	}
+	if opts.MaxLinks > 0 && len(c.Links) > opts.MaxLinks {
+		return malformed("%d links exceeds limit", len(c.Links))
+	}
+	if c.OverflowBuckets < 0 {
+		return malformed("negative overflow bucket count")
+	}
+	if len(c.Folded) > 0 && c.OverflowBuckets == 0 {
+		return malformed("folded keys without overflow buckets")
+	}
+	if opts.MaxLinks > 0 && len(c.Folded) > opts.MaxLinks {
+		return malformed("%d folded keys exceeds limit", len(c.Folded))
+	}
+	for key := range c.Folded {
+		if tokenErr := opts.checkToken(key); tokenErr != nil {
+			return tokenErr
+		}
+	}
+
+	for key, link := range c.Links {
+		if link == nil || link.NextTokenOccurrences == nil {
+			return malformed("link %q is empty", key)
+		}
+		if link.Token[0] != key {
+			return malformed("link %q is keyed as %q", link.Token[0], key)
+		}
+		if tokenErr := opts.checkToken(key); tokenErr != nil {
+			return tokenErr
+		}
+		if opts.MaxSuccessors > 0 && len(link.NextTokenOccurrences) > opts.MaxSuccessors {
+			return malformed("link %q has %d successors, exceeding limit", key, len(link.NextTokenOccurrences))
+		}
+
+		total := 0
+		for next, count := range link.NextTokenOccurrences {
+			if tokenErr := opts.checkToken(next); tokenErr != nil {
+				return tokenErr
+			}
+			if count <= 0 {
+				return malformed("transition %q to %q has count %d", key, next, count)
+			}
+			if total > math.MaxInt-count {
+				return malformed("link %q total overflows", key)
+			}
+			total += count
+		}
+		if total != link.Total {
+			return malformed("link %q total %d does not match its counts %d", key, link.Total, total)
+		}
+		if link.Cumulative != nil {
+			if tableErr := link.Cumulative.validate(link); tableErr != nil {
+				return tableErr
+			}
+		}
+	}
+
+	if c.Lengths != nil {
+		total := 0
+		for length, count := range c.Lengths.Counts {
+			if length < 0 || count <= 0 {
+				return malformed("invalid sequence length count")
+			}
+			if total > math.MaxInt-count {
+				return malformed("sequence length total overflows")
+			}
+			total += count
+		}
+		if c.Lengths.Counts == nil || total != c.Lengths.Total {
+			return malformed("sequence length total does not match its counts")
+		}
+	} else {
+		c.Lengths = NewLengthDistribution()
+	}
+
+	return nil
+}
+
+// encodedChain holds either JSON representation of a chain while it is
+// decoded, links are decoded once the format is known
+type encodedChain struct {
+	chainEnvelope
+	Format     string              `json:"format"`
+	Vocabulary []string            `json:"vocabulary"`
+	Links      json.RawMessage     `json:"links"`
+	Lengths    *LengthDistribution `json:"lengths,omitempty"`
+	Cumulative bool                `json:"cumulative,omitempty"`
+	Overflow   int                 `json:"overflow_buckets,omitempty"`
+	Folded     map[string]bool     `json:"folded,omitempty"`
+}
+
+// validate checks that the envelope describes a chain this version can load,
+// filling in the type of chains written before the envelope existed
+func (e *chainEnvelope) validate() error {
+	if e.Version < 0 || e.Version > chainVersion {
+		return malformed("unsupported version %d", e.Version)
+	}
+	switch e.Type {
+	case "":
+		e.Type = chainTypeSingle
+	case chainTypeSingle:
+	case chainTypeMulti:
+		if e.Order < 1 {
+			return malformed("multi token chain of order %d", e.Order)
+		}
+	default:
+		return malformed("unknown chain type %q", e.Type)
+	}
+	return nil
+}
+
+// decodeChain decodes and validates a chain encoded as gob, in either JSON
+// representation, in shards or as a stream, along with its envelope
+func decodeChain(r io.Reader, opts DecodeOptions) (*singleKeyChain, chainEnvelope, error) {
+	reader := bufio.NewReader(limitReader(r, opts))
+	var chain *singleKeyChain
+	var envelope chainEnvelope
+	var decodeErr error
+	if magic, _ := reader.Peek(len(streamMagic)); bytes.Equal(magic, streamMagic) {
+		chain, envelope, decodeErr = decodeChainStream(reader, opts)
+	} else if bytes.HasPrefix(magic, []byte(gobMagic)) {
+		reader.Discard(len(gobMagic))
+		chain, envelope, decodeErr = decodeChainGob(reader, opts)
+	} else if bytes.HasPrefix(magic, []byte(shardMagic)) {
+		reader.Discard(len(shardMagic))
+		chain, envelope, decodeErr = decodeShardedChain(reader, opts)
+	} else {
+		chain, envelope, decodeErr = decodeChainJSON(reader, opts)
+	}
+	if decodeErr != nil {
+		return nil, envelope, decodeErr
+	}
+	if envelopeErr := envelope.validate(); envelopeErr != nil {
+		return nil, envelope, envelopeErr
+	}
+	return chain, envelope, nil
+}
+
+// decodeChainStream decodes and validates a chain written by
+// WriteChainStream, which is always a single token chain
+func decodeChainStream(r io.Reader, opts DecodeOptions) (*singleKeyChain, chainEnvelope, error) {
+	envelope := chainEnvelope{Type: chainTypeSingle}
+	chain := newSingleKeyChain()
+	// the size limit is already applied to r
+	streamOpts := opts
+	streamOpts.MaxBytes = 0
+	if readErr := ReadChainStream(r, chain, streamOpts); readErr != nil {
+		return nil, envelope, readErr
+	}
+	if validateErr := opts.validate(chain); validateErr != nil {
+		return nil, envelope, validateErr
+	}
+	return chain, envelope, nil
+}
+
+// decodeChainGob decodes and validates a gob encoded chain
+func decodeChainGob(r io.Reader, opts DecodeOptions) (*singleKeyChain, chainEnvelope, error) {
+	encoded := &gobChain{}
+	if decodeErr := gob.NewDecoder(r).Decode(encoded); decodeErr != nil {
+		return nil, chainEnvelope{}, decodeErr
+	}
+	if encoded.Chain == nil {
+		return nil, encoded.Envelope, malformed("missing links")
+	}
+	if validateErr := opts.validate(encoded.Chain); validateErr != nil {
+		return nil, encoded.Envelope, validateErr
+	}
+	return encoded.Chain, encoded.Envelope, nil
+}
+
+// decodeChainJSON decodes and validates a JSON encoded chain in either the
+// standard or compact representation
+func decodeChainJSON(r io.Reader, opts DecodeOptions) (*singleKeyChain, chainEnvelope, error) {
+	encoded := &encodedChain{}
+	if decodeErr := json.NewDecoder(r).Decode(encoded); decodeErr != nil {
+		return nil, chainEnvelope{}, decodeErr
+	}
+	if encoded.Links == nil {
+		return nil, encoded.chainEnvelope, malformed("missing links")
+	}
+
+	var chain *singleKeyChain
+	switch encoded.Format {
+	case "":
+		chain = &singleKeyChain{Lengths: encoded.Lengths, OverflowBuckets: encoded.Overflow, Folded: encoded.Folded}
+		if decodeErr := json.Unmarshal(encoded.Links, &chain.Links); decodeErr != nil {
+			return nil, encoded.chainEnvelope, decodeErr
+		}
+	case compactFormat:
+		compact := &compactChain{
+			Vocabulary: encoded.Vocabulary,
+			Lengths:    encoded.Lengths,
+			Overflow:   encoded.Overflow,
+			Folded:     encoded.Folded,
+		}
+		if decodeErr := json.Unmarshal(encoded.Links, &compact.Links); decodeErr != nil {
+			return nil, encoded.chainEnvelope, decodeErr
+		}
+		var expandErr error
+		if chain, expandErr = compact.expand(); expandErr != nil {
+			return nil, encoded.chainEnvelope, expandErr
+		}
+	default:
+		return nil, encoded.chainEnvelope, malformed("unknown format %q", encoded.Format)
+	}
+
+	if validateErr := opts.validate(chain); validateErr != nil {
+		return nil, encoded.chainEnvelope, validateErr
+	}
+	if encoded.Cumulative {
+		for _, link := range chain.Links {
+			if link.Total > 0 && link.Cumulative == nil {
+				link.Cumulative = newCumulativeTable(link)
+			}
+		}
+	}
+	return chain, encoded.chainEnvelope, nil
+}
@@ -0,0 +1,166 @@
+package chain
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	minHashCount = 64
+	minHashBands = 16
+	minHashRows  = minHashCount / minHashBands
+)
+
+// Deduplicator detects sequences that repeat earlier ones, either exactly or,
+// if configured, approximately using MinHash signatures of token shingles.
+// A Deduplicator is safe for concurrent use
+type Deduplicator struct {
+	dedupTex sync.Mutex
+	exact    map[uint64]struct{}
+
+	// near-duplicate detection, disabled if shingleSize is zero
+	shingleSize int
+	threshold   float64
+	signatures  [][minHashCount]uint64
+	buckets     map[uint64][]int
+}
+
+// NewDeduplicator creates a Deduplicator that detects exact repeats
+func NewDeduplicator() *Deduplicator {
+	return &Deduplicator{
+		exact: make(map[uint64]struct{}),
+	}
+}
+
+// NewNearDeduplicator creates a Deduplicator that also detects sequences
+// whose shingles of shingleSize tokens have an estimated Jaccard similarity
+// of at least threshold with an earlier sequence
+func NewNearDeduplicator(shingleSize int, threshold float64) *Deduplicator {
+	if shingleSize < 1 {
+		shingleSize = 1
+	}
+
+	d := NewDeduplicator()
+	d.shingleSize = shingleSize
+	d.threshold = threshold
+	d.buckets = make(map[uint64][]int)
+	return d
+}
+
+func hashTokens(tokens []string) uint64 {
+	h := fnv.New64a()
+	for _, token := range tokens {
+		h.Write([]byte(token))
+		h.Write([]byte(keySeparator))
+	}
+	return h.Sum64()
+}
+
+// mixHash scrambles a hash with a seed so one shingle hash can stand in for
+// many independent hash functions
+func mixHash(h uint64, seed uint64) uint64 {
+	h ^= seed * 0x9e3779b97f4a7c15
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (d *Deduplicator) signature(tokens []string) [minHashCount]uint64 {
+	var signature [minHashCount]uint64
+	for i := range signature {
+		signature[i] = ^uint64(0)
+	}
+
+	shingles := len(tokens) - d.shingleSize + 1
+	if shingles < 1 {
+		shingles = 1
+	}
+	for start := 0; start < shingles; start++ {
+		end := start + d.shingleSize
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+
+		shingle := hashTokens(tokens[start:end])
+		for i := range signature {
+			if h := mixHash(shingle, uint64(i+1)); h < signature[i] {
+				signature[i] = h
+			}
+		}
+	}
+
+	return signature
+}
+
+func bandKey(band int, signature *[minHashCount]uint64) uint64 {
+	h := uint64(band)
+	for _, v := range signature[band*minHashRows : (band+1)*minHashRows] {
+		h = mixHash(h, v)
+	}
+	return h
+}
+
+// dropDuplicates removes the sequences of tokens, separated by
+// SegmentBreak, that repeat previously checked sequences, each is checked
+// on its own so a source isn't kept or skipped as a whole
+func (d *Deduplicator) dropDuplicates(tokens []string) []string {
+	kept := make([]string, 0, len(tokens))
+	for _, segment := range splitSegments(tokens) {
+		if len(segment) == 0 || d.IsDuplicate(segment) {
+			continue
+		}
+		if len(kept) > 0 {
+			kept = append(kept, SegmentBreak)
+		}
+		kept = append(kept, segment...)
+	}
+	return kept
+}
+
+// IsDuplicate reports whether tokens repeat a previously checked sequence,
+// sequences that aren't duplicates are remembered for future checks
+func (d *Deduplicator) IsDuplicate(tokens []string) bool {
+	exact := hashTokens(tokens)
+
+	d.dedupTex.Lock()
+	defer d.dedupTex.Unlock()
+	if _, ok := d.exact[exact]; ok {
+		return true
+	}
+
+	if d.shingleSize > 0 {
+		signature := d.signature(tokens)
+		checked := make(map[int]bool)
+		for band := 0; band < minHashBands; band++ {
+			for _, candidate := range d.buckets[bandKey(band, &signature)] {
+				if checked[candidate] {
+					continue
+				}
+				checked[candidate] = true
+
+				matches := 0
+				for i, v := range d.signatures[candidate] {
+					if v == signature[i] {
+						matches++
+					}
+				}
+				if float64(matches)/minHashCount >= d.threshold {
+					return true
+				}
+			}
+		}
+
+		index := len(d.signatures)
+		d.signatures = append(d.signatures, signature)
+		for band := 0; band < minHashBands; band++ {
+			key := bandKey(band, &signature)
+			d.buckets[key] = append(d.buckets[key], index)
+		}
+	}
+
+	d.exact[exact] = struct{}{}
+	return false
+}
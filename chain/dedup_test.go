@@ -0,0 +1,63 @@
+package chain
+
+import (
+	"strings"
+	"testing"
+)
+
+// segmentTokens joins segments of whitespace separated tokens with
+// SegmentBreak
+func segmentTokens(segments ...string) []string {
+	tokens := make([]string, 0)
+	for i, segment := range segments {
+		if i > 0 {
+			tokens = append(tokens, SegmentBreak)
+		}
+		tokens = append(tokens, strings.Fields(segment)...)
+	}
+	return tokens
+}
+
+func TestDeduplicatorSegments(t *testing.T) {
+	first := segmentTokens("the cat sat", "a dog ran")
+	second := segmentTokens("the cat sat", "a bird flew", "a dog ran")
+	want, _ := BuildChainFromSources(NewSliceSource(first), NewSliceSource(segmentTokens("a bird flew")))
+
+	// whichever source is checked first, each sequence is counted once
+	built, buildErr := BuildChainFromSourcesWithOptions(BuildOptions{Deduplicator: NewDeduplicator()},
+		NewSliceSource(first), NewSliceSource(second))
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	assertSameChain(t, want.(*singleKeyChain), built)
+
+	builder := NewChainBuilder(WithDeduplicator(NewDeduplicator()))
+	builder.Train(first)
+	builder.Train(second)
+	trained, finishErr := builder.Finish()
+	if finishErr != nil {
+		t.Fatal(finishErr)
+	}
+	assertSameChain(t, want.(*singleKeyChain), trained)
+}
+
+func TestBuildChainDeduplicatesSegmentsAsTheyStream(t *testing.T) {
+	deduplicator := NewDeduplicator()
+	tokens := make(chan string)
+	built := make(chan *singleKeyChain)
+	go func() {
+		built <- buildChain(BuildOptions{Deduplicator: deduplicator}, 0, tokens)
+	}()
+
+	for _, token := range segmentTokens("the cat sat", "the cat sat", "a dog") {
+		tokens <- token
+	}
+	// the first two segments were checked before the source is exhausted
+	if !deduplicator.IsDuplicate(strings.Fields("the cat sat")) {
+		t.Fatal("segment not checked until the source was exhausted")
+	}
+	close(tokens)
+
+	want, _ := BuildChainFromSources(NewSliceSource(segmentTokens("the cat sat", "a dog")))
+	assertSameChain(t, want.(*singleKeyChain), <-built)
+}
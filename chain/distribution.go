@@ -0,0 +1,235 @@
+package chain
+
+import (
+	"container/list"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// SamplerOptions adjusts the distribution the next token is sampled from
+type SamplerOptions struct {
+	// TopK, if positive, restricts sampling to the K most likely tokens
+	TopK int
+
+	// Temperature, if positive, raises each probability to the power of
+	// 1/Temperature before renormalizing, so values below 1 favour likely
+	// tokens and values above 1 flatten the distribution
+	Temperature float64
+}
+
+// Distribution is a normalized next token distribution derived from a link
+type Distribution struct {
+	// Tokens are ordered from most to least likely, ties broken by token
+	Tokens        []string
+	Probabilities []float64
+	cumulative    []float64
+}
+
+// NewDistribution derives the distribution of a link's next tokens under the
+// sampler options
+func NewDistribution(link MarkovChainLink, opts SamplerOptions) *Distribution {
+	tokens := link.RetrieveNextTokenPossibilities()
+	weights := make(map[string]float64, len(tokens))
+	for _, token := range tokens {
+		weights[token], _ = link.GetProbabilityOfToken(token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if weights[tokens[i]] != weights[tokens[j]] {
+			return weights[tokens[i]] > weights[tokens[j]]
+		}
+		return tokens[i] < tokens[j]
+	})
+	if opts.TopK > 0 && opts.TopK < len(tokens) {
+		tokens = tokens[:opts.TopK]
+	}
+
+	d := &Distribution{
+		Tokens:        tokens,
+		Probabilities: make([]float64, len(tokens)),
+		cumulative:    make([]float64, len(tokens)),
+	}
+	total := 0.0
+	for i, token := range tokens {
+		weight := weights[token]
+		if opts.Temperature > 0 && opts.Temperature != 1 {
+			weight = math.Pow(weight, 1/opts.Temperature)
+		}
+		total += weight
+		d.Probabilities[i] = weight
+		d.cumulative[i] = total
+	}
+	for i := range d.Probabilities {
+		d.Probabilities[i] /= total
+	}
+
+	return d
+}
+
+// Sample samples a token from the distribution, it returns the empty end of
+// sequence token if the distribution is empty
+func (d *Distribution) Sample(rand *rand.Rand) string {
+	if len(d.cumulative) == 0 {
+		return ""
+	}
+
+	goal := rand.Float64() * d.cumulative[len(d.cumulative)-1]
+	i := sort.Search(len(d.cumulative), func(i int) bool { return d.cumulative[i] > goal })
+	if i == len(d.cumulative) {
+		i--
+	}
+	return d.Tokens[i]
+}
+
+type distributionKey struct {
+	token string
+	opts  SamplerOptions
+}
+
+type cachedDistribution struct {
+	key          distributionKey
+	distribution *Distribution
+}
+
+// DistributionCache wraps a WritableChain and memoizes the distributions
+// derived from its links for each state and set of sampler options, so hot
+// states aren't renormalized on every call. Mutations made through the cache
+// invalidate the affected state. A DistributionCache is safe for concurrent
+// use if the wrapped chain is
+type DistributionCache struct {
+	chain      WritableChain
+	capacity   int
+	cacheTex   sync.Mutex
+	entries    map[distributionKey]*list.Element
+	lru        *list.List
+	generation uint64
+	stats      CacheStats
+}
+
+// MakeDistributionCache wraps a chain with an LRU cache holding up to
+// capacity distributions
+func MakeDistributionCache(chain WritableChain, capacity int) *DistributionCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &DistributionCache{
+		chain:    chain,
+		capacity: capacity,
+		entries:  make(map[distributionKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Distribution retrieves the distribution following a token under the
+// sampler options
+func (c *DistributionCache) Distribution(token string, opts SamplerOptions) (*Distribution, bool) {
+	key := distributionKey{token: token, opts: opts}
+
+	c.cacheTex.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(element)
+		c.stats.Hits++
+		distribution := element.Value.(*cachedDistribution).distribution
+		c.cacheTex.Unlock()
+		return distribution, true
+	}
+	c.stats.Misses++
+	generation := c.generation
+	c.cacheTex.Unlock()
+
+	link, ok := c.chain.RetrieveMarkovLink(token)
+	if !ok {
+		return nil, false
+	}
+	distribution := NewDistribution(link, opts)
+
+	c.cacheTex.Lock()
+	defer c.cacheTex.Unlock()
+	// a mutation while the distribution was derived may have made it stale
+	if _, ok := c.entries[key]; !ok && generation == c.generation {
+		c.entries[key] = c.lru.PushFront(&cachedDistribution{key: key, distribution: distribution})
+		for c.lru.Len() > c.capacity {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cachedDistribution).key)
+			c.stats.Evictions++
+		}
+	}
+
+	return distribution, true
+}
+
+// Sample samples the token following a token under the sampler options
+func (c *DistributionCache) Sample(token string, opts SamplerOptions, rand *rand.Rand) (nextToken string, keyPresent bool) {
+	distribution, ok := c.Distribution(token, opts)
+	if !ok {
+		return "", false
+	}
+	return distribution.Sample(rand), true
+}
+
+// Invalidate drops every cached distribution for the specified token, it
+// must be called if the wrapped chain is modified other than through the
+// cache
+func (c *DistributionCache) Invalidate(token string) {
+	c.cacheTex.Lock()
+	defer c.cacheTex.Unlock()
+	c.generation++
+	for key, element := range c.entries {
+		if key.token == token {
+			c.lru.Remove(element)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Purge drops all cached distributions
+func (c *DistributionCache) Purge() {
+	c.cacheTex.Lock()
+	defer c.cacheTex.Unlock()
+	c.generation++
+	c.entries = make(map[distributionKey]*list.Element)
+	c.lru.Init()
+}
+
+// Stats returns a snapshot of the cache's hit and miss counts
+func (c *DistributionCache) Stats() CacheStats {
+	c.cacheTex.Lock()
+	defer c.cacheTex.Unlock()
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+func (c *DistributionCache) CalculateNextToken(token string, rand *rand.Rand) (nextToken string, keyPresent bool) {
+	return c.Sample(token, SamplerOptions{}, rand)
+}
+
+func (c *DistributionCache) RetrieveMarkovLink(token string) (link MarkovChainLink, keyPresent bool) {
+	return c.chain.RetrieveMarkovLink(token)
+}
+
+func (c *DistributionCache) RetrieveTokens() []string {
+	return c.chain.RetrieveTokens()
+}
+
+func (c *DistributionCache) IsEmpty() bool {
+	return c.chain.IsEmpty()
+}
+
+func (c *DistributionCache) Increment(prev string, next string, n int) error {
+	defer c.Invalidate(prev)
+	return c.chain.Increment(prev, next, n)
+}
+
+func (c *DistributionCache) RemoveSuccessor(prev string, next string) error {
+	defer c.Invalidate(prev)
+	return c.chain.RemoveSuccessor(prev, next)
+}
+
+func (c *DistributionCache) SetCount(prev string, next string, n int) error {
+	defer c.Invalidate(prev)
+	return c.chain.SetCount(prev, next, n)
+}
@@ -0,0 +1,72 @@
+package chain
+
+import "math/rand"
+
+// diverseAttempts bounds how many sequences GenerateDiverse generates for
+// each one it returns
+const diverseAttempts = 32
+
+// bigramSet collects the bigrams of a sequence including its boundaries, so
+// sequences sharing a start or end overlap
+func bigramSet(tokens []string) map[[2]string]bool {
+	bigrams := make(map[[2]string]bool, len(tokens)+1)
+	prev := ""
+	for _, token := range tokens {
+		bigrams[[2]string{prev, token}] = true
+		prev = token
+	}
+	bigrams[[2]string{prev, ""}] = true
+	return bigrams
+}
+
+// bigramDistance is one minus the Jaccard similarity of the bigrams of two
+// sequences, between 0 for identical and 1 for disjoint sequences
+func bigramDistance(a map[[2]string]bool, b map[[2]string]bool) float64 {
+	shared := 0
+	for bigram := range a {
+		if b[bigram] {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0
+	}
+	return 1 - float64(shared)/float64(union)
+}
+
+// GenerateDiverse generates n sequences as Generate does, rejecting any
+// whose bigram distance from a sequence already chosen is below
+// minDistance, so suggestions presented together are varied. The distance
+// is one minus the Jaccard similarity of the sequences' bigrams, so a
+// minDistance of 1 requires sequences to share no bigrams. ErrTooSimilar is
+// returned along with the sequences chosen if n diverse sequences can't be
+// found
+func GenerateDiverse(chain MarkovChain, rand *rand.Rand, n int, minDistance float64, opts GenerateOptions) ([][]string, error) {
+	chosen := make([][]string, 0, n)
+	chosenBigrams := make([]map[[2]string]bool, 0, n)
+	for attempt := 0; len(chosen) < n && attempt < n*diverseAttempts; attempt++ {
+		tokens, generateErr := Generate(chain, rand, opts)
+		if generateErr != nil {
+			return chosen, generateErr
+		}
+
+		bigrams := bigramSet(tokens)
+		diverse := true
+		for _, other := range chosenBigrams {
+			if bigramDistance(bigrams, other) < minDistance {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			chosen = append(chosen, tokens)
+			chosenBigrams = append(chosenBigrams, bigrams)
+		}
+	}
+	if len(chosen) < n {
+		return chosen, ErrTooSimilar
+	}
+
+	return chosen, nil
+}
@@ -0,0 +1,176 @@
+package chain
+
+import (
+	"container/list"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// EmbeddingLookup retrieves vector embeddings of tokens, e.g. from a
+// word2vec or GloVe model
+type EmbeddingLookup interface {
+	Embedding(token string) (vector []float64, ok bool)
+}
+
+type mapEmbeddings map[string][]float64
+
+func (m mapEmbeddings) Embedding(token string) ([]float64, bool) {
+	vector, ok := m[token]
+	return vector, ok
+}
+
+// MakeMapEmbeddings creates an EmbeddingLookup from preloaded vectors
+func MakeMapEmbeddings(vectors map[string][]float64) EmbeddingLookup {
+	return mapEmbeddings(vectors)
+}
+
+// cosineSimilarity compares two vectors, vectors of differing lengths or
+// without magnitude are dissimilar
+func cosineSimilarity(a []float64, b []float64) float64 {
+	if len(a) != len(b) {
+		return -1
+	}
+
+	dot, magA, magB := 0.0, 0.0, 0.0
+	for i := range a {
+		dot += a[i] * b[i]
+		magA += a[i] * a[i]
+		magB += b[i] * b[i]
+	}
+	if magA == 0 || magB == 0 {
+		return -1
+	}
+	return dot / math.Sqrt(magA*magB)
+}
+
+type nearestToken struct {
+	key        string
+	token      string
+	similarity float64
+	found      bool
+}
+
+// EmbeddingFallbackChain wraps a chain so that lookups of unseen keys fall
+// back to the link of the most similar known key by embedding, so a chatbot
+// can respond to words it was never trained on. The nearest keys of the most
+// recently looked up tokens are cached, Purge must be called if keys are
+// added to the wrapped chain. An EmbeddingFallbackChain is safe for
+// concurrent use if the wrapped chain is
+type EmbeddingFallbackChain struct {
+	chain         IterableChain
+	embeddings    EmbeddingLookup
+	minSimilarity float64
+	capacity      int
+	nearestTex    sync.Mutex
+	nearest       map[string]*list.Element
+	lru           *list.List
+}
+
+// NewEmbeddingFallbackChain creates an EmbeddingFallbackChain, keys are only
+// substituted if their cosine similarity is at least minSimilarity. The
+// nearest keys of up to capacity tokens are cached
+func NewEmbeddingFallbackChain(chain IterableChain, embeddings EmbeddingLookup, minSimilarity float64, capacity int) *EmbeddingFallbackChain {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &EmbeddingFallbackChain{
+		chain:         chain,
+		embeddings:    embeddings,
+		minSimilarity: minSimilarity,
+		capacity:      capacity,
+		nearest:       make(map[string]*list.Element),
+		lru:           list.New(),
+	}
+}
+
+// Nearest finds the known key most similar to token, ties are broken by key
+// so the result is stable
+func (c *EmbeddingFallbackChain) Nearest(token string) (nearest string, similarity float64, ok bool) {
+	c.nearestTex.Lock()
+	if element, ok := c.nearest[token]; ok {
+		c.lru.MoveToFront(element)
+		cached := element.Value.(*nearestToken)
+		c.nearestTex.Unlock()
+		return cached.token, cached.similarity, cached.found
+	}
+	c.nearestTex.Unlock()
+
+	// tokens without an embedding are cheap to look up and are never
+	// cached, so arbitrary unknown input doesn't displace useful entries
+	vector, ok := c.embeddings.Embedding(token)
+	if !ok {
+		return "", math.Inf(-1), false
+	}
+
+	result := &nearestToken{key: token, similarity: math.Inf(-1)}
+	for _, key := range c.chain.RetrieveTokens() {
+		// the boundary token has no meaningful embedding
+		if key == "" {
+			continue
+		}
+		keyVector, ok := c.embeddings.Embedding(key)
+		if !ok {
+			continue
+		}
+
+		similarity := cosineSimilarity(vector, keyVector)
+		if similarity < c.minSimilarity {
+			continue
+		}
+		if !result.found || similarity > result.similarity || (similarity == result.similarity && key < result.token) {
+			result.token, result.similarity, result.found = key, similarity, true
+		}
+	}
+
+	c.nearestTex.Lock()
+	defer c.nearestTex.Unlock()
+	if _, ok := c.nearest[token]; !ok {
+		c.nearest[token] = c.lru.PushFront(result)
+		for c.lru.Len() > c.capacity {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.nearest, oldest.Value.(*nearestToken).key)
+		}
+	}
+	return result.token, result.similarity, result.found
+}
+
+// Purge drops all cached nearest keys
+func (c *EmbeddingFallbackChain) Purge() {
+	c.nearestTex.Lock()
+	defer c.nearestTex.Unlock()
+	c.nearest = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+func (c *EmbeddingFallbackChain) CalculateNextToken(token string, rand *rand.Rand) (nextToken string, keyPresent bool) {
+	link, ok := c.RetrieveMarkovLink(token)
+	if !ok {
+		return "", false
+	}
+	return link.GetNextToken(rand), true
+}
+
+// RetrieveMarkovLink retrieves the link for a token, or for its nearest
+// known key if it is unseen
+func (c *EmbeddingFallbackChain) RetrieveMarkovLink(token string) (link MarkovChainLink, keyPresent bool) {
+	if link, ok := c.chain.RetrieveMarkovLink(token); ok {
+		return link, true
+	}
+
+	nearest, _, ok := c.Nearest(token)
+	if !ok {
+		return nil, false
+	}
+	return c.chain.RetrieveMarkovLink(nearest)
+}
+
+func (c *EmbeddingFallbackChain) RetrieveTokens() []string {
+	return c.chain.RetrieveTokens()
+}
+
+func (c *EmbeddingFallbackChain) IsEmpty() bool {
+	return c.chain.IsEmpty()
+}
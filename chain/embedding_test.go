@@ -0,0 +1,40 @@
+package chain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEmbeddingFallbackChainCacheIsBounded(t *testing.T) {
+	built, buildErr := BuildChainFromSources(NewSliceSource(strings.Fields("cat sat")))
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	embeddings := MakeMapEmbeddings(map[string][]float64{
+		"cat":    {1, 0},
+		"sat":    {0, 1},
+		"kitten": {1, 0.1},
+		"kitty":  {1, 0.2},
+		"sit":    {0.1, 1},
+	})
+	fallback := NewEmbeddingFallbackChain(built.(IterableChain), embeddings, 0.5, 2)
+
+	for _, token := range []string{"kitten", "kitty", "sit"} {
+		if _, ok := fallback.RetrieveMarkovLink(token); !ok {
+			t.Fatalf("no link for %q", token)
+		}
+	}
+	if nearest, _, ok := fallback.Nearest("kitten"); !ok || nearest != "cat" {
+		t.Fatalf("kitten is nearest %q", nearest)
+	}
+	if _, _, ok := fallback.Nearest("dog"); ok {
+		t.Fatal("token without an embedding has a nearest key")
+	}
+
+	if fallback.lru.Len() != 2 || len(fallback.nearest) != 2 {
+		t.Fatalf("cached %d tokens, want 2", len(fallback.nearest))
+	}
+	if _, ok := fallback.nearest["dog"]; ok {
+		t.Fatal("token without an embedding was cached")
+	}
+}
@@ -0,0 +1,276 @@
+package chain
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// EmojiMode selects how an EmojiFilter treats emoji and emoticons
+type EmojiMode int
+
+const (
+	// EmojiStrip removes emoji and emoticons from tokens
+	EmojiStrip EmojiMode = iota
+	// EmojiSeparate splits emoji and emoticons into standalone tokens
+	EmojiSeparate
+	// EmojiShortcode splits emoji and emoticons into standalone :shortcode:
+	// placeholder tokens
+	EmojiShortcode
+)
+
+// DefaultEmojiShortcodes is the shortcode table used by EmojiFilter when none
+// is supplied. Emoji without a shortcode are named by their code points
+var DefaultEmojiShortcodes = map[string]string{
+	"😀":   ":grinning:",
+	"😂":   ":joy:",
+	"🤣":   ":rofl:",
+	"😊":   ":blush:",
+	"😍":   ":heart_eyes:",
+	"😉":   ":wink:",
+	"😢":   ":cry:",
+	"😭":   ":sob:",
+	"😡":   ":rage:",
+	"🤔":   ":thinking:",
+	"👍":   ":thumbsup:",
+	"👎":   ":thumbsdown:",
+	"👏":   ":clap:",
+	"🙏":   ":pray:",
+	"🔥":   ":fire:",
+	"🎉":   ":tada:",
+	"💯":   ":100:",
+	"❤️":  ":heart:",
+	"❤":   ":heart:",
+	":)":  ":slightly_smiling_face:",
+	":-)": ":slightly_smiling_face:",
+	":(":  ":slightly_frowning_face:",
+	":-(": ":slightly_frowning_face:",
+	":D":  ":smiley:",
+	":-D": ":smiley:",
+	";)":  ":wink:",
+	";-)": ":wink:",
+	":P":  ":stuck_out_tongue:",
+	":-P": ":stuck_out_tongue:",
+	":'(": ":cry:",
+	"<3":  ":heart:",
+}
+
+// emoticons are recognized as whole tokens or at the end of a token following
+// punctuation, longest first so ":-)" isn't read as ")"
+var emoticons = []string{":'(", ":-)", ":-(", ":-D", ":-P", ";-)", ":)", ":(", ":D", ":P", ";)", "<3"}
+
+const (
+	zeroWidthJoiner = '\u200d'
+	variationSelect = '\ufe0f'
+	keycapCombining = '\u20e3'
+)
+
+// isPictographic reports runes in the blocks holding emoji, many of which are
+// also ordinary symbols that only display as emoji when asked to
+func isPictographic(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF:
+		return true
+	case r >= 0x2600 && r <= 0x27BF:
+		return true
+	case r >= 0x2B00 && r <= 0x2BFF:
+		return true
+	case r == 0x00A9 || r == 0x00AE || r == 0x203C || r == 0x2049 || r == 0x2122:
+		return true
+	}
+	return false
+}
+
+// enclosedAlphanumerics holds the runes of the Enclosed Alphanumeric
+// Supplement that have Emoji_Presentation, the rest of it is letters and
+// digits in boxes and circles. Every other pictographic rune above it is
+// taken to be emoji
+var enclosedAlphanumerics = [][2]rune{{0x1F18E, 0x1F18E}, {0x1F191, 0x1F19A}}
+
+// emojiPresentation holds the ranges of runes in the symbol blocks that do
+// have Emoji_Presentation, every other rune there is text by default
+var emojiPresentation = [][2]rune{
+	{0x2614, 0x2615}, {0x2648, 0x2653}, {0x267F, 0x267F}, {0x2693, 0x2693},
+	{0x26A1, 0x26A1}, {0x26AA, 0x26AB}, {0x26BD, 0x26BE}, {0x26C4, 0x26C5},
+	{0x26CE, 0x26CE}, {0x26D4, 0x26D4}, {0x26EA, 0x26EA}, {0x26F2, 0x26F3},
+	{0x26F5, 0x26F5}, {0x26FA, 0x26FA}, {0x26FD, 0x26FD}, {0x2705, 0x2705},
+	{0x270A, 0x270B}, {0x2728, 0x2728}, {0x274C, 0x274C}, {0x274E, 0x274E},
+	{0x2753, 0x2755}, {0x2757, 0x2757}, {0x2795, 0x2797}, {0x27B0, 0x27B0},
+	{0x27BF, 0x27BF}, {0x2B1B, 0x2B1C}, {0x2B50, 0x2B50}, {0x2B55, 0x2B55},
+}
+
+func inRanges(r rune, ranges [][2]rune) bool {
+	for _, bounds := range ranges {
+		if r >= bounds[0] && r <= bounds[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasEmojiPresentation reports whether a pictographic rune displays as emoji
+// without a variation selector
+func hasEmojiPresentation(r rune) bool {
+	if r >= 0x1F100 && r <= 0x1F1E5 {
+		return inRanges(r, enclosedAlphanumerics)
+	}
+	if r >= 0x1F000 {
+		return true
+	}
+	return inRanges(r, emojiPresentation)
+}
+
+// isEmojiAt reports whether the rune at i starts an emoji, so symbols such
+// as ©, ™ and ✓ are only emoji when followed by variationSelect
+func isEmojiAt(runes []rune, i int) bool {
+	r := runes[i]
+	if !isPictographic(r) {
+		return false
+	}
+	if hasEmojiPresentation(r) {
+		return true
+	}
+	return i+1 < len(runes) && runes[i+1] == variationSelect
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// isEmojiModifier reports runes that extend the preceding emoji: variation
+// selectors, skin tones, keycaps and tag sequences
+func isEmojiModifier(r rune) bool {
+	return r == variationSelect || r == keycapCombining ||
+		(r >= 0x1F3FB && r <= 0x1F3FF) || (r >= 0xE0020 && r <= 0xE007F)
+}
+
+// splitEmoji splits a token into text and emoji segments, keeping ZWJ
+// sequences, modifiers and flag pairs together
+func splitEmoji(token string) (segments []string, isEmoji []bool) {
+	runes := []rune(token)
+	text := make([]rune, 0, len(runes))
+	flushText := func() {
+		if len(text) > 0 {
+			segments = append(segments, string(text))
+			isEmoji = append(isEmoji, false)
+			text = text[:0]
+		}
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if !isEmojiAt(runes, i) {
+			text = append(text, r)
+			i++
+			continue
+		}
+
+		start := i
+		if isRegionalIndicator(r) && i+1 < len(runes) && isRegionalIndicator(runes[i+1]) {
+			i += 2
+		} else {
+			i++
+		}
+		for i < len(runes) {
+			if isEmojiModifier(runes[i]) {
+				i++
+			} else if runes[i] == zeroWidthJoiner && i+1 < len(runes) && isPictographic(runes[i+1]) {
+				i += 2
+			} else {
+				break
+			}
+		}
+
+		flushText()
+		segments = append(segments, string(runes[start:i]))
+		isEmoji = append(isEmoji, true)
+	}
+	flushText()
+
+	return segments, isEmoji
+}
+
+// trailingEmoticon finds an emoticon making up the whole token or ending it
+// after whitespace, punctuation or a symbol, so the ends of words such as
+// "http:P" or "C:D" aren't mistaken for emoticons
+func trailingEmoticon(token string) (string, bool) {
+	for _, v := range emoticons {
+		if !strings.HasSuffix(token, v) {
+			continue
+		}
+		rest := strings.TrimSuffix(token, v)
+		if rest == "" {
+			return v, true
+		}
+		r, _ := utf8.DecodeLastRuneInString(rest)
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) || isEmojiModifier(r) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func emojiShortcode(emoji string, shortcodes map[string]string) string {
+	if code, ok := shortcodes[emoji]; ok {
+		return code
+	}
+
+	names := make([]string, 0)
+	for _, r := range emoji {
+		if r == zeroWidthJoiner || r == variationSelect {
+			continue
+		}
+		names = append(names, fmt.Sprintf("u%x", r))
+	}
+	return ":" + strings.Join(names, "_") + ":"
+}
+
+// EmojiFilter filters a TokenSource by separating emoji (including multi-rune
+// ZWJ sequences, skin tones and flags) and trailing ASCII emoticons from the
+// words they are attached to, then stripping them, keeping them as
+// standalone tokens or replacing them with :shortcode: tokens depending on
+// mode. Symbols displayed as text by default, such as © and ✓, are only
+// taken as emoji when followed by the emoji variation selector. If
+// shortcodes is nil DefaultEmojiShortcodes is used
+func EmojiFilter(mode EmojiMode, shortcodes map[string]string) SourceFilter {
+	if shortcodes == nil {
+		shortcodes = DefaultEmojiShortcodes
+	}
+
+	emit := func(emoji string) []string {
+		switch mode {
+		case EmojiSeparate:
+			return []string{emoji}
+		case EmojiShortcode:
+			return []string{emojiShortcode(emoji, shortcodes)}
+		default:
+			return nil
+		}
+	}
+
+	return MakeFuncFilter(func(candidate string) ([]string, error) {
+		tokens := make([]string, 0, 1)
+
+		var emoticon string
+		if v, ok := trailingEmoticon(candidate); ok {
+			emoticon = v
+			candidate = strings.TrimSuffix(candidate, v)
+		}
+
+		segments, isEmoji := splitEmoji(candidate)
+		for i, segment := range segments {
+			if isEmoji[i] {
+				tokens = append(tokens, emit(segment)...)
+			} else {
+				tokens = append(tokens, segment)
+			}
+		}
+
+		if emoticon != "" {
+			tokens = append(tokens, emit(emoticon)...)
+		}
+
+		return tokens, nil
+	})
+}
@@ -0,0 +1,46 @@
+package chain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEmojiFilterKeepsWords(t *testing.T) {
+	filter := EmojiFilter(EmojiStrip, nil)
+	for _, word := range []string{
+		"Microsoft®", "Coca-Cola™", "©2024", "✓done", "★★★", "→next", "☎", "⬆up",
+		"http:P", "C:D", "x<3", "e.g.", "(sic)",
+	} {
+		got, filterErr := filter.FilterToken(word)
+		if filterErr != nil {
+			t.Fatal(filterErr)
+		}
+		if !reflect.DeepEqual(got, []string{word}) {
+			t.Errorf("%q filtered to %q", word, got)
+		}
+	}
+}
+
+func TestEmojiFilterSeparatesEmoji(t *testing.T) {
+	filter := EmojiFilter(EmojiSeparate, nil)
+	for word, want := range map[string][]string{
+		"great😂":   {"great", "😂"},
+		"love❤️":   {"love", "❤️"},
+		"done✅":    {"done", "✅"},
+		"☕time":    {"☕", "time"},
+		"nice👍🏽":   {"nice", "👍🏽"},
+		"hi👨‍👩‍👧":  {"hi", "👨‍👩‍👧"},
+		":)":       {":)"},
+		"great!:)": {"great!", ":)"},
+		"wow.:D":   {"wow.", ":D"},
+		"🔥<3":      {"🔥", "<3"},
+	} {
+		got, filterErr := filter.FilterToken(word)
+		if filterErr != nil {
+			t.Fatal(filterErr)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q filtered to %q, want %q", word, got, want)
+		}
+	}
+}
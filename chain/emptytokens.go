@@ -0,0 +1,43 @@
+package chain
+
+// EmptyTokenPolicy decides what happens to empty tokens read while building,
+// as the empty token also marks the start and end of every sequence
+type EmptyTokenPolicy int
+
+const (
+	// EmptyTokenKeep adds empty tokens to the chain as they are, so each one
+	// ends the sequence and starts another without being counted as a
+	// sequence of its own
+	EmptyTokenKeep EmptyTokenPolicy = iota
+
+	// EmptyTokenDrop skips empty tokens
+	EmptyTokenDrop
+
+	// EmptyTokenPlaceholder replaces empty tokens with a placeholder so they
+	// are kept without ending the sequence
+	EmptyTokenPlaceholder
+)
+
+// DefaultEmptyPlaceholder replaces empty tokens under EmptyTokenPlaceholder
+// if BuildOptions.EmptyPlaceholder isn't set
+const DefaultEmptyPlaceholder = "<empty>"
+
+// emptyToken applies the policy of opts to a token, reporting false if it
+// should be skipped
+func (opts BuildOptions) emptyToken(token string) (string, bool) {
+	if token != "" {
+		return token, true
+	}
+
+	switch opts.EmptyTokens {
+	case EmptyTokenDrop:
+		return "", false
+	case EmptyTokenPlaceholder:
+		if opts.EmptyPlaceholder != "" {
+			return opts.EmptyPlaceholder, true
+		}
+		return DefaultEmptyPlaceholder, true
+	default:
+		return "", true
+	}
+}
@@ -0,0 +1,106 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+var (
+	// ErrKeyNotFound is returned by operations that require a key token to
+	// be present in a chain
+	ErrKeyNotFound = errors.New("chain: key not found")
+
+	// ErrEmptyChain is returned by operations that require a chain to hold
+	// at least one transition
+	ErrEmptyChain = errors.New("chain: chain is empty")
+
+	// ErrUncountableChain is returned when an operation requires a chain that
+	// can enumerate its tokens and expose raw occurrence counts
+	ErrUncountableChain = errors.New("chain: chain does not expose its tokens and counts")
+
+	// ErrMalformedChain is returned when a persisted chain is corrupt or
+	// exceeds the configured DecodeOptions
+	ErrMalformedChain = errors.New("chain: malformed chain")
+
+	// ErrUnwritableChain is returned when a persisted chain is read as a
+	// WritableChain but is of a type that can't be modified
+	ErrUnwritableChain = errors.New("chain: chain type is not writable")
+
+	// ErrInvalidCount is returned when a transition is incremented by a
+	// count that isn't positive
+	ErrInvalidCount = errors.New("chain: count must be positive")
+
+	// ErrUnknownName is returned when a named component, such as a key
+	// normalizer, has not been registered
+	ErrUnknownName = errors.New("chain: unknown name")
+
+	// ErrFrozen is returned when modifying transitions that can't be changed,
+	// such as those of a frozen chain or the base of an Overlay
+	ErrFrozen = errors.New("chain: chain is frozen")
+
+	// ErrTransactionDone is returned when using a Transaction that has been
+	// committed or rolled back
+	ErrTransactionDone = errors.New("chain: transaction already committed or rolled back")
+
+	// ErrNotNovel is returned when generation can't produce a sequence that
+	// isn't a copy of a training sequence
+	ErrNotNovel = errors.New("chain: no novel sequence generated")
+
+	// ErrTooSimilar is returned when generation can't produce enough
+	// sequences that differ from each other
+	ErrTooSimilar = errors.New("chain: not enough diverse sequences generated")
+)
+
+// SourceError wraps an error returned by a TokenSource while building
+type SourceError struct {
+	// SourceIndex is the position of the failing source in the sources
+	// passed to the build
+	SourceIndex int
+	Err         error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("chain: source %d: %v", e.SourceIndex, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// FilterError wraps an error returned by a SourceFilter
+type FilterError struct {
+	// Token is the candidate token the filter failed on
+	Token string
+	Err   error
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("chain: filtering token %q: %v", e.Token, e.Err)
+}
+
+func (e *FilterError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is returned when a panic, e.g. in a user supplied filter or
+// source, is recovered from one of the package's goroutines
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("chain: recovered panic: %v", e.Value)
+}
+
+// recoverPanic must be deferred, it converts a panic into a PanicError and
+// passes it to report
+func recoverPanic(report func(err error)) {
+	if value := recover(); value != nil {
+		report(&PanicError{
+			Value: value,
+			Stack: debug.Stack(),
+		})
+	}
+}
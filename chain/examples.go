@@ -0,0 +1,145 @@
+package chain
+
+import (
+	"sync"
+)
+
+// Example is a snippet of training data a transition was learned from
+type Example struct {
+	// Source is the index of the source the snippet came from, or the line
+	// number for chains built with BuildFromReader
+	Source int
+	Tokens []string
+}
+
+// exampleOverhead is the rough per-example cost in bytes beyond its tokens
+const exampleOverhead = 64
+
+// ExampleStore retains up to a fixed number of example snippets per
+// transition, within an overall memory budget, so tools can show where a
+// chain learned a transition. Once the budget is spent further examples are
+// discarded. An ExampleStore is safe for concurrent use
+type ExampleStore struct {
+	perTransition int
+	contextSize   int
+	maxBytes      int64
+	storeTex      sync.RWMutex
+	usedBytes     int64
+	examples      map[string][]Example
+}
+
+// NewExampleStore creates an ExampleStore keeping up to perTransition
+// examples of each transition, each with up to contextSize tokens either
+// side of the transition, using up to roughly maxBytes of memory. A maxBytes
+// of zero means no budget
+func NewExampleStore(perTransition int, contextSize int, maxBytes int64) *ExampleStore {
+	return &ExampleStore{
+		perTransition: perTransition,
+		contextSize:   contextSize,
+		maxBytes:      maxBytes,
+		examples:      make(map[string][]Example),
+	}
+}
+
+func (s *ExampleStore) record(prev string, next string, example Example) {
+	size := int64(exampleOverhead)
+	for _, token := range example.Tokens {
+		size += int64(len(token))
+	}
+
+	key := joinKey([]string{prev, next})
+
+	s.storeTex.Lock()
+	defer s.storeTex.Unlock()
+	if len(s.examples[key]) >= s.perTransition {
+		return
+	}
+	if s.maxBytes > 0 && s.usedBytes+size > s.maxBytes {
+		return
+	}
+
+	s.examples[key] = append(s.examples[key], example)
+	s.usedBytes += size
+}
+
+// Examples retrieves the snippets retained for a transition
+func (s *ExampleStore) Examples(prev string, next string) []Example {
+	s.storeTex.RLock()
+	defer s.storeTex.RUnlock()
+	return append([]Example(nil), s.examples[joinKey([]string{prev, next})]...)
+}
+
+// UsedBytes reports the approximate memory used by retained examples
+func (s *ExampleStore) UsedBytes() int64 {
+	s.storeTex.RLock()
+	defer s.storeTex.RUnlock()
+	return s.usedBytes
+}
+
+// exampleRecorder follows a single sequence, recording each transition once
+// enough following tokens have been seen to complete its snippet
+type exampleRecorder struct {
+	store  *ExampleStore
+	source int
+	// recent holds the sequence's most recent tokens, preceded by the empty
+	// boundary token until it scrolls out
+	recent []string
+	// pending is the number of transitions at the end of recent that have
+	// yet to be recorded
+	pending int
+}
+
+func (s *ExampleStore) newRecorder(source int) *exampleRecorder {
+	return &exampleRecorder{
+		store:  s,
+		source: source,
+		recent: []string{""},
+	}
+}
+
+func (r *exampleRecorder) add(token string) {
+	r.recent = append(r.recent, token)
+	r.pending++
+	if r.pending > r.store.contextSize {
+		r.emit(len(r.recent) - r.pending)
+		r.pending--
+	}
+
+	// keep enough tokens for the earliest pending transition's context
+	if keep := 2*r.store.contextSize + 2; len(r.recent) > keep {
+		r.recent = append(r.recent[:0], r.recent[len(r.recent)-keep:]...)
+	}
+}
+
+func (r *exampleRecorder) finish() {
+	r.recent = append(r.recent, "")
+	r.pending++
+	for r.pending > 0 {
+		r.emit(len(r.recent) - r.pending)
+		r.pending--
+	}
+}
+
+// emit records the transition into recent[at]
+func (r *exampleRecorder) emit(at int) {
+	start := at - 1 - r.store.contextSize
+	if start < 0 {
+		start = 0
+	}
+	end := at + 1 + r.store.contextSize
+	if end > len(r.recent) {
+		end = len(r.recent)
+	}
+
+	tokens := make([]string, 0, end-start)
+	for _, token := range r.recent[start:end] {
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+
+	r.store.record(r.recent[at-1], r.recent[at], Example{
+		Source: r.source,
+		Tokens: tokens,
+	})
+}
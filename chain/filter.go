@@ -1,6 +1,11 @@
 package chain
 
-import "strings"
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 // SourceFilter performs transforms on candidate tokens before they are fed into
 // the Markov chain
@@ -20,6 +25,10 @@ type filteredSource struct {
 }
 
 func (s *filteredSource) NextToken() (string, error) {
+	return s.NextTokenContext(context.Background())
+}
+
+func (s *filteredSource) NextTokenContext(ctx context.Context) (string, error) {
 	queueLen := len(s.queue)
 	if s.index < queueLen {
 		next := s.queue[s.index]
@@ -33,13 +42,13 @@ func (s *filteredSource) NextToken() (string, error) {
 	}
 
 	for {
-		candidate, readErr := s.src.NextToken()
+		candidate, readErr := nextTokenContext(ctx, s.src)
 		if readErr != nil {
 			return "", readErr
 		} else {
 			tokens, tokenErr := s.filter.FilterToken(candidate)
 			if tokenErr != nil {
-				return "", tokenErr
+				return "", &FilterError{Token: candidate, Err: tokenErr}
 			} else {
 				count := len(tokens)
 				if count == 1 {
@@ -55,6 +64,10 @@ func (s *filteredSource) NextToken() (string, error) {
 	}
 }
 
+func (s *filteredSource) Close() error {
+	return closeSource(s.src)
+}
+
 // MakeFilteredTokenSources applies a specified filter to number bunch of TokenSources
 // and returns TokeSources with the filters applied
 func MakeFilteredTokenSources(filter SourceFilter, sources ...TokenSource) []TokenSource {
@@ -153,3 +166,81 @@ func PrefixFilter(prefix string, iterate bool) SourceFilter {
 		}
 	})
 }
+
+// englishStopWords are common English function words that carry little
+// meaning on their own
+var englishStopWords = []string{
+	"a", "about", "above", "after", "again", "against", "all", "am", "an", "and",
+	"any", "are", "as", "at", "be", "because", "been", "before", "being", "below",
+	"between", "both", "but", "by", "can", "could", "did", "do", "does", "doing",
+	"down", "during", "each", "few", "for", "from", "further", "had", "has", "have",
+	"having", "he", "her", "here", "hers", "herself", "him", "himself", "his", "how",
+	"i", "if", "in", "into", "is", "it", "its", "itself", "just", "me",
+	"more", "most", "my", "myself", "no", "nor", "not", "now", "of", "off",
+	"on", "once", "only", "or", "other", "our", "ours", "ourselves", "out", "over",
+	"own", "same", "she", "should", "so", "some", "such", "than", "that", "the",
+	"their", "theirs", "them", "themselves", "then", "there", "these", "they", "this", "those",
+	"through", "to", "too", "under", "until", "up", "very", "was", "we", "were",
+	"what", "when", "where", "which", "while", "who", "whom", "why", "will", "with",
+	"would", "you", "your", "yours", "yourself", "yourselves",
+}
+
+// DefaultEnglishStopWords retrieves a list of common English stop words, for
+// use with StopWordFilter alone or extended with a corpus's own
+func DefaultEnglishStopWords() []string {
+	return append([]string(nil), englishStopWords...)
+}
+
+// StopWordFilter filters a TokenSource by dropping candidate tokens that are
+// one of the stop words, ignoring case
+func StopWordFilter(stopwords ...string) SourceFilter {
+	stopSet := make(map[string]bool, len(stopwords))
+	for _, v := range stopwords {
+		stopSet[strings.ToLower(v)] = true
+	}
+
+	return MakeFuncFilter(func(candidate string) ([]string, error) {
+		if stopSet[strings.ToLower(candidate)] {
+			return []string{}, nil
+		}
+		return []string{candidate}, nil
+	})
+}
+
+// RegexSplitFilter filters a TokenSource by splitting candidate tokens at
+// every match of pattern, dropping empty pieces, e.g. "[-/]" to split
+// hyphenated and slashed words
+func RegexSplitFilter(pattern string) (SourceFilter, error) {
+	expr, compileErr := regexp.Compile(pattern)
+	if compileErr != nil {
+		return nil, fmt.Errorf("chain: invalid split pattern: %w", compileErr)
+	}
+
+	return MakeFuncFilter(func(candidate string) ([]string, error) {
+		pieces := expr.Split(candidate, -1)
+		tokens := pieces[:0]
+		for _, piece := range pieces {
+			if piece != "" {
+				tokens = append(tokens, piece)
+			}
+		}
+		return tokens, nil
+	}), nil
+}
+
+// RegexRemoveFilter filters a TokenSource by removing every match of
+// pattern from candidate tokens, dropping tokens that are left empty, e.g.
+// "[[:punct:]]" to strip punctuation
+func RegexRemoveFilter(pattern string) (SourceFilter, error) {
+	expr, compileErr := regexp.Compile(pattern)
+	if compileErr != nil {
+		return nil, fmt.Errorf("chain: invalid remove pattern: %w", compileErr)
+	}
+
+	return MakeFuncFilter(func(candidate string) ([]string, error) {
+		if removed := expr.ReplaceAllString(candidate, ""); removed != "" {
+			return []string{removed}, nil
+		}
+		return []string{}, nil
+	}), nil
+}
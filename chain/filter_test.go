@@ -0,0 +1,18 @@
+package chain
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStopWordFilter(t *testing.T) {
+	filter := StopWordFilter(append(DefaultEnglishStopWords(), "rt")...)
+	got, collectErr := CollectTokens(ApplyFiltersToSource(NewSliceSource(strings.Fields("RT The cat would sit")), filter))
+	if collectErr != nil {
+		t.Fatal(collectErr)
+	}
+	if want := []string{"cat", "sit"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
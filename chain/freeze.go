@@ -0,0 +1,70 @@
+package chain
+
+import "math/rand"
+
+// frozenChain is a read only chain whose links all have sampling tables
+type frozenChain struct {
+	chain *singleKeyChain
+}
+
+// Freeze finalizes a chain once building is complete, precomputing a
+// cumulative weights table for every link so each sample is a binary search
+// rather than a scan of the link's successors, and is reproducible for a
+// given rand. A chain built by this package is frozen in place and must not
+// be modified afterwards, any other chain is copied. Modifying the frozen
+// chain returns ErrFrozen
+func Freeze(chain MarkovChain) (WritableChain, error) {
+	if frozen, ok := chain.(*frozenChain); ok {
+		return frozen, nil
+	}
+
+	source, ok := chain.(*singleKeyChain)
+	if !ok {
+		source = newSingleKeyChain()
+		if copyErr := Copy(source, chain); copyErr != nil {
+			return nil, copyErr
+		}
+		if lengths, ok := chain.(LengthModel); ok && lengths.SequenceLengths() != nil {
+			source.Lengths.merge(lengths.SequenceLengths())
+		}
+	}
+
+	for _, link := range source.Links {
+		if link.Total > 0 && link.Cumulative == nil {
+			link.Cumulative = newCumulativeTable(link)
+		}
+	}
+	return &frozenChain{chain: source}, nil
+}
+
+func (c *frozenChain) CalculateNextToken(token string, rand *rand.Rand) (string, bool) {
+	return c.chain.CalculateNextToken(token, rand)
+}
+
+func (c *frozenChain) RetrieveMarkovLink(token string) (MarkovChainLink, bool) {
+	return c.chain.RetrieveMarkovLink(token)
+}
+
+func (c *frozenChain) RetrieveTokens() []string {
+	return c.chain.RetrieveTokens()
+}
+
+func (c *frozenChain) IsEmpty() bool {
+	return c.chain.IsEmpty()
+}
+
+func (c *frozenChain) SequenceLengths() *LengthDistribution {
+	return c.chain.Lengths
+}
+
+func (c *frozenChain) Increment(prev string, next string, n int) error {
+	return ErrFrozen
+}
+
+func (c *frozenChain) RemoveSuccessor(prev string, next string) error {
+	return ErrFrozen
+}
+
+func (c *frozenChain) SetCount(prev string, next string, n int) error {
+	return ErrFrozen
+}
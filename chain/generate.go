@@ -0,0 +1,323 @@
+package chain
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// EndHazard gives the probability of forcing a generated sequence to end
+// once it has reached the given length, on top of the chain's own
+// probability of producing the end of sequence token
+type EndHazard func(length int) float64
+
+// ConstantHazard ends a sequence with a fixed probability after every
+// token, giving generated lengths a geometric tail
+func ConstantHazard(probability float64) EndHazard {
+	return func(length int) float64 {
+		return probability
+	}
+}
+
+// LinearHazard has no effect until a sequence reaches onset tokens, after
+// which the probability of ending grows by slope with each token
+func LinearHazard(onset int, slope float64) EndHazard {
+	return func(length int) float64 {
+		if length < onset {
+			return 0
+		}
+
+		hazard := float64(length-onset+1) * slope
+		if hazard > 1 {
+			return 1
+		}
+		return hazard
+	}
+}
+
+// QueryWeightFunc re-weights a transition at generation time, given its
+// previous and next tokens and the number of times it occurred. The
+// returned weight replaces the count, so returning float64(count) leaves
+// the transition unchanged; weights at or below zero exclude it
+type QueryWeightFunc func(prev string, next string, count int) float64
+
+// GenerateOptions configures Generate
+type GenerateOptions struct {
+	// Start is the token generation begins after, the empty token starts a
+	// new sequence
+	Start string
+
+	// RandomStart, if set, replaces Start with a token chosen uniformly at
+	// random from the chain's keys, which is included as the first token
+	// generated, or the tokens of the key of a MultiKeyChain. The chain must
+	// be an IterableChain
+	RandomStart bool
+
+	// MaxTokens caps the number of tokens generated
+	MaxTokens int
+
+	// StopTokens end generation once any of them is generated, the stop
+	// token is included, e.g. to stop at the end of a sentence
+	StopTokens map[string]bool
+
+	// EndHazard, if set, boosts the probability of ending the sequence as it
+	// grows, so chains that rarely produce the end of sequence token still
+	// produce sequences of a controllable length
+	EndHazard EndHazard
+
+	// Lengths, if set, is sampled for a target length. Generation stops at
+	// the target and avoids ending earlier wherever the chain offers an
+	// alternative to the end of sequence token. A chain's LengthModel
+	// provides the distribution of its training data
+	Lengths *LengthDistribution
+
+	// Weight, if set, re-weights every transition considered, e.g. to boost
+	// words related to a request. The chain's links must be CountedLinks
+	Weight QueryWeightFunc
+
+	// Steering, if set, boosts transitions leading towards keywords so
+	// generated sequences stay on topic
+	Steering *Steering
+
+	// Exclude lists tokens that are never generated, the probabilities of
+	// the remaining successors are renormalized. Generation stops if every
+	// successor of a token is excluded. The chain's links must be
+	// CountedLinks
+	Exclude map[string]bool
+
+	// Novel, if set, rejects and resamples sequences that are verbatim
+	// copies of a training sequence it indexed. ErrNotNovel is returned
+	// with the last sequence if none of NovelAttempts sequences is novel
+	Novel *SequenceIndex
+
+	// NovelAttempts bounds how many sequences are generated looking for a
+	// novel one, defaults to DefaultNovelAttempts
+	NovelAttempts int
+}
+
+// DefaultNovelAttempts is the number of sequences generated looking for a
+// novel one if GenerateOptions.NovelAttempts isn't set
+const DefaultNovelAttempts = 32
+
+// maxEndRetries bounds how many times Generate resamples to avoid ending a
+// sequence before its target length
+const maxEndRetries = 16
+
+// Generate walks a chain from opts.Start until the end of sequence token or
+// one of opts.StopTokens is produced, the current token has no link, or
+// opts.MaxTokens are generated.
+// ErrEmptyChain is returned if the chain holds no transitions, and
+// ErrKeyNotFound if the start token isn't in the chain
+func Generate(chain MarkovChain, rand *rand.Rand, opts GenerateOptions) ([]string, error) {
+	return GenerateContext(context.Background(), chain, rand, opts)
+}
+
+// contextDone reports whether ctx is done without blocking
+func contextDone(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// GenerateContext behaves as Generate, but stops once ctx is done, returning
+// the tokens generated so far along with ctx.Err()
+func GenerateContext(ctx context.Context, chain MarkovChain, rand *rand.Rand, opts GenerateOptions) ([]string, error) {
+	if IsEmpty(chain) {
+		return nil, ErrEmptyChain
+	}
+	if opts.RandomStart {
+		start, startErr := randomStart(chain, rand)
+		if startErr != nil {
+			return nil, startErr
+		}
+		opts.Start = start
+	}
+	if _, ok := chain.RetrieveMarkovLink(opts.Start); !ok {
+		if opts.Start == "" {
+			return nil, ErrEmptyChain
+		}
+		return nil, ErrKeyNotFound
+	}
+
+	if opts.Steering != nil {
+		steered, steerErr := opts.Steering.weight(chain, opts.Weight)
+		if steerErr != nil {
+			return nil, steerErr
+		}
+		opts.Weight = steered
+	}
+	if len(opts.Exclude) > 0 {
+		opts.Weight = excludeWeight(opts.Exclude, opts.Weight)
+	}
+
+	if opts.Novel == nil {
+		return generateSequence(ctx, chain, rand, opts)
+	}
+	attempts := opts.NovelAttempts
+	if attempts <= 0 {
+		attempts = DefaultNovelAttempts
+	}
+	var tokens []string
+	for attempt := 0; attempt < attempts; attempt++ {
+		var generateErr error
+		tokens, generateErr = generateSequence(ctx, chain, rand, opts)
+		if generateErr != nil || !opts.Novel.Contains(tokens) {
+			return tokens, generateErr
+		}
+	}
+	return tokens, ErrNotNovel
+}
+
+// generateSequence generates a single sequence for GenerateContext once
+// the options have been prepared
+func generateSequence(ctx context.Context, chain MarkovChain, rand *rand.Rand, opts GenerateOptions) ([]string, error) {
+	maxTokens := opts.MaxTokens
+	target := 0
+	if opts.Lengths != nil {
+		if target = opts.Lengths.Sample(rand); target < maxTokens {
+			maxTokens = target
+		}
+	}
+
+	tokens := make([]string, 0)
+	// history holds the start token followed by the tokens generated so
+	// far, so chains keyed on several tokens can look up the full context
+	history := keyTokens(chain, opts.Start)
+	if opts.RandomStart {
+		tokens = append(tokens, startTokens(history)...)
+	}
+	for len(tokens) < maxTokens {
+		if contextDone(ctx) {
+			return tokens, ctx.Err()
+		}
+		if opts.EndHazard != nil && len(tokens) > 0 && rand.Float64() < opts.EndHazard(len(tokens)) {
+			break
+		}
+
+		next, ok := nextToken(chain, history, rand, opts)
+		for retry := 0; ok && next == "" && len(tokens) < target && retry < maxEndRetries; retry++ {
+			next, ok = nextToken(chain, history, rand, opts)
+		}
+		if !ok || next == "" {
+			break
+		}
+
+		tokens = append(tokens, next)
+		history = append(history, next)
+		if opts.StopTokens[next] {
+			break
+		}
+	}
+
+	return tokens, nil
+}
+
+// startTokens retrieves the tokens of a start history that follow the
+// boundary
+func startTokens(history []string) []string {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i] == "" {
+			return history[i+1:]
+		}
+	}
+	return history
+}
+
+// randomStart chooses a key of the chain uniformly at random, ignoring the
+// boundary and keys used internally
+func randomStart(chain MarkovChain, rand *rand.Rand) (string, error) {
+	iterable, ok := chain.(IterableChain)
+	if !ok {
+		return "", ErrUncountableChain
+	}
+
+	// sort candidates so the choice is reproducible for a given rand
+	candidates := make([]string, 0)
+	for _, token := range iterable.RetrieveTokens() {
+		if !isBoundaryKey(token) && !isOverflowKey(token) && !strings.HasPrefix(token, replyPrefix) {
+			candidates = append(candidates, token)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", ErrEmptyChain
+	}
+	sort.Strings(candidates)
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
+// excludeWeight wraps base so excluded tokens have no weight
+func excludeWeight(exclude map[string]bool, base QueryWeightFunc) QueryWeightFunc {
+	return func(prev string, next string, count int) float64 {
+		if exclude[next] {
+			return 0
+		}
+		if base != nil {
+			return base(prev, next, count)
+		}
+		return float64(count)
+	}
+}
+
+// nextToken samples the token following the last token of history,
+// applying opts.Weight
+func nextToken(chain MarkovChain, history []string, rand *rand.Rand, opts GenerateOptions) (string, bool) {
+	if _, ok := chain.(HistoryChain); ok && opts.Weight == nil {
+		return CalculateNextTokenFromHistory(chain, history, rand)
+	}
+	current := history[len(history)-1]
+	if opts.Weight == nil {
+		return chain.CalculateNextToken(current, rand)
+	}
+
+	link, ok := retrieveLink(chain, history)
+	if !ok {
+		return "", false
+	}
+	counted, ok := link.(CountedLink)
+	if !ok {
+		return "", false
+	}
+
+	// sort candidates so sampling is reproducible for a given rand
+	candidates := counted.RetrieveNextTokenPossibilities()
+	sort.Strings(candidates)
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, next := range candidates {
+		count, _ := counted.GetOccurrencesOfToken(next)
+		if weight := opts.Weight(current, next, count); weight > 0 {
+			weights[i] = weight
+			total += weight
+		}
+	}
+	if total <= 0 {
+		return "", false
+	}
+
+	goal := rand.Float64() * total
+	for i, weight := range weights {
+		goal -= weight
+		if goal < 0 {
+			return candidates[i], true
+		}
+	}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return candidates[i], true
+		}
+	}
+	return "", false
+}
+
+// IsEmpty reports whether a chain holds no transitions. Chains that can't be
+// enumerated are assumed not to be empty
+func IsEmpty(chain MarkovChain) bool {
+	if checker, ok := chain.(interface{ IsEmpty() bool }); ok {
+		return checker.IsEmpty()
+	}
+	return false
+}
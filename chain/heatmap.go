@@ -0,0 +1,40 @@
+package chain
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// ExportHeatmapCSV writes the probabilities of the transitions among a
+// subset of tokens as a CSV matrix, with a row for each token it follows
+// and a column for each token that follows it, e.g. for plotting the
+// transitions between the states of a simulation. Probabilities are those
+// of the whole chain, so rows needn't sum to one. Tokens without a link have
+// a row of zeros
+func ExportHeatmapCSV(chain MarkovChain, tokens []string, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := append([]string{""}, tokens...)
+	if writeErr := writer.Write(header); writeErr != nil {
+		return writeErr
+	}
+
+	row := make([]string, len(tokens)+1)
+	for _, prev := range tokens {
+		row[0] = prev
+		link, ok := chain.RetrieveMarkovLink(prev)
+		for i, next := range tokens {
+			probability := 0.0
+			if ok {
+				probability, _ = link.GetProbabilityOfToken(next)
+			}
+			row[i+1] = strconv.FormatFloat(probability, 'g', -1, 64)
+		}
+		if writeErr := writer.Write(row); writeErr != nil {
+			return writeErr
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
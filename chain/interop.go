@@ -0,0 +1,123 @@
+package chain
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// boundary tokens used by github.com/mb-14/gomarkov
+const (
+	gomarkovStart = "^"
+	gomarkovEnd   = "$"
+)
+
+// gomarkovChain is the JSON form of a github.com/mb-14/gomarkov chain. Every
+// token and n-gram is interned in the spool, and the frequency matrix maps
+// the spool index of an n-gram to the counts of the spool indexes of the
+// tokens that followed it
+type gomarkovChain struct {
+	Order    int                 `json:"int"`
+	SpoolMap map[string]int      `json:"spool_map"`
+	FreqMat  map[int]map[int]int `json:"freq_mat"`
+}
+
+// ReadGomarkovJSON imports a chain saved as JSON by github.com/mb-14/gomarkov
+// into dst. Only order 1 chains can be imported, as their states are single
+// tokens
+func ReadGomarkovJSON(r io.Reader, dst WritableChain) error {
+	imported := gomarkovChain{}
+	if decodeErr := json.NewDecoder(r).Decode(&imported); decodeErr != nil {
+		return decodeErr
+	}
+	if imported.Order != 1 {
+		return malformed("gomarkov chain of order %d, only order 1 is supported", imported.Order)
+	}
+
+	tokens := make(map[int]string, len(imported.SpoolMap))
+	for token, index := range imported.SpoolMap {
+		tokens[index] = token
+	}
+	for prevIndex, successors := range imported.FreqMat {
+		prev, ok := tokens[prevIndex]
+		if !ok {
+			return malformed("gomarkov state %d is not in the spool", prevIndex)
+		}
+		if prev == gomarkovStart {
+			prev = ""
+		}
+
+		for nextIndex, count := range successors {
+			next, ok := tokens[nextIndex]
+			if !ok {
+				return malformed("gomarkov token %d is not in the spool", nextIndex)
+			}
+			if next == gomarkovEnd {
+				next = ""
+			}
+			if count <= 0 {
+				return malformed("transition %q to %q has count %d", prev, next, count)
+			}
+			if incErr := dst.Increment(prev, next, count); incErr != nil {
+				return incErr
+			}
+		}
+	}
+
+	return nil
+}
+
+// WriteGomarkovJSON exports a chain as an order 1 github.com/mb-14/gomarkov
+// chain. Tokens equal to gomarkov's "^" and "$" boundary tokens can't be
+// distinguished from sequence boundaries once exported
+func WriteGomarkovJSON(w io.Writer, chain MarkovChain) error {
+	iterable, ok := chain.(IterableChain)
+	if !ok {
+		return ErrUncountableChain
+	}
+
+	transitions := make([]Transition, 0)
+	iterateErr := forEachTransition(iterable, func(t Transition) error {
+		if t.Prev == "" {
+			t.Prev = gomarkovStart
+		}
+		if t.Next == "" {
+			t.Next = gomarkovEnd
+		}
+		transitions = append(transitions, t)
+		return nil
+	})
+	if iterateErr != nil {
+		return iterateErr
+	}
+
+	// tokens are interned in sorted order so the output is reproducible
+	tokenSet := make(map[string]bool)
+	for _, t := range transitions {
+		tokenSet[t.Prev] = true
+		tokenSet[t.Next] = true
+	}
+	tokens := make([]string, 0, len(tokenSet))
+	for token := range tokenSet {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+
+	exported := gomarkovChain{
+		Order:    1,
+		SpoolMap: make(map[string]int, len(tokens)),
+		FreqMat:  make(map[int]map[int]int),
+	}
+	for i, token := range tokens {
+		exported.SpoolMap[token] = i
+	}
+	for _, t := range transitions {
+		prev := exported.SpoolMap[t.Prev]
+		if exported.FreqMat[prev] == nil {
+			exported.FreqMat[prev] = make(map[int]int)
+		}
+		exported.FreqMat[prev][exported.SpoolMap[t.Next]] += t.Count
+	}
+
+	return json.NewEncoder(w).Encode(exported)
+}
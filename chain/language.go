@@ -0,0 +1,270 @@
+package chain
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// UndeterminedLanguage is reported when a LanguageDetector can't identify
+// the language of some text
+const UndeterminedLanguage = ""
+
+// LanguageDetector identifies the language of text, returning a language
+// code (e.g. "en") and a confidence between 0 and 1
+type LanguageDetector interface {
+	DetectLanguage(text string) (language string, confidence float64)
+}
+
+// scriptLanguages maps scripts used by a single dominant language to that
+// language, Cyrillic is approximated as Russian
+var scriptLanguages = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Han, "zh"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Cyrillic, "ru"},
+}
+
+// latinStopWords are very common words used to tell Latin script languages
+// apart
+var latinStopWords = map[string][]string{
+	"en": {"the", "and", "is", "of", "to", "in", "it", "that", "you", "was", "for", "with", "this", "are", "be"},
+	"es": {"el", "la", "de", "que", "y", "en", "los", "se", "las", "por", "un", "una", "es", "con", "para"},
+	"fr": {"le", "la", "de", "et", "les", "des", "est", "un", "une", "du", "que", "pas", "pour", "dans", "je"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "ich", "zu", "den", "mit", "ein", "eine", "sie", "es", "auf"},
+	"it": {"il", "di", "che", "e", "la", "non", "un", "per", "sono", "una", "del", "della", "gli", "con", "mi"},
+	"pt": {"o", "de", "que", "e", "do", "da", "em", "um", "para", "com", "não", "uma", "os", "no", "se"},
+	"nl": {"de", "het", "een", "en", "van", "ik", "te", "dat", "die", "is", "niet", "op", "zijn", "met", "voor"},
+}
+
+type heuristicDetector struct {
+	stopWords map[string]map[string]bool
+}
+
+// DefaultLanguageDetector creates a lightweight LanguageDetector that
+// identifies languages by their script, and tells common Latin script
+// languages apart by their stop words. It is intended for gating corpora,
+// not for precise identification of short text
+func DefaultLanguageDetector() LanguageDetector {
+	stopWords := make(map[string]map[string]bool)
+	for language, words := range latinStopWords {
+		for _, word := range words {
+			if stopWords[word] == nil {
+				stopWords[word] = make(map[string]bool)
+			}
+			stopWords[word][language] = true
+		}
+	}
+
+	return &heuristicDetector{stopWords: stopWords}
+}
+
+// scriptDetector is implemented by detectors that can identify a language
+// from its script alone, which unlike stop words can't be mistaken for a
+// word of another language
+type scriptDetector interface {
+	detectScript(text string) (language string, confidence float64)
+}
+
+// countScripts counts the letters of text, those in the Latin script, and
+// those in each script used by a single language
+func countScripts(text string) (map[string]int, int, int) {
+	scriptCounts := make(map[string]int)
+	latin, letters := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, v := range scriptLanguages {
+			if unicode.Is(v.table, r) {
+				scriptCounts[v.language]++
+				break
+			}
+		}
+	}
+
+	return scriptCounts, latin, letters
+}
+
+// detectScript identifies the language of text written mostly in a script
+// used by a single language
+func (d *heuristicDetector) detectScript(text string) (language string, confidence float64) {
+	scriptCounts, latin, letters := countScripts(text)
+	best, bestCount := UndeterminedLanguage, 0
+	for k, v := range scriptCounts {
+		if v > bestCount || (v == bestCount && k < best) {
+			best, bestCount = k, v
+		}
+	}
+	if bestCount == 0 || bestCount <= latin {
+		return UndeterminedLanguage, 0
+	}
+	return best, float64(bestCount) / float64(letters)
+}
+
+func (d *heuristicDetector) DetectLanguage(text string) (language string, confidence float64) {
+	if language, confidence := d.detectScript(text); language != UndeterminedLanguage {
+		return language, confidence
+	}
+
+	scores := make(map[string]int)
+	matched := 0
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		word = strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) })
+		if languages, ok := d.stopWords[word]; ok {
+			matched++
+			for language := range languages {
+				scores[language]++
+			}
+		}
+	}
+
+	best, bestCount := UndeterminedLanguage, 0
+	for k, v := range scores {
+		if v > bestCount || (v == bestCount && k < best) {
+			best, bestCount = k, v
+		}
+	}
+	if bestCount == 0 {
+		return UndeterminedLanguage, 0
+	}
+
+	return best, float64(bestCount) / float64(matched)
+}
+
+// LanguageFilter filters a TokenSource by dropping candidate tokens detected
+// as a language outside of the allowed set. Tokens whose language can't be
+// determined are kept. A single word is poor evidence of a language, e.g.
+// "die" and "con" are English as well as German and Spanish, so if the
+// detector can identify languages by script alone, as the default detector
+// can, only tokens in another language's script are dropped. Use a
+// LanguageRouter to classify whole sequences
+func LanguageFilter(detector LanguageDetector, allowed ...string) SourceFilter {
+	allowSet := make(map[string]bool, len(allowed))
+	for _, v := range allowed {
+		allowSet[v] = true
+	}
+	detect := detector.DetectLanguage
+	if scripts, ok := detector.(scriptDetector); ok {
+		detect = scripts.detectScript
+	}
+
+	return MakeFuncFilter(func(candidate string) ([]string, error) {
+		language, _ := detect(candidate)
+		if language == UndeterminedLanguage || allowSet[language] {
+			return []string{candidate}, nil
+		}
+		return []string{}, nil
+	})
+}
+
+// LanguageRouter classifies whole sequences by language and trains a
+// separate chain for each language, so a multilingual corpus doesn't produce
+// code-switched output. A LanguageRouter is safe for concurrent use
+type LanguageRouter struct {
+	detector      LanguageDetector
+	allowed       map[string]bool
+	minConfidence float64
+	routerTex     sync.RWMutex
+	chains        map[string]WritableChain
+}
+
+// NewLanguageRouter creates a LanguageRouter, if any languages are specified
+// sequences in other languages are dropped
+func NewLanguageRouter(detector LanguageDetector, allowed ...string) *LanguageRouter {
+	var allowSet map[string]bool
+	if len(allowed) > 0 {
+		allowSet = make(map[string]bool, len(allowed))
+		for _, v := range allowed {
+			allowSet[v] = true
+		}
+	}
+
+	return &LanguageRouter{
+		detector: detector,
+		allowed:  allowSet,
+		chains:   make(map[string]WritableChain),
+	}
+}
+
+// SetMinConfidence drops sequences whose language was detected with a
+// confidence below the threshold
+func (r *LanguageRouter) SetMinConfidence(threshold float64) {
+	r.routerTex.Lock()
+	defer r.routerTex.Unlock()
+	r.minConfidence = threshold
+}
+
+// AddSource reads the source until it is exhausted, detects the language of
+// the sequence and adds it to that language's chain, as several sequences
+// if the source emits SegmentBreak. It returns the detected language and
+// whether the sequence was kept, empty sources are never kept
+func (r *LanguageRouter) AddSource(source TokenSource) (language string, kept bool, err error) {
+	tokens, collectErr := drainSource(source)
+	if collectErr != nil {
+		return UndeterminedLanguage, false, collectErr
+	}
+	segments := splitSegments(tokens)
+	words := make([]string, 0, len(tokens))
+	for _, segment := range segments {
+		words = append(words, segment...)
+	}
+	if len(words) == 0 {
+		return UndeterminedLanguage, false, nil
+	}
+
+	language, confidence := r.detector.DetectLanguage(strings.Join(words, " "))
+
+	r.routerTex.Lock()
+	defer r.routerTex.Unlock()
+	if confidence < r.minConfidence || (r.allowed != nil && !r.allowed[language]) {
+		return language, false, nil
+	}
+
+	chain, ok := r.chains[language]
+	if !ok {
+		chain = NewWritableChain()
+		r.chains[language] = chain
+	}
+
+	if addErr := addSequence(chain, tokens); addErr != nil {
+		return language, false, addErr
+	}
+	return language, true, nil
+}
+
+// Chain retrieves the chain trained for a language
+func (r *LanguageRouter) Chain(language string) (MarkovChain, bool) {
+	r.routerTex.RLock()
+	defer r.routerTex.RUnlock()
+	chain, ok := r.chains[language]
+	return chain, ok
+}
+
+// Languages retrieves the languages that have a chain, in sorted order
+func (r *LanguageRouter) Languages() []string {
+	r.routerTex.RLock()
+	defer r.routerTex.RUnlock()
+	languages := make([]string, 0, len(r.chains))
+	for k := range r.chains {
+		languages = append(languages, k)
+	}
+	sort.Strings(languages)
+
+	return languages
+}
@@ -0,0 +1,45 @@
+package chain
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLanguageFilterKeepsAllowedWords(t *testing.T) {
+	// each of these is also a stop word of another Latin script language
+	english := strings.Fields("the die was cast van drivers con artists den of thieves la la land de facto")
+	foreign := strings.Fields("привет 你好 こんにちは مرحبا")
+
+	filter := LanguageFilter(DefaultLanguageDetector(), "en")
+	kept, collectErr := CollectTokens(ApplyFiltersToSource(NewSliceSource(append(english, foreign...)), filter))
+	if collectErr != nil {
+		t.Fatal(collectErr)
+	}
+	if !reflect.DeepEqual(kept, english) {
+		t.Fatalf("kept %q, want %q", kept, english)
+	}
+}
+
+func TestLanguageFilterAllowsOtherScripts(t *testing.T) {
+	filter := LanguageFilter(DefaultLanguageDetector(), "ru")
+	kept, _ := CollectTokens(ApplyFiltersToSource(NewSliceSource(strings.Fields("привет мир 你好 hello")), filter))
+	if !reflect.DeepEqual(kept, []string{"привет", "мир", "hello"}) {
+		t.Fatalf("kept %q", kept)
+	}
+}
+
+func TestDetectLanguageSentences(t *testing.T) {
+	detector := DefaultLanguageDetector()
+	for text, want := range map[string]string{
+		"the cat is in the garden and it is asleep":        "en",
+		"der Hund und die Katze sind nicht im Haus":        "de",
+		"el perro y la gata están en la casa de los niños": "es",
+		"le chien et le chat ne sont pas dans la maison":   "fr",
+		"Привет, как дела?":                                "ru",
+	} {
+		if got, _ := detector.DetectLanguage(text); got != want {
+			t.Errorf("%q detected as %q, want %q", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// LengthDistribution is the empirical distribution of the lengths of the
+// sequences a chain was trained on
+type LengthDistribution struct {
+	Counts map[int]int `json:"counts"`
+	Total  int         `json:"total"`
+}
+
+// NewLengthDistribution creates an empty LengthDistribution
+func NewLengthDistribution() *LengthDistribution {
+	return &LengthDistribution{
+		Counts: make(map[int]int),
+	}
+}
+
+// Observe records a sequence of the given length
+func (d *LengthDistribution) Observe(length int) {
+	d.Counts[length]++
+	d.Total++
+}
+
+// merge adds the observations of other to the distribution
+func (d *LengthDistribution) merge(other *LengthDistribution) {
+	for length, count := range other.Counts {
+		d.Counts[length] += count
+		d.Total += count
+	}
+}
+
+// Mean calculates the mean sequence length
+func (d *LengthDistribution) Mean() float64 {
+	if d.Total == 0 {
+		return 0
+	}
+
+	sum := 0
+	for length, count := range d.Counts {
+		sum += length * count
+	}
+	return float64(sum) / float64(d.Total)
+}
+
+// Sample draws a length from the distribution, it returns zero if no lengths
+// have been observed
+func (d *LengthDistribution) Sample(rand *rand.Rand) int {
+	if d.Total == 0 {
+		return 0
+	}
+
+	lengths := make([]int, 0, len(d.Counts))
+	for length := range d.Counts {
+		lengths = append(lengths, length)
+	}
+	sort.Ints(lengths)
+
+	goal := rand.Intn(d.Total)
+	for _, length := range lengths {
+		goal -= d.Counts[length]
+		if goal < 0 {
+			return length
+		}
+	}
+
+	return lengths[len(lengths)-1]
+}
+
+// LengthModel is implemented by chains that record the lengths of the
+// sequences they were trained on
+type LengthModel interface {
+	SequenceLengths() *LengthDistribution
+}
@@ -0,0 +1,152 @@
+package chain
+
+import (
+	"sort"
+	"strings"
+)
+
+// evictionTarget is the fraction of a cap that eviction reduces a chain to,
+// so that eviction doesn't have to run after every token once a cap has
+// been reached
+const evictionTarget = 0.9
+
+// chainLimiter enforces the caps in BuildOptions on a chain as it is built
+type chainLimiter struct {
+	opts      BuildOptions
+	frequency map[string]int
+}
+
+func newChainLimiter(opts BuildOptions) *chainLimiter {
+	limiter := &chainLimiter{opts: opts}
+	if opts.MaxVocabulary > 0 {
+		limiter.frequency = make(map[string]int)
+	}
+
+	return limiter
+}
+
+// newMergeLimiter creates a limiter for a chain that was built elsewhere,
+// estimating token frequencies from link totals
+func newMergeLimiter(opts BuildOptions, chain *singleKeyChain) *chainLimiter {
+	limiter := newChainLimiter(opts)
+	if limiter.frequency != nil {
+		for key, link := range chain.Links {
+			limiter.frequency[key] = link.Total
+			for next := range link.NextTokenOccurrences {
+				if _, ok := limiter.frequency[next]; !ok {
+					limiter.frequency[next] = 0
+				}
+			}
+		}
+	}
+
+	return limiter
+}
+
+func (l *chainLimiter) limited() bool {
+	return l.opts.MaxVocabulary > 0 || l.opts.MaxStates > 0
+}
+
+// observe records that a token was added to the chain and evicts as required
+func (l *chainLimiter) observe(chain *singleKeyChain, token string) {
+	if l.frequency != nil {
+		l.frequency[token]++
+	}
+	l.enforce(chain)
+}
+
+func (l *chainLimiter) enforce(chain *singleKeyChain) {
+	if l.frequency != nil && len(l.frequency) > l.opts.MaxVocabulary {
+		l.evictVocabulary(chain)
+	}
+	if l.opts.MaxStates > 0 && len(chain.Links) > l.opts.MaxStates {
+		l.evictStates(chain)
+	}
+}
+
+// lowestFrequency sorts candidates by ascending count, ties broken by token
+// so eviction is deterministic
+func lowestFrequency(candidates []string, count func(string) int) {
+	sort.Slice(candidates, func(i, j int) bool {
+		ci, cj := count(candidates[i]), count(candidates[j])
+		if ci != cj {
+			return ci < cj
+		}
+		return candidates[i] < candidates[j]
+	})
+}
+
+func (l *chainLimiter) evictVocabulary(chain *singleKeyChain) {
+	candidates := make([]string, 0, len(l.frequency))
+	for token := range l.frequency {
+		// the sequence boundary token is never evicted
+		if token != "" {
+			candidates = append(candidates, token)
+		}
+	}
+	lowestFrequency(candidates, func(token string) int { return l.frequency[token] })
+
+	target := int(float64(l.opts.MaxVocabulary) * evictionTarget)
+	evicted := make(map[string]bool)
+	for _, token := range candidates {
+		if len(l.frequency) <= target {
+			break
+		}
+		evicted[token] = true
+		delete(l.frequency, token)
+		chain.deleteLink(token)
+		delete(chain.Folded, token)
+	}
+
+	for key, link := range chain.Links {
+		if l.opts.Order > 1 && keyEvicted(key, evicted) {
+			chain.deleteLink(key)
+			continue
+		}
+		for next, count := range link.NextTokenOccurrences {
+			if evicted[next] {
+				link.Total -= count
+				delete(link.NextTokenOccurrences, next)
+				link.Cumulative = nil
+			}
+		}
+		if len(link.NextTokenOccurrences) == 0 {
+			chain.deleteLink(key)
+		}
+	}
+}
+
+// keyEvicted reports whether any token of a multi token key was evicted
+func keyEvicted(key string, evicted map[string]bool) bool {
+	for _, token := range strings.Split(key, keySeparator) {
+		if evicted[token] {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *chainLimiter) evictStates(chain *singleKeyChain) {
+	candidates := make([]string, 0, len(chain.Links))
+	for key := range chain.Links {
+		if !isBoundaryKey(key) && !isOverflowKey(key) {
+			candidates = append(candidates, key)
+		}
+	}
+	lowestFrequency(candidates, func(key string) int { return chain.Links[key].Total })
+
+	if l.opts.OverflowBuckets > 0 {
+		chain.OverflowBuckets = l.opts.OverflowBuckets
+	}
+	target := int(float64(l.opts.MaxStates) * evictionTarget)
+	for _, key := range candidates {
+		if len(chain.Links) <= target {
+			break
+		}
+		if chain.OverflowBuckets > 0 {
+			chain.foldState(key)
+		} else {
+			chain.deleteLink(key)
+		}
+	}
+}
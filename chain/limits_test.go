@@ -0,0 +1,63 @@
+package chain
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestOverflowBucketsOnlyHoldFoldedKeys(t *testing.T) {
+	sources := make([]TokenSource, 0)
+	for i := 0; i < 50; i++ {
+		sources = append(sources, NewSliceSource(strings.Fields(fmt.Sprintf("the word%d ends", i))))
+	}
+	built, buildErr := BuildChainFromSourcesWithOptions(BuildOptions{MaxStates: 10, OverflowBuckets: 2}, sources...)
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	chain := built.(*singleKeyChain)
+	if len(chain.Folded) == 0 {
+		t.Fatal("no states were folded")
+	}
+
+	for _, opts := range []EncodeOptions{{}, {Compact: true}, {Gob: true}, {Shards: 3}} {
+		encoded := &bytes.Buffer{}
+		if writeErr := WriteChain(encoded, chain, opts); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		loaded, loadErr := LoadChain(encoded, DefaultDecodeOptions())
+		if loadErr != nil {
+			t.Fatalf("%+v: %v", opts, loadErr)
+		}
+
+		for key := range chain.Folded {
+			if _, ok := loaded.RetrieveMarkovLink(key); !ok {
+				t.Fatalf("%+v: folded key %q not found in its bucket", opts, key)
+			}
+		}
+		if _, ok := loaded.RetrieveMarkovLink("zzz-never-seen"); ok {
+			t.Fatalf("%+v: a token that was never seen was found", opts)
+		}
+	}
+}
+
+func TestEvictionReleasesArenaLinks(t *testing.T) {
+	chain := newBuildChain(0)
+	for i := 0; i < 20; i++ {
+		chain.increment(fmt.Sprintf("state%d", i), "next", i+1)
+	}
+	block := chain.arena.block[:chain.arena.next]
+
+	limiter := newChainLimiter(BuildOptions{MaxStates: 10})
+	limiter.evictStates(chain)
+	if len(chain.Links) > 10 {
+		t.Fatalf("%d links remain, want at most 10", len(chain.Links))
+	}
+	for i := range block {
+		link := &block[i]
+		if chain.Links[link.Token[0]] != link && link.NextTokenOccurrences != nil {
+			t.Fatalf("evicted link %q still holds its successors", link.Token[0])
+		}
+	}
+}
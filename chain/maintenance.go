@@ -0,0 +1,211 @@
+package chain
+
+import (
+	"fmt"
+	"math"
+)
+
+// Merge adds every transition of the source chains into dst
+func Merge(dst WritableChain, sources ...MarkovChain) error {
+	for _, src := range sources {
+		if copyErr := Copy(dst, src); copyErr != nil {
+			return copyErr
+		}
+	}
+
+	return nil
+}
+
+// Weighted pairs a chain with a factor its counts are scaled by
+type Weighted struct {
+	Chain  MarkovChain
+	Weight float64
+}
+
+// MergeWeighted adds every transition of the source chains into dst with
+// each count scaled by its chain's weight and rounded to the nearest
+// integer. This lets an old chain be decayed as it is merged with fresh
+// data so the new data isn't drowned out by history
+func MergeWeighted(dst WritableChain, sources ...Weighted) error {
+	for _, src := range sources {
+		iterable, ok := src.Chain.(IterableChain)
+		if !ok {
+			return ErrUncountableChain
+		}
+
+		weight := src.Weight
+		mergeErr := forEachTransition(iterable, func(t Transition) error {
+			if scaled := scaleCount(t.Count, weight); scaled > 0 {
+				return dst.Increment(t.Prev, t.Next, scaled)
+			}
+			return nil
+		})
+		if mergeErr != nil {
+			return mergeErr
+		}
+	}
+
+	return nil
+}
+
+func scaleCount(count int, factor float64) int {
+	return int(math.Floor(float64(count)*factor + 0.5))
+}
+
+// forEachTransition collects every transition in a chain before invoking fn
+// on each, so fn is free to modify the chain
+func forEachTransition(chain IterableChain, fn func(t Transition) error) error {
+	transitions := make([]Transition, 0)
+	for _, token := range chain.RetrieveTokens() {
+		link, present := chain.RetrieveMarkovLink(token)
+		if !present {
+			continue
+		}
+		counted, ok := link.(CountedLink)
+		if !ok {
+			return ErrUncountableChain
+		}
+
+		for _, next := range counted.RetrieveNextTokenPossibilities() {
+			count, _ := counted.GetOccurrencesOfToken(next)
+			transitions = append(transitions, Transition{Prev: token, Next: next, Count: count})
+		}
+	}
+
+	for _, t := range transitions {
+		if fnErr := fn(t); fnErr != nil {
+			return fnErr
+		}
+	}
+
+	return nil
+}
+
+// Prune removes every transition that occurred fewer than minCount times
+func Prune(chain WritableChain, minCount int) error {
+	return forEachTransition(chain, func(t Transition) error {
+		if t.Count < minCount {
+			return chain.RemoveSuccessor(t.Prev, t.Next)
+		}
+		return nil
+	})
+}
+
+// Decay multiplies every count in the chain by factor, rounding to the
+// nearest integer. Transitions whose count falls to zero are removed
+func Decay(chain WritableChain, factor float64) error {
+	return forEachTransition(chain, func(t Transition) error {
+		return chain.SetCount(t.Prev, t.Next, scaleCount(t.Count, factor))
+	})
+}
+
+// MergeStates folds tokens into canonical ones after training, e.g.
+// "colour" into "color", so vocabulary can be cleaned up without
+// retraining. Every occurrence of an aliased token, both as a key and as a
+// successor, is rewritten as its canonical token, merging counts. Aliases
+// may point to other aliases, but not in a cycle, and the boundary token
+// can't be aliased. The tokens a HistoryChain's keys join are each aliased
+func MergeStates(chain WritableChain, aliases map[string]string) error {
+	canonical := make(map[string]string, len(aliases))
+	for alias := range aliases {
+		if alias == "" {
+			return fmt.Errorf("chain: the boundary token can't be aliased")
+		}
+
+		token := alias
+		for steps := 0; ; steps++ {
+			next, ok := aliases[token]
+			if !ok || next == token {
+				break
+			}
+			if steps > len(aliases) {
+				return fmt.Errorf("chain: alias %q is part of a cycle", alias)
+			}
+			token = next
+		}
+		canonical[alias] = token
+	}
+	resolve := func(token string) string {
+		if to, ok := canonical[token]; ok {
+			return to
+		}
+		return token
+	}
+
+	return rewriteTokens(chain, keyRewrite(chain, resolve), resolve)
+}
+
+// rewriteTokens replaces every token of a chain, both as a key and as a
+// successor, with what rewrite returns for it, merging counts. Keys are
+// rewritten by rewriteKey, which is keyRewrite of rewrite for the chain
+// being changed, as a Transaction hides whether it is a HistoryChain.
+// Every rewritten transition is removed before any are added back, so
+// tokens can be swapped
+func rewriteTokens(chain WritableChain, rewriteKey func(key string) string, rewrite func(token string) string) error {
+	rewritten := make([]Transition, 0)
+	removeErr := forEachTransition(chain, func(t Transition) error {
+		prev, next := rewriteKey(t.Prev), rewrite(t.Next)
+		if prev == t.Prev && next == t.Next {
+			return nil
+		}
+		rewritten = append(rewritten, Transition{Prev: prev, Next: next, Count: t.Count})
+		return chain.RemoveSuccessor(t.Prev, t.Next)
+	})
+	if removeErr != nil {
+		return removeErr
+	}
+
+	for _, t := range rewritten {
+		if incErr := chain.Increment(t.Prev, t.Next, t.Count); incErr != nil {
+			return incErr
+		}
+	}
+	return nil
+}
+
+// RenameToken replaces a token with another, both as a key and as a
+// successor, e.g. to redact a name after training, including within the
+// keys of a HistoryChain. If the new token is
+// already in the chain their counts are merged. The change is made in a
+// Transaction, so the chain is left unchanged if it fails
+func RenameToken(chain WritableChain, from string, to string) error {
+	if from == "" || to == "" {
+		return fmt.Errorf("chain: the boundary token can't be renamed")
+	}
+
+	rename := func(token string) string {
+		if token == from {
+			return to
+		}
+		return token
+	}
+	return Update(chain, func(tx WritableChain) error {
+		return rewriteTokens(tx, keyRewrite(chain, rename), rename)
+	})
+}
+
+// RemapVocabulary replaces every token with what remap returns for it, both
+// as a key and as a successor, merging the counts of tokens remapped to the
+// same token. Tokens are remapped simultaneously, so remap may swap tokens.
+// The boundary token isn't remapped and remap mustn't return it. The change
+// is made in a Transaction, so the chain is left unchanged if it fails
+func RemapVocabulary(chain WritableChain, remap func(token string) string) error {
+	var remapErr error
+	mapped := func(token string) string {
+		if token == "" {
+			return ""
+		}
+		to := remap(token)
+		if to == "" && remapErr == nil {
+			remapErr = fmt.Errorf("chain: token %q can't be remapped to the boundary token", token)
+		}
+		return to
+	}
+
+	return Update(chain, func(tx WritableChain) error {
+		if rewriteErr := rewriteTokens(tx, keyRewrite(chain, mapped), mapped); rewriteErr != nil {
+			return rewriteErr
+		}
+		return remapErr
+	})
+}
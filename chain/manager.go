@@ -0,0 +1,371 @@
+package chain
+
+import (
+	"container/list"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ChainStore persists the chains held by a ChainManager
+type ChainStore interface {
+	// OpenChain opens the persisted chain for the specified key for reading,
+	// an error satisfying os.IsNotExist is returned if there is none
+	OpenChain(key string) (io.ReadCloser, error)
+
+	// CreateChain opens the persisted chain for the specified key for writing,
+	// replacing any existing data once the writer is closed. Until then, or
+	// if writing fails, the existing data must remain readable, so a crash
+	// while writing doesn't lose it
+	CreateChain(key string) (io.WriteCloser, error)
+}
+
+type directoryStore struct {
+	dir string
+}
+
+func (s *directoryStore) path(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key)+".json")
+}
+
+func (s *directoryStore) OpenChain(key string) (io.ReadCloser, error) {
+	return os.Open(s.path(key))
+}
+
+func (s *directoryStore) CreateChain(key string) (io.WriteCloser, error) {
+	path := s.path(key)
+	file, createErr := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if createErr != nil {
+		return nil, createErr
+	}
+	return &replacingFile{file: file, path: path}, nil
+}
+
+// replacingFile writes to a temporary file that replaces the file at path
+// when it is closed, unless a write failed
+type replacingFile struct {
+	file     *os.File
+	path     string
+	writeErr error
+}
+
+func (f *replacingFile) Write(p []byte) (int, error) {
+	n, writeErr := f.file.Write(p)
+	if writeErr != nil && f.writeErr == nil {
+		f.writeErr = writeErr
+	}
+	return n, writeErr
+}
+
+func (f *replacingFile) Close() error {
+	closeErr := f.file.Close()
+	if f.writeErr != nil || closeErr != nil {
+		os.Remove(f.file.Name())
+		if f.writeErr != nil {
+			return f.writeErr
+		}
+		return closeErr
+	}
+	return os.Rename(f.file.Name(), f.path)
+}
+
+// MakeDirectoryStore creates a ChainStore that keeps one file per key in
+// the specified directory
+func MakeDirectoryStore(dir string) ChainStore {
+	return &directoryStore{dir: dir}
+}
+
+// ChainManagerOptions configures a ChainManager
+type ChainManagerOptions struct {
+	// MaxMemory is the approximate number of bytes the in-memory chains may
+	// occupy before the least recently used are evicted, zero means unlimited
+	MaxMemory int64
+
+	// Store persists evicted chains so they can be reloaded later, if nil
+	// evicted chains are discarded
+	Store ChainStore
+
+	// DecodeOptions limits what is accepted when loading chains from Store
+	DecodeOptions DecodeOptions
+}
+
+type managedChain struct {
+	key   string
+	chain *singleKeyChain
+	size  int64
+	// dirty is set once the chain has changed since it was loaded or saved
+	dirty bool
+}
+
+// ChainManager maintains a separate Markov chain per key (e.g. per user),
+// loading chains on demand and evicting the least recently used when the
+// memory limit is exceeded. Evicted chains are only written to the store if
+// they changed, and outside of the lock so one tenant's eviction doesn't
+// block the others. A ChainManager is safe for concurrent use
+type ChainManager struct {
+	opts       ChainManagerOptions
+	managerTex sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List
+	usage      int64
+	// saving holds a channel for each evicted chain being saved, closed once
+	// it has been, so it isn't reloaded from the store before then
+	saving map[string]chan struct{}
+}
+
+// NewChainManager creates an empty ChainManager
+func NewChainManager(opts ChainManagerOptions) *ChainManager {
+	return &ChainManager{
+		opts:    opts,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		saving:  make(map[string]chan struct{}),
+	}
+}
+
+// acquire retrieves the entry for key, loading it from the store or, if
+// create is set, creating it as required, and marks it as most recently
+// used. ErrKeyNotFound is returned if there is no chain for key and create
+// isn't set. Must be called with the lock held, which is released while
+// waiting for an evicted chain to be saved
+func (m *ChainManager) acquire(key string, create bool) (*managedChain, error) {
+	for {
+		saved, ok := m.saving[key]
+		if !ok {
+			break
+		}
+		m.managerTex.Unlock()
+		<-saved
+		m.managerTex.Lock()
+	}
+
+	if element, ok := m.entries[key]; ok {
+		m.lru.MoveToFront(element)
+		return element.Value.(*managedChain), nil
+	}
+
+	chain, loadErr := m.load(key)
+	if loadErr != nil {
+		return nil, loadErr
+	}
+	if chain == nil {
+		if !create {
+			return nil, ErrKeyNotFound
+		}
+		chain = newSingleKeyChain()
+	}
+
+	entry := &managedChain{
+		key:   key,
+		chain: chain,
+		size:  chain.approximateSize(),
+	}
+	m.entries[key] = m.lru.PushFront(entry)
+	m.usage += entry.size
+
+	return entry, nil
+}
+
+// load reads the chain for key from the store, returning nil if there is
+// none
+func (m *ChainManager) load(key string) (*singleKeyChain, error) {
+	if m.opts.Store == nil {
+		return nil, nil
+	}
+
+	reader, openErr := m.opts.Store.OpenChain(key)
+	if os.IsNotExist(openErr) {
+		return nil, nil
+	} else if openErr != nil {
+		return nil, openErr
+	}
+	defer reader.Close()
+
+	return readSingleKeyChain(reader, m.opts.DecodeOptions)
+}
+
+func (m *ChainManager) save(key string, chain *singleKeyChain) error {
+	writer, createErr := m.opts.Store.CreateChain(key)
+	if createErr != nil {
+		return createErr
+	}
+
+	encodeErr := WriteChain(writer, chain, EncodeOptions{})
+	closeErr := writer.Close()
+	if encodeErr != nil {
+		return encodeErr
+	}
+
+	return closeErr
+}
+
+// detach drops an entry from memory, returning it if it must be saved, in
+// which case persist must be called once the lock is released. Must be
+// called with the lock held
+func (m *ChainManager) detach(element *list.Element) *managedChain {
+	entry := element.Value.(*managedChain)
+	m.lru.Remove(element)
+	delete(m.entries, entry.key)
+	m.usage -= entry.size
+
+	if !entry.dirty || m.opts.Store == nil {
+		return nil
+	}
+	m.saving[entry.key] = make(chan struct{})
+	return entry
+}
+
+// persist saves detached entries, it must be called without the lock held.
+// An entry that fails to save is returned to memory so it isn't lost
+func (m *ChainManager) persist(entries []*managedChain) error {
+	var persistErr error
+	for _, entry := range entries {
+		saveErr := m.save(entry.key, entry.chain)
+
+		m.managerTex.Lock()
+		if saveErr != nil {
+			if persistErr == nil {
+				persistErr = saveErr
+			}
+			m.entries[entry.key] = m.lru.PushBack(entry)
+			m.usage += entry.size
+		}
+		close(m.saving[entry.key])
+		delete(m.saving, entry.key)
+		m.managerTex.Unlock()
+	}
+
+	return persistErr
+}
+
+// enforceLimit evicts least recently used entries until usage is within the
+// limit, always retaining the most recently used entry, and returns those
+// that must be saved. Must be called with the lock held
+func (m *ChainManager) enforceLimit() []*managedChain {
+	if m.opts.MaxMemory <= 0 {
+		return nil
+	}
+
+	evicted := make([]*managedChain, 0)
+	for m.usage > m.opts.MaxMemory && m.lru.Len() > 1 {
+		if entry := m.detach(m.lru.Back()); entry != nil {
+			evicted = append(evicted, entry)
+		}
+	}
+
+	return evicted
+}
+
+// Train reads the source until it is exhausted and adds its tokens to the
+// chain for the specified key
+func (m *ChainManager) Train(key string, source TokenSource) error {
+	built, buildErr := buildChainFromSource(source)
+	if buildErr != nil {
+		return buildErr
+	}
+
+	m.managerTex.Lock()
+	entry, acquireErr := m.acquire(key, true)
+	if acquireErr != nil {
+		m.managerTex.Unlock()
+		return acquireErr
+	}
+
+	entry.chain.mergeFrom(built)
+	entry.dirty = true
+	size := entry.chain.approximateSize()
+	m.usage += size - entry.size
+	entry.size = size
+
+	evicted := m.enforceLimit()
+	m.managerTex.Unlock()
+	return m.persist(evicted)
+}
+
+// Generate walks the chain for the specified key from the start of a
+// sequence, returning at most maxTokens tokens. ErrKeyNotFound is returned
+// if no chain has been trained for the key
+func (m *ChainManager) Generate(key string, maxTokens int, rand *rand.Rand) ([]string, error) {
+	m.managerTex.Lock()
+	entry, acquireErr := m.acquire(key, false)
+	if acquireErr != nil {
+		m.managerTex.Unlock()
+		return nil, acquireErr
+	}
+
+	tokens, generateErr := Generate(entry.chain, rand, GenerateOptions{MaxTokens: maxTokens})
+	evicted := m.enforceLimit()
+	m.managerTex.Unlock()
+	if persistErr := m.persist(evicted); persistErr != nil {
+		return nil, persistErr
+	}
+
+	return tokens, generateErr
+}
+
+// Evict persists the chain for the specified key, if it changed, and
+// removes it from memory
+func (m *ChainManager) Evict(key string) error {
+	m.managerTex.Lock()
+	element, ok := m.entries[key]
+	if !ok {
+		m.managerTex.Unlock()
+		return nil
+	}
+	entry := m.detach(element)
+	m.managerTex.Unlock()
+
+	if entry == nil {
+		return nil
+	}
+	return m.persist([]*managedChain{entry})
+}
+
+// Flush persists every chain held in memory that has changed. Unlike
+// eviction it holds the lock while saving, so a chain can't change or be
+// evicted and saved again while it is written
+func (m *ChainManager) Flush() error {
+	if m.opts.Store == nil {
+		return nil
+	}
+
+	m.managerTex.Lock()
+	defer m.managerTex.Unlock()
+	for element := m.lru.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*managedChain)
+		if !entry.dirty {
+			continue
+		}
+		if saveErr := m.save(entry.key, entry.chain); saveErr != nil {
+			return saveErr
+		}
+		entry.dirty = false
+	}
+
+	return nil
+}
+
+// MemoryUsage reports the approximate number of bytes occupied by the chains
+// currently held in memory
+func (m *ChainManager) MemoryUsage() int64 {
+	m.managerTex.Lock()
+	defer m.managerTex.Unlock()
+	return m.usage
+}
+
+// Keys returns the keys of the chains currently held in memory, most
+// recently used first
+func (m *ChainManager) Keys() []string {
+	m.managerTex.Lock()
+	defer m.managerTex.Unlock()
+	keys := make([]string, 0, m.lru.Len())
+	for element := m.lru.Front(); element != nil; element = element.Next() {
+		keys = append(keys, element.Value.(*managedChain).key)
+	}
+
+	return keys
+}
@@ -0,0 +1,155 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingStore is an in-memory ChainStore counting writes, whose writes
+// wait on release if it is set
+type countingStore struct {
+	storeTex sync.Mutex
+	chains   map[string][]byte
+	writes   int
+	release  chan struct{}
+}
+
+func newCountingStore() *countingStore {
+	return &countingStore{chains: make(map[string][]byte)}
+}
+
+func (s *countingStore) OpenChain(key string) (io.ReadCloser, error) {
+	s.storeTex.Lock()
+	defer s.storeTex.Unlock()
+	data, ok := s.chains[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *countingStore) CreateChain(key string) (io.WriteCloser, error) {
+	return &countingWriter{store: s, key: key}, nil
+}
+
+func (s *countingStore) count() int {
+	s.storeTex.Lock()
+	defer s.storeTex.Unlock()
+	return s.writes
+}
+
+type countingWriter struct {
+	bytes.Buffer
+	store *countingStore
+	key   string
+}
+
+func (w *countingWriter) Close() error {
+	if w.store.release != nil {
+		<-w.store.release
+	}
+	w.store.storeTex.Lock()
+	defer w.store.storeTex.Unlock()
+	w.store.chains[w.key] = w.Bytes()
+	w.store.writes++
+	return nil
+}
+
+func TestChainManagerUnknownKey(t *testing.T) {
+	store := newCountingStore()
+	manager := NewChainManager(ChainManagerOptions{Store: store})
+	if _, generateErr := manager.Generate("typo", 10, rand.New(rand.NewSource(1))); !errors.Is(generateErr, ErrKeyNotFound) {
+		t.Fatalf("generating from an unknown key returned %v", generateErr)
+	}
+	if keys := manager.Keys(); len(keys) != 0 {
+		t.Fatalf("unknown key cached as %q", keys)
+	}
+	if flushErr := manager.Flush(); flushErr != nil {
+		t.Fatal(flushErr)
+	}
+	if store.count() != 0 {
+		t.Fatalf("%d chains written for an unknown key", store.count())
+	}
+}
+
+func TestChainManagerSavesOnlyChangedChains(t *testing.T) {
+	store := newCountingStore()
+	manager := NewChainManager(ChainManagerOptions{Store: store, MaxMemory: 1})
+	r := rand.New(rand.NewSource(1))
+
+	if trainErr := manager.Train("a", NewSliceSource(strings.Fields("the cat sat"))); trainErr != nil {
+		t.Fatal(trainErr)
+	}
+	if trainErr := manager.Train("b", NewSliceSource(strings.Fields("the dog ran"))); trainErr != nil {
+		t.Fatal(trainErr)
+	}
+	if store.count() != 1 {
+		t.Fatalf("%d chains written after evicting one", store.count())
+	}
+
+	// reloading and evicting an unchanged chain doesn't write it again
+	tokens, generateErr := manager.Generate("a", 10, r)
+	if generateErr != nil {
+		t.Fatal(generateErr)
+	}
+	if strings.Join(tokens, " ") != "the cat sat" {
+		t.Fatalf("reloaded chain generated %q", tokens)
+	}
+	if _, generateErr := manager.Generate("b", 10, r); generateErr != nil {
+		t.Fatal(generateErr)
+	}
+	if store.count() != 2 {
+		t.Fatalf("%d chains written, want 2", store.count())
+	}
+}
+
+func TestChainManagerSavesOutsideLock(t *testing.T) {
+	store := newCountingStore()
+	store.release = make(chan struct{})
+	manager := NewChainManager(ChainManagerOptions{Store: store, MaxMemory: 1})
+	if trainErr := manager.Train("a", NewSliceSource(strings.Fields("the cat sat"))); trainErr != nil {
+		t.Fatal(trainErr)
+	}
+
+	trained := make(chan error)
+	go func() {
+		trained <- manager.Train("b", NewSliceSource(strings.Fields("the dog ran")))
+	}()
+
+	// other tenants are served while "a" is being saved
+	served := make(chan struct{})
+	go func() {
+		for len(manager.Keys()) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		manager.MemoryUsage()
+		close(served)
+	}()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("manager locked while saving")
+	}
+
+	// "a" is only reloaded once it has been saved
+	loaded := make(chan error)
+	go func() {
+		_, generateErr := manager.Generate("a", 10, rand.New(rand.NewSource(1)))
+		loaded <- generateErr
+	}()
+	close(store.release)
+	if trainErr := <-trained; trainErr != nil {
+		t.Fatal(trainErr)
+	}
+	if loadErr := <-loaded; loadErr != nil {
+		t.Fatal(loadErr)
+	}
+}
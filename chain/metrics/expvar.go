@@ -0,0 +1,50 @@
+// Package metrics publishes the chain package's runtime counters through
+// expvar. It is separate from the chain package because importing expvar
+// registers a /debug/vars handler on http.DefaultServeMux
+package metrics
+
+import (
+	"errors"
+	"expvar"
+
+	"github.com/lvanoort/markov/chain"
+)
+
+// DefaultPrefix is prepended to the name of every published variable if
+// no prefix is given
+const DefaultPrefix = "markov."
+
+// ErrAlreadyPublished is returned when a variable with the same name has
+// already been published, expvar variables can't be replaced
+var ErrAlreadyPublished = errors.New("metrics: variable already published")
+
+// counters maps the name of each published variable to its counter
+var counters = map[string]func(chain.RuntimeCounters) uint64{
+	"lookup_hits":   func(c chain.RuntimeCounters) uint64 { return c.LookupHits },
+	"lookup_misses": func(c chain.RuntimeCounters) uint64 { return c.LookupMisses },
+	"samples_drawn": func(c chain.RuntimeCounters) uint64 { return c.SamplesDrawn },
+	"trainer_adds":  func(c chain.RuntimeCounters) uint64 { return c.TrainerAdds },
+}
+
+// PublishExpvar publishes each of chain.Counters as an expvar variable named
+// with prefix, e.g. "markov.lookup_hits". Values are read when the
+// variables are, so publishing costs nothing until they're inspected.
+// Nothing is published if any name is already in use
+func PublishExpvar(prefix string) error {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	for name := range counters {
+		if expvar.Get(prefix+name) != nil {
+			return ErrAlreadyPublished
+		}
+	}
+
+	for name, counter := range counters {
+		counter := counter
+		expvar.Publish(prefix+name, expvar.Func(func() interface{} {
+			return counter(chain.Counters())
+		}))
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package chain
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MinimizeOptions configures Minimize
+type MinimizeOptions struct {
+	// Epsilon is the Jensen-Shannon divergence below which two states'
+	// successor distributions are considered close enough to merge
+	Epsilon float64
+
+	// MaxLoss, if positive, stops merging once the introduced loss would
+	// exceed it
+	MaxLoss float64
+}
+
+// MinimizeReport describes what Minimize changed
+type MinimizeReport struct {
+	StatesBefore int
+	StatesAfter  int
+	// Loss is the average number of bits lost per transition, the
+	// Kullback-Leibler divergence of each merged state's original successor
+	// distribution from the merged one, weighted by how often the state
+	// occurred, plus the bits needed to tell which of the merged states
+	// followed each predecessor
+	Loss float64
+}
+
+// klDivergence calculates the base 2 Kullback-Leibler divergence of p from
+// q, q must be non-zero wherever p is
+func klDivergence(p stateDistribution, q stateDistribution) float64 {
+	divergence := 0.0
+	for token, pv := range p {
+		if pv > 0 {
+			divergence += pv * math.Log2(pv/q[token])
+		}
+	}
+	return divergence
+}
+
+// pooledDistribution is the successor distribution of states merged into one
+func pooledDistribution(states []*clusterState) stateDistribution {
+	total := 0
+	for _, state := range states {
+		total += state.total
+	}
+	pooled := make(stateDistribution)
+	for _, state := range states {
+		for token, p := range state.distribution {
+			pooled[token] += p * float64(state.total) / float64(total)
+		}
+	}
+	return pooled
+}
+
+// groupLoss is the occurrence weighted loss of merging states, both as
+// states whose successors are pooled and as successors of other states that
+// can no longer be told apart. incoming holds how often each token follows
+// each state
+func groupLoss(states []*clusterState, incoming map[string]map[string]float64, chainTotal int) float64 {
+	pooled := pooledDistribution(states)
+	grouped := make(map[string]float64)
+	loss := 0.0
+	for _, state := range states {
+		loss += float64(state.total) / float64(chainTotal) * klDivergence(state.distribution, pooled)
+		for prev, count := range incoming[state.token] {
+			grouped[prev] += count
+		}
+	}
+	for _, state := range states {
+		for prev, count := range incoming[state.token] {
+			loss += count / float64(chainTotal) * math.Log2(grouped[prev]/count)
+		}
+	}
+	return loss
+}
+
+// Minimize is a lossy pass that merges states whose successor distributions
+// are within opts.Epsilon of a more frequent state's, as CollapseClusters
+// does, trading accuracy for a smaller chain. States are compared pairwise,
+// so it is quadratic in the number of states. States are single tokens, so
+// a HistoryChain can't be minimized
+func Minimize(chain WritableChain, opts MinimizeOptions) (MinimizeReport, error) {
+	report := MinimizeReport{}
+	if order := chainOrder(chain); order > 1 {
+		return report, fmt.Errorf("chain: can't minimize a chain of order %d", order)
+	}
+	tokens := chain.RetrieveTokens()
+	report.StatesBefore = len(tokens)
+	sort.Strings(tokens)
+
+	states := make([]*clusterState, 0, len(tokens))
+	incoming := make(map[string]map[string]float64)
+	chainTotal := 0
+	for _, token := range tokens {
+		link, ok := chain.RetrieveMarkovLink(token)
+		if !ok {
+			continue
+		}
+		distribution, distributionErr := distributionOf(link)
+		if distributionErr != nil {
+			return report, distributionErr
+		}
+		total := link.(CountedLink).GetTotalOccurrences()
+		chainTotal += total
+		for next, p := range distribution {
+			if incoming[next] == nil {
+				incoming[next] = make(map[string]float64)
+			}
+			incoming[next][token] += p * float64(total)
+		}
+		if token != "" && total > 0 {
+			states = append(states, &clusterState{token: token, total: total, distribution: distribution})
+		}
+	}
+	// the most frequent states absorb the others
+	sort.SliceStable(states, func(i, j int) bool { return states[i].total > states[j].total })
+
+	merged := make(map[string]bool)
+	aliases := make(map[string]string)
+	for i, representative := range states {
+		if merged[representative.token] {
+			continue
+		}
+
+		group := []*clusterState{representative}
+		lossBefore := 0.0
+		for _, candidate := range states[i+1:] {
+			if merged[candidate.token] || jensenShannon(representative.distribution, candidate.distribution) >= opts.Epsilon {
+				continue
+			}
+
+			lossAfter := groupLoss(append(group, candidate), incoming, chainTotal)
+			if opts.MaxLoss > 0 && report.Loss+lossAfter-lossBefore > opts.MaxLoss {
+				continue
+			}
+			report.Loss += lossAfter - lossBefore
+			lossBefore = lossAfter
+			group = append(group, candidate)
+			merged[candidate.token] = true
+			aliases[candidate.token] = representative.token
+		}
+	}
+
+	if foldErr := MergeStates(chain, aliases); foldErr != nil {
+		return report, foldErr
+	}
+	report.StatesAfter = len(chain.RetrieveTokens())
+
+	return report, nil
+}
@@ -0,0 +1,38 @@
+package chain
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestMinimizeLossIncludesMergedSuccessors(t *testing.T) {
+	build := func() WritableChain {
+		built, buildErr := BuildChainFromSources(
+			NewSliceSource(strings.Fields("a b x")),
+			NewSliceSource(strings.Fields("a c x")),
+		)
+		if buildErr != nil {
+			t.Fatal(buildErr)
+		}
+		return built.(WritableChain)
+	}
+
+	// b and c have the same successors, but merging them loses which of
+	// them followed a, one bit for two of the eight transitions
+	report, minimizeErr := Minimize(build(), MinimizeOptions{Epsilon: 0.1})
+	if minimizeErr != nil {
+		t.Fatal(minimizeErr)
+	}
+	if report.StatesAfter != report.StatesBefore-1 || math.Abs(report.Loss-0.25) > 1e-9 {
+		t.Fatalf("merged %d states losing %v bits, want 1 losing 0.25", report.StatesBefore-report.StatesAfter, report.Loss)
+	}
+
+	report, minimizeErr = Minimize(build(), MinimizeOptions{Epsilon: 0.1, MaxLoss: 0.2})
+	if minimizeErr != nil {
+		t.Fatal(minimizeErr)
+	}
+	if report.StatesAfter != report.StatesBefore || report.Loss != 0 {
+		t.Fatalf("merged %d states beyond MaxLoss", report.StatesBefore-report.StatesAfter)
+	}
+}
@@ -0,0 +1,262 @@
+package chain
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+// HistoryChain is a MarkovChain whose links are keyed on more than one
+// preceding token
+type HistoryChain interface {
+	MarkovChain
+
+	// Order is the number of preceding tokens links are keyed on
+	Order() int
+
+	// RetrieveMarkovLinkFromHistory retrieves the link following the last
+	// Order tokens of history, returns false if they were not found.
+	// Histories shorter than Order are padded with the sequence boundary
+	RetrieveMarkovLinkFromHistory(history []string) (link MarkovChainLink, keyPresent bool)
+
+	// Key joins the last Order tokens of history into the key of the link
+	// following them, as taken by RetrieveMarkovLink
+	Key(history []string) string
+}
+
+// CalculateNextTokenFromHistory samples the token following a history,
+// chains that aren't a HistoryChain are looked up by the last token alone
+func CalculateNextTokenFromHistory(chain MarkovChain, history []string, rand *rand.Rand) (string, bool) {
+	link, ok := retrieveLink(chain, history)
+	if !ok {
+		return "", false
+	}
+	return link.GetNextToken(rand), true
+}
+
+// retrieveLink looks up the link following a history in any chain
+func retrieveLink(chain MarkovChain, history []string) (MarkovChainLink, bool) {
+	if historyChain, ok := chain.(HistoryChain); ok {
+		return historyChain.RetrieveMarkovLinkFromHistory(history)
+	}
+	current := ""
+	if len(history) > 0 {
+		current = history[len(history)-1]
+	}
+	return chain.RetrieveMarkovLink(current)
+}
+
+// keyTokens retrieves the tokens a key of chain joins, which is the key
+// alone unless the chain is a HistoryChain
+func keyTokens(chain MarkovChain, key string) []string {
+	if _, ok := chain.(HistoryChain); ok {
+		return splitKey(key)
+	}
+	return []string{key}
+}
+
+// keyRewrite wraps a function rewriting tokens so it rewrites each token a
+// key of chain joins
+func keyRewrite(chain MarkovChain, rewrite func(token string) string) func(key string) string {
+	if _, ok := chain.(HistoryChain); !ok {
+		return rewrite
+	}
+	return func(key string) string {
+		tokens := splitKey(key)
+		for i, token := range tokens {
+			tokens[i] = rewrite(token)
+		}
+		return joinKey(tokens)
+	}
+}
+
+// MultiKeyChain is a chain of order N, whose links are keyed on the
+// previous N tokens rather than one. Higher orders give more coherent
+// output but need more training data to avoid reproducing it verbatim.
+//
+// The methods taking a single token, such as RetrieveMarkovLink and
+// Increment, take a key instead, as returned by RetrieveTokens or Key, so
+// the chain can be copied, merged and analysed like any other. A key of
+// fewer than N tokens, such as a single token, is padded with the boundary
+// as the start of a sequence. A MultiKeyChain is safe for concurrent use
+type MultiKeyChain struct {
+	order    int
+	chainTex sync.RWMutex
+	chain    *singleKeyChain
+}
+
+// NewMultiKeyChain creates an empty chain of the given order, orders below
+// one are treated as one
+func NewMultiKeyChain(order int) *MultiKeyChain {
+	if order < 1 {
+		order = 1
+	}
+	return &MultiKeyChain{order: order, chain: newSingleKeyChain()}
+}
+
+// BuildChainWithOrder builds a chain of order n, as
+// BuildChainFromSourcesWithOptions does with BuildOptions.Order set to n,
+// which can be used to apply other options. Each source is added as a
+// single sequence, or as several if it emits SegmentBreak
+func BuildChainWithOrder(n int, sources ...TokenSource) (*MultiKeyChain, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("chain: order must be at least 1, got %d", n)
+	}
+
+	built, buildErr := buildChainFromSources(BuildOptions{Order: n}, nil, sources...)
+	if buildErr != nil {
+		return nil, buildErr
+	}
+	if multi, ok := built.(*MultiKeyChain); ok {
+		return multi, nil
+	}
+	return &MultiKeyChain{order: 1, chain: built.(*singleKeyChain)}, nil
+}
+
+// order retrieves the order the options build, which is at least one
+func (opts BuildOptions) order() int {
+	if opts.Order < 1 {
+		return 1
+	}
+	return opts.Order
+}
+
+// withOrder wraps a chain built with opts in a MultiKeyChain if its links
+// are keyed on more than one token
+func (opts BuildOptions) withOrder(chain *singleKeyChain) MarkovChain {
+	if opts.Order > 1 {
+		return &MultiKeyChain{order: opts.Order, chain: chain}
+	}
+	return chain
+}
+
+// chainOrder retrieves the number of tokens a chain's links are keyed on
+func chainOrder(chain MarkovChain) int {
+	if historyChain, ok := chain.(HistoryChain); ok {
+		return historyChain.Order()
+	}
+	return 1
+}
+
+// isBoundaryKey reports whether a key, which may join several tokens, is
+// the start of a sequence
+func isBoundaryKey(key string) bool {
+	return strings.Trim(key, keySeparator) == ""
+}
+
+func (c *MultiKeyChain) Order() int {
+	return c.order
+}
+
+// Key joins the last Order tokens of history, padded with the boundary if
+// there are fewer, into the key of the link following them
+func (c *MultiKeyChain) Key(history []string) string {
+	return joinKey(historyContext(history, c.order))
+}
+
+// splitKey splits a key into the history it joins
+func splitKey(key string) []string {
+	return strings.Split(key, keySeparator)
+}
+
+// Train adds a sequence of tokens to the chain, empty sequences are
+// ignored, so a MultiKeyChain can be used as a Trainer
+func (c *MultiKeyChain) Train(tokens []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	countEvent(counterTrainerAdds, tokens[0])
+
+	c.chainTex.Lock()
+	defer c.chainTex.Unlock()
+	for _, segment := range splitSegments(tokens) {
+		if len(segment) == 0 {
+			continue
+		}
+		for i, token := range segment {
+			c.chain.increment(c.Key(segment[:i]), token, 1)
+		}
+		c.chain.increment(c.Key(segment), "", 1)
+		c.chain.Lengths.Observe(len(segment))
+	}
+	return nil
+}
+
+func (c *MultiKeyChain) RetrieveMarkovLinkFromHistory(history []string) (MarkovChainLink, bool) {
+	key := c.Key(history)
+
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+	link, ok := c.chain.link(key)
+	if !ok {
+		countEvent(counterLookupMisses, key)
+		return nil, false
+	}
+	countEvent(counterLookupHits, key)
+	return link, true
+}
+
+// RetrieveMarkovLink retrieves the link of a key
+func (c *MultiKeyChain) RetrieveMarkovLink(key string) (MarkovChainLink, bool) {
+	return c.RetrieveMarkovLinkFromHistory(splitKey(key))
+}
+
+// CalculateNextToken samples the token following a key
+func (c *MultiKeyChain) CalculateNextToken(key string, rand *rand.Rand) (string, bool) {
+	return CalculateNextTokenFromHistory(c, splitKey(key), rand)
+}
+
+// RetrieveTokens retrieves the key of every link, each joining Order tokens
+func (c *MultiKeyChain) RetrieveTokens() []string {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+	return c.chain.RetrieveTokens()
+}
+
+func (c *MultiKeyChain) Increment(prev string, next string, n int) error {
+	key := c.Key(splitKey(prev))
+
+	c.chainTex.Lock()
+	defer c.chainTex.Unlock()
+	return c.chain.Increment(key, next, n)
+}
+
+func (c *MultiKeyChain) RemoveSuccessor(prev string, next string) error {
+	key := c.Key(splitKey(prev))
+
+	c.chainTex.Lock()
+	defer c.chainTex.Unlock()
+	return c.chain.RemoveSuccessor(key, next)
+}
+
+func (c *MultiKeyChain) SetCount(prev string, next string, n int) error {
+	key := c.Key(splitKey(prev))
+
+	c.chainTex.Lock()
+	defer c.chainTex.Unlock()
+	return c.chain.SetCount(key, next, n)
+}
+
+func (c *MultiKeyChain) SequenceLengths() *LengthDistribution {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+	return c.chain.Lengths
+}
+
+func (c *MultiKeyChain) IsEmpty() bool {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+	return len(c.chain.Links) == 0
+}
+
+// snapshot copies the chain's links so they can be encoded while the chain
+// is in use
+func (c *MultiKeyChain) snapshot() *singleKeyChain {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+
+	snapshot := newSingleKeyChain()
+	snapshot.mergeFrom(c.chain)
+	return snapshot
+}
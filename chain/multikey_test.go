@@ -0,0 +1,356 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var orderCorpus = []string{
+	"the cat sat on the mat",
+	"the cat ran to the dog",
+	"a dog sat on the cat",
+}
+
+func orderSources() []TokenSource {
+	sources := make([]TokenSource, 0, len(orderCorpus))
+	for _, text := range orderCorpus {
+		sources = append(sources, NewSliceSource(strings.Fields(text)))
+	}
+	return sources
+}
+
+// trainedOrderChain trains the corpus into a chain of order n sequentially
+func trainedOrderChain(n int) *MultiKeyChain {
+	chain := NewMultiKeyChain(n)
+	for _, text := range orderCorpus {
+		chain.Train(strings.Fields(text))
+	}
+	return chain
+}
+
+// assertSameMultiKeyChain fails unless got holds the transitions of want
+func assertSameMultiKeyChain(t *testing.T, want *MultiKeyChain, got MarkovChain) {
+	t.Helper()
+	multi, ok := got.(*MultiKeyChain)
+	if !ok {
+		t.Fatalf("got %T", got)
+	}
+	if multi.Order() != want.Order() {
+		t.Fatalf("got order %d, want %d", multi.Order(), want.Order())
+	}
+	assertSameChain(t, want.chain, multi)
+}
+
+func TestBuildChainWithOrder(t *testing.T) {
+	built, buildErr := BuildChainWithOrder(2, orderSources()...)
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	assertSameMultiKeyChain(t, trainedOrderChain(2), built)
+
+	// "the cat" is followed by "sat" and "ran", but "on the" only by "mat"
+	// and "cat"
+	link, ok := built.RetrieveMarkovLinkFromHistory([]string{"on", "the"})
+	if !ok || len(link.RetrieveNextTokenPossibilities()) != 2 {
+		t.Fatal("link of \"on the\" missing")
+	}
+	if _, ok := link.GetProbabilityOfToken("dog"); ok {
+		t.Fatal("\"on the\" is never followed by \"dog\"")
+	}
+}
+
+func TestBuildChainWithOrderOptions(t *testing.T) {
+	sources := append(orderSources(), NewSliceSource(strings.Fields(strings.ToUpper(orderCorpus[0]))))
+	built, buildErr := BuildChainFromSourcesWithOptions(BuildOptions{
+		Order:        2,
+		Filters:      []SourceFilter{LowercaseFilter()},
+		Deduplicator: NewDeduplicator(),
+	}, sources...)
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	// the uppercased repeat is lowercased then skipped as a duplicate
+	assertSameMultiKeyChain(t, trainedOrderChain(2), built)
+}
+
+func TestMultiKeyChainKeys(t *testing.T) {
+	chain := trainedOrderChain(2)
+
+	// a single token is the start of a sequence
+	if link, ok := chain.RetrieveMarkovLink("the"); !ok || len(link.RetrieveNextTokenPossibilities()) != 1 {
+		t.Fatal("\"the\" should start a sequence followed only by \"cat\"")
+	}
+	if _, ok := chain.RetrieveMarkovLink("mat"); ok {
+		t.Fatal("no sequence starts with \"mat\"")
+	}
+	if _, ok := chain.RetrieveMarkovLink(chain.Key([]string{"the", "mat"})); !ok {
+		t.Fatal("link of \"the mat\" missing")
+	}
+	for _, key := range chain.RetrieveTokens() {
+		if _, ok := chain.RetrieveMarkovLink(key); !ok {
+			t.Fatalf("key %q not found", key)
+		}
+	}
+}
+
+func TestMultiKeyChainCopyMerge(t *testing.T) {
+	want := trainedOrderChain(3)
+
+	var copied WritableChain = NewMultiKeyChain(3)
+	if copyErr := Copy(copied, want); copyErr != nil {
+		t.Fatal(copyErr)
+	}
+	// Copy only copies transitions
+	assertSameChain(t, &singleKeyChain{Links: want.chain.Links, Lengths: NewLengthDistribution()}, copied)
+
+	merged := NewMultiKeyChain(3)
+	if mergeErr := Merge(merged, trainedOrderChain(3), trainedOrderChain(3)); mergeErr != nil {
+		t.Fatal(mergeErr)
+	}
+	// "the cat" starts two sequences of each chain
+	link, _ := merged.RetrieveMarkovLink(merged.Key([]string{"the", "cat"}))
+	if total := link.(CountedLink).GetTotalOccurrences(); total != 4 {
+		t.Fatalf("merged \"the cat\" occurs %d times, want 4", total)
+	}
+}
+
+func TestMultiKeyChainTrainers(t *testing.T) {
+	want := trainedOrderChain(2)
+
+	trained := NewMultiKeyChain(2)
+	trainer := MakeTrainer(trained)
+	for _, text := range orderCorpus {
+		if trainErr := trainer.Train(strings.Fields(text)); trainErr != nil {
+			t.Fatal(trainErr)
+		}
+	}
+	assertSameMultiKeyChain(t, want, trained)
+
+	// addSequence doesn't record lengths, so only transitions are compared
+	added := NewMultiKeyChain(2)
+	for _, text := range orderCorpus {
+		if addErr := addSequence(added, strings.Fields(text)); addErr != nil {
+			t.Fatal(addErr)
+		}
+	}
+	assertSameChain(t, &singleKeyChain{Links: want.chain.Links, Lengths: NewLengthDistribution()}, added)
+}
+
+func TestMultiKeyChainScoring(t *testing.T) {
+	chain := NewMultiKeyChain(2)
+	for i := 0; i < 5; i++ {
+		chain.Train(strings.Fields("the cat sat"))
+	}
+
+	score := Score(chain, []string{"", "the", "cat", "sat", ""})
+	if score.Transitions != 4 || score.Unseen != 0 || score.LogProbability != 0 {
+		t.Fatalf("scored %+v, want every transition seen with probability one", score)
+	}
+
+	paths := TopPaths(chain, "", 3, 1)
+	if len(paths) != 1 || strings.Join(paths[0].Tokens, " ") != "the cat sat" {
+		t.Fatalf("got paths %+v", paths)
+	}
+
+	corrections, rerankErr := RerankCorrections(chain, []string{"the", "cta", "sat"}, 1, []string{"sat", "cat"})
+	if rerankErr != nil {
+		t.Fatal(rerankErr)
+	}
+	if corrections[0].Candidate != "cat" || corrections[0].Score.Unseen != 0 {
+		t.Fatalf("got corrections %+v", corrections)
+	}
+
+	continuation, continueErr := ContinuePrompt(chain, []string{"the", "cat", "purred"}, rand.New(rand.NewSource(1)), GenerateOptions{MaxTokens: 10})
+	if continueErr != nil {
+		t.Fatal(continueErr)
+	}
+	if continuation.Used != 2 || strings.Join(continuation.Tokens, " ") != "sat" {
+		t.Fatalf("got continuation %+v", continuation)
+	}
+}
+
+// assertNoToken fails if any key or successor of chain includes token
+func assertNoToken(t *testing.T, chain *MultiKeyChain, token string) {
+	t.Helper()
+	for _, key := range chain.RetrieveTokens() {
+		for _, keyToken := range splitKey(key) {
+			if keyToken == token {
+				t.Fatalf("key %q still includes %q", key, token)
+			}
+		}
+		link, _ := chain.RetrieveMarkovLink(key)
+		if _, ok := link.GetProbabilityOfToken(token); ok {
+			t.Fatalf("%q is still a successor of %q", token, key)
+		}
+	}
+}
+
+func TestMultiKeyChainMaintenance(t *testing.T) {
+	renamed := trainedOrderChain(2)
+	if renameErr := RenameToken(renamed, "cat", "bob"); renameErr != nil {
+		t.Fatal(renameErr)
+	}
+	assertNoToken(t, renamed, "cat")
+	if _, ok := renamed.RetrieveMarkovLinkFromHistory([]string{"the", "bob"}); !ok {
+		t.Fatal("\"the bob\" missing after renaming")
+	}
+
+	masked := trainedOrderChain(2)
+	if _, redactErr := Redact(masked, RedactOptions{Patterns: []*regexp.Regexp{regexp.MustCompile("^dog$")}, Mask: "pet"}); redactErr != nil {
+		t.Fatal(redactErr)
+	}
+	assertNoToken(t, masked, "dog")
+
+	removed := trainedOrderChain(2)
+	if _, redactErr := Redact(removed, RedactOptions{Patterns: []*regexp.Regexp{regexp.MustCompile("^dog$")}}); redactErr != nil {
+		t.Fatal(redactErr)
+	}
+	assertNoToken(t, removed, "dog")
+
+	private, privatizeErr := Privatize(trainedOrderChain(2), PrivacyOptions{Epsilon: 1}, rand.New(rand.NewSource(1)))
+	if privatizeErr != nil {
+		t.Fatal(privatizeErr)
+	}
+	if chainOrder(private) != 2 {
+		t.Fatalf("privatized chain has order %d, want 2", chainOrder(private))
+	}
+
+	if _, minimizeErr := Minimize(trainedOrderChain(2), MinimizeOptions{Epsilon: 0.1}); minimizeErr == nil {
+		t.Fatal("minimized a chain of order 2")
+	}
+}
+
+func TestMultiKeyChainRoundTrip(t *testing.T) {
+	want := trainedOrderChain(2)
+	for _, opts := range []EncodeOptions{{}, {Gob: true}, {Compact: true}, {Shards: 3}} {
+		encoded := &bytes.Buffer{}
+		if writeErr := WriteChain(encoded, want, opts); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		loaded, loadErr := LoadChain(encoded, DefaultDecodeOptions())
+		if loadErr != nil {
+			t.Fatalf("%+v: %v", opts, loadErr)
+		}
+		assertSameMultiKeyChain(t, want, loaded)
+	}
+}
+
+func TestMultiKeyChainRandomStart(t *testing.T) {
+	chain := trainedOrderChain(2)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		generated, generateErr := Generate(chain, r, GenerateOptions{RandomStart: true, MaxTokens: 20})
+		if generateErr != nil {
+			t.Fatal(generateErr)
+		}
+		if len(generated) == 0 || strings.Contains(strings.Join(generated, " "), keySeparator) {
+			t.Fatalf("generated %q", generated)
+		}
+	}
+}
+
+func TestPipelineResumeWithOrder(t *testing.T) {
+	store := MakeDirectoryStore(t.TempDir())
+	options := []BuildOption{WithBuildOptions(BuildOptions{Order: 2})}
+	interrupted := &Pipeline{
+		Sources:       append(orderSources()[:2], failingSource{}),
+		Options:       options,
+		Checkpoints:   store,
+		CheckpointKey: "job",
+	}
+	if _, runErr := interrupted.Run(context.Background()); runErr == nil {
+		t.Fatal("the interrupted run should fail")
+	}
+
+	resumed := &Pipeline{
+		Sources:       append([]TokenSource{failingSource{}, failingSource{}}, orderSources()[2]),
+		Options:       options,
+		Checkpoints:   store,
+		CheckpointKey: "job",
+	}
+	built, runErr := resumed.Run(context.Background())
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	assertSameMultiKeyChain(t, trainedOrderChain(2), built)
+}
+
+// truncatedSource fails once it has read limit tokens
+type truncatedSource struct {
+	source TokenSource
+	limit  int
+}
+
+func (s *truncatedSource) NextToken() (string, error) {
+	if s.limit == 0 {
+		return "", errors.New("interrupted")
+	}
+	s.limit--
+	return s.source.NextToken()
+}
+
+func TestPipelineResumeStreamWithOrder(t *testing.T) {
+	store := MakeDirectoryStore(t.TempDir())
+	words := strings.Fields(orderCorpus[0] + " " + orderCorpus[1])
+	newPipeline := func(source TokenSource) *Pipeline {
+		return &Pipeline{
+			Sources:          []TokenSource{MakeCountingSource(source)},
+			Options:          []BuildOption{WithBuildOptions(BuildOptions{Order: 3})},
+			Checkpoints:      store,
+			CheckpointKey:    "job",
+			CheckpointTokens: 4,
+		}
+	}
+
+	// interrupted mid sequence, after a checkpoint of the first 8 tokens
+	if _, runErr := newPipeline(&truncatedSource{NewSliceSource(words), 10}).Run(context.Background()); runErr == nil {
+		t.Fatal("the interrupted run should fail")
+	}
+	built, runErr := newPipeline(NewSliceSource(words)).Run(context.Background())
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+
+	want := NewMultiKeyChain(3)
+	want.Train(words)
+	assertSameMultiKeyChain(t, want, built)
+}
+
+func TestBuildChainWithOrderLimits(t *testing.T) {
+	built, buildErr := BuildChainFromSourcesWithOptions(BuildOptions{Order: 2, MaxStates: 3}, orderSources()...)
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	if _, ok := built.RetrieveMarkovLink(""); !ok {
+		t.Fatal("the start of every sequence was evicted")
+	}
+
+	built, buildErr = BuildChainFromSourcesWithOptions(BuildOptions{Order: 2, MaxVocabulary: 4}, orderSources()...)
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	multi := built.(*MultiKeyChain)
+
+	vocabulary := map[string]bool{"": true}
+	for _, key := range multi.RetrieveTokens() {
+		link, _ := multi.RetrieveMarkovLink(key)
+		for _, next := range link.RetrieveNextTokenPossibilities() {
+			vocabulary[next] = true
+		}
+	}
+	if len(vocabulary) > 5 {
+		t.Fatalf("vocabulary of %d tokens exceeds limit", len(vocabulary)-1)
+	}
+	for _, key := range multi.RetrieveTokens() {
+		for _, token := range strings.Split(key, keySeparator) {
+			if !vocabulary[token] {
+				t.Fatalf("key %q holds evicted token %q", key, token)
+			}
+		}
+	}
+}
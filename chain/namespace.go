@@ -0,0 +1,188 @@
+package chain
+
+import (
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// NamespaceStats summarizes one namespace of a NamespacedChain
+type NamespaceStats struct {
+	Name        string `json:"name"`
+	Sequences   int    `json:"sequences"`
+	States      int    `json:"states"`
+	Transitions int    `json:"transitions"`
+}
+
+// NamespacedChain holds a separate chain per named corpus in one container,
+// e.g. one per chat channel, so each can be generated from alone or all can
+// be generated from together. A NamespacedChain is safe for concurrent use
+type NamespacedChain struct {
+	chainTex   sync.RWMutex
+	namespaces map[string]*singleKeyChain
+}
+
+// NewNamespacedChain creates a NamespacedChain with no namespaces
+func NewNamespacedChain() *NamespacedChain {
+	return &NamespacedChain{namespaces: make(map[string]*singleKeyChain)}
+}
+
+// namespace returns the chain of a namespace, creating it if necessary. The
+// caller must hold the write lock
+func (c *NamespacedChain) namespace(name string) *singleKeyChain {
+	chain, ok := c.namespaces[name]
+	if !ok {
+		chain = newSingleKeyChain()
+		c.namespaces[name] = chain
+	}
+	return chain
+}
+
+// Train adds a sequence of tokens to a namespace, creating the namespace if
+// it doesn't exist. Empty sequences are ignored
+func (c *NamespacedChain) Train(namespace string, tokens []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	c.chainTex.Lock()
+	defer c.chainTex.Unlock()
+	chain := c.namespace(namespace)
+	for _, segment := range splitSegments(tokens) {
+		if len(segment) == 0 {
+			continue
+		}
+		if addErr := addSequence(chain, segment); addErr != nil {
+			return addErr
+		}
+		chain.Lengths.Observe(len(segment))
+	}
+	return nil
+}
+
+// AddSource reads the source until it is exhausted and adds its tokens to a
+// namespace as a single sequence
+func (c *NamespacedChain) AddSource(namespace string, source TokenSource) error {
+	tokens, drainErr := drainSource(source)
+	if drainErr != nil {
+		return drainErr
+	}
+	return c.Train(namespace, tokens)
+}
+
+// Namespaces lists the namespaces in sorted order
+func (c *NamespacedChain) Namespaces() []string {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+
+	names := make([]string, 0, len(c.namespaces))
+	for name := range c.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Remove deletes a namespace and everything trained into it
+func (c *NamespacedChain) Remove(namespace string) {
+	c.chainTex.Lock()
+	defer c.chainTex.Unlock()
+	delete(c.namespaces, namespace)
+}
+
+// Generate generates a sequence from a single namespace, ErrKeyNotFound is
+// returned if the namespace doesn't exist
+func (c *NamespacedChain) Generate(namespace string, rand *rand.Rand, opts GenerateOptions) ([]string, error) {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+
+	chain, ok := c.namespaces[namespace]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return Generate(chain, rand, opts)
+}
+
+// GenerateAny chooses a namespace with probability proportional to the
+// number of sequences trained into it, then generates a sequence from it.
+// It returns the namespace chosen, or ErrEmptyChain if nothing was trained
+func (c *NamespacedChain) GenerateAny(rand *rand.Rand, opts GenerateOptions) (string, []string, error) {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+
+	// sort namespaces so the choice is reproducible for a given rand
+	names := make([]string, 0, len(c.namespaces))
+	total := 0
+	for name, chain := range c.namespaces {
+		names = append(names, name)
+		total += chain.Lengths.Total
+	}
+	if total == 0 {
+		return "", nil, ErrEmptyChain
+	}
+	sort.Strings(names)
+
+	goal := rand.Intn(total)
+	chosen := names[len(names)-1]
+	for _, name := range names {
+		goal -= c.namespaces[name].Lengths.Total
+		if goal < 0 {
+			chosen = name
+			break
+		}
+	}
+
+	tokens, generateErr := Generate(c.namespaces[chosen], rand, opts)
+	return chosen, tokens, generateErr
+}
+
+// Stats summarizes every namespace, sorted by name
+func (c *NamespacedChain) Stats() []NamespaceStats {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+
+	stats := make([]NamespaceStats, 0, len(c.namespaces))
+	for name, chain := range c.namespaces {
+		namespaceStats := NamespaceStats{
+			Name:      name,
+			Sequences: chain.Lengths.Total,
+			States:    len(chain.Links),
+		}
+		for _, link := range chain.Links {
+			namespaceStats.Transitions += link.Total
+		}
+		stats = append(stats, namespaceStats)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+
+	return stats
+}
+
+// Export copies a namespace into a standalone chain, ErrKeyNotFound is
+// returned if the namespace doesn't exist
+func (c *NamespacedChain) Export(namespace string) (WritableChain, error) {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+
+	chain, ok := c.namespaces[namespace]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	exported := newSingleKeyChain()
+	exported.mergeFrom(chain)
+	return exported, nil
+}
+
+// WriteNamespace encodes a namespace as WriteChain does, ErrKeyNotFound is
+// returned if the namespace doesn't exist
+func (c *NamespacedChain) WriteNamespace(w io.Writer, namespace string, opts EncodeOptions) error {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+
+	chain, ok := c.namespaces[namespace]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	return WriteChain(w, chain, opts)
+}
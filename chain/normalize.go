@@ -0,0 +1,219 @@
+package chain
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+// KeyNormalizer maps tokens to the canonical form used as chain keys. The
+// same normalizer is applied when training and when querying so lookups
+// match the way the chain was built
+type KeyNormalizer interface {
+	// NormalizeKey returns the canonical form of token
+	NormalizeKey(token string) string
+
+	// Name identifies the normalizer so it can be recorded alongside a chain
+	// and recreated with LookupKeyNormalizer
+	Name() string
+}
+
+type funcNormalizer struct {
+	name      string
+	normalize func(string) string
+}
+
+func (n *funcNormalizer) NormalizeKey(token string) string {
+	return n.normalize(token)
+}
+
+func (n *funcNormalizer) Name() string {
+	return n.name
+}
+
+// MakeFuncNormalizer converts a function into a named KeyNormalizer
+func MakeFuncNormalizer(name string, normalize func(string) string) KeyNormalizer {
+	return &funcNormalizer{
+		name:      name,
+		normalize: normalize,
+	}
+}
+
+// CaseFoldNormalizer normalizes keys by converting them to lowercase
+func CaseFoldNormalizer() KeyNormalizer {
+	return MakeFuncNormalizer("lowercase", strings.ToLower)
+}
+
+// TrimNormalizer normalizes keys by trimming surrounding whitespace
+func TrimNormalizer() KeyNormalizer {
+	return MakeFuncNormalizer("trim", strings.TrimSpace)
+}
+
+// ComposeNormalizers applies several normalizers in order
+func ComposeNormalizers(normalizers ...KeyNormalizer) KeyNormalizer {
+	names := make([]string, 0, len(normalizers))
+	for _, v := range normalizers {
+		names = append(names, v.Name())
+	}
+
+	return MakeFuncNormalizer(strings.Join(names, "+"), func(token string) string {
+		for _, v := range normalizers {
+			token = v.NormalizeKey(token)
+		}
+		return token
+	})
+}
+
+var (
+	normalizerTex      sync.RWMutex
+	normalizerRegistry = map[string]func() KeyNormalizer{
+		"lowercase": CaseFoldNormalizer,
+		"trim":      TrimNormalizer,
+	}
+)
+
+// RegisterKeyNormalizer makes a normalizer available to LookupKeyNormalizer
+// under the specified name, e.g. so a Unicode NFKC or stemming normalizer
+// can be recreated when a chain is loaded
+func RegisterKeyNormalizer(name string, constructor func() KeyNormalizer) {
+	normalizerTex.Lock()
+	defer normalizerTex.Unlock()
+	normalizerRegistry[name] = constructor
+}
+
+// LookupKeyNormalizer recreates a normalizer from its name. Names of
+// composed normalizers are joined with '+'
+func LookupKeyNormalizer(name string) (KeyNormalizer, error) {
+	normalizerTex.RLock()
+	defer normalizerTex.RUnlock()
+
+	parts := strings.Split(name, "+")
+	normalizers := make([]KeyNormalizer, 0, len(parts))
+	for _, part := range parts {
+		constructor, ok := normalizerRegistry[part]
+		if !ok {
+			return nil, fmt.Errorf("%w: key normalizer %q", ErrUnknownName, part)
+		}
+		normalizers = append(normalizers, constructor())
+	}
+
+	if len(normalizers) == 1 {
+		return normalizers[0], nil
+	}
+	return ComposeNormalizers(normalizers...), nil
+}
+
+// NormalizedChain is a WritableChain that normalizes every key it is given
+type NormalizedChain interface {
+	WritableChain
+
+	// KeyNormalizer retrieves the normalizer applied to keys
+	KeyNormalizer() KeyNormalizer
+}
+
+type normalizedChain struct {
+	chain      WritableChain
+	normalizer KeyNormalizer
+}
+
+// MakeNormalizedChain wraps a chain so that keys are normalized both when
+// training and when querying. The empty end of sequence token is never
+// normalized, and transitions to or from other tokens that normalize to it
+// are ignored
+func MakeNormalizedChain(chain WritableChain, normalizer KeyNormalizer) NormalizedChain {
+	return &normalizedChain{
+		chain:      chain,
+		normalizer: normalizer,
+	}
+}
+
+// BuildNormalizedChainFromSources builds an in-memory chain from sources
+// with every key normalized
+func BuildNormalizedChainFromSources(normalizer KeyNormalizer, tokenSources ...TokenSource) (NormalizedChain, error) {
+	built, buildErr := BuildChainFromSources(tokenSources...)
+	if buildErr != nil {
+		return nil, buildErr
+	}
+
+	normalized := MakeNormalizedChain(NewWritableChain(), normalizer)
+	if copyErr := Copy(normalized, built); copyErr != nil {
+		return nil, copyErr
+	}
+
+	return normalized, nil
+}
+
+// normalize normalizes a token, reporting false if a token other than the
+// boundary normalizes to the boundary, as it would be mistaken for the
+// start or end of a sequence
+func (c *normalizedChain) normalize(token string) (string, bool) {
+	if token == "" {
+		return token, true
+	}
+	normalized := c.normalizer.NormalizeKey(token)
+	return normalized, normalized != ""
+}
+
+// normalizePair normalizes both tokens of a transition
+func (c *normalizedChain) normalizePair(prev string, next string) (string, string, bool) {
+	prev, prevOk := c.normalize(prev)
+	next, nextOk := c.normalize(next)
+	return prev, next, prevOk && nextOk
+}
+
+func (c *normalizedChain) KeyNormalizer() KeyNormalizer {
+	return c.normalizer
+}
+
+func (c *normalizedChain) CalculateNextToken(token string, rand *rand.Rand) (nextToken string, keyPresent bool) {
+	token, ok := c.normalize(token)
+	if !ok {
+		return "", false
+	}
+	return c.chain.CalculateNextToken(token, rand)
+}
+
+func (c *normalizedChain) RetrieveMarkovLink(token string) (link MarkovChainLink, keyPresent bool) {
+	token, ok := c.normalize(token)
+	if !ok {
+		return nil, false
+	}
+	return c.chain.RetrieveMarkovLink(token)
+}
+
+func (c *normalizedChain) RetrieveTokens() []string {
+	return c.chain.RetrieveTokens()
+}
+
+func (c *normalizedChain) IsEmpty() bool {
+	return c.chain.IsEmpty()
+}
+
+// Increment ignores transitions to or from a token that normalizes to the
+// boundary
+func (c *normalizedChain) Increment(prev string, next string, n int) error {
+	prev, next, ok := c.normalizePair(prev, next)
+	if !ok {
+		return nil
+	}
+	return c.chain.Increment(prev, next, n)
+}
+
+func (c *normalizedChain) RemoveSuccessor(prev string, next string) error {
+	prev, next, ok := c.normalizePair(prev, next)
+	if !ok {
+		return nil
+	}
+	return c.chain.RemoveSuccessor(prev, next)
+}
+
+// SetCount ignores transitions to or from a token that normalizes to the
+// boundary
+func (c *normalizedChain) SetCount(prev string, next string, n int) error {
+	prev, next, ok := c.normalizePair(prev, next)
+	if !ok {
+		return nil
+	}
+	return c.chain.SetCount(prev, next, n)
+}
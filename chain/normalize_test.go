@@ -0,0 +1,29 @@
+package chain
+
+import "testing"
+
+func TestNormalizedChainIgnoresBoundaryKeys(t *testing.T) {
+	chain := MakeNormalizedChain(NewWritableChain(), TrimNormalizer())
+	for _, transition := range []Transition{
+		{Prev: "", Next: " a ", Count: 1},
+		{Prev: "a", Next: "  ", Count: 1},
+		{Prev: "  ", Next: "b", Count: 1},
+		{Prev: "a", Next: "", Count: 1},
+	} {
+		if incErr := chain.Increment(transition.Prev, transition.Next, transition.Count); incErr != nil {
+			t.Fatal(incErr)
+		}
+	}
+
+	start, ok := chain.RetrieveMarkovLink("")
+	if !ok || len(start.RetrieveNextTokenPossibilities()) != 1 {
+		t.Fatal("whitespace merged into the start of a sequence")
+	}
+	link, _ := chain.RetrieveMarkovLink("a")
+	if probability, _ := link.GetProbabilityOfToken(""); probability != 1 {
+		t.Fatalf("whitespace recorded as an end of sequence, \"a\" ends with probability %v", probability)
+	}
+	if _, ok := chain.RetrieveMarkovLink("  "); ok {
+		t.Fatal("whitespace looked up as the start of a sequence")
+	}
+}
@@ -0,0 +1,130 @@
+package chain
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// npyMagic begins every array in the NumPy .npy format, version 1.0
+const npyMagic = "\x93NUMPY\x01\x00"
+
+// writeNPYHeader writes the header of a .npy array, padded so the data is
+// aligned to 64 bytes as the format requires
+func writeNPYHeader(w io.Writer, descr string, shape []int) error {
+	dims := make([]string, len(shape))
+	for i, dim := range shape {
+		dims[i] = fmt.Sprint(dim)
+	}
+	shapeText := strings.Join(dims, ", ")
+	if len(shape) == 1 {
+		shapeText += ","
+	}
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", descr, shapeText)
+
+	// magic, header length and a trailing newline surround the header
+	prefix := len(npyMagic) + 2
+	padding := 64 - (prefix+len(header)+1)%64
+	if padding == 64 {
+		padding = 0
+	}
+	header += strings.Repeat(" ", padding) + "\n"
+
+	buf := make([]byte, prefix, prefix+len(header))
+	copy(buf, npyMagic)
+	binary.LittleEndian.PutUint16(buf[len(npyMagic):], uint16(len(header)))
+	buf = append(buf, header...)
+	_, writeErr := w.Write(buf)
+	return writeErr
+}
+
+// WriteNPZ exports a chain as a NumPy .npz archive, so it can be embedded as
+// a baseline in ML tooling, e.g. loaded with numpy.load and passed to ONNX
+// as initializers. The archive holds two arrays, vocab, the sorted tokens
+// as unicode strings with the sequence boundary first, and transitions, a
+// dense float64 matrix whose row i holds the probabilities of each token
+// following vocab[i]. The matrix grows with the square of the vocabulary so
+// large chains should be pruned first
+func WriteNPZ(w io.Writer, chain MarkovChain) error {
+	iterable, ok := chain.(IterableChain)
+	if !ok {
+		return ErrUncountableChain
+	}
+
+	transitions := make([]Transition, 0)
+	index := map[string]int{"": 0}
+	iterateErr := forEachTransition(iterable, func(t Transition) error {
+		transitions = append(transitions, t)
+		index[t.Prev], index[t.Next] = 0, 0
+		return nil
+	})
+	if iterateErr != nil {
+		return iterateErr
+	}
+
+	vocab := make([]string, 0, len(index))
+	width := 1
+	for token := range index {
+		vocab = append(vocab, token)
+		if length := utf8.RuneCountInString(token); length > width {
+			width = length
+		}
+	}
+	sort.Strings(vocab)
+	for i, token := range vocab {
+		index[token] = i
+	}
+
+	matrix := make([]float64, len(vocab)*len(vocab))
+	totals := make([]int, len(vocab))
+	for _, t := range transitions {
+		matrix[index[t.Prev]*len(vocab)+index[t.Next]] += float64(t.Count)
+		totals[index[t.Prev]] += t.Count
+	}
+	for i, value := range matrix {
+		if total := totals[i/len(vocab)]; total > 0 {
+			matrix[i] = value / float64(total)
+		}
+	}
+
+	archive := zip.NewWriter(w)
+	vocabWriter, createErr := archive.Create("vocab.npy")
+	if createErr != nil {
+		return createErr
+	}
+	if headerErr := writeNPYHeader(vocabWriter, fmt.Sprintf("<U%d", width), []int{len(vocab)}); headerErr != nil {
+		return headerErr
+	}
+	// fixed width unicode arrays hold each token as zero padded UTF-32
+	cell := make([]byte, 4*width)
+	for _, token := range vocab {
+		for i := range cell {
+			cell[i] = 0
+		}
+		i := 0
+		for _, r := range token {
+			binary.LittleEndian.PutUint32(cell[i:], uint32(r))
+			i += 4
+		}
+		if _, writeErr := vocabWriter.Write(cell); writeErr != nil {
+			return writeErr
+		}
+	}
+
+	matrixWriter, createErr := archive.Create("transitions.npy")
+	if createErr != nil {
+		return createErr
+	}
+	if headerErr := writeNPYHeader(matrixWriter, "<f8", []int{len(vocab), len(vocab)}); headerErr != nil {
+		return headerErr
+	}
+	if writeErr := binary.Write(matrixWriter, binary.LittleEndian, matrix); writeErr != nil {
+		return writeErr
+	}
+
+	return archive.Close()
+}
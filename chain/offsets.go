@@ -0,0 +1,125 @@
+package chain
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+)
+
+// OffsetSource is a TokenSource that reports its position so reading can
+// later resume from it, e.g. after a Pipeline is interrupted
+type OffsetSource interface {
+	TokenSource
+
+	// Offset is the position following the last token returned
+	Offset() int64
+
+	// Resume positions the source at an offset returned by Offset, it must
+	// be called before any token is read
+	Resume(offset int64) error
+}
+
+// fileSource reads the whitespace separated words of a file, tracking the
+// byte offset following each
+type fileSource struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	offset  int64
+}
+
+// NewFileSource opens a file as an OffsetSource of its whitespace separated
+// words, whose offsets are byte offsets into the file. The file is closed
+// once the source is exhausted or fails
+func NewFileSource(path string) (OffsetSource, error) {
+	file, openErr := os.Open(path)
+	if openErr != nil {
+		return nil, openErr
+	}
+	source := &fileSource{file: file}
+	source.scan()
+	return source, nil
+}
+
+// scan starts scanning from the file's current position
+func (s *fileSource) scan() {
+	s.scanner = bufio.NewScanner(s.file)
+	s.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, splitErr := bufio.ScanWords(data, atEOF)
+		s.offset += int64(advance)
+		return advance, token, splitErr
+	})
+}
+
+func (s *fileSource) NextToken() (string, error) {
+	if s.scanner.Scan() {
+		return s.scanner.Text(), nil
+	}
+	if scanErr := s.scanner.Err(); scanErr != nil {
+		return "", scanErr
+	}
+	return "", io.EOF
+}
+
+func (s *fileSource) Offset() int64 {
+	return s.offset
+}
+
+func (s *fileSource) Resume(offset int64) error {
+	if _, seekErr := s.file.Seek(offset, io.SeekStart); seekErr != nil {
+		return seekErr
+	}
+	s.offset = offset
+	s.scan()
+	return nil
+}
+
+func (s *fileSource) Close() error {
+	return s.file.Close()
+}
+
+// countingSource counts the records read from a source that can't seek
+type countingSource struct {
+	src   TokenSource
+	count int64
+}
+
+// MakeCountingSource converts a source that can't seek, such as a stream
+// that can be replayed from the start, into an OffsetSource whose offsets
+// count the tokens read. Resuming reads and discards the tokens before the
+// offset, so it saves training on them but not reading them
+func MakeCountingSource(source TokenSource) OffsetSource {
+	return &countingSource{src: source}
+}
+
+func (s *countingSource) NextToken() (string, error) {
+	return s.NextTokenContext(context.Background())
+}
+
+func (s *countingSource) NextTokenContext(ctx context.Context) (string, error) {
+	token, readErr := nextTokenContext(ctx, s.src)
+	if readErr == nil {
+		s.count++
+	}
+	return token, readErr
+}
+
+func (s *countingSource) Offset() int64 {
+	return s.count
+}
+
+func (s *countingSource) Resume(offset int64) error {
+	for s.count < offset {
+		if _, readErr := s.NextToken(); readErr != nil {
+			if readErr == io.EOF {
+				return malformed("offset %d is beyond the end of the source", offset)
+			}
+			return readErr
+		}
+	}
+	return nil
+}
+
+func (s *countingSource) Close() error {
+	return closeSource(s.src)
+}
@@ -0,0 +1,397 @@
+package chain
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+)
+
+// BuildOption customizes a build
+type BuildOption func(*BuildOptions)
+
+func applyBuildOptions(opts []BuildOption) BuildOptions {
+	options := BuildOptions{}
+	for _, v := range opts {
+		v(&options)
+	}
+	return options
+}
+
+// BuildChain builds a Markov chain from sources providing tokens, customized
+// by opts. BuildChainFromSources and BuildChainFromSourcesWithOptions are
+// equivalent to calling BuildChain with no options and WithBuildOptions
+func BuildChain(tokenSources []TokenSource, opts ...BuildOption) (MarkovChain, error) {
+	return BuildChainFromSourcesWithOptions(applyBuildOptions(opts), tokenSources...)
+}
+
+// WithBuildOptions replaces every setting of a build with those in opts, any
+// options that follow it are applied on top
+func WithBuildOptions(opts BuildOptions) BuildOption {
+	return func(o *BuildOptions) {
+		*o = opts
+	}
+}
+
+// WithOrder keys the links of a build on the previous n tokens, building a
+// MultiKeyChain if n is above one
+func WithOrder(n int) BuildOption {
+	return func(o *BuildOptions) {
+		o.Order = n
+	}
+}
+
+// WithFilters applies filters, in order, to every source of a build
+func WithFilters(filters ...SourceFilter) BuildOption {
+	return func(o *BuildOptions) {
+		o.Filters = append(o.Filters, filters...)
+	}
+}
+
+// WithConcurrency caps the number of sources read at once
+func WithConcurrency(sources int) BuildOption {
+	return func(o *BuildOptions) {
+		o.Concurrency = sources
+	}
+}
+
+// WithProgress reports the progress of a build
+func WithProgress(progress func(BuildProgress)) BuildOption {
+	return func(o *BuildOptions) {
+		o.Progress = progress
+	}
+}
+
+// WithMaxVocabulary caps the number of distinct tokens retained by a build
+func WithMaxVocabulary(tokens int) BuildOption {
+	return func(o *BuildOptions) {
+		o.MaxVocabulary = tokens
+	}
+}
+
+// WithMaxStates caps the number of links retained by a build
+func WithMaxStates(states int) BuildOption {
+	return func(o *BuildOptions) {
+		o.MaxStates = states
+	}
+}
+
+// WithOverflowBuckets folds links beyond the MaxStates cap into hashed
+// buckets rather than dropping them
+func WithOverflowBuckets(buckets int) BuildOption {
+	return func(o *BuildOptions) {
+		o.OverflowBuckets = buckets
+	}
+}
+
+// WithDeduplicator skips repeated sequences during a build
+func WithDeduplicator(deduplicator *Deduplicator) BuildOption {
+	return func(o *BuildOptions) {
+		o.Deduplicator = deduplicator
+	}
+}
+
+// WithNGramIndex populates an n-gram index during a build
+func WithNGramIndex(index *NGramIndex) BuildOption {
+	return func(o *BuildOptions) {
+		o.NGramIndex = index
+	}
+}
+
+// WithExamples retains example snippets for transitions during a build
+func WithExamples(examples *ExampleStore) BuildOption {
+	return func(o *BuildOptions) {
+		o.Examples = examples
+	}
+}
+
+// WithContext cancels a build when ctx is done
+func WithContext(ctx context.Context) BuildOption {
+	return func(o *BuildOptions) {
+		o.Context = ctx
+	}
+}
+
+// WithReport fills in report with a summary once the build completes
+func WithReport(report *BuildReport) BuildOption {
+	return func(o *BuildOptions) {
+		o.Report = report
+	}
+}
+
+// WithWarnings reports suspicious training data as it is read
+func WithWarnings(warn func(BuildWarning)) BuildOption {
+	return func(o *BuildOptions) {
+		o.Warn = warn
+	}
+}
+
+// WithEmptyTokens sets what happens to empty tokens
+func WithEmptyTokens(policy EmptyTokenPolicy) BuildOption {
+	return func(o *BuildOptions) {
+		o.EmptyTokens = policy
+	}
+}
+
+// WithEmptyPlaceholder replaces empty tokens with placeholder
+func WithEmptyPlaceholder(placeholder string) BuildOption {
+	return func(o *BuildOptions) {
+		o.EmptyTokens = EmptyTokenPlaceholder
+		o.EmptyPlaceholder = placeholder
+	}
+}
+
+// WithSequenceIndex indexes every sequence so generation can reject copies
+func WithSequenceIndex(index *SequenceIndex) BuildOption {
+	return func(o *BuildOptions) {
+		o.SequenceIndex = index
+	}
+}
+
+// BuildProgress reports how far a build has progressed
+type BuildProgress struct {
+	TokensRead       int64
+	SourcesCompleted int
+	// SourcesTotal is zero when the number of sources isn't known up front
+	SourcesTotal int
+}
+
+// progressInterval is the number of tokens read between progress reports
+const progressInterval = 10000
+
+// progressTracker serializes progress reports from concurrent readers, a nil
+// tracker discards all progress
+type progressTracker struct {
+	callback   func(BuildProgress)
+	trackerTex sync.Mutex
+	progress   BuildProgress
+}
+
+func newProgressTracker(report func(BuildProgress), sources int) *progressTracker {
+	if report == nil {
+		return nil
+	}
+
+	return &progressTracker{
+		callback: report,
+		progress: BuildProgress{SourcesTotal: sources},
+	}
+}
+
+func (t *progressTracker) token() {
+	if t == nil {
+		return
+	}
+
+	t.trackerTex.Lock()
+	defer t.trackerTex.Unlock()
+	t.progress.TokensRead++
+	if t.progress.TokensRead%progressInterval == 0 {
+		t.callback(t.progress)
+	}
+}
+
+func (t *progressTracker) sourceDone() {
+	if t == nil {
+		return
+	}
+
+	t.trackerTex.Lock()
+	defer t.trackerTex.Unlock()
+	t.progress.SourcesCompleted++
+	t.callback(t.progress)
+}
+
+// report sends a final report once a build completes
+func (t *progressTracker) report() {
+	if t == nil {
+		return
+	}
+
+	t.trackerTex.Lock()
+	defer t.trackerTex.Unlock()
+	t.callback(t.progress)
+}
+
+// GenerateOption customizes generation
+type GenerateOption func(*GenerateOptions)
+
+// GenerateTokens walks a chain as Generate does, customized by opts. Unless
+// overridden up to DefaultMaxTokens tokens are generated
+func GenerateTokens(chain MarkovChain, rand *rand.Rand, opts ...GenerateOption) ([]string, error) {
+	options := GenerateOptions{MaxTokens: DefaultMaxTokens}
+	for _, v := range opts {
+		v(&options)
+	}
+
+	return Generate(chain, rand, options)
+}
+
+// DefaultMaxTokens is the number of tokens GenerateTokens stops at if no
+// WithMaxTokens option is given
+const DefaultMaxTokens = 100
+
+// WithStartToken begins generation after token rather than at the start of a
+// sequence
+func WithStartToken(token string) GenerateOption {
+	return func(o *GenerateOptions) {
+		o.Start = token
+	}
+}
+
+// WithRandomStart begins generation from a key of the chain chosen at random
+func WithRandomStart() GenerateOption {
+	return func(o *GenerateOptions) {
+		o.RandomStart = true
+	}
+}
+
+// WithStopTokens ends generation once any of tokens is generated
+func WithStopTokens(tokens ...string) GenerateOption {
+	return func(o *GenerateOptions) {
+		if o.StopTokens == nil {
+			o.StopTokens = make(map[string]bool, len(tokens))
+		}
+		for _, token := range tokens {
+			o.StopTokens[token] = true
+		}
+	}
+}
+
+// WithMaxTokens caps the number of tokens generated
+func WithMaxTokens(tokens int) GenerateOption {
+	return func(o *GenerateOptions) {
+		o.MaxTokens = tokens
+	}
+}
+
+// WithEndHazard boosts the probability of ending a sequence as it grows
+func WithEndHazard(hazard EndHazard) GenerateOption {
+	return func(o *GenerateOptions) {
+		o.EndHazard = hazard
+	}
+}
+
+// WithLengths samples a target length from a distribution
+func WithLengths(lengths *LengthDistribution) GenerateOption {
+	return func(o *GenerateOptions) {
+		o.Lengths = lengths
+	}
+}
+
+// WithWeight re-weights transitions as they are considered
+func WithWeight(weight QueryWeightFunc) GenerateOption {
+	return func(o *GenerateOptions) {
+		o.Weight = weight
+	}
+}
+
+// WithSmoothing applies additive smoothing, adding alpha to the weight of
+// every successor considered so rarer successors are chosen more often. It
+// applies on top of any weight set by an earlier option
+func WithSmoothing(alpha float64) GenerateOption {
+	return func(o *GenerateOptions) {
+		base := o.Weight
+		o.Weight = func(prev string, next string, count int) float64 {
+			if base != nil {
+				return base(prev, next, count) + alpha
+			}
+			return float64(count) + alpha
+		}
+	}
+}
+
+// WithNovelty rejects sequences that copy a training sequence in index
+func WithNovelty(index *SequenceIndex) GenerateOption {
+	return func(o *GenerateOptions) {
+		o.Novel = index
+	}
+}
+
+// WithExcluded stops tokens from being generated
+func WithExcluded(tokens ...string) GenerateOption {
+	return func(o *GenerateOptions) {
+		if o.Exclude == nil {
+			o.Exclude = make(map[string]bool, len(tokens))
+		}
+		for _, token := range tokens {
+			o.Exclude[token] = true
+		}
+	}
+}
+
+// WithNovelAttempts bounds how many sequences are generated looking for a
+// novel one
+func WithNovelAttempts(attempts int) GenerateOption {
+	return func(o *GenerateOptions) {
+		o.NovelAttempts = attempts
+	}
+}
+
+// WithSteering boosts transitions to tokens within DefaultSteeringDepth
+// steps of any of the keywords, the closer the token the greater the boost
+func WithSteering(keywords []string, strength float64) GenerateOption {
+	return func(o *GenerateOptions) {
+		o.Steering = &Steering{
+			Keywords: keywords,
+			Strength: strength,
+			Depth:    DefaultSteeringDepth,
+		}
+	}
+}
+
+// SteerTowards is WithSteering
+//
+// Deprecated: use WithSteering
+func SteerTowards(keywords []string, strength float64) GenerateOption {
+	return WithSteering(keywords, strength)
+}
+
+// EncodeOption customizes how a chain is encoded
+type EncodeOption func(*EncodeOptions)
+
+// EncodeChain encodes a chain as WriteChain does, customized by opts.
+// WriteChain is equivalent to calling EncodeChain with WithEncodeOptions
+func EncodeChain(w io.Writer, chain MarkovChain, opts ...EncodeOption) error {
+	options := EncodeOptions{}
+	for _, v := range opts {
+		v(&options)
+	}
+	return WriteChain(w, chain, options)
+}
+
+// WithEncodeOptions replaces every setting of an encoding with those in
+// opts, any options that follow it are applied on top
+func WithEncodeOptions(opts EncodeOptions) EncodeOption {
+	return func(o *EncodeOptions) {
+		*o = opts
+	}
+}
+
+// WithCompact writes the compact representation, storing each token once
+func WithCompact() EncodeOption {
+	return func(o *EncodeOptions) {
+		o.Compact = true
+	}
+}
+
+// WithGob encodes the chain with encoding/gob rather than as JSON
+func WithGob() EncodeOption {
+	return func(o *EncodeOptions) {
+		o.Gob = true
+	}
+}
+
+// WithCumulative stores a cumulative weights table with every link
+func WithCumulative() EncodeOption {
+	return func(o *EncodeOptions) {
+		o.IncludeCumulative = true
+	}
+}
+
+// WithShards splits the chain's links between shards decoded in parallel
+func WithShards(shards int) EncodeOption {
+	return func(o *EncodeOptions) {
+		o.Shards = shards
+	}
+}
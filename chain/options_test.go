@@ -0,0 +1,134 @@
+package chain
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestBuildChainWithOrderOption(t *testing.T) {
+	built, buildErr := BuildChain(orderSources(), WithOrder(2), WithFilters(LowercaseFilter()))
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	assertSameMultiKeyChain(t, trainedOrderChain(2), built)
+
+	built, buildErr = BuildChain(orderSources(), WithOrder(1))
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	if _, ok := built.(*singleKeyChain); !ok {
+		t.Fatalf("order one built %T", built)
+	}
+}
+
+func TestEncodeChainOptions(t *testing.T) {
+	want := testChain(t)
+	for _, opts := range [][]EncodeOption{
+		nil,
+		{WithCompact()},
+		{WithGob(), WithCumulative()},
+		{WithShards(3)},
+		{WithEncodeOptions(EncodeOptions{Compact: true}), WithShards(2)},
+	} {
+		encoded := &bytes.Buffer{}
+		if encodeErr := EncodeChain(encoded, want, opts...); encodeErr != nil {
+			t.Fatal(encodeErr)
+		}
+		loaded, loadErr := LoadChain(encoded, DefaultDecodeOptions())
+		if loadErr != nil {
+			t.Fatal(loadErr)
+		}
+		assertSameChain(t, want, loaded)
+	}
+
+	gob, json := &bytes.Buffer{}, &bytes.Buffer{}
+	EncodeChain(gob, want, WithGob())
+	EncodeChain(json, want)
+	if !strings.HasPrefix(gob.String(), gobMagic) || strings.HasPrefix(json.String(), gobMagic) {
+		t.Fatal("WithGob not applied")
+	}
+}
+
+func TestGenerateTokensExcludedNovelty(t *testing.T) {
+	index := NewSequenceIndex()
+	built, buildErr := BuildChain([]TokenSource{
+		NewSliceSource(strings.Fields("a b c")),
+		NewSliceSource(strings.Fields("a d c")),
+	}, WithSequenceIndex(index))
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		generated, generateErr := GenerateTokens(built, r, WithExcluded("b"))
+		if generateErr != nil {
+			t.Fatal(generateErr)
+		}
+		if strings.Join(generated, " ") != "a d c" {
+			t.Fatalf("generated %q", generated)
+		}
+	}
+	// every sequence the chain can generate was trained on
+	if _, generateErr := GenerateTokens(built, r, WithNovelty(index)); generateErr != ErrNotNovel {
+		t.Fatalf("got %v, want ErrNotNovel", generateErr)
+	}
+}
+
+func TestGenerateTokensWithSmoothing(t *testing.T) {
+	sources := []TokenSource{NewSliceSource(strings.Fields("a c"))}
+	for i := 0; i < 9; i++ {
+		sources = append(sources, NewSliceSource(strings.Fields("a b")))
+	}
+	built, buildErr := BuildChain(sources)
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+
+	rare := func(opts ...GenerateOption) int {
+		r := rand.New(rand.NewSource(1))
+		count := 0
+		for i := 0; i < 500; i++ {
+			generated, generateErr := GenerateTokens(built, r, append(opts, WithStartToken("a"), WithMaxTokens(1))...)
+			if generateErr != nil {
+				t.Fatal(generateErr)
+			}
+			if len(generated) == 1 && generated[0] == "c" {
+				count++
+			}
+		}
+		return count
+	}
+	// c follows a one time in ten, smoothing brings it close to one in two
+	if unsmoothed, smoothed := rare(), rare(WithSmoothing(1000)); unsmoothed > 100 || smoothed < 200 {
+		t.Fatalf("c generated %d times unsmoothed and %d times smoothed", unsmoothed, smoothed)
+	}
+}
+
+func TestGenerateTokensWithStopTokensAndRandomStart(t *testing.T) {
+	built, buildErr := BuildChain([]TokenSource{NewSliceSource(strings.Fields("a b c d"))})
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	generated, generateErr := GenerateTokens(built, r, WithStopTokens("b"))
+	if generateErr != nil {
+		t.Fatal(generateErr)
+	}
+	if strings.Join(generated, " ") != "a b" {
+		t.Fatalf("generated %q, want to stop at b", generated)
+	}
+
+	for i := 0; i < 20; i++ {
+		generated, generateErr = GenerateTokens(built, r, WithRandomStart())
+		if generateErr != nil {
+			t.Fatal(generateErr)
+		}
+		if !strings.HasSuffix("a b c d", strings.Join(generated, " ")) {
+			t.Fatalf("generated %q from a random start", generated)
+		}
+	}
+}
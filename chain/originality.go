@@ -0,0 +1,131 @@
+package chain
+
+import (
+	"fmt"
+	"sync"
+)
+
+// NGramIndex retains hashes of every n-gram of the configured sizes seen in
+// training, so generated sequences can be checked for verbatim copying. It
+// stores 8 bytes per distinct n-gram rather than the tokens themselves. An
+// NGramIndex is safe for concurrent use
+type NGramIndex struct {
+	sizes    map[int]bool
+	maxSize  int
+	indexTex sync.RWMutex
+	hashes   map[uint64]struct{}
+}
+
+// NewNGramIndex creates an empty index of n-grams of the given sizes
+func NewNGramIndex(sizes ...int) *NGramIndex {
+	index := &NGramIndex{
+		sizes:  make(map[int]bool),
+		hashes: make(map[uint64]struct{}),
+	}
+	for _, size := range sizes {
+		if size < 1 {
+			continue
+		}
+		index.sizes[size] = true
+		if size > index.maxSize {
+			index.maxSize = size
+		}
+	}
+
+	return index
+}
+
+// ngramWindow tracks the most recent tokens of a sequence as it streams into
+// an NGramIndex
+type ngramWindow struct {
+	index  *NGramIndex
+	tokens []string
+}
+
+func (i *NGramIndex) newWindow() *ngramWindow {
+	return &ngramWindow{
+		index:  i,
+		tokens: make([]string, 0, i.maxSize),
+	}
+}
+
+func (w *ngramWindow) add(token string) {
+	if len(w.tokens) == w.index.maxSize {
+		copy(w.tokens, w.tokens[1:])
+		w.tokens = w.tokens[:len(w.tokens)-1]
+	}
+	w.tokens = append(w.tokens, token)
+
+	w.index.indexTex.Lock()
+	defer w.index.indexTex.Unlock()
+	for size := range w.index.sizes {
+		if size <= len(w.tokens) {
+			w.index.hashes[hashTokens(w.tokens[len(w.tokens)-size:])] = struct{}{}
+		}
+	}
+}
+
+// AddSequence indexes every n-gram of the configured sizes in tokens
+func (i *NGramIndex) AddSequence(tokens []string) {
+	window := i.newWindow()
+	for _, token := range tokens {
+		window.add(token)
+	}
+}
+
+// Contains reports whether an n-gram was seen in training, it is always false
+// for n-grams of a size the index doesn't retain
+func (i *NGramIndex) Contains(ngram []string) bool {
+	if !i.sizes[len(ngram)] {
+		return false
+	}
+
+	i.indexTex.RLock()
+	defer i.indexTex.RUnlock()
+	_, ok := i.hashes[hashTokens(ngram)]
+	return ok
+}
+
+// CopiedNGram is an n-gram of a generated sequence that appears verbatim in
+// the training data
+type CopiedNGram struct {
+	Position int
+	Tokens   []string
+}
+
+// OriginalityReport describes how much of a generated sequence was copied
+// verbatim from training data
+type OriginalityReport struct {
+	NGrams int
+	Copied []CopiedNGram
+}
+
+// CopiedRatio is the fraction of the sequence's n-grams that were copied
+func (r OriginalityReport) CopiedRatio() float64 {
+	if r.NGrams == 0 {
+		return 0
+	}
+	return float64(len(r.Copied)) / float64(r.NGrams)
+}
+
+// CheckOriginality reports which n-grams of generated appear verbatim in the
+// training data, n must be one of the sizes the index retains
+func (i *NGramIndex) CheckOriginality(generated []string, n int) (OriginalityReport, error) {
+	report := OriginalityReport{}
+	if !i.sizes[n] {
+		return report, fmt.Errorf("chain: n-gram index does not retain %d-grams", n)
+	}
+
+	for start := 0; start+n <= len(generated); start++ {
+		report.NGrams++
+		ngram := generated[start : start+n]
+		if i.Contains(ngram) {
+			report.Copied = append(report.Copied, CopiedNGram{
+				Position: start,
+				Tokens:   append([]string(nil), ngram...),
+			})
+		}
+	}
+
+	return report, nil
+}
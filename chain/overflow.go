@@ -0,0 +1,46 @@
+package chain
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+// overflowPrefix marks the keys of overflow buckets, it can't appear in
+// whitespace separated text
+const overflowPrefix = "\x1d"
+
+// overflowKey is the key of the overflow bucket a token's state is folded
+// into
+func overflowKey(token string, buckets int) string {
+	h := fnv.New32a()
+	h.Write([]byte(token))
+	return overflowPrefix + strconv.Itoa(int(h.Sum32()%uint32(buckets)))
+}
+
+// isOverflowKey reports whether a key is that of an overflow bucket
+func isOverflowKey(key string) bool {
+	return len(key) > 0 && key[:1] == overflowPrefix
+}
+
+// fold records that the link of a key was folded into its overflow bucket
+func (c *singleKeyChain) fold(key string) {
+	if c.Folded == nil {
+		c.Folded = make(map[string]bool)
+	}
+	c.Folded[key] = true
+}
+
+// foldState merges the link of a key into its overflow bucket
+func (c *singleKeyChain) foldState(key string) {
+	link, ok := c.Links[key]
+	if !ok {
+		return
+	}
+
+	bucket := overflowKey(key, c.OverflowBuckets)
+	for next, count := range link.NextTokenOccurrences {
+		c.increment(bucket, next, count)
+	}
+	c.deleteLink(key)
+	c.fold(key)
+}
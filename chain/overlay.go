@@ -0,0 +1,89 @@
+package chain
+
+import "math/rand"
+
+// Overlay is a WritableChain that records changes in a delta layer on top of
+// a base chain which is never modified, e.g. so a chatbot can adapt to a
+// conversation and discard what it learned once the conversation ends.
+// Lookups combine the counts of both layers. An Overlay is only as safe for
+// concurrent use as its layers are
+type Overlay struct {
+	base  MarkovChain
+	delta WritableChain
+	union *unionChain
+}
+
+// OverlayChain creates an Overlay that records changes in delta on top of
+// base, which is usually a chain shared by many overlays. Base links must be
+// CountedLinks
+func OverlayChain(base MarkovChain, delta WritableChain) *Overlay {
+	return &Overlay{
+		base:  base,
+		delta: delta,
+		union: &unionChain{chains: []Weighted{{Chain: delta, Weight: 1}, {Chain: base, Weight: 1}}},
+	}
+}
+
+// Base retrieves the chain beneath the overlay
+func (o *Overlay) Base() MarkovChain {
+	return o.base
+}
+
+// Delta retrieves the layer holding the changes made through the overlay
+func (o *Overlay) Delta() WritableChain {
+	return o.delta
+}
+
+// Discard removes every change made through the overlay, leaving it as its
+// base
+func (o *Overlay) Discard() error {
+	return forEachTransition(o.delta, func(t Transition) error {
+		return o.delta.RemoveSuccessor(t.Prev, t.Next)
+	})
+}
+
+func (o *Overlay) CalculateNextToken(token string, rand *rand.Rand) (string, bool) {
+	return o.union.CalculateNextToken(token, rand)
+}
+
+func (o *Overlay) RetrieveMarkovLink(token string) (MarkovChainLink, bool) {
+	return o.union.RetrieveMarkovLink(token)
+}
+
+func (o *Overlay) RetrieveTokens() []string {
+	return o.union.RetrieveTokens()
+}
+
+func (o *Overlay) IsEmpty() bool {
+	return o.union.IsEmpty()
+}
+
+func (o *Overlay) Increment(prev string, next string, n int) error {
+	return o.delta.Increment(prev, next, n)
+}
+
+// RemoveSuccessor removes a successor added through the overlay,
+// ErrFrozen is returned if the base holds the transition
+func (o *Overlay) RemoveSuccessor(prev string, next string) error {
+	baseCount, countErr := occurrencesIn(o.base, prev, next)
+	if countErr != nil {
+		return countErr
+	}
+	if baseCount > 0 {
+		return ErrFrozen
+	}
+	return o.delta.RemoveSuccessor(prev, next)
+}
+
+// SetCount sets the combined count of a transition, ErrFrozen is returned if
+// it would fall below the count in the base
+func (o *Overlay) SetCount(prev string, next string, n int) error {
+	baseCount, countErr := occurrencesIn(o.base, prev, next)
+	if countErr != nil {
+		return countErr
+	}
+	if n < baseCount {
+		return ErrFrozen
+	}
+	return o.delta.SetCount(prev, next, n-baseCount)
+}
@@ -0,0 +1,78 @@
+package chain
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Path is a sequence of tokens and the probability of the chain producing it
+type Path struct {
+	Tokens      []string
+	Probability float64
+}
+
+// maxPathExpansions bounds the work done by TopPaths for each requested path
+const maxPathExpansions = 10000
+
+type pathHeap []Path
+
+func (h pathHeap) Len() int            { return len(h) }
+func (h pathHeap) Less(i, j int) bool  { return h[i].Probability > h[j].Probability }
+func (h pathHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *pathHeap) Push(x interface{}) { *h = append(*h, x.(Path)) }
+func (h *pathHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// TopPaths finds the n most probable paths of exactly length tokens that
+// follow start, most probable first, e.g. to mine common phrases. Paths
+// that reach the end of a sequence early are excluded. The search is
+// best-first and so exact, but gives up after a bounded amount of work on
+// very large chains and returns the best paths found. For a HistoryChain
+// start is a key, and each path is followed on from the history it joins
+func TopPaths(chain MarkovChain, start string, length int, n int) []Path {
+	history := keyTokens(chain, start)
+	results := make([]Path, 0, n)
+	if length <= 0 || n <= 0 {
+		return results
+	}
+
+	frontier := &pathHeap{{Tokens: []string{}, Probability: 1}}
+	for expansions := 0; frontier.Len() > 0 && len(results) < n; expansions++ {
+		if expansions >= maxPathExpansions*n {
+			break
+		}
+
+		current := heap.Pop(frontier).(Path)
+		if len(current.Tokens) == length {
+			results = append(results, current)
+			continue
+		}
+
+		link, ok := retrieveLink(chain, append(history[:len(history):len(history)], current.Tokens...))
+		if !ok {
+			continue
+		}
+
+		nextTokens := link.RetrieveNextTokenPossibilities()
+		sort.Strings(nextTokens)
+		for _, next := range nextTokens {
+			if next == "" {
+				continue
+			}
+			probability, _ := link.GetProbabilityOfToken(next)
+
+			tokens := make([]string, len(current.Tokens), len(current.Tokens)+1)
+			copy(tokens, current.Tokens)
+			heap.Push(frontier, Path{
+				Tokens:      append(tokens, next),
+				Probability: current.Probability * probability,
+			})
+		}
+	}
+
+	return results
+}
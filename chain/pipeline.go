@@ -0,0 +1,355 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Pipeline is a complete training job, reading its sources through its
+// filters into a chain, post-processing the chain and writing it to its
+// destination. Sources are read one at a time, each as a single sequence,
+// so with a checkpoint store a job that fails or is cancelled can be run
+// again and resume after the last source checkpointed
+type Pipeline struct {
+	// Sources provide the training data, in order
+	Sources []TokenSource
+
+	// Filters are applied, in order, to every source
+	Filters []SourceFilter
+
+	// Options configure the chain as it is built, options that only concern
+	// reading sources concurrently are ignored
+	Options []BuildOption
+
+	// PostProcess are applied, in order, to the chain once every source has
+	// been read, e.g. to prune rare transitions
+	PostProcess []func(chain WritableChain) error
+
+	// Destination, if set, receives the chain encoded by WriteChain
+	Destination io.Writer
+
+	// Encode configures how the chain is written to Destination
+	Encode EncodeOptions
+
+	// Checkpoints, if set, persists progress under CheckpointKey so Run can
+	// resume. The sources must be given in the same order on every run
+	Checkpoints ChainStore
+
+	// CheckpointKey identifies the job's checkpoint in Checkpoints
+	CheckpointKey string
+
+	// CheckpointEvery is the number of sources read between checkpoints,
+	// defaults to one
+	CheckpointEvery int
+
+	// CheckpointTokens, if set, also checkpoints every CheckpointTokens
+	// tokens read from sources implementing OffsetSource, recording their
+	// offset so an interrupted run resumes exactly where it left off. Such
+	// sources are added to the chain as they are read rather than buffered,
+	// so they aren't deduplicated
+	CheckpointTokens int
+
+	// DecodeOptions limits what is accepted when loading a checkpoint
+	DecodeOptions DecodeOptions
+}
+
+// partialCheckpoint records how far a source being streamed was read
+type partialCheckpoint struct {
+	Offset int64 `json:"offset"`
+	// Last and Length are the key the source's sequence has reached, its
+	// last token unless links are keyed on several, and the number of
+	// tokens added, so the sequence can be continued
+	Last   string `json:"last"`
+	Length int    `json:"length"`
+}
+
+// pipelineCheckpoint records the chain built from the first Completed
+// sources of a Pipeline, and part of the next if Partial is set
+type pipelineCheckpoint struct {
+	Completed int                `json:"completed"`
+	Partial   *partialCheckpoint `json:"partial,omitempty"`
+	Chain     json.RawMessage    `json:"chain"`
+}
+
+// loadCheckpoint loads the pipeline's checkpoint, returning a nil chain if
+// there is none
+func (p *Pipeline) loadCheckpoint() (MarkovChain, *pipelineCheckpoint, error) {
+	checkpoint := &pipelineCheckpoint{}
+	if p.Checkpoints == nil {
+		return nil, checkpoint, nil
+	}
+	reader, openErr := p.Checkpoints.OpenChain(p.CheckpointKey)
+	if os.IsNotExist(openErr) {
+		return nil, checkpoint, nil
+	} else if openErr != nil {
+		return nil, nil, openErr
+	}
+	defer reader.Close()
+
+	if decodeErr := json.NewDecoder(limitReader(reader, p.DecodeOptions)).Decode(checkpoint); decodeErr != nil {
+		return nil, nil, decodeErr
+	}
+	if checkpoint.Completed < 0 || checkpoint.Completed > len(p.Sources) {
+		return nil, nil, malformed("checkpoint of %d sources for a pipeline of %d", checkpoint.Completed, len(p.Sources))
+	}
+	if checkpoint.Partial != nil {
+		if checkpoint.Completed == len(p.Sources) {
+			return nil, nil, malformed("checkpoint of part of a source beyond the last")
+		}
+		if _, ok := p.Sources[checkpoint.Completed].(OffsetSource); !ok {
+			return nil, nil, malformed("checkpoint of part of source %d, which isn't an OffsetSource", checkpoint.Completed)
+		}
+	}
+	chain, readErr := LoadChain(bytes.NewReader(checkpoint.Chain), p.DecodeOptions)
+	if readErr != nil {
+		return nil, nil, readErr
+	}
+	return chain, checkpoint, nil
+}
+
+// saveCheckpoint records that the first completed sources, and part of the
+// next if partial is set, have been read into the builder's chain
+func (p *Pipeline) saveCheckpoint(builder *ChainBuilder, completed int, partial *partialCheckpoint) error {
+	chain, finishErr := builder.Finish()
+	if finishErr != nil {
+		return finishErr
+	}
+	encoded := &bytes.Buffer{}
+	if encodeErr := WriteChain(encoded, chain, EncodeOptions{Compact: true}); encodeErr != nil {
+		return encodeErr
+	}
+
+	writer, createErr := p.Checkpoints.CreateChain(p.CheckpointKey)
+	if createErr != nil {
+		return createErr
+	}
+	encodeErr := json.NewEncoder(writer).Encode(&pipelineCheckpoint{
+		Completed: completed,
+		Partial:   partial,
+		Chain:     encoded.Bytes(),
+	})
+	closeErr := writer.Close()
+	if encodeErr != nil {
+		return encodeErr
+	}
+	return closeErr
+}
+
+// buildOptions combines the pipeline's Options and Filters into the options
+// its chain is built with
+func (p *Pipeline) buildOptions() []BuildOption {
+	opts := append([]BuildOption{}, p.Options...)
+	if len(p.Filters) > 0 {
+		opts = append(opts, WithFilters(p.Filters...))
+	}
+	return opts
+}
+
+// Run executes the pipeline, returning the post-processed chain. If ctx is
+// done the sources not yet read are closed and ctx.Err() is returned
+func (p *Pipeline) Run(ctx context.Context) (MarkovChain, error) {
+	opts := p.buildOptions()
+
+	resumed, checkpoint, loadErr := p.loadCheckpoint()
+	if loadErr != nil {
+		closeSources(p.Sources)
+		return nil, loadErr
+	}
+	completed := checkpoint.Completed
+	builder := NewChainBuilder(opts...)
+	if resumed != nil {
+		var resumeErr error
+		if builder, resumeErr = NewChainBuilderFrom(resumed, opts...); resumeErr != nil {
+			closeSources(p.Sources)
+			return nil, resumeErr
+		}
+	}
+	// sources read by an earlier run are skipped
+	closeSources(p.Sources[:completed])
+
+	every := p.CheckpointEvery
+	if every <= 0 {
+		every = 1
+	}
+	filters := applyBuildOptions(opts).Filters
+	for i := completed; i < len(p.Sources); i++ {
+		var readErr error
+		if source, ok := p.Sources[i].(OffsetSource); ok && (p.CheckpointTokens > 0 || checkpoint.Partial != nil) {
+			readErr = p.streamSource(ctx, builder, i, source, checkpoint.Partial, filters)
+			checkpoint.Partial = nil
+		} else {
+			var tokens []string
+			if tokens, readErr = drainSourceContext(ctx, p.Sources[i]); readErr == nil {
+				readErr = builder.Train(tokens)
+			}
+		}
+		if readErr != nil {
+			closeSources(p.Sources[i+1:])
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, &SourceError{SourceIndex: i, Err: readErr}
+		}
+
+		if p.Checkpoints != nil && ((i+1)%every == 0 || i+1 == len(p.Sources)) {
+			if saveErr := p.saveCheckpoint(builder, i+1, nil); saveErr != nil {
+				closeSources(p.Sources[i+1:])
+				return nil, saveErr
+			}
+		}
+	}
+
+	built, finishErr := builder.Finish()
+	if finishErr != nil {
+		return nil, finishErr
+	}
+	chain := built.(WritableChain)
+	for _, process := range p.PostProcess {
+		if processErr := process(chain); processErr != nil {
+			return nil, processErr
+		}
+	}
+
+	if p.Destination != nil {
+		if writeErr := WriteChain(p.Destination, chain, p.Encode); writeErr != nil {
+			return nil, writeErr
+		}
+	}
+	return chain, nil
+}
+
+// streamSource adds an OffsetSource to the builder as it is read, resuming
+// from partial if set and checkpointing every CheckpointTokens tokens
+func (p *Pipeline) streamSource(ctx context.Context, builder *ChainBuilder, index int, source OffsetSource, partial *partialCheckpoint, filters []SourceFilter) error {
+	last, length := "", 0
+	if partial != nil {
+		if resumeErr := source.Resume(partial.Offset); resumeErr != nil {
+			closeSource(source)
+			return resumeErr
+		}
+		last, length = partial.Last, partial.Length
+	}
+
+	builder.streamSequence(last, length)
+	for read := 1; ; read++ {
+		token, readErr := nextTokenContext(ctx, source)
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			closeSource(source)
+			return readErr
+		}
+		filtered, filterErr := filterToken(token, filters)
+		if filterErr != nil {
+			closeSource(source)
+			return filterErr
+		}
+		builder.streamTokens(filtered)
+
+		if p.Checkpoints != nil && p.CheckpointTokens > 0 && read%p.CheckpointTokens == 0 {
+			last, length := builder.streamPosition()
+			position := &partialCheckpoint{Offset: source.Offset(), Last: last, Length: length}
+			if saveErr := p.saveCheckpoint(builder, index, position); saveErr != nil {
+				closeSource(source)
+				return saveErr
+			}
+		}
+	}
+	builder.endStream()
+	return closeSource(source)
+}
+
+// DryRunSample is a token read by a dry run and the tokens the pipeline's
+// filters turned it into, which is empty if it was dropped
+type DryRunSample struct {
+	Before string   `json:"before"`
+	After  []string `json:"after"`
+}
+
+// DryRunReport summarizes how a pipeline's filters treat a sample of its
+// tokens
+type DryRunReport struct {
+	Samples []DryRunSample `json:"samples"`
+
+	// TokensIn and TokensOut count the tokens before and after filtering
+	TokensIn  int `json:"tokens_in"`
+	TokensOut int `json:"tokens_out"`
+
+	// Dropped counts the sampled tokens the filters removed entirely
+	Dropped int `json:"dropped"`
+
+	// VocabularyBefore and Vocabulary count the distinct tokens before and
+	// after filtering. Unless capped by MaxVocabulary, the vocabulary of
+	// the built chain is at least Vocabulary
+	VocabularyBefore int `json:"vocabulary_before"`
+	Vocabulary       int `json:"vocabulary"`
+}
+
+// filterToken passes a single token through filters in order
+func filterToken(token string, filters []SourceFilter) ([]string, error) {
+	tokens := []string{token}
+	for _, filter := range filters {
+		next := make([]string, 0, len(tokens))
+		for _, candidate := range tokens {
+			filtered, filterErr := filter.FilterToken(candidate)
+			if filterErr != nil {
+				return nil, &FilterError{Token: candidate, Err: filterErr}
+			}
+			next = append(next, filtered...)
+		}
+		tokens = next
+	}
+	return tokens, nil
+}
+
+// DryRun passes up to n tokens from the pipeline's sources, read in order,
+// through the filters Run would apply, including any set through Options,
+// and reports the result, so a cleaning configuration can be checked before
+// a long build. The sources are consumed and closed, so they must be
+// recreated before the pipeline is run. An error is returned if n is
+// negative
+func (p *Pipeline) DryRun(ctx context.Context, n int) (DryRunReport, error) {
+	defer closeSources(p.Sources)
+	if n < 0 {
+		return DryRunReport{}, fmt.Errorf("chain: dry run of %d tokens", n)
+	}
+
+	filters := applyBuildOptions(p.buildOptions()).Filters
+	// samples aren't sized by n, which may be far more than the sources hold
+	report := DryRunReport{Samples: make([]DryRunSample, 0)}
+	before := make(map[string]bool)
+	after := make(map[string]bool)
+	for i, source := range p.Sources {
+		for report.TokensIn < n {
+			token, readErr := nextTokenContext(ctx, source)
+			if readErr == io.EOF {
+				break
+			} else if readErr != nil {
+				return report, &SourceError{SourceIndex: i, Err: readErr}
+			}
+
+			filtered, filterErr := filterToken(token, filters)
+			if filterErr != nil {
+				return report, &SourceError{SourceIndex: i, Err: filterErr}
+			}
+			report.Samples = append(report.Samples, DryRunSample{Before: token, After: filtered})
+			report.TokensIn++
+			report.TokensOut += len(filtered)
+			if len(filtered) == 0 {
+				report.Dropped++
+			}
+			before[token] = true
+			for _, v := range filtered {
+				after[v] = true
+			}
+		}
+	}
+
+	report.VocabularyBefore = len(before)
+	report.Vocabulary = len(after)
+	return report, nil
+}
@@ -0,0 +1,120 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestPipelineDryRunFilters(t *testing.T) {
+	newPipeline := func() *Pipeline {
+		return &Pipeline{
+			Sources: []TokenSource{NewSliceSource(strings.Fields("The CAT sat"))},
+			Filters: []SourceFilter{StopWordFilter("the")},
+			Options: []BuildOption{WithFilters(LowercaseFilter())},
+		}
+	}
+
+	report, dryErr := newPipeline().DryRun(context.Background(), 10)
+	if dryErr != nil {
+		t.Fatal(dryErr)
+	}
+	built, runErr := newPipeline().Run(context.Background())
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+
+	// the dry run reports exactly the tokens the build trains on
+	trained := make([]string, 0)
+	for _, sample := range report.Samples {
+		trained = append(trained, sample.After...)
+	}
+	if strings.Join(trained, " ") != "cat sat" || report.Dropped != 1 {
+		t.Fatalf("dry run produced %q", trained)
+	}
+	if _, ok := built.RetrieveMarkovLink("cat"); !ok {
+		t.Fatal("the build should have trained on lowercased tokens")
+	}
+
+	if _, dryErr := newPipeline().DryRun(context.Background(), -1); dryErr == nil {
+		t.Fatal("dry run of a negative number of tokens")
+	}
+	report, dryErr = newPipeline().DryRun(context.Background(), math.MaxInt32)
+	if dryErr != nil {
+		t.Fatal(dryErr)
+	}
+	if report.TokensIn != 3 || cap(report.Samples) >= math.MaxInt32 {
+		t.Fatalf("dry run of more tokens than the sources hold read %d tokens", report.TokensIn)
+	}
+}
+
+// failingSource fails on its first read
+type failingSource struct{}
+
+func (failingSource) NextToken() (string, error) {
+	return "", errors.New("unavailable")
+}
+
+func TestPipelineResumeAfterInterrupt(t *testing.T) {
+	store := MakeDirectoryStore(t.TempDir())
+	corpus := [][]string{
+		strings.Fields("the cat sat on the mat and the cat ran"),
+		strings.Fields("a dog sat on the cat"),
+	}
+
+	// the second source fails, interrupting the run after the first
+	interrupted := &Pipeline{
+		Sources:       []TokenSource{NewSliceSource(corpus[0]), failingSource{}},
+		Checkpoints:   store,
+		CheckpointKey: "job",
+	}
+	if _, runErr := interrupted.Run(context.Background()); runErr == nil {
+		t.Fatal("the interrupted run should fail")
+	}
+
+	// the first source is never read again, so it may now fail too
+	resumed := &Pipeline{
+		Sources:       []TokenSource{failingSource{}, NewSliceSource(corpus[1])},
+		Checkpoints:   store,
+		CheckpointKey: "job",
+	}
+	built, runErr := resumed.Run(context.Background())
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	assertSameChain(t, testChain(t), built)
+}
+
+func TestPipelineCheckpointSurvivesInterruptedWrite(t *testing.T) {
+	store := MakeDirectoryStore(t.TempDir())
+	first := &Pipeline{
+		Sources:       []TokenSource{NewSliceSource(strings.Fields("the cat sat on the mat and the cat ran")), NewSliceSource(strings.Fields("a dog sat on the cat"))},
+		Checkpoints:   store,
+		CheckpointKey: "job",
+	}
+	if _, runErr := first.Run(context.Background()); runErr != nil {
+		t.Fatal(runErr)
+	}
+
+	// a checkpoint being written when the process dies is never closed
+	writer, createErr := store.CreateChain("job")
+	if createErr != nil {
+		t.Fatal(createErr)
+	}
+	if _, writeErr := writer.Write([]byte(`{"completed": 1, "cha`)); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+
+	resumed := &Pipeline{
+		Sources:       []TokenSource{failingSource{}, failingSource{}},
+		Checkpoints:   store,
+		CheckpointKey: "job",
+	}
+	built, runErr := resumed.Run(context.Background())
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	assertSameChain(t, testChain(t), built)
+}
@@ -0,0 +1,272 @@
+package chain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Tokenizer splits text into a TokenSource
+type Tokenizer func(r io.Reader) TokenSource
+
+// FilterConstructor creates a filter from the argument following the colon
+// of its name in a pipeline, e.g. "en" in "stopwords:en", which is empty if
+// there was none
+type FilterConstructor func(arg string) (SourceFilter, error)
+
+// TokenizerConstructor creates a tokenizer from the argument following the
+// colon of its name in a pipeline, which is empty if there was none
+type TokenizerConstructor func(arg string) (Tokenizer, error)
+
+// noArgument wraps a constructor for a component that takes no argument
+func noArgument(name string, constructor func() SourceFilter) FilterConstructor {
+	return func(arg string) (SourceFilter, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("chain: filter %q takes no argument", name)
+		}
+		return constructor(), nil
+	}
+}
+
+// fixedTokenizer creates a constructor for a tokenizer that takes no
+// argument
+func fixedTokenizer(name string, tokenizer Tokenizer) TokenizerConstructor {
+	return func(arg string) (Tokenizer, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("chain: tokenizer %q takes no argument", name)
+		}
+		return tokenizer, nil
+	}
+}
+
+var emojiModes = map[string]EmojiMode{
+	"":          EmojiStrip,
+	"strip":     EmojiStrip,
+	"separate":  EmojiSeparate,
+	"shortcode": EmojiShortcode,
+}
+
+var punctuationModes = map[string]PunctuationMode{
+	"":         PunctuationStrip,
+	"strip":    PunctuationStrip,
+	"separate": PunctuationSeparate,
+}
+
+var (
+	pipelineTex    sync.RWMutex
+	filterRegistry = map[string]FilterConstructor{
+		"trim":      noArgument("trim", TrimFilter),
+		"lowercase": noArgument("lowercase", LowercaseFilter),
+		"prefix": func(arg string) (SourceFilter, error) {
+			if arg == "" {
+				return nil, fmt.Errorf("chain: filter \"prefix\" requires a prefix")
+			}
+			return PrefixFilter(arg, true), nil
+		},
+		"stopwords": func(arg string) (SourceFilter, error) {
+			if arg == "" || arg == "en" {
+				return StopWordFilter(englishStopWords...), nil
+			}
+			words, ok := latinStopWords[arg]
+			if !ok {
+				return nil, fmt.Errorf("%w: stop words for language %q", ErrUnknownName, arg)
+			}
+			return StopWordFilter(words...), nil
+		},
+		"split": func(arg string) (SourceFilter, error) {
+			if arg == "" {
+				return nil, fmt.Errorf("chain: filter \"split\" requires a pattern")
+			}
+			return RegexSplitFilter(arg)
+		},
+		"remove": func(arg string) (SourceFilter, error) {
+			if arg == "" {
+				return nil, fmt.Errorf("chain: filter \"remove\" requires a pattern")
+			}
+			return RegexRemoveFilter(arg)
+		},
+		"emoji": func(arg string) (SourceFilter, error) {
+			mode, ok := emojiModes[arg]
+			if !ok {
+				return nil, fmt.Errorf("%w: emoji mode %q", ErrUnknownName, arg)
+			}
+			return EmojiFilter(mode, nil), nil
+		},
+		"punctuation": func(arg string) (SourceFilter, error) {
+			mode, ok := punctuationModes[arg]
+			if !ok {
+				return nil, fmt.Errorf("%w: punctuation mode %q", ErrUnknownName, arg)
+			}
+			return PunctuationFilter(mode), nil
+		},
+		"language": func(arg string) (SourceFilter, error) {
+			if arg == "" {
+				return nil, fmt.Errorf("chain: filter \"language\" requires allowed languages")
+			}
+			return LanguageFilter(DefaultLanguageDetector(), strings.Split(arg, ",")...), nil
+		},
+	}
+	tokenizerRegistry = map[string]TokenizerConstructor{
+		"words":     fixedTokenizer("words", WordSource),
+		"lines":     fixedTokenizer("lines", LineSource),
+		"sentences": fixedTokenizer("sentences", SentenceSource),
+		"runes":     fixedTokenizer("runes", RuneSource),
+	}
+)
+
+// RegisterFilter makes a filter available to pipelines under the specified
+// name, which must not contain a colon
+func RegisterFilter(name string, constructor FilterConstructor) {
+	pipelineTex.Lock()
+	defer pipelineTex.Unlock()
+	filterRegistry[name] = constructor
+}
+
+// RegisterTokenizer makes a tokenizer available to pipelines under the
+// specified name, which must not contain a colon
+func RegisterTokenizer(name string, constructor TokenizerConstructor) {
+	pipelineTex.Lock()
+	defer pipelineTex.Unlock()
+	tokenizerRegistry[name] = constructor
+}
+
+// splitSpec splits a component such as "stopwords:en" into its name and
+// argument
+func splitSpec(spec string) (string, string) {
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		return spec[:i], spec[i+1:]
+	}
+	return spec, ""
+}
+
+// LookupFilter creates a filter from its name, optionally followed by a
+// colon and an argument, e.g. "stopwords:en"
+func LookupFilter(spec string) (SourceFilter, error) {
+	name, arg := splitSpec(spec)
+	pipelineTex.RLock()
+	constructor, ok := filterRegistry[name]
+	pipelineTex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: filter %q", ErrUnknownName, name)
+	}
+	return constructor(arg)
+}
+
+// LookupTokenizer creates a tokenizer from its name, optionally followed by
+// a colon and an argument
+func LookupTokenizer(spec string) (Tokenizer, error) {
+	name, arg := splitSpec(spec)
+	pipelineTex.RLock()
+	constructor, ok := tokenizerRegistry[name]
+	pipelineTex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: tokenizer %q", ErrUnknownName, name)
+	}
+	return constructor(arg)
+}
+
+// Filters retrieves the names of every registered filter in sorted order
+func Filters() []string {
+	pipelineTex.RLock()
+	defer pipelineTex.RUnlock()
+
+	names := make([]string, 0, len(filterRegistry))
+	for name := range filterRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tokenizers retrieves the names of every registered tokenizer in sorted
+// order
+func Tokenizers() []string {
+	pipelineTex.RLock()
+	defer pipelineTex.RUnlock()
+
+	names := make([]string, 0, len(tokenizerRegistry))
+	for name := range tokenizerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultTokenizer is the tokenizer used by a PipelineConfig that doesn't
+// name one
+const DefaultTokenizer = "words"
+
+// PipelineConfig declares how training text is turned into tokens by
+// naming registered components, e.g.
+//
+//	{"tokenizer": "words", "filters": ["trim", "lowercase", "stopwords:en"]}
+type PipelineConfig struct {
+	// Tokenizer names the tokenizer splitting text, defaults to
+	// DefaultTokenizer
+	Tokenizer string `json:"tokenizer,omitempty"`
+
+	// Filters name the filters applied to tokens, in order
+	Filters []string `json:"filters,omitempty"`
+}
+
+// LoadPipelineConfig decodes a JSON PipelineConfig, checking that every
+// component it names is registered
+func LoadPipelineConfig(r io.Reader) (PipelineConfig, error) {
+	config := PipelineConfig{}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if decodeErr := decoder.Decode(&config); decodeErr != nil {
+		return config, decodeErr
+	}
+	if _, tokenizerErr := config.BuildTokenizer(); tokenizerErr != nil {
+		return config, tokenizerErr
+	}
+	if _, filtersErr := config.BuildFilters(); filtersErr != nil {
+		return config, filtersErr
+	}
+	return config, nil
+}
+
+// BuildTokenizer creates the tokenizer named by the config
+func (c PipelineConfig) BuildTokenizer() (Tokenizer, error) {
+	if c.Tokenizer == "" {
+		return LookupTokenizer(DefaultTokenizer)
+	}
+	return LookupTokenizer(c.Tokenizer)
+}
+
+// BuildFilters creates the filters named by the config, e.g. to pass to
+// WithFilters
+func (c PipelineConfig) BuildFilters() ([]SourceFilter, error) {
+	filters := make([]SourceFilter, 0, len(c.Filters))
+	for _, spec := range c.Filters {
+		filter, lookupErr := LookupFilter(spec)
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// Sources tokenizes each reader and applies the config's filters, giving
+// one TokenSource per reader
+func (c PipelineConfig) Sources(readers ...io.Reader) ([]TokenSource, error) {
+	tokenizer, tokenizerErr := c.BuildTokenizer()
+	if tokenizerErr != nil {
+		return nil, tokenizerErr
+	}
+	filters, filtersErr := c.BuildFilters()
+	if filtersErr != nil {
+		return nil, filtersErr
+	}
+
+	sources := make([]TokenSource, 0, len(readers))
+	for _, r := range readers {
+		sources = append(sources, ApplyFiltersToSource(tokenizer(r), filters...))
+	}
+	return sources, nil
+}
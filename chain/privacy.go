@@ -0,0 +1,139 @@
+package chain
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// PrivacyOptions configures Privatize
+type PrivacyOptions struct {
+	// Epsilon is the privacy budget, smaller values add more noise and give
+	// a stronger guarantee
+	Epsilon float64
+
+	// Sensitivity is the most any one contributor can change the counts of
+	// the chain by in total, e.g. the number of transitions in their
+	// longest sequence if each contributed one. Defaults to 1. The
+	// guarantee only holds if contributions were bounded while training
+	Sensitivity float64
+
+	// Delta is the probability the guarantee may fail, by releasing a
+	// transition only one contributor made, it must be below one and
+	// defaults to DefaultPrivacyDelta
+	Delta float64
+
+	// Threshold drops transitions whose noisy count is below it, so rare
+	// transitions that could identify a contributor aren't released. It
+	// defaults to, and can't be below, the threshold Delta requires, see
+	// MinPrivacyThreshold
+	Threshold int
+}
+
+// DefaultPrivacyDelta is the Delta used if PrivacyOptions.Delta is zero
+const DefaultPrivacyDelta = 1e-6
+
+// MinPrivacyThreshold is the lowest threshold for which a transition made
+// only by one contributor, whose count is at most opts.Sensitivity, is
+// released with probability at most opts.Delta. The Laplace noise exceeds
+// scale·ln(1/2δ) with probability δ, and noisy counts are rounded
+func MinPrivacyThreshold(opts PrivacyOptions) (int, error) {
+	if opts.Epsilon <= 0 {
+		return 0, fmt.Errorf("chain: privacy epsilon must be positive")
+	}
+	delta := opts.Delta
+	if delta == 0 {
+		delta = DefaultPrivacyDelta
+	}
+	if delta < 0 || delta >= 1 {
+		return 0, fmt.Errorf("chain: privacy delta must be between zero and one, got %v", delta)
+	}
+	sensitivity := opts.Sensitivity
+	if sensitivity <= 0 {
+		sensitivity = 1
+	}
+	scale := sensitivity / opts.Epsilon
+
+	return int(math.Ceil(sensitivity + 0.5 + scale*math.Log(1/(2*delta)))), nil
+}
+
+// laplace samples Laplace noise with the given scale
+func laplace(scale float64, rand *rand.Rand) float64 {
+	u := rand.Float64() - 0.5
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
+
+// Privatize creates a copy of a chain with Laplace noise of scale
+// opts.Sensitivity / opts.Epsilon added to every count and transitions
+// below opts.Threshold dropped, so a chain trained on user data can be
+// shared with an (ε,δ) differential privacy guarantee, ε being
+// opts.Epsilon and δ opts.Delta. Only transitions present in the chain are
+// released, so the threshold must be high enough that a transition only
+// one contributor made survives with probability at most δ, a Threshold
+// below MinPrivacyThreshold is rejected. The copy of a MultiKeyChain is of
+// the same order
+func Privatize(chain MarkovChain, opts PrivacyOptions, rand *rand.Rand) (WritableChain, error) {
+	minThreshold, thresholdErr := MinPrivacyThreshold(opts)
+	if thresholdErr != nil {
+		return nil, thresholdErr
+	}
+	threshold := opts.Threshold
+	if threshold == 0 {
+		threshold = minThreshold
+	} else if threshold < minThreshold {
+		return nil, fmt.Errorf("chain: privacy threshold %d is below the minimum of %d for delta", threshold, minThreshold)
+	}
+	iterable, ok := chain.(IterableChain)
+	if !ok {
+		return nil, ErrUncountableChain
+	}
+	sensitivity := opts.Sensitivity
+	if sensitivity <= 0 {
+		sensitivity = 1
+	}
+	scale := sensitivity / opts.Epsilon
+
+	transitions := make([]Transition, 0)
+	collectErr := forEachTransition(iterable, func(t Transition) error {
+		transitions = append(transitions, t)
+		return nil
+	})
+	if collectErr != nil {
+		return nil, collectErr
+	}
+	// sort transitions so the noise is reproducible for a given rand
+	sort.Slice(transitions, func(i, j int) bool {
+		if transitions[i].Prev != transitions[j].Prev {
+			return transitions[i].Prev < transitions[j].Prev
+		}
+		return transitions[i].Next < transitions[j].Next
+	})
+
+	private := newSingleKeyChain()
+	for _, t := range transitions {
+		noisy := int(math.Floor(float64(t.Count) + laplace(scale, rand) + 0.5))
+		if noisy >= threshold {
+			private.increment(t.Prev, t.Next, noisy)
+		}
+	}
+
+	if order := chainOrder(chain); order > 1 {
+		return &MultiKeyChain{order: order, chain: private}, nil
+	}
+	return private, nil
+}
+
+// WritePrivateChain privatizes a chain and encodes the result as WriteChain
+// does
+func WritePrivateChain(w io.Writer, chain MarkovChain, opts PrivacyOptions, rand *rand.Rand, encodeOpts EncodeOptions) error {
+	private, privatizeErr := Privatize(chain, opts, rand)
+	if privatizeErr != nil {
+		return privatizeErr
+	}
+	return WriteChain(w, private, encodeOpts)
+}
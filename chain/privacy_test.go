@@ -0,0 +1,51 @@
+package chain
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPrivatizeDropsRareTransitions(t *testing.T) {
+	chain := NewWritableChain()
+	chain.Increment("", "common", 1000)
+	chain.Increment("", "rare", 1)
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		private, privatizeErr := Privatize(chain, PrivacyOptions{Epsilon: 1}, r)
+		if privatizeErr != nil {
+			t.Fatal(privatizeErr)
+		}
+		link, ok := private.RetrieveMarkovLink("")
+		if !ok {
+			t.Fatal("common transition dropped")
+		}
+		if _, ok := link.GetProbabilityOfToken("rare"); ok {
+			t.Fatalf("transition seen once released on attempt %d", i)
+		}
+	}
+}
+
+func TestPrivatizeRejectsLowThresholds(t *testing.T) {
+	chain := NewWritableChain()
+	chain.Increment("", "a", 10)
+
+	minThreshold, thresholdErr := MinPrivacyThreshold(PrivacyOptions{Epsilon: 1, Delta: 1e-5})
+	if thresholdErr != nil {
+		t.Fatal(thresholdErr)
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, opts := range []PrivacyOptions{
+		{Epsilon: 1, Delta: 1e-5, Threshold: minThreshold - 1},
+		{Epsilon: 1, Delta: 1},
+		{Epsilon: 1, Delta: -0.5},
+		{Delta: 1e-5},
+	} {
+		if _, privatizeErr := Privatize(chain, opts, r); privatizeErr == nil {
+			t.Fatalf("privatized with %+v", opts)
+		}
+	}
+	if _, privatizeErr := Privatize(chain, PrivacyOptions{Epsilon: 1, Delta: 1e-5, Threshold: minThreshold}, r); privatizeErr != nil {
+		t.Fatal(privatizeErr)
+	}
+}
@@ -0,0 +1,61 @@
+package chain
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PunctuationMode selects how a PunctuationFilter treats punctuation
+type PunctuationMode int
+
+const (
+	// PunctuationStrip removes leading and trailing punctuation from tokens
+	PunctuationStrip PunctuationMode = iota
+	// PunctuationSeparate splits leading and trailing punctuation into
+	// standalone tokens, so it can be modeled explicitly
+	PunctuationSeparate
+)
+
+// punctuationRuns splits punctuation into runs of the same rune, so "?!"
+// becomes "?" and "!" while "..." stays whole
+func punctuationRuns(punctuation string) []string {
+	runs := make([]string, 0, 1)
+	for len(punctuation) > 0 {
+		r, width := utf8.DecodeRuneInString(punctuation)
+		end := width
+		for end < len(punctuation) && strings.HasPrefix(punctuation[end:], string(r)) {
+			end += width
+		}
+		runs = append(runs, punctuation[:end])
+		punctuation = punctuation[end:]
+	}
+	return runs
+}
+
+// PunctuationFilter filters a TokenSource by separating leading and trailing
+// punctuation from the words it is attached to, so "dog." and "dog" share a
+// key, then stripping it or keeping it as standalone tokens depending on
+// mode. Punctuation within a word, such as in "don't" or "well-known", is
+// kept, and tokens consisting only of punctuation are stripped or split
+func PunctuationFilter(mode PunctuationMode) SourceFilter {
+	return MakeFuncFilter(func(candidate string) ([]string, error) {
+		word := strings.TrimLeftFunc(candidate, unicode.IsPunct)
+		leading := candidate[:len(candidate)-len(word)]
+		word = strings.TrimRightFunc(word, unicode.IsPunct)
+		trailing := candidate[len(leading)+len(word):]
+
+		if mode != PunctuationSeparate {
+			if word == "" {
+				return []string{}, nil
+			}
+			return []string{word}, nil
+		}
+
+		tokens := punctuationRuns(leading)
+		if word != "" {
+			tokens = append(tokens, word)
+		}
+		return append(tokens, punctuationRuns(trailing)...), nil
+	})
+}
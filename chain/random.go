@@ -0,0 +1,74 @@
+package chain
+
+import (
+	"math/rand"
+	"strconv"
+)
+
+// maxRandomCount bounds the counts of the transitions Random creates
+const maxRandomCount = 10
+
+// Random creates a synthetic chain of states tokens named "s0", "s1" and so
+// on. The start of sequence and every state have branching successors
+// chosen uniformly, one of which is always the end of sequence token so
+// every walk can terminate. Counts are between 1 and 10. The chain is
+// reproducible for a given rand, making it suitable for tests and
+// benchmarks
+func Random(states int, branching int, rand *rand.Rand) WritableChain {
+	chain := NewWritableChain()
+	if states <= 0 || branching <= 0 {
+		return chain
+	}
+
+	tokens := make([]string, states)
+	for i := range tokens {
+		tokens[i] = "s" + strconv.Itoa(i)
+	}
+	successors := branching
+	if successors > states {
+		successors = states
+	}
+
+	// the start of sequence links to states but never directly to the end
+	for _, i := range rand.Perm(states)[:successors] {
+		chain.Increment("", tokens[i], 1+rand.Intn(maxRandomCount))
+	}
+	for _, token := range tokens {
+		chain.Increment(token, "", 1+rand.Intn(maxRandomCount))
+		for _, i := range rand.Perm(states)[:successors-1] {
+			chain.Increment(token, tokens[i], 1+rand.Intn(maxRandomCount))
+		}
+	}
+
+	return chain
+}
+
+// SynthesizeCorpus generates sequences from a chain, each of at most
+// maxTokens tokens, e.g. to produce a training corpus of known statistics
+func SynthesizeCorpus(chain MarkovChain, sequences int, maxTokens int, rand *rand.Rand) ([][]string, error) {
+	corpus := make([][]string, 0, sequences)
+	for i := 0; i < sequences; i++ {
+		sequence, generateErr := Generate(chain, rand, GenerateOptions{MaxTokens: maxTokens})
+		if generateErr != nil {
+			return corpus, generateErr
+		}
+		corpus = append(corpus, sequence)
+	}
+
+	return corpus, nil
+}
+
+// SynthesizeSources generates sequences as SynthesizeCorpus does, providing
+// each as a TokenSource for building chains
+func SynthesizeSources(chain MarkovChain, sequences int, maxTokens int, rand *rand.Rand) ([]TokenSource, error) {
+	corpus, synthesizeErr := SynthesizeCorpus(chain, sequences, maxTokens, rand)
+	if synthesizeErr != nil {
+		return nil, synthesizeErr
+	}
+
+	sources := make([]TokenSource, 0, len(corpus))
+	for _, sequence := range corpus {
+		sources = append(sources, NewSliceSource(sequence))
+	}
+	return sources, nil
+}
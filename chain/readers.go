@@ -0,0 +1,117 @@
+package chain
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// readerSource reads tokens split from a reader, closing the reader when
+// it is closed if it is an io.Closer
+type readerSource struct {
+	bufioScannerSource
+	r io.Reader
+}
+
+func newReaderSource(r io.Reader, split bufio.SplitFunc) *readerSource {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(split)
+	return &readerSource{bufioScannerSource: bufioScannerSource{src: scanner}, r: r}
+}
+
+func (s *readerSource) Close() error {
+	if closer, ok := s.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// WordSource reads the whitespace separated words of r
+func WordSource(r io.Reader) TokenSource {
+	return newReaderSource(r, bufio.ScanWords)
+}
+
+// LineSource reads the lines of r, without their line endings
+func LineSource(r io.Reader) TokenSource {
+	return newReaderSource(r, bufio.ScanLines)
+}
+
+// RuneSource reads the individual runes of r, including whitespace, for
+// character level chains
+func RuneSource(r io.Reader) TokenSource {
+	return newReaderSource(r, bufio.ScanRunes)
+}
+
+// SentenceSource reads the sentences of r, which end in terminal
+// punctuation followed by whitespace or the end of the text. Whitespace
+// within a sentence, including line breaks, is collapsed to single spaces
+func SentenceSource(r io.Reader) TokenSource {
+	return newReaderSource(r, scanSentences)
+}
+
+// isSentenceTerminal reports whether r is punctuation that ends a sentence
+func isSentenceTerminal(r rune) bool {
+	return r == '.' || r == '!' || r == '?' || r == '…'
+}
+
+// sentenceClosers may follow terminal punctuation within the sentence
+const sentenceClosers = "\"')]}’”»"
+
+// scanSentences is a bufio.SplitFunc splitting text into sentences
+func scanSentences(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	for start < len(data) {
+		r, width := utf8.DecodeRune(data[start:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		start += width
+	}
+
+	for i := start; i < len(data); {
+		if !atEOF && !utf8.FullRune(data[i:]) {
+			return start, nil, nil
+		}
+		r, width := utf8.DecodeRune(data[i:])
+		i += width
+		if !isSentenceTerminal(r) {
+			continue
+		}
+
+		// runs of terminal punctuation and closing quotes end the sentence
+		for i < len(data) {
+			if !atEOF && !utf8.FullRune(data[i:]) {
+				return start, nil, nil
+			}
+			r, width = utf8.DecodeRune(data[i:])
+			if !isSentenceTerminal(r) && !strings.ContainsRune(sentenceClosers, r) {
+				break
+			}
+			i += width
+		}
+		if i == len(data) {
+			if !atEOF {
+				return start, nil, nil
+			}
+			return i, collapseSpace(data[start:i]), nil
+		}
+		if r, _ = utf8.DecodeRune(data[i:]); unicode.IsSpace(r) {
+			return i, collapseSpace(data[start:i]), nil
+		}
+	}
+
+	if atEOF && start < len(data) {
+		return len(data), collapseSpace(data[start:]), nil
+	}
+	if atEOF {
+		return len(data), nil, nil
+	}
+	return start, nil, nil
+}
+
+// collapseSpace replaces every run of whitespace with a single space
+func collapseSpace(text []byte) []byte {
+	return []byte(strings.Join(strings.Fields(string(text)), " "))
+}
@@ -0,0 +1,99 @@
+package chain
+
+import "regexp"
+
+var (
+	// EmailPattern matches tokens that look like email addresses
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+	// PhonePattern matches tokens that look like phone numbers, seven or more
+	// digits optionally grouped by spaces, dots, dashes or parentheses with
+	// an optional leading plus
+	PhonePattern = regexp.MustCompile(`\+?\(?[0-9]{1,4}\)?(?:[\s.\-]?[0-9]){6,}`)
+)
+
+// RedactOptions configures Redact
+type RedactOptions struct {
+	// Patterns are matched against every token, a token matching any of
+	// them anywhere is redacted. EmailPattern and PhonePattern cover common
+	// personal information
+	Patterns []*regexp.Regexp
+
+	// Mask replaces redacted tokens, merging their counts. If empty,
+	// redacted tokens are removed along with every transition to or from
+	// them
+	Mask string
+}
+
+// Redact removes or masks the tokens of a chain matching any of
+// opts.Patterns, both as keys and as successors, so a chain trained on
+// user data can be sanitized before it is shared. The tokens a
+// HistoryChain's keys join are each checked. It returns the number of
+// distinct tokens redacted. The change is made in a Transaction, so the
+// chain is left unchanged if it fails
+func Redact(chain WritableChain, opts RedactOptions) (int, error) {
+	redacted := make(map[string]bool)
+	check := func(token string) {
+		if token == "" || token == opts.Mask {
+			return
+		}
+		if _, seen := redacted[token]; seen {
+			return
+		}
+		redacted[token] = false
+		for _, pattern := range opts.Patterns {
+			if pattern.MatchString(token) {
+				redacted[token] = true
+				return
+			}
+		}
+	}
+	scanErr := forEachTransition(chain, func(t Transition) error {
+		for _, token := range keyTokens(chain, t.Prev) {
+			check(token)
+		}
+		check(t.Next)
+		return nil
+	})
+	if scanErr != nil {
+		return 0, scanErr
+	}
+
+	count := 0
+	for _, matched := range redacted {
+		if matched {
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	if opts.Mask != "" {
+		mask := func(token string) string {
+			if redacted[token] {
+				return opts.Mask
+			}
+			return token
+		}
+		return count, Update(chain, func(tx WritableChain) error {
+			return rewriteTokens(tx, keyRewrite(chain, mask), mask)
+		})
+	}
+	redactedKey := func(key string) bool {
+		for _, token := range keyTokens(chain, key) {
+			if redacted[token] {
+				return true
+			}
+		}
+		return false
+	}
+	return count, Update(chain, func(tx WritableChain) error {
+		return forEachTransition(tx, func(t Transition) error {
+			if redactedKey(t.Prev) || redacted[t.Next] {
+				return tx.RemoveSuccessor(t.Prev, t.Next)
+			}
+			return nil
+		})
+	})
+}
@@ -0,0 +1,100 @@
+package chain
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// replyPrefix marks the keys linking prompt tokens to the first token of
+// their replies, it can't appear in whitespace separated text
+const replyPrefix = "\x1e"
+
+func replyKey(token string) string {
+	return replyPrefix + token
+}
+
+// TrainPair trains a chain on a prompt and its response, e.g. consecutive
+// messages of a conversation, so Reply can answer similar prompts. Both are
+// added as ordinary sequences, and every distinct prompt token is linked to
+// the first token of the response through a reply key, which appears among
+// the chain's tokens but is never reached by Generate
+func TrainPair(chain WritableChain, prompt []string, response []string) error {
+	if addErr := addSequence(chain, prompt); addErr != nil {
+		return addErr
+	}
+	if addErr := addSequence(chain, response); addErr != nil {
+		return addErr
+	}
+	if len(response) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(prompt))
+	for _, token := range prompt {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		if incErr := chain.Increment(replyKey(token), response[0], 1); incErr != nil {
+			return incErr
+		}
+	}
+	return nil
+}
+
+// Reply generates a response to a prompt from a chain trained with
+// TrainPair. The first token is sampled from the responses that followed
+// prompts sharing tokens with this one, and the rest is generated from it
+// as Generate does; opts.Start is ignored. If no prompt token was seen in a
+// prompt the response is generated from the start of a sequence
+func Reply(chain MarkovChain, prompt []string, rand *rand.Rand, opts GenerateOptions) ([]string, error) {
+	counts := make(map[string]int)
+	seen := make(map[string]bool, len(prompt))
+	for _, token := range prompt {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+
+		link, ok := chain.RetrieveMarkovLink(replyKey(token))
+		if !ok {
+			continue
+		}
+		counted, ok := link.(CountedLink)
+		if !ok {
+			return nil, ErrUncountableChain
+		}
+		for _, next := range counted.RetrieveNextTokenPossibilities() {
+			count, _ := counted.GetOccurrencesOfToken(next)
+			counts[next] += count
+		}
+	}
+
+	opts.Start = ""
+	if len(counts) == 0 || opts.MaxTokens <= 0 {
+		return Generate(chain, rand, opts)
+	}
+
+	// sort candidates so sampling is reproducible for a given rand
+	candidates := make([]string, 0, len(counts))
+	total := 0
+	for next, count := range counts {
+		candidates = append(candidates, next)
+		total += count
+	}
+	sort.Strings(candidates)
+	first := candidates[len(candidates)-1]
+	goal := rand.Intn(total)
+	for _, next := range candidates {
+		goal -= counts[next]
+		if goal < 0 {
+			first = next
+			break
+		}
+	}
+
+	opts.Start = first
+	opts.MaxTokens--
+	rest, generateErr := Generate(chain, rand, opts)
+	return append([]string{first}, rest...), generateErr
+}
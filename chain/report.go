@@ -0,0 +1,144 @@
+package chain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BuildReport summarizes a completed build so training runs can be logged
+// and compared, it can be marshalled as JSON
+type BuildReport struct {
+	Sources        []SourceReport `json:"sources"`
+	TokensRead     int64          `json:"tokens_read"`
+	VocabularySize int            `json:"vocabulary_size"`
+	States         int            `json:"states"`
+	Duration       time.Duration  `json:"duration_ns"`
+	Warnings       []BuildWarning `json:"warnings,omitempty"`
+}
+
+// SourceReport summarizes what was read from one source
+type SourceReport struct {
+	Index int `json:"index"`
+	// TokensRead counts the tokens read after filtering
+	TokensRead int64 `json:"tokens_read"`
+	// FilterDrops counts, for each filter in order, the candidate tokens it
+	// dropped entirely
+	FilterDrops []int64 `json:"filter_drops,omitempty"`
+}
+
+// BuildWarning reports suspicious training data that didn't stop the build
+type BuildWarning struct {
+	// SourceIndex is the source the warning concerns, or -1 for the build
+	// as a whole
+	SourceIndex int         `json:"source"`
+	Kind        WarningKind `json:"kind"`
+	Message     string      `json:"message"`
+}
+
+func (w BuildWarning) String() string {
+	if w.SourceIndex < 0 {
+		return w.Message
+	}
+	return fmt.Sprintf("source %d: %s", w.SourceIndex, w.Message)
+}
+
+// reportBuilder collects a BuildReport from concurrent readers, a nil
+// builder discards everything
+type reportBuilder struct {
+	report    *BuildReport
+	start     time.Time
+	filters   int
+	reportTex sync.Mutex
+	sources   []SourceReport
+}
+
+func newReportBuilder(report *BuildReport, sources int, filters int) *reportBuilder {
+	if report == nil {
+		return nil
+	}
+
+	b := &reportBuilder{
+		report:  report,
+		start:   time.Now(),
+		filters: filters,
+		sources: make([]SourceReport, sources),
+	}
+	for i := range b.sources {
+		b.sources[i] = SourceReport{Index: i, FilterDrops: make([]int64, filters)}
+	}
+	return b
+}
+
+// countingFilter counts the candidates a filter drops for a report
+type countingFilter struct {
+	filter  SourceFilter
+	builder *reportBuilder
+	source  int
+	index   int
+}
+
+func (f *countingFilter) FilterToken(candidate string) ([]string, error) {
+	tokens, err := f.filter.FilterToken(candidate)
+	if err == nil && len(tokens) == 0 {
+		f.builder.reportTex.Lock()
+		f.builder.sources[f.source].FilterDrops[f.index]++
+		f.builder.reportTex.Unlock()
+	}
+	return tokens, err
+}
+
+// wrapFilters wraps the filters applied to a source so their drops are
+// counted
+func (b *reportBuilder) wrapFilters(source int, filters []SourceFilter) []SourceFilter {
+	if b == nil {
+		return filters
+	}
+
+	wrapped := make([]SourceFilter, 0, len(filters))
+	for i, filter := range filters {
+		wrapped = append(wrapped, &countingFilter{filter: filter, builder: b, source: source, index: i})
+	}
+	return wrapped
+}
+
+func (b *reportBuilder) token(source int) {
+	if b == nil {
+		return
+	}
+
+	b.reportTex.Lock()
+	defer b.reportTex.Unlock()
+	b.sources[source].TokensRead++
+}
+
+// finish fills in the report once the chain is built
+func (b *reportBuilder) finish(chain *singleKeyChain, warnings []BuildWarning) {
+	if b == nil {
+		return
+	}
+
+	b.reportTex.Lock()
+	defer b.reportTex.Unlock()
+	report := BuildReport{
+		Sources:  b.sources,
+		States:   len(chain.Links),
+		Duration: time.Since(b.start),
+		Warnings: warnings,
+	}
+	for _, source := range b.sources {
+		report.TokensRead += source.TokensRead
+	}
+
+	vocabulary := make(map[string]bool)
+	for key, link := range chain.Links {
+		vocabulary[key] = true
+		for next := range link.NextTokenOccurrences {
+			vocabulary[next] = true
+		}
+	}
+	delete(vocabulary, "")
+	report.VocabularySize = len(vocabulary)
+
+	*b.report = report
+}
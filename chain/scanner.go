@@ -24,7 +24,15 @@ func (s *bufioScannerSource) NextToken() (string, error) {
 // BuildChainFromScanners is a convenience function for building a markov chain from scanners providing
 // tokens
 func BuildChainFromScanners(tokenSources ...*bufio.Scanner) (MarkovChain, error) {
-	return BuildChainFromSources(SourcesFromScanners(tokenSources...)...)
+	return BuildChainFromScannersWithOptions(BuildOptions{}, tokenSources...)
+}
+
+// BuildChainFromScannersWithOptions builds a Markov chain from scanners
+// providing tokens, applying the supplied options. A scanner's error fails
+// the build as a SourceError, and opts.EmptyTokens decides what happens to
+// empty tokens, e.g. EmptyTokenDrop for scanners splitting on separators
+func BuildChainFromScannersWithOptions(opts BuildOptions, tokenSources ...*bufio.Scanner) (MarkovChain, error) {
+	return BuildChainFromSourcesWithOptions(opts, SourcesFromScanners(tokenSources...)...)
 }
 
 // SourcesFromScanners converts scanners into token sources
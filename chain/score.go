@@ -0,0 +1,144 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// UnseenProbability is the probability assumed for transitions that never
+// occurred in training, so unseen transitions penalize rather than zero out
+// a score
+const UnseenProbability = 1e-6
+
+// SequenceScore describes how likely a chain is to produce a sequence
+type SequenceScore struct {
+	// LogProbability is the natural log of the product of the probabilities
+	// of each transition
+	LogProbability float64
+	// Transitions is the number of transitions scored
+	Transitions int
+	// Unseen is the number of transitions that never occurred in training
+	Unseen int
+}
+
+// Perplexity is the inverse geometric mean of the transition probabilities
+func (s SequenceScore) Perplexity() float64 {
+	if s.Transitions == 0 {
+		return 1
+	}
+	return math.Exp(-s.LogProbability / float64(s.Transitions))
+}
+
+// transitionProbability retrieves the probability of next following the
+// tokens of history, and whether the transition occurred in training
+func transitionProbability(chain MarkovChain, history []string, next string) (float64, bool) {
+	link, ok := retrieveLink(chain, history)
+	if !ok {
+		return 0, false
+	}
+
+	return link.GetProbabilityOfToken(next)
+}
+
+// Score scores the transitions between consecutive tokens. Include the empty
+// boundary token at either end to score sequence starts and ends. A chain
+// keyed on several tokens looks each transition up from the tokens before
+// it, back to the last boundary
+func Score(chain MarkovChain, tokens []string) SequenceScore {
+	score, _ := ScoreContext(context.Background(), chain, tokens)
+	return score
+}
+
+// ScoreContext behaves as Score, but stops once ctx is done, returning the
+// score of the transitions scored so far along with ctx.Err()
+func ScoreContext(ctx context.Context, chain MarkovChain, tokens []string) (SequenceScore, error) {
+	return scoreTransitions(ctx, chain, tokens, 1)
+}
+
+// scoreTransitions scores the transitions into tokens from position from
+// onwards, the tokens before it are only history
+func scoreTransitions(ctx context.Context, chain MarkovChain, tokens []string, from int) (SequenceScore, error) {
+	score := SequenceScore{}
+	// the history of each transition begins after the last boundary
+	start := 0
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i-1] == "" {
+			start = i
+		}
+		if i < from {
+			continue
+		}
+		if contextDone(ctx) {
+			return score, ctx.Err()
+		}
+
+		probability, seen := transitionProbability(chain, tokens[start:i], tokens[i])
+		if !seen || probability <= 0 {
+			probability = UnseenProbability
+			score.Unseen++
+		}
+
+		score.LogProbability += math.Log(probability)
+		score.Transitions++
+	}
+
+	return score, nil
+}
+
+// Correction is a candidate correction and its score in context
+type Correction struct {
+	Candidate string
+	Score     SequenceScore
+}
+
+// RerankCorrections ranks candidate corrections for the misspelled token at
+// position in sentence by how probable the chain finds each candidate given
+// its neighbouring tokens, most probable first. The transitions scored are
+// those the candidate takes part in, into it and out of it for as many
+// tokens as the chain's links are keyed on. An error is returned if
+// position isn't within sentence
+func RerankCorrections(chain MarkovChain, sentence []string, position int, candidates []string) ([]Correction, error) {
+	if position < 0 || position >= len(sentence) {
+		return nil, fmt.Errorf("chain: position %d is outside a sentence of %d tokens", position, len(sentence))
+	}
+
+	order := chainOrder(chain)
+	// the candidate's history, which is the start of a sequence if the
+	// sentence begins within it
+	var before []string
+	if position > order {
+		before = sentence[position-order : position]
+	} else {
+		before = append([]string{""}, sentence[:position]...)
+	}
+	var after []string
+	if position+1 < len(sentence) {
+		after = sentence[position+1:]
+	}
+
+	corrections := make([]Correction, 0, len(candidates))
+	for _, candidate := range candidates {
+		tokens := make([]string, 0, len(before)+order+2)
+		tokens = append(append(tokens, before...), candidate)
+		if len(after) > order {
+			tokens = append(tokens, after[:order]...)
+		} else {
+			tokens = append(append(tokens, after...), "")
+		}
+		score, _ := scoreTransitions(context.Background(), chain, tokens, len(before))
+		corrections = append(corrections, Correction{
+			Candidate: candidate,
+			Score:     score,
+		})
+	}
+
+	// stable so candidates that score equally keep the caller's ordering,
+	// which usually reflects edit distance
+	sort.SliceStable(corrections, func(i, j int) bool {
+		return corrections[i].Score.LogProbability > corrections[j].Score.LogProbability
+	})
+
+	return corrections, nil
+}
@@ -0,0 +1,26 @@
+package chain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRerankCorrectionsPosition(t *testing.T) {
+	chain := testChain(t)
+	sentence := strings.Fields("the cta sat")
+	for _, position := range []int{-2, -1, 3, 10} {
+		if _, rerankErr := RerankCorrections(chain, sentence, position, []string{"cat"}); rerankErr == nil {
+			t.Fatalf("reranked at position %d", position)
+		}
+	}
+
+	for _, position := range []int{0, 2} {
+		corrections, rerankErr := RerankCorrections(chain, sentence, position, []string{"cat", "dog"})
+		if rerankErr != nil {
+			t.Fatalf("position %d: %v", position, rerankErr)
+		}
+		if len(corrections) != 2 {
+			t.Fatalf("position %d: got %d corrections", position, len(corrections))
+		}
+	}
+}
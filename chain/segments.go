@@ -0,0 +1,66 @@
+package chain
+
+import (
+	"context"
+	"strings"
+)
+
+// SegmentBreak is a token sources can emit to end the current sequence and
+// start another, so a single source holding many sentences or documents
+// doesn't link the end of one to the start of the next, and generation
+// begins from genuine starts. Builders never add it to a chain
+const SegmentBreak = "\x1c"
+
+// SentenceEnd reports whether a token ends a sentence, i.e. ends in
+// terminal punctuation optionally followed by closing quotes or brackets
+func SentenceEnd(token string) bool {
+	trimmed := strings.TrimRight(token, "\"')]}’”»")
+	return strings.HasSuffix(trimmed, ".") || strings.HasSuffix(trimmed, "!") ||
+		strings.HasSuffix(trimmed, "?") || strings.HasSuffix(trimmed, "…")
+}
+
+type segmentedSource struct {
+	src      TokenSource
+	isEnd    func(token string) bool
+	breakDue bool
+}
+
+// SegmentedSource wraps a source so a SegmentBreak follows every token
+// isEnd reports as ending a segment, e.g. SentenceEnd to train on sentences
+func SegmentedSource(source TokenSource, isEnd func(token string) bool) TokenSource {
+	return &segmentedSource{src: source, isEnd: isEnd}
+}
+
+func (s *segmentedSource) NextToken() (string, error) {
+	return s.NextTokenContext(context.Background())
+}
+
+func (s *segmentedSource) NextTokenContext(ctx context.Context) (string, error) {
+	if s.breakDue {
+		s.breakDue = false
+		return SegmentBreak, nil
+	}
+
+	token, readErr := nextTokenContext(ctx, s.src)
+	if readErr == nil && s.isEnd(token) {
+		s.breakDue = true
+	}
+	return token, readErr
+}
+
+func (s *segmentedSource) Close() error {
+	return closeSource(s.src)
+}
+
+// splitSegments splits tokens into the sequences separated by SegmentBreak
+func splitSegments(tokens []string) [][]string {
+	segments := make([][]string, 0, 1)
+	start := 0
+	for i, token := range tokens {
+		if token == SegmentBreak {
+			segments = append(segments, tokens[start:i])
+			start = i + 1
+		}
+	}
+	return append(segments, tokens[start:])
+}
@@ -0,0 +1,109 @@
+package chain
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// assertSegmented fails if a chain trained on segmentTokens("a b", "c d")
+// learned a transition across the break
+func assertSegmented(t *testing.T, name string, chain MarkovChain) {
+	t.Helper()
+	if link, ok := chain.RetrieveMarkovLink("b"); !ok {
+		t.Errorf("%s: link of \"b\" missing", name)
+	} else if next := link.RetrieveNextTokenPossibilities(); len(next) != 1 || next[0] != "" {
+		t.Errorf("%s: learned \"b\" to %q across the break", name, next)
+	}
+	if link, ok := chain.RetrieveMarkovLink(""); !ok {
+		t.Errorf("%s: start link missing", name)
+	} else if _, starts := link.GetProbabilityOfToken("c"); !starts {
+		t.Errorf("%s: \"c\" doesn't start a sequence", name)
+	}
+}
+
+func TestIngestSplitsSegments(t *testing.T) {
+	tokens := segmentTokens("a b", "c d")
+
+	trained := NewWritableChain()
+	MakeTrainer(trained).Train(tokens)
+	assertSegmented(t, "Trainer", trained)
+	if lengths := trained.(LengthModel).SequenceLengths(); lengths.Total != 2 {
+		t.Errorf("Trainer: observed %d sequences, want 2", lengths.Total)
+	}
+
+	multi := NewMultiKeyChain(1)
+	MakeTrainer(multi).Train(tokens)
+	assertSegmented(t, "MultiKeyChain", multi)
+	if lengths := multi.SequenceLengths(); lengths.Total != 2 {
+		t.Errorf("MultiKeyChain: observed %d sequences, want 2", lengths.Total)
+	}
+
+	sketch := NewSketchChain(SketchOptions{})
+	sketch.Train(tokens)
+	assertSegmented(t, "SketchChain", sketch)
+
+	cased := NewCaseRestoringChain()
+	cased.AddSource(NewSliceSource(tokens))
+	assertSegmented(t, "CaseRestoringChain", cased)
+
+	namespaced := NewNamespacedChain()
+	namespaced.AddSource("ns", NewSliceSource(tokens))
+	exported, _ := namespaced.Export("ns")
+	assertSegmented(t, "NamespacedChain", exported)
+	if lengths := exported.(LengthModel).SequenceLengths(); lengths.Total != 2 {
+		t.Errorf("NamespacedChain: observed %d sequences, want 2", lengths.Total)
+	}
+
+	router := NewLanguageRouter(DefaultLanguageDetector())
+	language, kept, routeErr := router.AddSource(NewSliceSource(segmentTokens("the cat sat", "on the mat")))
+	if routeErr != nil || !kept {
+		t.Fatalf("router dropped the source, %v", routeErr)
+	}
+	routed, _ := router.Chain(language)
+	if link, _ := routed.RetrieveMarkovLink("sat"); link.RetrieveNextTokenPossibilities()[0] != "" {
+		t.Error("LanguageRouter: learned across the break")
+	}
+
+	tagged := NewTaggedChain()
+	tagged.AddSource(NewTaggedSliceSource([]TaggedToken{
+		{"a", "x"}, {"b", "x"}, {Surface: SegmentBreak}, {"c", "x"}, {"d", "x"},
+	}))
+	next := make(map[string]bool)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		token, _ := tagged.NextTagged(TaggedToken{"b", "x"}, "", r)
+		next[token.Surface] = true
+	}
+	if next["c"] || strings.Contains(strings.Join(tagged.chain.RetrieveTokens(), ""), SegmentBreak) {
+		t.Errorf("TaggedChain: learned across the break, followed by %v", next)
+	}
+
+	completer := NewAutocompleter(2)
+	completer.AddSource(NewSliceSource(tokens))
+	for _, suggestion := range completer.Autocomplete([]string{"b"}, 5) {
+		if suggestion.Token != "" {
+			t.Error("Autocompleter: learned across the break")
+		}
+	}
+}
+
+func TestIngestSkipsEmptySources(t *testing.T) {
+	cased := NewCaseRestoringChain()
+	cased.AddSource(NewSliceSource(nil))
+	cased.AddSource(NewSliceSource([]string{SegmentBreak}))
+	if _, ok := cased.RetrieveMarkovLink(""); ok {
+		t.Error("CaseRestoringChain: empty source recorded")
+	}
+
+	trained := NewWritableChain()
+	MakeTrainer(trained).Train([]string{SegmentBreak, SegmentBreak})
+	if !trained.IsEmpty() || len(trained.RetrieveTokens()) != 0 {
+		t.Error("Trainer: empty sequences recorded")
+	}
+
+	router := NewLanguageRouter(DefaultLanguageDetector())
+	if _, kept, _ := router.AddSource(NewSliceSource(nil)); kept || len(router.Languages()) != 0 {
+		t.Error("LanguageRouter: empty source kept")
+	}
+}
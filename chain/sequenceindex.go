@@ -0,0 +1,69 @@
+package chain
+
+import (
+	"hash"
+	"hash/fnv"
+	"sync"
+)
+
+// SequenceIndex retains a hash of every sequence seen in training, so
+// generated sequences that are verbatim copies of a whole training sequence
+// can be rejected. It stores 8 bytes per distinct sequence. A SequenceIndex
+// is safe for concurrent use
+type SequenceIndex struct {
+	indexTex sync.RWMutex
+	hashes   map[uint64]struct{}
+}
+
+// NewSequenceIndex creates an empty SequenceIndex
+func NewSequenceIndex() *SequenceIndex {
+	return &SequenceIndex{hashes: make(map[uint64]struct{})}
+}
+
+// Add indexes a sequence
+func (i *SequenceIndex) Add(tokens []string) {
+	i.add(hashTokens(tokens))
+}
+
+func (i *SequenceIndex) add(hash uint64) {
+	i.indexTex.Lock()
+	defer i.indexTex.Unlock()
+	i.hashes[hash] = struct{}{}
+}
+
+// Contains reports whether a sequence was seen in training
+func (i *SequenceIndex) Contains(tokens []string) bool {
+	hash := hashTokens(tokens)
+
+	i.indexTex.RLock()
+	defer i.indexTex.RUnlock()
+	_, ok := i.hashes[hash]
+	return ok
+}
+
+// Len retrieves the number of distinct sequences indexed
+func (i *SequenceIndex) Len() int {
+	i.indexTex.RLock()
+	defer i.indexTex.RUnlock()
+	return len(i.hashes)
+}
+
+// sequenceHasher hashes a sequence as it streams into a SequenceIndex,
+// matching hashTokens
+type sequenceHasher struct {
+	index *SequenceIndex
+	hash  hash.Hash64
+}
+
+func (i *SequenceIndex) newHasher() *sequenceHasher {
+	return &sequenceHasher{index: i, hash: fnv.New64a()}
+}
+
+func (h *sequenceHasher) add(token string) {
+	h.hash.Write([]byte(token))
+	h.hash.Write([]byte(keySeparator))
+}
+
+func (h *sequenceHasher) finish() {
+	h.index.add(h.hash.Sum64())
+}
@@ -0,0 +1,224 @@
+package chain
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// chainVersion is the version of the envelope written by WriteChain,
+// chains written by a later version are rejected rather than misread
+const chainVersion = 1
+
+// Chain types recorded in the envelope, so chains of types other than the
+// single token chain can be detected on load
+const (
+	chainTypeSingle = "single"
+	chainTypeMulti  = "multi"
+)
+
+// gobMagic identifies a chain encoded with encoding/gob
+const gobMagic = "MKVG"
+
+// chainEnvelope describes an encoded chain. Chains written before the
+// envelope was introduced have version zero and are single token chains
+type chainEnvelope struct {
+	Version int    `json:"version,omitempty"`
+	Type    string `json:"type,omitempty"`
+	// Order is the number of tokens each link of a multi token chain is
+	// keyed on
+	Order int `json:"order,omitempty"`
+	// Normalizer is the name of the KeyNormalizer of a NormalizedChain, the
+	// links hold keys it has already normalized
+	Normalizer string `json:"normalizer,omitempty"`
+}
+
+// standardChain is the standard JSON representation of a chain
+type standardChain struct {
+	chainEnvelope
+	*singleKeyChain
+}
+
+// gobChain is the gob representation of a chain
+type gobChain struct {
+	Envelope chainEnvelope
+	Chain    *singleKeyChain
+}
+
+// EncodeOptions configures how WriteChain encodes a chain
+type EncodeOptions struct {
+	// IncludeCumulative stores a cumulative weights table with every link,
+	// so the loaded chain samples by binary search without first building
+	// the tables. This increases the size of the encoded chain
+	IncludeCumulative bool
+
+	// Compact writes the compact representation, which stores each token
+	// once and refers to tokens by index
+	Compact bool
+
+	// Gob writes the chain with encoding/gob rather than JSON, which is
+	// smaller and faster to load but only readable from Go. Compact is
+	// ignored
+	Gob bool
+
+	// Shards, if greater than one, splits the chain's links between that
+	// many shards, each encoded as configured, which are decoded in parallel
+	// when the chain is loaded
+	Shards int
+}
+
+// WriteChain encodes a chain as JSON, or gob if requested, in a versioned
+// envelope recording the chain's type. The chain must expose its tokens and
+// counts or be a MultiKeyChain
+func WriteChain(w io.Writer, chain MarkovChain, opts EncodeOptions) error {
+	source, envelope, sourceErr := encodableChain(chain)
+	if sourceErr != nil {
+		return sourceErr
+	}
+	if opts.Shards > 1 {
+		return writeShardedChain(w, source, envelope, opts)
+	}
+	return writeSingleKeyChain(w, source, envelope, opts)
+}
+
+// encodableChain retrieves the single token chain holding a chain's links,
+// copying it if necessary, and the envelope describing it
+func encodableChain(chain MarkovChain) (*singleKeyChain, chainEnvelope, error) {
+	if normalized, isNormalized := chain.(*normalizedChain); isNormalized {
+		return encodableNormalizedChain(normalized)
+	}
+
+	envelope := chainEnvelope{Version: chainVersion, Type: chainTypeSingle}
+	source, ok := chain.(*singleKeyChain)
+	if frozen, isFrozen := chain.(*frozenChain); isFrozen {
+		source, ok = frozen.chain, true
+	}
+	if multi, isMulti := chain.(*MultiKeyChain); isMulti {
+		envelope.Type, envelope.Order = chainTypeMulti, multi.order
+		source, ok = multi.snapshot(), true
+	}
+	if !ok {
+		source = newSingleKeyChain()
+		if copyErr := Copy(source, chain); copyErr != nil {
+			return nil, envelope, copyErr
+		}
+		if lengths, ok := chain.(LengthModel); ok && lengths.SequenceLengths() != nil {
+			source.Lengths.merge(lengths.SequenceLengths())
+		}
+	}
+	return source, envelope, nil
+}
+
+// encodableNormalizedChain retrieves the links of the chain wrapped by a
+// NormalizedChain, recording the normalizer's name so it can be recreated
+func encodableNormalizedChain(chain *normalizedChain) (*singleKeyChain, chainEnvelope, error) {
+	name := chain.normalizer.Name()
+	if name == "" {
+		return nil, chainEnvelope{}, fmt.Errorf("chain: key normalizer has no name")
+	}
+	source, envelope, sourceErr := encodableChain(chain.chain)
+	if sourceErr != nil {
+		return nil, envelope, sourceErr
+	}
+	// a wrapped NormalizedChain normalizes keys after this one
+	if envelope.Normalizer != "" {
+		name += "+" + envelope.Normalizer
+	}
+	envelope.Normalizer = name
+	return source, envelope, nil
+}
+
+// writeSingleKeyChain encodes a chain in a single piece
+func writeSingleKeyChain(w io.Writer, source *singleKeyChain, envelope chainEnvelope, opts EncodeOptions) error {
+	if opts.Compact && !opts.Gob {
+		compact := newCompactChain(source, opts)
+		compact.chainEnvelope = envelope
+		return json.NewEncoder(w).Encode(compact)
+	}
+
+	// links are copied so the tables can be added or stripped without
+	// modifying a chain that may be in use
+	encoded := &singleKeyChain{
+		Links:           make(map[string]*singleTokenLink, len(source.Links)),
+		Lengths:         source.Lengths,
+		OverflowBuckets: source.OverflowBuckets,
+		Folded:          source.Folded,
+	}
+	for key, link := range source.Links {
+		linkCopy := *link
+		linkCopy.Cumulative = nil
+		if opts.IncludeCumulative && link.Total > 0 {
+			linkCopy.Cumulative = link.Cumulative
+			if linkCopy.Cumulative == nil {
+				linkCopy.Cumulative = newCumulativeTable(link)
+			}
+		}
+		encoded.Links[key] = &linkCopy
+	}
+
+	if opts.Gob {
+		if _, writeErr := io.WriteString(w, gobMagic); writeErr != nil {
+			return writeErr
+		}
+		return gob.NewEncoder(w).Encode(&gobChain{Envelope: envelope, Chain: encoded})
+	}
+	return json.NewEncoder(w).Encode(&standardChain{chainEnvelope: envelope, singleKeyChain: encoded})
+}
+
+// ReadChain decodes and validates a single token chain encoded by
+// WriteChain in any representation, wrapped in a NormalizedChain if it was
+// written from one. ErrUnwritableChain is returned for chains of other
+// types, which can be read with LoadChain
+func ReadChain(r io.Reader, opts DecodeOptions) (WritableChain, error) {
+	chain, envelope, decodeErr := decodeChain(r, opts)
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	if envelope.Type != chainTypeSingle {
+		return nil, ErrUnwritableChain
+	}
+	return normalizeLoadedChain(chain, envelope)
+}
+
+// readSingleKeyChain decodes a chain that must be a single token chain
+// without a normalizer
+func readSingleKeyChain(r io.Reader, opts DecodeOptions) (*singleKeyChain, error) {
+	chain, envelope, decodeErr := decodeChain(r, opts)
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	if envelope.Type != chainTypeSingle || envelope.Normalizer != "" {
+		return nil, ErrUnwritableChain
+	}
+	return chain, nil
+}
+
+// LoadChain decodes and validates a chain of any type encoded by
+// WriteChain, the type written is detected from the chain's envelope. A
+// chain written from a NormalizedChain is wrapped in one again, with its
+// normalizer recreated by LookupKeyNormalizer
+func LoadChain(r io.Reader, opts DecodeOptions) (MarkovChain, error) {
+	chain, envelope, decodeErr := decodeChain(r, opts)
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	var loaded WritableChain = chain
+	if envelope.Type == chainTypeMulti {
+		loaded = &MultiKeyChain{order: envelope.Order, chain: chain}
+	}
+	return normalizeLoadedChain(loaded, envelope)
+}
+
+// normalizeLoadedChain wraps a decoded chain in the normalizer recorded in
+// its envelope, if any
+func normalizeLoadedChain(chain WritableChain, envelope chainEnvelope) (WritableChain, error) {
+	if envelope.Normalizer == "" {
+		return chain, nil
+	}
+	normalizer, lookupErr := LookupKeyNormalizer(envelope.Normalizer)
+	if lookupErr != nil {
+		return nil, fmt.Errorf("chain: loading normalized chain: %w", lookupErr)
+	}
+	return MakeNormalizedChain(chain, normalizer), nil
+}
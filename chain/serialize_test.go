@@ -0,0 +1,189 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testChain builds a small chain with repeated transitions and two
+// sequences
+func testChain(t *testing.T) *singleKeyChain {
+	t.Helper()
+	built, buildErr := BuildChainFromSources(
+		NewSliceSource(strings.Fields("the cat sat on the mat and the cat ran")),
+		NewSliceSource(strings.Fields("a dog sat on the cat")),
+	)
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	return built.(*singleKeyChain)
+}
+
+// assertSameChain fails unless got holds exactly the transitions and
+// sequence lengths of want
+func assertSameChain(t *testing.T, want *singleKeyChain, got MarkovChain) {
+	t.Helper()
+	iterable, ok := got.(IterableChain)
+	if !ok {
+		t.Fatalf("%T is not iterable", got)
+	}
+	if len(iterable.RetrieveTokens()) != len(want.Links) {
+		t.Fatalf("got %d links, want %d", len(iterable.RetrieveTokens()), len(want.Links))
+	}
+	for key, link := range want.Links {
+		gotLink, ok := got.RetrieveMarkovLink(key)
+		if !ok {
+			t.Fatalf("link %q missing", key)
+		}
+		counted := gotLink.(CountedLink)
+		if counted.GetTotalOccurrences() != link.Total {
+			t.Fatalf("link %q total %d, want %d", key, counted.GetTotalOccurrences(), link.Total)
+		}
+		for next, count := range link.NextTokenOccurrences {
+			if gotCount, _ := counted.GetOccurrencesOfToken(next); gotCount != count {
+				t.Fatalf("%q to %q has count %d, want %d", key, next, gotCount, count)
+			}
+		}
+	}
+	if lengths, ok := got.(LengthModel); ok && want.Lengths.Total > 0 {
+		if lengths.SequenceLengths().Total != want.Lengths.Total {
+			t.Fatalf("got %d sequence lengths, want %d", lengths.SequenceLengths().Total, want.Lengths.Total)
+		}
+	}
+}
+
+func TestWriteChainRoundTrip(t *testing.T) {
+	want := testChain(t)
+	for _, opts := range []EncodeOptions{
+		{},
+		{IncludeCumulative: true},
+		{Compact: true},
+		{Compact: true, IncludeCumulative: true},
+		{Gob: true},
+		{Gob: true, IncludeCumulative: true},
+		{Shards: 3, Gob: true},
+	} {
+		encoded := &bytes.Buffer{}
+		if writeErr := WriteChain(encoded, want, opts); writeErr != nil {
+			t.Fatalf("%+v: %v", opts, writeErr)
+		}
+
+		read, readErr := ReadChain(bytes.NewReader(encoded.Bytes()), DefaultDecodeOptions())
+		if readErr != nil {
+			t.Fatalf("%+v: %v", opts, readErr)
+		}
+		assertSameChain(t, want, read)
+		loaded, loadErr := LoadChain(bytes.NewReader(encoded.Bytes()), DefaultDecodeOptions())
+		if loadErr != nil {
+			t.Fatalf("%+v: %v", opts, loadErr)
+		}
+		assertSameChain(t, want, loaded)
+	}
+}
+
+func TestLoadChainStream(t *testing.T) {
+	want := testChain(t)
+	encoded := &bytes.Buffer{}
+	if writeErr := WriteChainStream(encoded, want); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+
+	got, loadErr := LoadChain(bytes.NewReader(encoded.Bytes()), DefaultDecodeOptions())
+	if loadErr != nil {
+		t.Fatal(loadErr)
+	}
+	assertSameChain(t, want, got)
+
+	limited := DefaultDecodeOptions()
+	limited.MaxLinks = 2
+	if _, loadErr := LoadChain(bytes.NewReader(encoded.Bytes()), limited); !errors.Is(loadErr, ErrMalformedChain) {
+		t.Fatalf("link limit not applied, got %v", loadErr)
+	}
+	if _, loadErr := LoadChain(bytes.NewReader(encoded.Bytes()[:encoded.Len()-4]), DefaultDecodeOptions()); loadErr == nil {
+		t.Fatal("truncated stream loaded")
+	}
+}
+
+func TestLoadChainShards(t *testing.T) {
+	want := testChain(t)
+	for _, opts := range []EncodeOptions{
+		{Shards: 3},
+		{Shards: 4, Gob: true},
+		{Shards: 5, Compact: true, IncludeCumulative: true},
+	} {
+		encoded := &bytes.Buffer{}
+		if writeErr := WriteChain(encoded, want, opts); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		got, loadErr := LoadChain(bytes.NewReader(encoded.Bytes()), DefaultDecodeOptions())
+		if loadErr != nil {
+			t.Fatalf("%+v: %v", opts, loadErr)
+		}
+		assertSameChain(t, want, got)
+
+		limited := DefaultDecodeOptions()
+		limited.MaxLinks = 3
+		if _, loadErr := LoadChain(bytes.NewReader(encoded.Bytes()), limited); !errors.Is(loadErr, ErrMalformedChain) {
+			t.Fatalf("%+v: link limit not applied, got %v", opts, loadErr)
+		}
+		if _, loadErr := LoadChain(bytes.NewReader(encoded.Bytes()[:encoded.Len()-3]), DefaultDecodeOptions()); loadErr == nil {
+			t.Fatalf("%+v: truncated shards loaded", opts)
+		}
+	}
+}
+
+func TestLoadChainNormalized(t *testing.T) {
+	normalizer := ComposeNormalizers(TrimNormalizer(), CaseFoldNormalizer())
+	built, buildErr := BuildNormalizedChainFromSources(normalizer, NewSliceSource(strings.Fields("The cat saw THE dog")))
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+
+	for _, opts := range []EncodeOptions{{}, {Gob: true}, {Compact: true}, {Shards: 2}} {
+		encoded := &bytes.Buffer{}
+		if writeErr := WriteChain(encoded, built, opts); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		loaded, loadErr := LoadChain(bytes.NewReader(encoded.Bytes()), DefaultDecodeOptions())
+		if loadErr != nil {
+			t.Fatalf("%+v: %v", opts, loadErr)
+		}
+		normalized, ok := loaded.(NormalizedChain)
+		if !ok {
+			t.Fatalf("%+v: loaded %T", opts, loaded)
+		}
+		if normalized.KeyNormalizer().Name() != "trim+lowercase" {
+			t.Fatalf("%+v: normalizer %q", opts, normalized.KeyNormalizer().Name())
+		}
+		if link, ok := normalized.RetrieveMarkovLink("THE"); !ok || len(link.RetrieveNextTokenPossibilities()) != 2 {
+			t.Fatalf("%+v: lookup of THE not normalized", opts)
+		}
+
+		read, readErr := ReadChain(bytes.NewReader(encoded.Bytes()), DefaultDecodeOptions())
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		if _, ok := read.(NormalizedChain); !ok {
+			t.Fatalf("%+v: read %T", opts, read)
+		}
+	}
+}
+
+func TestLoadChainUnknownNormalizer(t *testing.T) {
+	built := MakeNormalizedChain(NewWritableChain(), MakeFuncNormalizer("unregistered", strings.ToUpper))
+	built.Increment("", "a", 1)
+	encoded := &bytes.Buffer{}
+	if writeErr := WriteChain(encoded, built, EncodeOptions{}); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	if _, loadErr := LoadChain(encoded, DefaultDecodeOptions()); !errors.Is(loadErr, ErrUnknownName) {
+		t.Fatalf("loaded with unknown normalizer, got %v", loadErr)
+	}
+
+	unnamed := MakeNormalizedChain(NewWritableChain(), MakeFuncNormalizer("", strings.ToUpper))
+	if writeErr := WriteChain(&bytes.Buffer{}, unnamed, EncodeOptions{}); writeErr == nil {
+		t.Fatal("wrote chain with unnamed normalizer")
+	}
+}
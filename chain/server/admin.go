@@ -0,0 +1,124 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/lvanoort/markov/chain"
+)
+
+var (
+	// ErrUnauthorized is returned by admin endpoints when the request
+	// doesn't carry the admin token
+	ErrUnauthorized = errors.New("server: unauthorized")
+
+	// ErrNotConfigured is returned by admin operations whose hook wasn't
+	// set in Options
+	ErrNotConfigured = errors.New("server: not configured")
+)
+
+// requireAdmin rejects requests that aren't POSTs carrying the admin token
+// as a bearer token
+func (s *Server) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.AdminToken == "" {
+			writeError(w, http.StatusNotFound, ErrNotConfigured)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.AdminToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("server: POST required"))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// Reload replaces the served chain with one read by the Load hook. Requests
+// in progress complete against the old chain
+func (s *Server) Reload() error {
+	if s.opts.Load == nil {
+		return ErrNotConfigured
+	}
+
+	loaded, loadErr := s.opts.Load()
+	if loadErr != nil {
+		return loadErr
+	}
+
+	s.chainTex.Lock()
+	defer s.chainTex.Unlock()
+	s.opts.Chain = loaded
+	return nil
+}
+
+// Prune removes transitions that occurred fewer than minCount times, then
+// scales the remaining counts by decay if it is between 0 and 1
+func (s *Server) Prune(minCount int, decay float64) error {
+	s.chainTex.Lock()
+	defer s.chainTex.Unlock()
+
+	if decay > 0 && decay < 1 {
+		if decayErr := chain.Decay(s.opts.Chain, decay); decayErr != nil {
+			return decayErr
+		}
+	}
+	return chain.Prune(s.opts.Chain, minCount)
+}
+
+// Snapshot writes the chain with the Snapshot hook, or the Flush hook if
+// there isn't one. Training is blocked while the snapshot is written
+func (s *Server) Snapshot() error {
+	snapshot := s.opts.Snapshot
+	if snapshot == nil {
+		snapshot = s.opts.Flush
+	}
+	if snapshot == nil {
+		return ErrNotConfigured
+	}
+
+	s.chainTex.RLock()
+	defer s.chainTex.RUnlock()
+	return snapshot(s.opts.Chain)
+}
+
+// writeAdminResult writes the outcome of an admin operation
+func writeAdminResult(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotConfigured) {
+		writeError(w, http.StatusNotImplemented, err)
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+	} else {
+		writeJSON(w, http.StatusOK, struct{}{})
+	}
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	writeAdminResult(w, s.Reload())
+}
+
+// PruneRequest is the body of an admin prune request
+type PruneRequest struct {
+	MinCount int     `json:"min_count"`
+	Decay    float64 `json:"decay"`
+}
+
+func (s *Server) handlePrune(w http.ResponseWriter, r *http.Request) {
+	req := PruneRequest{}
+	if !decodeRequest(w, r, maxRequestBytes, &req) {
+		return
+	}
+	writeAdminResult(w, s.Prune(req.MinCount, req.Decay))
+}
+
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	writeAdminResult(w, s.Snapshot())
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// OpenAPIVersion is the version of the API described by OpenAPI
+const OpenAPIVersion = "1.0.0"
+
+type endpoint struct {
+	path     string
+	method   string
+	summary  string
+	request  interface{}
+	response interface{}
+	admin    bool
+}
+
+var endpoints = []endpoint{
+	{"/generate", "post", "Generate a sequence of tokens", GenerateRequest{}, GenerateResponse{}, false},
+	{"/score", "post", "Score a sequence of tokens", ScoreRequest{}, ScoreResponse{}, false},
+	{"/train", "post", "Train the chain on sequences of tokens", TrainRequest{}, TrainResponse{}, false},
+	{"/link", "post", "Retrieve the tokens following a token", LinkRequest{}, LinkResponse{}, false},
+	{"/links", "post", "Retrieve the tokens following each of many tokens", LinksRequest{}, LinksResponse{}, false},
+	{"/stats", "get", "Retrieve statistics about the chain", nil, StatsResponse{}, false},
+	{"/admin/reload", "post", "Reload the chain from disk", nil, struct{}{}, true},
+	{"/admin/prune", "post", "Prune and decay the chain", PruneRequest{}, struct{}{}, true},
+	{"/admin/snapshot", "post", "Write a snapshot of the chain to disk", nil, struct{}{}, true},
+}
+
+// schemaFor derives a JSON schema from the type of a request or response
+// body, using the same field names as encoding/json
+func schemaFor(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" || field.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaFor(field.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+
+	return map[string]interface{}{}
+}
+
+func jsonContent(body interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": schemaFor(reflect.TypeOf(body)),
+		},
+	}
+}
+
+// OpenAPI describes the server's endpoints as an OpenAPI 3 document, admin
+// endpoints are only included if they are enabled
+func (s *Server) OpenAPI() map[string]interface{} {
+	errorResponseSpec := map[string]interface{}{
+		"description": "Error",
+		"content":     jsonContent(errorResponse{}),
+	}
+
+	paths := make(map[string]interface{})
+	for _, e := range endpoints {
+		if e.admin && s.opts.AdminToken == "" {
+			continue
+		}
+
+		operation := map[string]interface{}{
+			"summary": e.summary,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Success",
+					"content":     jsonContent(e.response),
+				},
+				"default": errorResponseSpec,
+			},
+		}
+		if e.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(e.request),
+			}
+		}
+		if e.admin {
+			operation["security"] = []interface{}{
+				map[string]interface{}{"adminToken": []string{}},
+			}
+		}
+		paths[e.path] = map[string]interface{}{e.method: operation}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Markov chain service",
+			"version": OpenAPIVersion,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{
+					"type":   "http",
+					"scheme": "bearer",
+				},
+			},
+		},
+	}
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(s.OpenAPI())
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned for requests over a client's rate limit
+var ErrRateLimited = errors.New("server: rate limit exceeded")
+
+// maxIdleBuckets is the number of client buckets kept before full, idle
+// buckets are swept
+const maxIdleBuckets = 10000
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket rate limiter keyed by client IP
+type rateLimiter struct {
+	rate       float64
+	burst      float64
+	limiterTex sync.Mutex
+	buckets    map[string]*tokenBucket
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from the client's bucket, if none are available it
+// returns how long until one will be
+func (l *rateLimiter) allow(client string, now time.Time) (time.Duration, bool) {
+	l.limiterTex.Lock()
+	defer l.limiterTex.Unlock()
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.sweep(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second)), false
+	}
+	bucket.tokens--
+	return 0, true
+}
+
+// sweep removes buckets that would have refilled completely, they are
+// equivalent to a new bucket
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// clientIP identifies the client of a request by its remote address
+func clientIP(r *http.Request) string {
+	host, _, splitErr := net.SplitHostPort(r.RemoteAddr)
+	if splitErr != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,494 @@
+// Package server serves a Markov chain over HTTP, exposing generation,
+// scoring and training endpoints. The API is described by the OpenAPI 3
+// document served at /openapi.json
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lvanoort/markov/chain"
+)
+
+// ErrShuttingDown is returned for requests made once Shutdown has begun
+var ErrShuttingDown = errors.New("server: shutting down")
+
+// ErrTooLarge is returned for request bodies over the configured size
+var ErrTooLarge = errors.New("server: request body too large")
+
+// ErrTooManyTokens is returned for links requests of over 1000 tokens
+var ErrTooManyTokens = errors.New("server: too many tokens requested")
+
+// maxRequestBytes caps the size of request bodies other than training
+const maxRequestBytes = 64 << 10
+
+// Options configures a Server
+type Options struct {
+	// Chain is the chain served, it is trained by the train endpoint
+	Chain chain.WritableChain
+
+	// Flush, if set, is called with the chain during Shutdown once all
+	// in-flight requests have completed, e.g. to persist training to disk
+	Flush func(chain.MarkovChain) error
+
+	// AdminToken is the bearer token required by the admin endpoints, they
+	// are disabled if it is empty
+	AdminToken string
+
+	// Load, if set, is used by Reload to read the chain from disk
+	Load func() (chain.WritableChain, error)
+
+	// Snapshot, if set, is used by Snapshot to write the chain to disk,
+	// Flush is used if it isn't set
+	Snapshot func(chain.MarkovChain) error
+
+	// MaxTokens caps the number of tokens a single generate request may
+	// produce, defaults to chain.DefaultMaxTokens
+	MaxTokens int
+
+	// MaxTrainBytes caps the size of a train request body, defaults to 1MiB
+	MaxTrainBytes int64
+
+	// RateLimit is the number of requests per second allowed from each
+	// client IP, with bursts of up to RateBurst requests. Requests aren't
+	// rate limited if it is zero
+	RateLimit float64
+	RateBurst int
+}
+
+// Server serves a Markov chain over HTTP. The chain is guarded by the
+// server, so it must not be modified elsewhere while being served
+type Server struct {
+	opts       Options
+	chainTex   sync.RWMutex
+	seed       int64
+	mux        *http.ServeMux
+	limiter    *rateLimiter
+	httpServer *http.Server
+	flightTex  sync.Mutex
+	drained    *sync.Cond
+	inFlight   int
+	stopping   bool
+	done       chan struct{}
+	doneOnce   sync.Once
+}
+
+// New creates a Server for the chain in opts
+func New(opts Options) *Server {
+	if opts.MaxTokens <= 0 {
+		opts.MaxTokens = chain.DefaultMaxTokens
+	}
+	if opts.MaxTrainBytes <= 0 {
+		opts.MaxTrainBytes = 1 << 20
+	}
+
+	s := &Server{
+		opts: opts,
+		seed: time.Now().UnixNano(),
+		mux:  http.NewServeMux(),
+		done: make(chan struct{}),
+	}
+	s.drained = sync.NewCond(&s.flightTex)
+	if opts.RateLimit > 0 {
+		s.limiter = newRateLimiter(opts.RateLimit, opts.RateBurst)
+	}
+	s.mux.HandleFunc("/generate", s.handleGenerate)
+	s.mux.HandleFunc("/score", s.handleScore)
+	s.mux.HandleFunc("/train", s.handleTrain)
+	s.mux.HandleFunc("/stats", s.handleStats)
+	s.mux.HandleFunc("/link", s.handleLink)
+	s.mux.HandleFunc("/links", s.handleLinks)
+	s.mux.HandleFunc("/openapi.json", s.handleOpenAPI)
+	s.mux.HandleFunc("/admin/reload", s.requireAdmin(s.handleReload))
+	s.mux.HandleFunc("/admin/prune", s.requireAdmin(s.handlePrune))
+	s.mux.HandleFunc("/admin/snapshot", s.requireAdmin(s.handleSnapshot))
+
+	return s
+}
+
+// Handler returns the server's HTTP handler, requests made through it are
+// tracked for Shutdown
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(s.serveHTTP)
+}
+
+// begin tracks a request as in flight, it returns false once Shutdown has
+// begun
+func (s *Server) begin() bool {
+	s.flightTex.Lock()
+	defer s.flightTex.Unlock()
+	if s.stopping {
+		return false
+	}
+	s.inFlight++
+	return true
+}
+
+// end completes a request tracked by begin
+func (s *Server) end() {
+	s.flightTex.Lock()
+	defer s.flightTex.Unlock()
+	s.inFlight--
+	if s.inFlight == 0 {
+		s.drained.Broadcast()
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.begin() {
+		writeError(w, http.StatusServiceUnavailable, ErrShuttingDown)
+		return
+	}
+	defer s.end()
+	if s.limiter != nil {
+		if wait, ok := s.limiter.allow(clientIP(r), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, ErrRateLimited)
+			return
+		}
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// Serve accepts connections on the listener until Shutdown is called
+func (s *Server) Serve(listener net.Listener) error {
+	s.chainTex.Lock()
+	s.httpServer = &http.Server{Handler: s.Handler()}
+	httpServer := s.httpServer
+	s.chainTex.Unlock()
+
+	if serveErr := httpServer.Serve(listener); serveErr != http.ErrServerClosed {
+		return serveErr
+	}
+	return nil
+}
+
+// ListenAndServe listens on the TCP address and serves until Shutdown is
+// called
+func (s *Server) ListenAndServe(addr string) error {
+	listener, listenErr := net.Listen("tcp", addr)
+	if listenErr != nil {
+		return listenErr
+	}
+	return s.Serve(listener)
+}
+
+// Shutdown stops accepting requests, waits for in-flight requests to
+// complete, then flushes the chain. If ctx is done first its error is
+// returned and the chain is not flushed. Done is closed once Shutdown
+// completes
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.flightTex.Lock()
+	s.stopping = true
+	s.flightTex.Unlock()
+
+	s.chainTex.RLock()
+	httpServer := s.httpServer
+	s.chainTex.RUnlock()
+	if httpServer != nil {
+		if shutdownErr := httpServer.Shutdown(ctx); shutdownErr != nil {
+			return shutdownErr
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.flightTex.Lock()
+		for s.inFlight > 0 {
+			s.drained.Wait()
+		}
+		s.flightTex.Unlock()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var flushErr error
+	if s.opts.Flush != nil {
+		s.chainTex.RLock()
+		flushErr = s.opts.Flush(s.opts.Chain)
+		s.chainTex.RUnlock()
+	}
+
+	s.doneOnce.Do(func() { close(s.done) })
+	return flushErr
+}
+
+// Done is closed once Shutdown has completed
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// newRand creates a random source for a single request
+func (s *Server) newRand() *rand.Rand {
+	return rand.New(rand.NewSource(atomic.AddInt64(&s.seed, 1)))
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// decodeRequest decodes a JSON POST body of at most maxBytes, writing an
+// error response and returning false if it can't
+func decodeRequest(w http.ResponseWriter, r *http.Request, maxBytes int64, body interface{}) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("server: POST required"))
+		return false
+	}
+	if r.ContentLength > maxBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrTooLarge)
+		return false
+	}
+
+	data, readErr := ioutil.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+	if readErr != nil {
+		writeError(w, http.StatusBadRequest, readErr)
+		return false
+	}
+	if int64(len(data)) > maxBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrTooLarge)
+		return false
+	}
+	if decodeErr := json.Unmarshal(data, body); decodeErr != nil {
+		writeError(w, http.StatusBadRequest, decodeErr)
+		return false
+	}
+	return true
+}
+
+// GenerateRequest is the body of a generate request
+type GenerateRequest struct {
+	Start     string `json:"start"`
+	MaxTokens int    `json:"max_tokens"`
+}
+
+// GenerateResponse is the body of a generate response
+type GenerateResponse struct {
+	Tokens []string `json:"tokens"`
+}
+
+func (s *Server) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	req := GenerateRequest{}
+	if !decodeRequest(w, r, maxRequestBytes, &req) {
+		return
+	}
+	if req.MaxTokens <= 0 || req.MaxTokens > s.opts.MaxTokens {
+		req.MaxTokens = s.opts.MaxTokens
+	}
+
+	s.chainTex.RLock()
+	tokens, generateErr := chain.GenerateContext(r.Context(), s.opts.Chain, s.newRand(), chain.GenerateOptions{
+		Start:     req.Start,
+		MaxTokens: req.MaxTokens,
+	})
+	s.chainTex.RUnlock()
+
+	if errors.Is(generateErr, chain.ErrKeyNotFound) || errors.Is(generateErr, chain.ErrEmptyChain) {
+		writeError(w, http.StatusNotFound, generateErr)
+		return
+	} else if generateErr != nil {
+		writeError(w, http.StatusServiceUnavailable, generateErr)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, GenerateResponse{Tokens: tokens})
+}
+
+// ScoreRequest is the body of a score request
+type ScoreRequest struct {
+	Tokens []string `json:"tokens"`
+}
+
+// ScoreResponse is the body of a score response
+type ScoreResponse struct {
+	LogProbability float64 `json:"log_probability"`
+	Perplexity     float64 `json:"perplexity"`
+	Transitions    int     `json:"transitions"`
+	Unseen         int     `json:"unseen"`
+}
+
+func (s *Server) handleScore(w http.ResponseWriter, r *http.Request) {
+	req := ScoreRequest{}
+	if !decodeRequest(w, r, maxRequestBytes, &req) {
+		return
+	}
+
+	s.chainTex.RLock()
+	score, scoreErr := chain.ScoreContext(r.Context(), s.opts.Chain, req.Tokens)
+	s.chainTex.RUnlock()
+	if scoreErr != nil {
+		writeError(w, http.StatusServiceUnavailable, scoreErr)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ScoreResponse{
+		LogProbability: score.LogProbability,
+		Perplexity:     score.Perplexity(),
+		Transitions:    score.Transitions,
+		Unseen:         score.Unseen,
+	})
+}
+
+// TrainRequest is the body of a train request, each sequence is added to the
+// chain separately
+type TrainRequest struct {
+	Sequences [][]string `json:"sequences"`
+}
+
+// TrainResponse is the body of a train response
+type TrainResponse struct {
+	Sequences int `json:"sequences"`
+	Tokens    int `json:"tokens"`
+}
+
+func (s *Server) handleTrain(w http.ResponseWriter, r *http.Request) {
+	req := TrainRequest{}
+	if !decodeRequest(w, r, s.opts.MaxTrainBytes, &req) {
+		return
+	}
+
+	resp := TrainResponse{}
+	s.chainTex.Lock()
+	defer s.chainTex.Unlock()
+	trainer := chain.MakeTrainer(s.opts.Chain)
+	for _, sequence := range req.Sequences {
+		if len(sequence) == 0 {
+			continue
+		}
+
+		if trainErr := trainer.Train(sequence); trainErr != nil {
+			writeError(w, http.StatusInternalServerError, trainErr)
+			return
+		}
+		resp.Sequences++
+		resp.Tokens += len(sequence)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// StatsResponse is the body of a stats response
+type StatsResponse struct {
+	States int  `json:"states"`
+	Empty  bool `json:"empty"`
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.chainTex.RLock()
+	resp := StatsResponse{
+		States: len(s.opts.Chain.RetrieveTokens()),
+		Empty:  s.opts.Chain.IsEmpty(),
+	}
+	s.chainTex.RUnlock()
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// LinkRequest is the body of a link request
+type LinkRequest struct {
+	Token string `json:"token"`
+}
+
+// LinkResponse is the body of a link response, Occurrences counts each token
+// seen following the requested token
+type LinkResponse struct {
+	Present     bool           `json:"present"`
+	Occurrences map[string]int `json:"occurrences"`
+}
+
+// linkResponse describes a link, the caller must hold chainTex
+func linkResponse(link chain.MarkovChainLink, present bool) (LinkResponse, error) {
+	resp := LinkResponse{Occurrences: make(map[string]int)}
+	if !present {
+		return resp, nil
+	}
+	counted, ok := link.(chain.CountedLink)
+	if !ok {
+		return resp, chain.ErrUncountableChain
+	}
+
+	resp.Present = true
+	for _, next := range counted.RetrieveNextTokenPossibilities() {
+		resp.Occurrences[next], _ = counted.GetOccurrencesOfToken(next)
+	}
+	return resp, nil
+}
+
+func (s *Server) handleLink(w http.ResponseWriter, r *http.Request) {
+	req := LinkRequest{}
+	if !decodeRequest(w, r, maxRequestBytes, &req) {
+		return
+	}
+
+	s.chainTex.RLock()
+	defer s.chainTex.RUnlock()
+	resp, linkErr := linkResponse(s.opts.Chain.RetrieveMarkovLink(req.Token))
+	if linkErr != nil {
+		writeError(w, http.StatusInternalServerError, linkErr)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// maxBatchTokens caps the number of tokens in a links request
+const maxBatchTokens = 1000
+
+// LinksRequest is the body of a links request
+type LinksRequest struct {
+	Tokens []string `json:"tokens"`
+}
+
+// LinksResponse is the body of a links response, it holds a link for each
+// requested token in the order requested
+type LinksResponse struct {
+	Links []LinkResponse `json:"links"`
+}
+
+func (s *Server) handleLinks(w http.ResponseWriter, r *http.Request) {
+	req := LinksRequest{}
+	if !decodeRequest(w, r, maxRequestBytes, &req) {
+		return
+	}
+	if len(req.Tokens) > maxBatchTokens {
+		writeError(w, http.StatusBadRequest, ErrTooManyTokens)
+		return
+	}
+
+	s.chainTex.RLock()
+	defer s.chainTex.RUnlock()
+	links, present := chain.RetrieveMarkovLinks(s.opts.Chain, req.Tokens)
+	resp := LinksResponse{Links: make([]LinkResponse, len(links))}
+	for i := range links {
+		var linkErr error
+		if resp.Links[i], linkErr = linkResponse(links[i], present[i]); linkErr != nil {
+			writeError(w, http.StatusInternalServerError, linkErr)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
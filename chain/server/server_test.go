@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lvanoort/markov/chain"
+)
+
+// blockingChain holds stats requests in flight until release is closed
+type blockingChain struct {
+	chain.WritableChain
+	entered chan struct{}
+	release chan struct{}
+	active  int32
+}
+
+func newBlockingChain() *blockingChain {
+	return &blockingChain{
+		WritableChain: chain.NewWritableChain(),
+		entered:       make(chan struct{}, 100),
+		release:       make(chan struct{}),
+	}
+}
+
+func (c *blockingChain) RetrieveTokens() []string {
+	atomic.AddInt32(&c.active, 1)
+	defer atomic.AddInt32(&c.active, -1)
+	select {
+	case c.entered <- struct{}{}:
+	default:
+	}
+	<-c.release
+	return c.WritableChain.RetrieveTokens()
+}
+
+func get(handler http.Handler, path string) int {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, strings.NewReader("")))
+	return recorder.Code
+}
+
+func TestShutdownDrainsThenFlushes(t *testing.T) {
+	blocking := newBlockingChain()
+	flushed := make(chan struct{})
+	server := New(Options{Chain: blocking, Flush: func(chain.MarkovChain) error {
+		if active := atomic.LoadInt32(&blocking.active); active != 0 {
+			t.Errorf("flushed with %d requests in flight", active)
+		}
+		close(flushed)
+		return nil
+	}})
+	handler := server.Handler()
+
+	served := make(chan int)
+	go func() { served <- get(handler, "/stats") }()
+	<-blocking.entered
+
+	shutdown := make(chan error)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	// the OpenAPI document doesn't consult the chain so isn't held
+	for get(handler, "/openapi.json") != http.StatusServiceUnavailable {
+	}
+	select {
+	case <-flushed:
+		t.Fatal("flushed before the request in flight completed")
+	default:
+	}
+
+	close(blocking.release)
+	if code := <-served; code != http.StatusOK {
+		t.Fatalf("request in flight got %d", code)
+	}
+	if shutdownErr := <-shutdown; shutdownErr != nil {
+		t.Fatal(shutdownErr)
+	}
+	<-flushed
+	<-server.Done()
+}
+
+func TestShutdownContextDone(t *testing.T) {
+	blocking := newBlockingChain()
+	server := New(Options{Chain: blocking, Flush: func(chain.MarkovChain) error {
+		t.Error("flushed before the request in flight completed")
+		return nil
+	}})
+	handler := server.Handler()
+
+	served := make(chan int)
+	go func() { served <- get(handler, "/stats") }()
+	<-blocking.entered
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if shutdownErr := server.Shutdown(ctx); !errors.Is(shutdownErr, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", shutdownErr)
+	}
+	close(blocking.release)
+	<-served
+}
+
+func TestShutdownDuringRequests(t *testing.T) {
+	blocking := newBlockingChain()
+	close(blocking.release)
+	server := New(Options{Chain: blocking, Flush: func(chain.MarkovChain) error {
+		if active := atomic.LoadInt32(&blocking.active); active != 0 {
+			t.Errorf("flushed with %d requests in flight", active)
+		}
+		return nil
+	}})
+	handler := server.Handler()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for get(handler, "/stats") != http.StatusServiceUnavailable {
+			}
+		}()
+	}
+	if shutdownErr := server.Shutdown(context.Background()); shutdownErr != nil {
+		t.Fatal(shutdownErr)
+	}
+	wg.Wait()
+}
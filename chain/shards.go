@@ -0,0 +1,166 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// shardMagic identifies a chain encoded in shards, it must be the same
+// length as gobMagic and, like it, must not be a prefix of streamMagic
+const shardMagic = "MKVP"
+
+// maxShards caps the number of shards accepted when decoding, so a corrupt
+// header can't start an unbounded number of goroutines
+const maxShards = 4096
+
+// shardedHeader follows shardMagic, as a big endian uint32 length and then
+// JSON, and describes the shards that follow it. Each shard is a single
+// token chain written by WriteChain holding a subset of the links, the
+// first also holds the sequence lengths, overflow bucket count and folded
+// keys
+type shardedHeader struct {
+	chainEnvelope
+	// Shards are the sizes in bytes of each shard, in order
+	Shards []int64 `json:"shards"`
+}
+
+// writeShardedChain encodes a chain as opts.Shards shards
+func writeShardedChain(w io.Writer, source *singleKeyChain, envelope chainEnvelope, opts EncodeOptions) error {
+	parts := make([]*singleKeyChain, opts.Shards)
+	for i := range parts {
+		parts[i] = &singleKeyChain{Links: make(map[string]*singleTokenLink, len(source.Links)/opts.Shards+1)}
+	}
+	parts[0].Lengths, parts[0].OverflowBuckets, parts[0].Folded = source.Lengths, source.OverflowBuckets, source.Folded
+	shard := 0
+	for key, link := range source.Links {
+		parts[shard].Links[key] = link
+		shard = (shard + 1) % len(parts)
+	}
+
+	shardOpts := opts
+	shardOpts.Shards = 0
+	header := shardedHeader{chainEnvelope: envelope, Shards: make([]int64, len(parts))}
+	encoded := make([]*bytes.Buffer, len(parts))
+	for i, part := range parts {
+		encoded[i] = &bytes.Buffer{}
+		if encodeErr := writeSingleKeyChain(encoded[i], part, chainEnvelope{Version: chainVersion, Type: chainTypeSingle}, shardOpts); encodeErr != nil {
+			return encodeErr
+		}
+		header.Shards[i] = int64(encoded[i].Len())
+	}
+
+	headerBytes, marshalErr := json.Marshal(&header)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	if _, writeErr := io.WriteString(w, shardMagic); writeErr != nil {
+		return writeErr
+	}
+	if writeErr := binary.Write(w, binary.BigEndian, uint32(len(headerBytes))); writeErr != nil {
+		return writeErr
+	}
+	if _, writeErr := w.Write(headerBytes); writeErr != nil {
+		return writeErr
+	}
+	for _, shard := range encoded {
+		if _, writeErr := shard.WriteTo(w); writeErr != nil {
+			return writeErr
+		}
+	}
+	return nil
+}
+
+// readShard reads exactly size bytes of a shard, without trusting size
+// when allocating
+func readShard(r io.Reader, size int64) ([]byte, error) {
+	data, readErr := ioutil.ReadAll(io.LimitReader(r, size))
+	if readErr != nil {
+		return nil, readErr
+	}
+	if int64(len(data)) != size {
+		return nil, malformed("shard of %d bytes truncated to %d", size, len(data))
+	}
+	return data, nil
+}
+
+// decodeShardedChain reads every shard of a chain, decodes and validates
+// them in parallel, then combines their links
+func decodeShardedChain(r io.Reader, opts DecodeOptions) (*singleKeyChain, chainEnvelope, error) {
+	var headerLength uint32
+	if readErr := binary.Read(r, binary.BigEndian, &headerLength); readErr != nil {
+		return nil, chainEnvelope{}, readErr
+	}
+	headerBytes, readErr := readShard(r, int64(headerLength))
+	if readErr != nil {
+		return nil, chainEnvelope{}, readErr
+	}
+	header := &shardedHeader{}
+	if decodeErr := json.Unmarshal(headerBytes, header); decodeErr != nil {
+		return nil, chainEnvelope{}, decodeErr
+	}
+	if len(header.Shards) == 0 || len(header.Shards) > maxShards {
+		return nil, header.chainEnvelope, malformed("%d shards", len(header.Shards))
+	}
+
+	shards := make([][]byte, len(header.Shards))
+	for i, size := range header.Shards {
+		if size < 0 {
+			return nil, header.chainEnvelope, malformed("shard %d has negative size", i)
+		}
+		if shards[i], readErr = readShard(r, size); readErr != nil {
+			return nil, header.chainEnvelope, readErr
+		}
+		if bytes.HasPrefix(shards[i], []byte(shardMagic)) {
+			return nil, header.chainEnvelope, malformed("shard %d is itself sharded", i)
+		}
+	}
+
+	// the size limit was applied as the shards were read, and the link
+	// limit applies to the combined chain
+	shardOpts := opts
+	shardOpts.MaxBytes, shardOpts.MaxLinks = 0, 0
+	parts := make([]*singleKeyChain, len(shards))
+	shardErrs := make([]error, len(shards))
+	wg := &sync.WaitGroup{}
+	for i := range shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer recoverPanic(func(err error) {
+				shardErrs[i] = err
+			})
+			var envelope chainEnvelope
+			parts[i], envelope, shardErrs[i] = decodeChain(bytes.NewReader(shards[i]), shardOpts)
+			if shardErrs[i] == nil && envelope.Type != chainTypeSingle {
+				shardErrs[i] = malformed("shard %d is a %s chain", i, envelope.Type)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, shardErr := range shardErrs {
+		if shardErr != nil {
+			return nil, header.chainEnvelope, shardErr
+		}
+	}
+
+	chain := parts[0]
+	for i, part := range parts[1:] {
+		if part.OverflowBuckets != 0 || len(part.Folded) != 0 || part.Lengths.Total != 0 {
+			return nil, header.chainEnvelope, malformed("shard %d records more than links", i+1)
+		}
+		for key, link := range part.Links {
+			if _, ok := chain.Links[key]; ok {
+				return nil, header.chainEnvelope, malformed("link %q is in more than one shard", key)
+			}
+			chain.Links[key] = link
+		}
+	}
+	if opts.MaxLinks > 0 && len(chain.Links) > opts.MaxLinks {
+		return nil, header.chainEnvelope, malformed("%d links exceeds limit", len(chain.Links))
+	}
+	return chain, header.chainEnvelope, nil
+}
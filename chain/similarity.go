@@ -0,0 +1,136 @@
+package chain
+
+import (
+	"math"
+	"sort"
+)
+
+// SimilarityMeasure compares the next token distributions of two states
+type SimilarityMeasure int
+
+const (
+	// CosineSimilarity is the cosine of the angle between the probability
+	// vectors, it is cheap and favours states sharing their likeliest tokens
+	CosineSimilarity SimilarityMeasure = iota
+
+	// JensenShannonSimilarity is one minus the base 2 Jensen-Shannon
+	// divergence, it accounts for the whole of both distributions
+	JensenShannonSimilarity
+)
+
+// SimilarState is a state ranked by its similarity to another
+type SimilarState struct {
+	Token      string
+	Similarity float64
+}
+
+// stateDistribution is a state's next token probabilities
+type stateDistribution map[string]float64
+
+func distributionOf(link MarkovChainLink) (stateDistribution, error) {
+	counted, ok := link.(CountedLink)
+	if !ok {
+		return nil, ErrUncountableChain
+	}
+
+	total := float64(counted.GetTotalOccurrences())
+	distribution := make(stateDistribution)
+	if total <= 0 {
+		return distribution, nil
+	}
+	for _, next := range counted.RetrieveNextTokenPossibilities() {
+		count, _ := counted.GetOccurrencesOfToken(next)
+		distribution[next] = float64(count) / total
+	}
+	return distribution, nil
+}
+
+func (m SimilarityMeasure) compare(a stateDistribution, b stateDistribution) float64 {
+	if m == JensenShannonSimilarity {
+		return 1 - jensenShannon(a, b)
+	}
+
+	dot, magA, magB := 0.0, 0.0, 0.0
+	for token, p := range a {
+		dot += p * b[token]
+		magA += p * p
+	}
+	for _, q := range b {
+		magB += q * q
+	}
+	if magA == 0 || magB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(magA*magB)
+}
+
+// jensenShannon calculates the base 2 Jensen-Shannon divergence, which is
+// between 0 for identical and 1 for disjoint distributions
+func jensenShannon(a stateDistribution, b stateDistribution) float64 {
+	divergence := 0.0
+	term := func(p float64, m float64) float64 {
+		if p == 0 {
+			return 0
+		}
+		return p * math.Log2(p/m)
+	}
+	for token, p := range a {
+		q := b[token]
+		m := (p + q) / 2
+		divergence += term(p, m) + term(q, m)
+	}
+	for token, q := range b {
+		if _, ok := a[token]; !ok {
+			// p is zero, so the midpoint is half of q
+			divergence += term(q, q/2)
+		}
+	}
+
+	return math.Min(1, math.Max(0, divergence/2))
+}
+
+// NearestStates ranks the other states of a chain by how similar their next
+// token distributions are to the token's, most similar first, returning at
+// most n. States followed by similar tokens tend to be words used alike
+func NearestStates(chain MarkovChain, token string, n int, measure SimilarityMeasure) ([]SimilarState, error) {
+	iterable, ok := chain.(IterableChain)
+	if !ok {
+		return nil, ErrUncountableChain
+	}
+	link, ok := chain.RetrieveMarkovLink(token)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	target, distributionErr := distributionOf(link)
+	if distributionErr != nil {
+		return nil, distributionErr
+	}
+
+	states := make([]SimilarState, 0)
+	for _, key := range iterable.RetrieveTokens() {
+		if key == token {
+			continue
+		}
+		other, ok := chain.RetrieveMarkovLink(key)
+		if !ok {
+			continue
+		}
+		distribution, distributionErr := distributionOf(other)
+		if distributionErr != nil {
+			return nil, distributionErr
+		}
+		states = append(states, SimilarState{Token: key, Similarity: measure.compare(target, distribution)})
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Similarity != states[j].Similarity {
+			return states[i].Similarity > states[j].Similarity
+		}
+		return states[i].Token < states[j].Token
+	})
+	if n >= 0 && len(states) > n {
+		states = states[:n]
+	}
+
+	return states, nil
+}
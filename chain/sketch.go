@@ -0,0 +1,240 @@
+package chain
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// SketchOptions configures a SketchChain
+type SketchOptions struct {
+	// Width is the number of counters in each row of the sketch, defaults
+	// to 1 << 16. Counts are overestimated by about e / Width of all
+	// transitions added
+	Width int
+
+	// Depth is the number of rows of the sketch, defaults to 4. The
+	// overestimate is exceeded with probability about e ^ -Depth
+	Depth int
+
+	// MaxSuccessors caps the successors remembered for each state, those
+	// estimated to be most frequent are kept. Defaults to 32
+	MaxSuccessors int
+
+	// MaxStates caps the number of states, defaults to 1 << 16. When
+	// exceeded the states with the fewest occurrences are dropped
+	MaxStates int
+}
+
+type sketchState struct {
+	total      int
+	successors []string
+}
+
+// SketchChain is an approximate chain for training on unbounded streams in
+// bounded memory. Transition counts are estimated by a count-min sketch, so
+// they may be overestimated but never underestimated, and only the likeliest
+// successors of each state are kept. A SketchChain is safe for concurrent
+// use
+type SketchChain struct {
+	opts      SketchOptions
+	sketchTex sync.RWMutex
+	counters  []uint64
+	states    map[string]*sketchState
+}
+
+// NewSketchChain creates an empty SketchChain
+func NewSketchChain(opts SketchOptions) *SketchChain {
+	if opts.Width <= 0 {
+		opts.Width = 1 << 16
+	}
+	if opts.Depth <= 0 {
+		opts.Depth = 4
+	}
+	if opts.MaxSuccessors <= 0 {
+		opts.MaxSuccessors = 32
+	}
+	if opts.MaxStates <= 0 {
+		opts.MaxStates = 1 << 16
+	}
+
+	return &SketchChain{
+		opts:     opts,
+		counters: make([]uint64, opts.Width*opts.Depth),
+		states:   make(map[string]*sketchState),
+	}
+}
+
+// cell locates the counter of a transition in a row of the sketch
+func (c *SketchChain) cell(pair uint64, row int) int {
+	return row*c.opts.Width + int(mixHash(pair, uint64(row+1))%uint64(c.opts.Width))
+}
+
+// estimate retrieves the estimated count of a transition. The caller must
+// hold a lock
+func (c *SketchChain) estimate(pair uint64) int {
+	estimate := ^uint64(0)
+	for row := 0; row < c.opts.Depth; row++ {
+		if count := c.counters[c.cell(pair, row)]; count < estimate {
+			estimate = count
+		}
+	}
+	return int(estimate)
+}
+
+// Increment records n additional occurrences of next following prev,
+// ErrInvalidCount is returned if n isn't positive
+func (c *SketchChain) Increment(prev string, next string, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidCount, n)
+	}
+	pair := hashTokens([]string{prev, next})
+
+	c.sketchTex.Lock()
+	defer c.sketchTex.Unlock()
+	for row := 0; row < c.opts.Depth; row++ {
+		c.counters[c.cell(pair, row)] += uint64(n)
+	}
+
+	state, ok := c.states[prev]
+	if !ok {
+		state = &sketchState{}
+		c.states[prev] = state
+	}
+	state.total += n
+
+	known := false
+	for _, successor := range state.successors {
+		if successor == next {
+			known = true
+			break
+		}
+	}
+	if !known {
+		if len(state.successors) < c.opts.MaxSuccessors {
+			state.successors = append(state.successors, next)
+		} else {
+			// replace the least frequent successor if next is now more
+			// frequent, the end of the sequence is never replaced so the
+			// state can still end
+			lowest, lowestCount := -1, 0
+			for i, successor := range state.successors {
+				if successor == "" {
+					continue
+				}
+				if count := c.estimate(hashTokens([]string{prev, successor})); lowest < 0 || count < lowestCount {
+					lowest, lowestCount = i, count
+				}
+			}
+			if lowest >= 0 && c.estimate(pair) > lowestCount {
+				state.successors[lowest] = next
+			}
+		}
+	}
+
+	if len(c.states) > c.opts.MaxStates {
+		c.evictStates()
+	}
+	return nil
+}
+
+// evictStates drops the least frequent states. The caller must hold the
+// write lock
+func (c *SketchChain) evictStates() {
+	candidates := make([]string, 0, len(c.states))
+	for key := range c.states {
+		// the sequence boundary is never evicted
+		if key != "" {
+			candidates = append(candidates, key)
+		}
+	}
+	lowestFrequency(candidates, func(key string) int { return c.states[key].total })
+
+	target := int(float64(c.opts.MaxStates) * evictionTarget)
+	for _, key := range candidates {
+		if len(c.states) <= target {
+			break
+		}
+		delete(c.states, key)
+	}
+}
+
+// Train adds a sequence of tokens to the chain, empty sequences are
+// ignored, so a SketchChain can be used as a Trainer
+func (c *SketchChain) Train(tokens []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	countEvent(counterTrainerAdds, tokens[0])
+	for _, segment := range splitSegments(tokens) {
+		if len(segment) == 0 {
+			continue
+		}
+		prev := ""
+		for _, token := range segment {
+			c.Increment(prev, token, 1)
+			prev = token
+		}
+		c.Increment(prev, "", 1)
+	}
+	return nil
+}
+
+func (c *SketchChain) CalculateNextToken(token string, rand *rand.Rand) (string, bool) {
+	link, ok := c.RetrieveMarkovLink(token)
+	if !ok {
+		return "", false
+	}
+	return link.GetNextToken(rand), true
+}
+
+// RetrieveMarkovLink retrieves a snapshot of the estimated counts of a
+// state's remembered successors
+func (c *SketchChain) RetrieveMarkovLink(token string) (MarkovChainLink, bool) {
+	c.sketchTex.RLock()
+	defer c.sketchTex.RUnlock()
+
+	state, ok := c.states[token]
+	if !ok {
+		countEvent(counterLookupMisses, token)
+		return nil, false
+	}
+	countEvent(counterLookupHits, token)
+
+	link := &singleTokenLink{
+		Token:                [1]string{token},
+		NextTokenOccurrences: make(map[string]int, len(state.successors)),
+	}
+	for _, next := range state.successors {
+		count := c.estimate(hashTokens([]string{token, next}))
+		link.NextTokenOccurrences[next] = count
+		link.Total += count
+	}
+	return link, true
+}
+
+func (c *SketchChain) RetrieveTokens() []string {
+	c.sketchTex.RLock()
+	defer c.sketchTex.RUnlock()
+
+	tokens := make([]string, 0, len(c.states))
+	for key := range c.states {
+		tokens = append(tokens, key)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+func (c *SketchChain) IsEmpty() bool {
+	c.sketchTex.RLock()
+	defer c.sketchTex.RUnlock()
+
+	for key, state := range c.states {
+		if key != "" || len(state.successors) != 1 || state.successors[0] != "" {
+			return false
+		}
+	}
+	return true
+}
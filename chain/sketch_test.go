@@ -0,0 +1,24 @@
+package chain
+
+import "testing"
+
+func TestSketchChainKeepsEndSuccessor(t *testing.T) {
+	sketch := NewSketchChain(SketchOptions{MaxSuccessors: 2})
+	if sketch.opts.MaxStates <= 0 {
+		t.Fatalf("states are unbounded by default")
+	}
+
+	sketch.Increment("a", "", 1)
+	sketch.Increment("a", "b", 1)
+	sketch.Increment("a", "c", 5)
+	link, ok := sketch.RetrieveMarkovLink("a")
+	if !ok {
+		t.Fatal("state missing")
+	}
+	if _, ok := link.GetProbabilityOfToken(""); !ok {
+		t.Fatal("end of sequence successor was replaced")
+	}
+	if _, ok := link.GetProbabilityOfToken("c"); !ok {
+		t.Fatal("frequent successor wasn't remembered")
+	}
+}
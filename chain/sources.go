@@ -0,0 +1,149 @@
+package chain
+
+import (
+	"context"
+	"io"
+)
+
+type concatSource struct {
+	sources []TokenSource
+}
+
+func (s *concatSource) NextToken() (string, error) {
+	return s.NextTokenContext(context.Background())
+}
+
+func (s *concatSource) NextTokenContext(ctx context.Context) (string, error) {
+	for len(s.sources) > 0 {
+		token, tokenErr := nextTokenContext(ctx, s.sources[0])
+		if tokenErr == io.EOF {
+			// close exhausted sources straight away to release their resources
+			closeErr := closeSource(s.sources[0])
+			s.sources = s.sources[1:]
+			if closeErr != nil {
+				return "", closeErr
+			}
+			continue
+		}
+		return token, tokenErr
+	}
+
+	return "", io.EOF
+}
+
+func (s *concatSource) Close() error {
+	return closeSources(s.sources)
+}
+
+// ConcatSources combines sources into a single TokenSource that reads each
+// source in turn until it is exhausted
+func ConcatSources(sources ...TokenSource) TokenSource {
+	return &concatSource{
+		sources: append([]TokenSource(nil), sources...),
+	}
+}
+
+type interleaveSource struct {
+	sources []TokenSource
+	index   int
+}
+
+func (s *interleaveSource) NextToken() (string, error) {
+	return s.NextTokenContext(context.Background())
+}
+
+func (s *interleaveSource) NextTokenContext(ctx context.Context) (string, error) {
+	for len(s.sources) > 0 {
+		if s.index >= len(s.sources) {
+			s.index = 0
+		}
+
+		token, tokenErr := nextTokenContext(ctx, s.sources[s.index])
+		if tokenErr == io.EOF {
+			// drop the exhausted source, index now refers to its successor
+			closeErr := closeSource(s.sources[s.index])
+			s.sources = append(s.sources[:s.index], s.sources[s.index+1:]...)
+			if closeErr != nil {
+				return "", closeErr
+			}
+			continue
+		}
+
+		s.index++
+		return token, tokenErr
+	}
+
+	return "", io.EOF
+}
+
+func (s *interleaveSource) Close() error {
+	return closeSources(s.sources)
+}
+
+// InterleaveSources combines sources into a single TokenSource that takes one
+// token from each source in turn, skipping sources once they are exhausted
+func InterleaveSources(sources ...TokenSource) TokenSource {
+	return &interleaveSource{
+		sources: append([]TokenSource(nil), sources...),
+	}
+}
+
+type channelSource struct {
+	tokens <-chan string
+}
+
+func (s *channelSource) NextToken() (string, error) {
+	return s.NextTokenContext(context.Background())
+}
+
+func (s *channelSource) NextTokenContext(ctx context.Context) (string, error) {
+	select {
+	case token, ok := <-s.tokens:
+		if !ok {
+			return "", io.EOF
+		}
+		return token, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// NewChannelSource creates a TokenSource that reads tokens from a channel
+// until it is closed
+func NewChannelSource(tokens <-chan string) TokenSource {
+	return &channelSource{tokens: tokens}
+}
+
+type sliceSource struct {
+	tokens []string
+	index  int
+}
+
+func (s *sliceSource) NextToken() (string, error) {
+	if s.index >= len(s.tokens) {
+		return "", io.EOF
+	}
+
+	token := s.tokens[s.index]
+	s.index++
+	return token, nil
+}
+
+// NewSliceSource creates a TokenSource that provides the tokens of a slice
+func NewSliceSource(tokens []string) TokenSource {
+	return &sliceSource{tokens: tokens}
+}
+
+// CollectTokens reads a source until it is exhausted and returns its tokens
+func CollectTokens(source TokenSource) ([]string, error) {
+	tokens := make([]string, 0)
+	for {
+		token, tokenErr := source.NextToken()
+		if tokenErr == io.EOF {
+			return tokens, nil
+		} else if tokenErr != nil {
+			return tokens, tokenErr
+		}
+		tokens = append(tokens, token)
+	}
+}
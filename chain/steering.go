@@ -0,0 +1,80 @@
+package chain
+
+// DefaultSteeringDepth is the number of steps from a keyword within which
+// WithSteering boosts transitions
+const DefaultSteeringDepth = 3
+
+// Steering boosts transitions leading towards keywords. A transition to a
+// keyword is weighted by 1+Strength, and the boost falls linearly for tokens
+// further from a keyword until it disappears at Depth steps
+type Steering struct {
+	Keywords []string
+	Strength float64
+	Depth    int
+}
+
+// distances finds how many steps each token is from reaching a keyword, by
+// searching backwards from the keywords. Tokens depth or more steps away are
+// omitted
+func (s *Steering) distances(chain IterableChain, depth int) (map[string]int, error) {
+	predecessors := make(map[string][]string)
+	iterateErr := forEachTransition(chain, func(t Transition) error {
+		predecessors[t.Next] = append(predecessors[t.Next], t.Prev)
+		return nil
+	})
+	if iterateErr != nil {
+		return nil, iterateErr
+	}
+
+	distances := make(map[string]int)
+	frontier := make([]string, 0, len(s.Keywords))
+	for _, keyword := range s.Keywords {
+		if _, ok := distances[keyword]; !ok {
+			distances[keyword] = 0
+			frontier = append(frontier, keyword)
+		}
+	}
+	for steps := 1; steps < depth && len(frontier) > 0; steps++ {
+		next := make([]string, 0)
+		for _, token := range frontier {
+			for _, prev := range predecessors[token] {
+				// the boundary token leads everywhere, so it isn't boosted
+				if _, ok := distances[prev]; !ok && prev != "" {
+					distances[prev] = steps
+					next = append(next, prev)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	return distances, nil
+}
+
+// weight creates a QueryWeightFunc applying the steering on top of base,
+// which may be nil
+func (s *Steering) weight(chain MarkovChain, base QueryWeightFunc) (QueryWeightFunc, error) {
+	iterable, ok := chain.(IterableChain)
+	if !ok {
+		return nil, ErrUncountableChain
+	}
+	depth := s.Depth
+	if depth <= 0 {
+		depth = DefaultSteeringDepth
+	}
+	distances, distanceErr := s.distances(iterable, depth)
+	if distanceErr != nil {
+		return nil, distanceErr
+	}
+
+	return func(prev string, next string, count int) float64 {
+		weight := float64(count)
+		if base != nil {
+			weight = base(prev, next, count)
+		}
+		if distance, ok := distances[next]; ok {
+			weight *= 1 + s.Strength*float64(depth-distance)/float64(depth)
+		}
+		return weight
+	}, nil
+}
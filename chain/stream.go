@@ -0,0 +1,373 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// streamMagic begins every streamed chain, the final byte is the version
+var streamMagic = []byte{'M', 'K', 'V', 'S', 1}
+
+// record types of the streaming format. Each record is prefixed by its
+// length as a uvarint, and strings and integers within a record are encoded
+// as uvarints
+const (
+	streamEnd     = 0
+	streamLink    = 1
+	streamLengths = 2
+)
+
+// StreamEncoder writes a chain one link at a time as length-prefixed
+// records, so only a single link is held in memory at once. Close must be
+// called to complete the stream
+type StreamEncoder struct {
+	w       *bufio.Writer
+	record  bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+	started bool
+}
+
+// NewStreamEncoder creates a StreamEncoder writing to w
+func NewStreamEncoder(w io.Writer) *StreamEncoder {
+	return &StreamEncoder{w: bufio.NewWriter(w)}
+}
+
+func (e *StreamEncoder) putUvarint(v uint64) {
+	n := binary.PutUvarint(e.scratch[:], v)
+	e.record.Write(e.scratch[:n])
+}
+
+func (e *StreamEncoder) putString(s string) {
+	e.putUvarint(uint64(len(s)))
+	e.record.WriteString(s)
+}
+
+// flushRecord writes the pending record with its length prefix
+func (e *StreamEncoder) flushRecord() error {
+	if !e.started {
+		if _, writeErr := e.w.Write(streamMagic); writeErr != nil {
+			return writeErr
+		}
+		e.started = true
+	}
+
+	n := binary.PutUvarint(e.scratch[:], uint64(e.record.Len()))
+	if _, writeErr := e.w.Write(e.scratch[:n]); writeErr != nil {
+		return writeErr
+	}
+	_, writeErr := e.record.WriteTo(e.w)
+	return writeErr
+}
+
+// WriteLink writes a link and its successor counts
+func (e *StreamEncoder) WriteLink(key string, link CountedLink) error {
+	successors := link.RetrieveNextTokenPossibilities()
+	e.record.Reset()
+	e.record.WriteByte(streamLink)
+	e.putString(key)
+	e.putUvarint(uint64(len(successors)))
+	for _, next := range successors {
+		count, _ := link.GetOccurrencesOfToken(next)
+		e.putString(next)
+		e.putUvarint(uint64(count))
+	}
+
+	return e.flushRecord()
+}
+
+// WriteLengths writes the distribution of training sequence lengths
+func (e *StreamEncoder) WriteLengths(lengths *LengthDistribution) error {
+	e.record.Reset()
+	e.record.WriteByte(streamLengths)
+	e.putUvarint(uint64(len(lengths.Counts)))
+	for length, count := range lengths.Counts {
+		e.putUvarint(uint64(length))
+		e.putUvarint(uint64(count))
+	}
+
+	return e.flushRecord()
+}
+
+// Close writes the end of the stream and flushes it, it does not close the
+// underlying writer
+func (e *StreamEncoder) Close() error {
+	e.record.Reset()
+	e.record.WriteByte(streamEnd)
+	if flushErr := e.flushRecord(); flushErr != nil {
+		return flushErr
+	}
+	return e.w.Flush()
+}
+
+// WriteChainStream streams every link of a chain to w
+func WriteChainStream(w io.Writer, chain MarkovChain) error {
+	encoder := NewStreamEncoder(w)
+	writeLink := func(key string, link MarkovChainLink) error {
+		counted, ok := link.(CountedLink)
+		if !ok {
+			return ErrUncountableChain
+		}
+		return encoder.WriteLink(key, counted)
+	}
+
+	if c, ok := chain.(*singleKeyChain); ok {
+		for key, link := range c.Links {
+			if writeErr := writeLink(key, link); writeErr != nil {
+				return writeErr
+			}
+		}
+	} else {
+		iterable, ok := chain.(IterableChain)
+		if !ok {
+			return ErrUncountableChain
+		}
+		for _, key := range iterable.RetrieveTokens() {
+			if link, ok := chain.RetrieveMarkovLink(key); ok {
+				if writeErr := writeLink(key, link); writeErr != nil {
+					return writeErr
+				}
+			}
+		}
+	}
+
+	if lengths, ok := chain.(LengthModel); ok && lengths.SequenceLengths() != nil {
+		if writeErr := encoder.WriteLengths(lengths.SequenceLengths()); writeErr != nil {
+			return writeErr
+		}
+	}
+
+	return encoder.Close()
+}
+
+// recordReader reads within a single record, failing rather than reading
+// into the next one
+type recordReader struct {
+	r         *bufio.Reader
+	remaining uint64
+}
+
+func (r *recordReader) ReadByte() (byte, error) {
+	if r.remaining == 0 {
+		return 0, malformed("truncated record")
+	}
+	b, readErr := r.r.ReadByte()
+	if readErr == io.EOF {
+		return 0, malformed("truncated stream")
+	} else if readErr != nil {
+		return 0, readErr
+	}
+	r.remaining--
+	return b, nil
+}
+
+func (r *recordReader) uvarint() (uint64, error) {
+	v, readErr := binary.ReadUvarint(r)
+	if readErr != nil && !errors.Is(readErr, ErrMalformedChain) {
+		return 0, malformed("invalid integer")
+	}
+	return v, readErr
+}
+
+// count reads a count that must be positive and fit in an int, matching
+// what decoding a JSON chain accepts
+func (r *recordReader) count() (int, error) {
+	v, readErr := r.uvarint()
+	if readErr != nil {
+		return 0, readErr
+	}
+	if v == 0 || v > math.MaxInt {
+		return 0, malformed("invalid count %d", v)
+	}
+	return int(v), nil
+}
+
+func (r *recordReader) token(opts DecodeOptions) (string, error) {
+	length, readErr := r.uvarint()
+	if readErr != nil {
+		return "", readErr
+	}
+	if length > r.remaining {
+		return "", malformed("token exceeds record")
+	}
+	if opts.MaxTokenLength > 0 && length > uint64(opts.MaxTokenLength) {
+		return "", malformed("token of %d bytes exceeds limit", length)
+	}
+
+	buf := make([]byte, length)
+	if _, readErr := io.ReadFull(r.r, buf); readErr != nil {
+		return "", malformed("truncated stream")
+	}
+	r.remaining -= length
+
+	token := string(buf)
+	return token, opts.checkToken(token)
+}
+
+// StreamDecoder reads a chain written by a StreamEncoder one link at a time,
+// applying the limits in its DecodeOptions
+type StreamDecoder struct {
+	r       *bufio.Reader
+	opts    DecodeOptions
+	started bool
+	done    bool
+	links   int
+	lengths *LengthDistribution
+}
+
+// NewStreamDecoder creates a StreamDecoder reading from r
+func NewStreamDecoder(r io.Reader, opts DecodeOptions) *StreamDecoder {
+	return &StreamDecoder{
+		r:       bufio.NewReader(limitReader(r, opts)),
+		opts:    opts,
+		lengths: NewLengthDistribution(),
+	}
+}
+
+// ReadLink reads the transitions of the next link, it returns io.EOF once
+// the end of the stream has been read
+func (d *StreamDecoder) ReadLink() ([]Transition, error) {
+	if !d.started {
+		magic := make([]byte, len(streamMagic))
+		if _, readErr := io.ReadFull(d.r, magic); readErr != nil || !bytes.Equal(magic, streamMagic) {
+			return nil, malformed("not a chain stream")
+		}
+		d.started = true
+	}
+
+	for !d.done {
+		length, readErr := binary.ReadUvarint(d.r)
+		if readErr != nil {
+			return nil, malformed("truncated stream")
+		}
+		record := &recordReader{r: d.r, remaining: length}
+		recordType, typeErr := record.ReadByte()
+		if typeErr != nil {
+			return nil, typeErr
+		}
+
+		var transitions []Transition
+		var parseErr error
+		switch recordType {
+		case streamEnd:
+			d.done = true
+		case streamLink:
+			transitions, parseErr = d.readLink(record)
+		case streamLengths:
+			parseErr = d.readLengths(record)
+		default:
+			parseErr = malformed("unknown record type %d", recordType)
+		}
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		if record.remaining != 0 {
+			return nil, malformed("record has %d trailing bytes", record.remaining)
+		}
+		if transitions != nil {
+			return transitions, nil
+		}
+	}
+
+	return nil, io.EOF
+}
+
+func (d *StreamDecoder) readLink(record *recordReader) ([]Transition, error) {
+	d.links++
+	if d.opts.MaxLinks > 0 && d.links > d.opts.MaxLinks {
+		return nil, malformed("%d links exceeds limit", d.links)
+	}
+
+	key, keyErr := record.token(d.opts)
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	successors, countErr := record.uvarint()
+	if countErr != nil {
+		return nil, countErr
+	}
+	if successors == 0 || successors > record.remaining {
+		return nil, malformed("link %q has an invalid number of successors", key)
+	}
+	if d.opts.MaxSuccessors > 0 && successors > uint64(d.opts.MaxSuccessors) {
+		return nil, malformed("link %q has %d successors, exceeding limit", key, successors)
+	}
+
+	transitions := make([]Transition, 0, successors)
+	total := 0
+	for i := uint64(0); i < successors; i++ {
+		next, nextErr := record.token(d.opts)
+		if nextErr != nil {
+			return nil, nextErr
+		}
+		count, countErr := record.count()
+		if countErr != nil {
+			return nil, countErr
+		}
+		if total > math.MaxInt-count {
+			return nil, malformed("link %q total overflows", key)
+		}
+		total += count
+		transitions = append(transitions, Transition{Prev: key, Next: next, Count: count})
+	}
+
+	return transitions, nil
+}
+
+func (d *StreamDecoder) readLengths(record *recordReader) error {
+	entries, readErr := record.uvarint()
+	if readErr != nil {
+		return readErr
+	}
+	if entries > record.remaining {
+		return malformed("invalid sequence length count")
+	}
+	for i := uint64(0); i < entries; i++ {
+		length, lengthErr := record.uvarint()
+		if lengthErr != nil {
+			return lengthErr
+		}
+		count, countErr := record.count()
+		if countErr != nil {
+			return countErr
+		}
+		if length > math.MaxInt || d.lengths.Total > math.MaxInt-count {
+			return malformed("invalid sequence length count")
+		}
+		d.lengths.Counts[int(length)] += count
+		d.lengths.Total += count
+	}
+
+	return nil
+}
+
+// Lengths retrieves the sequence length distribution read so far
+func (d *StreamDecoder) Lengths() *LengthDistribution {
+	return d.lengths
+}
+
+// ReadChainStream reads a streamed chain into dst one link at a time, so if
+// dst is disk backed the chain is never fully held in memory. Links that
+// appear more than once are merged
+func ReadChainStream(r io.Reader, dst WritableChain, opts DecodeOptions) error {
+	decoder := NewStreamDecoder(r, opts)
+	for {
+		transitions, readErr := decoder.ReadLink()
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			return readErr
+		}
+		if writeErr := writeTransitions(dst, transitions); writeErr != nil {
+			return writeErr
+		}
+	}
+
+	if lengths, ok := dst.(LengthModel); ok && lengths.SequenceLengths() != nil {
+		lengths.SequenceLengths().merge(decoder.Lengths())
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package chain
+
+import (
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TaggedToken is a token annotated with a tag, e.g. a part of speech
+// assigned by an upstream tagger
+type TaggedToken struct {
+	Surface string
+	Tag     string
+}
+
+func (t TaggedToken) key() string {
+	if t.Surface == "" && t.Tag == "" {
+		return ""
+	}
+	return t.Surface + keySeparator + t.Tag
+}
+
+func taggedFromKey(key string) TaggedToken {
+	if key == "" {
+		return TaggedToken{}
+	}
+	parts := strings.SplitN(key, keySeparator, 2)
+	return TaggedToken{Surface: parts[0], Tag: parts[1]}
+}
+
+// TaggedTokenSource is a TokenSource for tagged tokens, it returns io.EOF
+// once exhausted
+type TaggedTokenSource interface {
+	NextTaggedToken() (TaggedToken, error)
+}
+
+type taggedSliceSource struct {
+	tokens []TaggedToken
+}
+
+func (s *taggedSliceSource) NextTaggedToken() (TaggedToken, error) {
+	if len(s.tokens) == 0 {
+		return TaggedToken{}, io.EOF
+	}
+	token := s.tokens[0]
+	s.tokens = s.tokens[1:]
+	return token, nil
+}
+
+// NewTaggedSliceSource creates a TaggedTokenSource that provides the tokens
+// of a slice
+func NewTaggedSliceSource(tokens []TaggedToken) TaggedTokenSource {
+	return &taggedSliceSource{tokens: tokens}
+}
+
+// TaggedChain is trained on tagged tokens, its states are (surface, tag)
+// pairs so generation can be constrained to a sequence of tags while
+// emitting surface forms. The zero TaggedToken is the sequence boundary. A
+// TaggedChain is safe for concurrent use
+type TaggedChain struct {
+	chainTex sync.RWMutex
+	chain    WritableChain
+}
+
+// NewTaggedChain creates an empty TaggedChain
+func NewTaggedChain() *TaggedChain {
+	return &TaggedChain{chain: NewWritableChain()}
+}
+
+// AddSource reads the source until it is exhausted and adds its tokens to
+// the chain as a single sequence, or several if it emits a token whose
+// Surface is SegmentBreak
+func (c *TaggedChain) AddSource(source TaggedTokenSource) error {
+	keys := make([]string, 0)
+	for {
+		token, tokenErr := source.NextTaggedToken()
+		if tokenErr == io.EOF {
+			break
+		} else if tokenErr != nil {
+			return tokenErr
+		}
+		if token.Surface == SegmentBreak {
+			keys = append(keys, SegmentBreak)
+			continue
+		}
+		keys = append(keys, token.key())
+	}
+
+	c.chainTex.Lock()
+	defer c.chainTex.Unlock()
+	return addSequence(c.chain, keys)
+}
+
+// NextTagged samples the token following prev. If tag isn't empty only
+// successors with that tag are considered, renormalizing their
+// probabilities. It reports false if no successor qualifies
+func (c *TaggedChain) NextTagged(prev TaggedToken, tag string, rand *rand.Rand) (TaggedToken, bool) {
+	c.chainTex.RLock()
+	defer c.chainTex.RUnlock()
+
+	link, ok := c.chain.RetrieveMarkovLink(prev.key())
+	if !ok {
+		return TaggedToken{}, false
+	}
+	if tag == "" {
+		return taggedFromKey(link.GetNextToken(rand)), true
+	}
+
+	counted, ok := link.(CountedLink)
+	if !ok {
+		return TaggedToken{}, false
+	}
+	// sort candidates so sampling is reproducible for a given rand
+	candidates := make([]string, 0)
+	total := 0
+	for _, key := range counted.RetrieveNextTokenPossibilities() {
+		if taggedFromKey(key).Tag == tag {
+			candidates = append(candidates, key)
+			count, _ := counted.GetOccurrencesOfToken(key)
+			total += count
+		}
+	}
+	if total == 0 {
+		return TaggedToken{}, false
+	}
+	sort.Strings(candidates)
+
+	goal := rand.Intn(total)
+	for _, key := range candidates {
+		count, _ := counted.GetOccurrencesOfToken(key)
+		goal -= count
+		if goal < 0 {
+			return taggedFromKey(key), true
+		}
+	}
+	return taggedFromKey(candidates[len(candidates)-1]), true
+}
+
+// GenerateTagged generates the surface forms of a sequence whose tags match
+// the pattern, an empty tag in the pattern matches any tag. Generation ends
+// at the end of the pattern; ErrKeyNotFound is returned if the chain can't
+// produce a sequence matching it
+func (c *TaggedChain) GenerateTagged(pattern []string, rand *rand.Rand) ([]string, error) {
+	surfaces := make([]string, 0, len(pattern))
+	prev := TaggedToken{}
+	for _, tag := range pattern {
+		next, ok := c.NextTagged(prev, tag, rand)
+		if !ok || next == (TaggedToken{}) {
+			return surfaces, ErrKeyNotFound
+		}
+		surfaces = append(surfaces, next.Surface)
+		prev = next
+	}
+
+	return surfaces, nil
+}
+
+// Generate generates the surface forms of a sequence of at most maxTokens
+// tokens, without constraining tags
+func (c *TaggedChain) Generate(maxTokens int, rand *rand.Rand) ([]string, []string) {
+	surfaces := make([]string, 0)
+	tags := make([]string, 0)
+	prev := TaggedToken{}
+	for len(surfaces) < maxTokens {
+		next, ok := c.NextTagged(prev, "", rand)
+		if !ok || next == (TaggedToken{}) {
+			break
+		}
+		surfaces = append(surfaces, next.Surface)
+		tags = append(tags, next.Tag)
+		prev = next
+	}
+
+	return surfaces, tags
+}
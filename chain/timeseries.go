@@ -0,0 +1,121 @@
+package chain
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Common bucket sizes for a TimeSeriesChain
+const (
+	HourBucket = time.Hour
+	DayBucket  = 24 * time.Hour
+)
+
+// TimeSeriesChain maintains separate transition counts for each time bucket
+// so that a chain can be produced for any window of time, e.g. only last
+// week's data versus all of it
+type TimeSeriesChain struct {
+	bucketSize time.Duration
+	bucketTex  sync.RWMutex
+	buckets    map[int64]*singleKeyChain
+}
+
+// NewTimeSeriesChain creates an empty TimeSeriesChain that groups its counts
+// into buckets of the specified size
+func NewTimeSeriesChain(bucketSize time.Duration) *TimeSeriesChain {
+	if bucketSize <= 0 {
+		bucketSize = HourBucket
+	}
+
+	return &TimeSeriesChain{
+		bucketSize: bucketSize,
+		buckets:    make(map[int64]*singleKeyChain),
+	}
+}
+
+func (c *TimeSeriesChain) bucketKey(at time.Time) int64 {
+	return at.Truncate(c.bucketSize).Unix()
+}
+
+// AddSource reads the source until it is exhausted and records its tokens in
+// the bucket containing the specified time
+func (c *TimeSeriesChain) AddSource(at time.Time, source TokenSource) error {
+	built, buildErr := buildChainFromSource(source)
+	if buildErr != nil {
+		return buildErr
+	}
+
+	key := c.bucketKey(at)
+
+	c.bucketTex.Lock()
+	defer c.bucketTex.Unlock()
+	if bucket, ok := c.buckets[key]; !ok {
+		c.buckets[key] = built
+	} else {
+		bucket.mergeFrom(built)
+	}
+
+	return nil
+}
+
+// ChainForRange merges all buckets overlapping the half-open range [from, to)
+// into a single MarkovChain
+func (c *TimeSeriesChain) ChainForRange(from time.Time, to time.Time) MarkovChain {
+	start := c.bucketKey(from)
+	end := to.Unix()
+
+	c.bucketTex.RLock()
+	defer c.bucketTex.RUnlock()
+	selected := make([]*singleKeyChain, 0, len(c.buckets))
+	for key, bucket := range c.buckets {
+		if key >= start && key < end {
+			selected = append(selected, bucket)
+		}
+	}
+
+	return mergeChains(selected...)
+}
+
+// Chain merges every bucket into a single MarkovChain covering all time
+func (c *TimeSeriesChain) Chain() MarkovChain {
+	c.bucketTex.RLock()
+	defer c.bucketTex.RUnlock()
+	selected := make([]*singleKeyChain, 0, len(c.buckets))
+	for _, bucket := range c.buckets {
+		selected = append(selected, bucket)
+	}
+
+	return mergeChains(selected...)
+}
+
+// Buckets returns the start times of all buckets that contain data, in
+// ascending order
+func (c *TimeSeriesChain) Buckets() []time.Time {
+	c.bucketTex.RLock()
+	keys := make([]int64, 0, len(c.buckets))
+	for key := range c.buckets {
+		keys = append(keys, key)
+	}
+	c.bucketTex.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	times := make([]time.Time, 0, len(keys))
+	for _, key := range keys {
+		times = append(times, time.Unix(key, 0))
+	}
+
+	return times
+}
+
+// PruneBefore discards all buckets that end at or before the specified time
+func (c *TimeSeriesChain) PruneBefore(before time.Time) {
+	c.bucketTex.Lock()
+	defer c.bucketTex.Unlock()
+	for key := range c.buckets {
+		if time.Unix(key, 0).Add(c.bucketSize).After(before) {
+			continue
+		}
+		delete(c.buckets, key)
+	}
+}
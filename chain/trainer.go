@@ -0,0 +1,82 @@
+package chain
+
+// Trainer incrementally adds sequences of tokens to a chain, e.g. as they
+// arrive in a long running service
+type Trainer interface {
+	// Train adds a sequence of tokens to the chain, or several if it
+	// includes SegmentBreak, empty sequences are ignored
+	Train(tokens []string) error
+}
+
+type chainTrainer struct {
+	chain WritableChain
+}
+
+// MakeTrainer creates a Trainer that adds sequences directly to a chain, or
+// retrieves the chain itself if it is already a Trainer, such as a
+// MultiKeyChain. It is only safe for concurrent use if the chain is
+func MakeTrainer(chain WritableChain) Trainer {
+	if trainer, ok := chain.(Trainer); ok {
+		return trainer
+	}
+	return &chainTrainer{chain: chain}
+}
+
+func (t *chainTrainer) Train(tokens []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	countEvent(counterTrainerAdds, tokens[0])
+	if addErr := addSequence(t.chain, tokens); addErr != nil {
+		return addErr
+	}
+
+	// the lengths are observed here rather than by addSequence, as
+	// NamespacedChain observes its own
+	if model, ok := t.chain.(LengthModel); ok && model.SequenceLengths() != nil {
+		for _, segment := range splitSegments(tokens) {
+			if len(segment) > 0 {
+				model.SequenceLengths().Observe(len(segment))
+			}
+		}
+	}
+	return nil
+}
+
+// lastToken is the key of the link following history in a chain keyed on
+// a single token
+func lastToken(history []string) string {
+	if len(history) == 0 {
+		return ""
+	}
+	return history[len(history)-1]
+}
+
+// addSequence adds a sequence to a chain, bounded by the empty token, or
+// several if it includes SegmentBreak. Empty sequences are skipped. Links
+// are keyed with HistoryChain.Key if the chain is keyed on more than one
+// token
+func addSequence(chain WritableChain, tokens []string) error {
+	key := lastToken
+	if historyChain, ok := chain.(HistoryChain); ok {
+		key = historyChain.Key
+	}
+
+	for _, segment := range splitSegments(tokens) {
+		if len(segment) == 0 {
+			continue
+		}
+
+		for i, token := range segment {
+			if incErr := chain.Increment(key(segment[:i]), token, 1); incErr != nil {
+				return incErr
+			}
+		}
+		if incErr := chain.Increment(key(segment), "", 1); incErr != nil {
+			return incErr
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package chain
+
+import (
+	"math/rand"
+	"sync"
+)
+
+type transitionKey struct {
+	prev string
+	next string
+}
+
+// Transaction groups changes to a chain so they can be undone together,
+// e.g. so a malformed file doesn't leave a chain half updated. Changes are
+// applied to the chain as they are made, with the original count of each
+// transition recorded so Rollback can restore it. Readers of the chain see
+// changes before they are committed, and changes made to the same
+// transitions outside the transaction are overwritten by Rollback. A
+// Transaction is safe for concurrent use if its chain is
+type Transaction struct {
+	chain    WritableChain
+	txTex    sync.Mutex
+	original map[transitionKey]int
+	order    []transitionKey
+	done     bool
+}
+
+// Begin starts a Transaction on a chain, whose links must be CountedLinks
+func Begin(chain WritableChain) *Transaction {
+	return &Transaction{
+		chain:    chain,
+		original: make(map[transitionKey]int),
+		order:    make([]transitionKey, 0),
+	}
+}
+
+// record remembers the count of a transition before it is first changed.
+// The caller must hold txTex
+func (t *Transaction) record(prev string, next string) error {
+	if t.done {
+		return ErrTransactionDone
+	}
+	key := transitionKey{prev: prev, next: next}
+	if _, ok := t.original[key]; ok {
+		return nil
+	}
+
+	count, countErr := occurrencesIn(t.chain, prev, next)
+	if countErr != nil {
+		return countErr
+	}
+	t.original[key] = count
+	t.order = append(t.order, key)
+	return nil
+}
+
+func (t *Transaction) CalculateNextToken(token string, rand *rand.Rand) (string, bool) {
+	return t.chain.CalculateNextToken(token, rand)
+}
+
+func (t *Transaction) RetrieveMarkovLink(token string) (MarkovChainLink, bool) {
+	return t.chain.RetrieveMarkovLink(token)
+}
+
+func (t *Transaction) RetrieveTokens() []string {
+	return t.chain.RetrieveTokens()
+}
+
+func (t *Transaction) IsEmpty() bool {
+	return t.chain.IsEmpty()
+}
+
+func (t *Transaction) Increment(prev string, next string, n int) error {
+	t.txTex.Lock()
+	defer t.txTex.Unlock()
+	if recordErr := t.record(prev, next); recordErr != nil {
+		return recordErr
+	}
+	return t.chain.Increment(prev, next, n)
+}
+
+func (t *Transaction) RemoveSuccessor(prev string, next string) error {
+	t.txTex.Lock()
+	defer t.txTex.Unlock()
+	if recordErr := t.record(prev, next); recordErr != nil {
+		return recordErr
+	}
+	return t.chain.RemoveSuccessor(prev, next)
+}
+
+func (t *Transaction) SetCount(prev string, next string, n int) error {
+	t.txTex.Lock()
+	defer t.txTex.Unlock()
+	if recordErr := t.record(prev, next); recordErr != nil {
+		return recordErr
+	}
+	return t.chain.SetCount(prev, next, n)
+}
+
+// Commit keeps the changes made in the transaction
+func (t *Transaction) Commit() error {
+	t.txTex.Lock()
+	defer t.txTex.Unlock()
+	if t.done {
+		return ErrTransactionDone
+	}
+
+	t.done = true
+	t.original = nil
+	t.order = nil
+	return nil
+}
+
+// Rollback restores every transition changed in the transaction to its
+// original count
+func (t *Transaction) Rollback() error {
+	t.txTex.Lock()
+	defer t.txTex.Unlock()
+	if t.done {
+		return ErrTransactionDone
+	}
+
+	t.done = true
+	for _, key := range t.order {
+		if setErr := t.chain.SetCount(key.prev, key.next, t.original[key]); setErr != nil {
+			return setErr
+		}
+	}
+	t.original = nil
+	t.order = nil
+	return nil
+}
+
+// Update runs fn in a Transaction on chain, committing if fn succeeds and
+// rolling back if it returns an error or panics
+func Update(chain WritableChain, fn func(tx WritableChain) error) error {
+	tx := Begin(chain)
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			tx.Rollback()
+			panic(recovered)
+		}
+	}()
+
+	if fnErr := fn(tx); fnErr != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return fnErr
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,316 @@
+package chain
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+)
+
+// packedChain is a read only chain held in flat arrays rather than maps.
+// States are indexed by their position in the vocabulary, with the boundary
+// at index zero, and the successors of state i are next[start[i]:start[i+1]],
+// sorted by index, with running totals of their counts in cumulative
+type packedChain struct {
+	vocabulary []string
+	index      map[string]int32
+	start      []int
+	next       []int32
+	cumulative []int
+	lengths    *LengthDistribution
+}
+
+// packedLink is a state of a packedChain
+type packedLink struct {
+	chain *packedChain
+	state int32
+}
+
+// twoPassVocabulary is gathered by the first pass of a two pass build
+type twoPassVocabulary struct {
+	vocabulary []string
+	index      map[string]int32
+	// pairs holds each distinct transition, keyed by the index of its
+	// state shifted above the index of its successor
+	pairs map[uint64]struct{}
+	// transitions counts every transition, including repeats
+	transitions int
+	lengths     *LengthDistribution
+}
+
+// intern retrieves the index of a token, adding it to the vocabulary if new
+func (v *twoPassVocabulary) intern(token string) int32 {
+	id, ok := v.index[token]
+	if !ok {
+		id = int32(len(v.vocabulary))
+		v.index[token] = id
+		v.vocabulary = append(v.vocabulary, token)
+	}
+	return id
+}
+
+// forEachTwoPassSequence tokenizes a reader from its start and calls add
+// with the index of every transition's states, one sequence per reader or
+// per SegmentBreak, and end with each sequence's length. The source is left
+// open so the reader can be read again
+func forEachTwoPassSequence(r io.ReadSeeker, tokenizer Tokenizer, lookup func(token string) (int32, error), add func(prev int32, next int32) error, end func(length int)) error {
+	if _, seekErr := r.Seek(0, io.SeekStart); seekErr != nil {
+		return seekErr
+	}
+	source := tokenizer(r)
+
+	prev, length := int32(0), 0
+	for {
+		token, readErr := source.NextToken()
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if readErr == io.EOF || token == SegmentBreak {
+			// empty sequences add nothing to the chain
+			if length > 0 {
+				if addErr := add(prev, 0); addErr != nil {
+					return addErr
+				}
+				end(length)
+			}
+			if readErr == io.EOF {
+				return nil
+			}
+			prev, length = 0, 0
+			continue
+		}
+
+		token, ok := BuildOptions{}.emptyToken(token)
+		if !ok {
+			continue
+		}
+		next, lookupErr := lookup(token)
+		if lookupErr != nil {
+			return lookupErr
+		}
+		if addErr := add(prev, next); addErr != nil {
+			return addErr
+		}
+		prev = next
+		length++
+	}
+}
+
+// BuildChainTwoPass builds a chain from seekable readers in two passes,
+// using much less memory at its peak than building incrementally. The first
+// pass gathers the vocabulary and the distinct transitions, which are laid
+// out in flat arrays, and the second counts them. tokenizer splits each
+// reader into tokens, defaulting to WordSource, and is applied once per
+// pass, so a reader whose content changes between passes fails the build.
+// The readers are closed once read if they implement io.Closer.
+//
+// Each reader is read as a single sequence, or several if its tokens
+// include SegmentBreak. The chain is read only and samples by binary search
+func BuildChainTwoPass(tokenizer Tokenizer, readers ...io.ReadSeeker) (IterableChain, error) {
+	if tokenizer == nil {
+		tokenizer = WordSource
+	}
+	defer func() {
+		for _, r := range readers {
+			if closer, ok := r.(io.Closer); ok {
+				closer.Close()
+			}
+		}
+	}()
+
+	vocab := &twoPassVocabulary{
+		vocabulary: []string{""},
+		index:      map[string]int32{"": 0},
+		pairs:      make(map[uint64]struct{}),
+		lengths:    NewLengthDistribution(),
+	}
+	for i, r := range readers {
+		readErr := forEachTwoPassSequence(r, tokenizer, func(token string) (int32, error) {
+			return vocab.intern(token), nil
+		}, func(prev int32, next int32) error {
+			vocab.pairs[uint64(prev)<<32|uint64(uint32(next))] = struct{}{}
+			vocab.transitions++
+			return nil
+		}, vocab.lengths.Observe)
+		if readErr != nil {
+			return nil, &SourceError{SourceIndex: i, Err: readErr}
+		}
+	}
+
+	// the second pass counts into cumulative, which is then summed in place
+	packed := packPairs(vocab)
+	counts := packed.cumulative
+	transitions := 0
+	for i, r := range readers {
+		readErr := forEachTwoPassSequence(r, tokenizer, func(token string) (int32, error) {
+			id, ok := vocab.index[token]
+			if !ok {
+				return 0, fmt.Errorf("chain: source %d changed between passes", i)
+			}
+			return id, nil
+		}, func(prev int32, next int32) error {
+			successor, ok := packed.successor(prev, next)
+			if !ok {
+				return fmt.Errorf("chain: source %d changed between passes", i)
+			}
+			counts[successor]++
+			transitions++
+			return nil
+		}, func(int) {})
+		if readErr != nil {
+			return nil, &SourceError{SourceIndex: i, Err: readErr}
+		}
+	}
+	if transitions != vocab.transitions {
+		return nil, fmt.Errorf("chain: sources changed between passes")
+	}
+
+	for state := range packed.vocabulary {
+		total := 0
+		for successor := packed.start[state]; successor < packed.start[state+1]; successor++ {
+			if counts[successor] == 0 {
+				return nil, fmt.Errorf("chain: sources changed between passes")
+			}
+			total += counts[successor]
+			packed.cumulative[successor] = total
+		}
+	}
+	return packed, nil
+}
+
+// packPairs lays out the distinct transitions found by the first pass, so
+// the arrays are sized by the packed chain rather than the corpus. The
+// pairs are released once laid out, the counts are filled by the second
+// pass
+func packPairs(vocab *twoPassVocabulary) *packedChain {
+	keys := make([]uint64, 0, len(vocab.pairs))
+	for key := range vocab.pairs {
+		keys = append(keys, key)
+	}
+	vocab.pairs = nil
+	// sorting by state then successor groups each state's successors
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	packed := &packedChain{
+		vocabulary: vocab.vocabulary,
+		index:      vocab.index,
+		start:      make([]int, len(vocab.vocabulary)+1),
+		next:       make([]int32, len(keys)),
+		cumulative: make([]int, len(keys)),
+		lengths:    vocab.lengths,
+	}
+	for i, key := range keys {
+		packed.next[i] = int32(uint32(key))
+		packed.start[key>>32+1]++
+	}
+	for state := range vocab.vocabulary {
+		packed.start[state+1] += packed.start[state]
+	}
+	return packed
+}
+
+// successor finds the position of a transition in the chain's arrays
+func (c *packedChain) successor(state int32, next int32) (int, bool) {
+	from, to := c.start[state], c.start[state+1]
+	i := from + sort.Search(to-from, func(i int) bool { return c.next[from+i] >= next })
+	return i, i < to && c.next[i] == next
+}
+
+// link looks up the state of a token, which must have successors
+func (c *packedChain) link(token string) (*packedLink, bool) {
+	state, ok := c.index[token]
+	if !ok || c.start[state] == c.start[state+1] {
+		return nil, false
+	}
+	return &packedLink{chain: c, state: state}, true
+}
+
+func (c *packedChain) CalculateNextToken(token string, rand *rand.Rand) (string, bool) {
+	link, ok := c.link(token)
+	if !ok {
+		countEvent(counterLookupMisses, token)
+		return "", false
+	}
+	countEvent(counterLookupHits, token)
+	return link.GetNextToken(rand), true
+}
+
+func (c *packedChain) RetrieveMarkovLink(token string) (MarkovChainLink, bool) {
+	link, ok := c.link(token)
+	if !ok {
+		countEvent(counterLookupMisses, token)
+		return nil, false
+	}
+	countEvent(counterLookupHits, token)
+	return link, true
+}
+
+func (c *packedChain) RetrieveTokens() []string {
+	tokens := make([]string, 0, len(c.vocabulary))
+	for state, token := range c.vocabulary {
+		if c.start[state] != c.start[state+1] {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func (c *packedChain) IsEmpty() bool {
+	return len(c.next) == 0
+}
+
+func (c *packedChain) SequenceLengths() *LengthDistribution {
+	return c.lengths
+}
+
+// successors retrieves the region of the arrays holding the link's
+// successors
+func (l *packedLink) successors() ([]int32, []int) {
+	from, to := l.chain.start[l.state], l.chain.start[l.state+1]
+	return l.chain.next[from:to], l.chain.cumulative[from:to]
+}
+
+func (l *packedLink) GetNextToken(rand *rand.Rand) string {
+	countEvent(counterSamplesDrawn, l.chain.vocabulary[l.state])
+	next, cumulative := l.successors()
+	goal := rand.Intn(cumulative[len(cumulative)-1])
+	i := sort.Search(len(cumulative), func(i int) bool { return cumulative[i] > goal })
+	return l.chain.vocabulary[next[i]]
+}
+
+func (l *packedLink) RetrieveNextTokenPossibilities() []string {
+	next, _ := l.successors()
+	tokens := make([]string, 0, len(next))
+	for _, id := range next {
+		tokens = append(tokens, l.chain.vocabulary[id])
+	}
+	return tokens
+}
+
+func (l *packedLink) GetOccurrencesOfToken(nextToken string) (int, bool) {
+	id, ok := l.chain.index[nextToken]
+	if !ok {
+		return 0, false
+	}
+	i, ok := l.chain.successor(l.state, id)
+	if !ok {
+		return 0, false
+	}
+	if i == l.chain.start[l.state] {
+		return l.chain.cumulative[i], true
+	}
+	return l.chain.cumulative[i] - l.chain.cumulative[i-1], true
+}
+
+func (l *packedLink) GetTotalOccurrences() int {
+	_, cumulative := l.successors()
+	return cumulative[len(cumulative)-1]
+}
+
+func (l *packedLink) GetProbabilityOfToken(nextToken string) (float64, bool) {
+	occurrences, ok := l.GetOccurrencesOfToken(nextToken)
+	if !ok {
+		return 0, false
+	}
+	return float64(occurrences) / float64(l.GetTotalOccurrences()), true
+}
@@ -0,0 +1,72 @@
+package chain
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildChainTwoPass(t *testing.T) {
+	texts := []string{"the cat sat on the mat and the cat ran", "a dog sat on the cat", ""}
+	readers := make([]io.ReadSeeker, 0, len(texts))
+	for _, text := range texts {
+		readers = append(readers, strings.NewReader(text))
+	}
+
+	built, buildErr := BuildChainTwoPass(nil, readers...)
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	want := testChain(t)
+	assertSameChain(t, want, built)
+
+	// the arrays hold one entry per distinct transition, not per token
+	distinct := 0
+	for _, link := range want.Links {
+		distinct += len(link.NextTokenOccurrences)
+	}
+	if packed := built.(*packedChain); len(packed.next) != distinct || len(packed.cumulative) != distinct {
+		t.Fatalf("packed %d transitions, want %d", len(packed.next), distinct)
+	}
+}
+
+func TestBuildChainTwoPassSegments(t *testing.T) {
+	sentences := func(r io.Reader) TokenSource {
+		return SegmentedSource(WordSource(r), SentenceEnd)
+	}
+	built, buildErr := BuildChainTwoPass(sentences, strings.NewReader("a b. c d."))
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	starts, ok := built.RetrieveMarkovLink("")
+	if !ok || len(starts.RetrieveNextTokenPossibilities()) != 2 {
+		t.Fatal("each sentence should start a sequence")
+	}
+	if lengths := built.(LengthModel).SequenceLengths(); lengths.Total != 2 {
+		t.Fatalf("got %d sequences, want 2", lengths.Total)
+	}
+}
+
+func TestBuildChainTwoPassChangedSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus")
+	if writeErr := os.WriteFile(path, []byte("p q r"), 0o644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	file, openErr := os.Open(path)
+	if openErr != nil {
+		t.Fatal(openErr)
+	}
+
+	passes := 0
+	changing := func(r io.Reader) TokenSource {
+		if passes++; passes == 2 {
+			os.WriteFile(path, []byte("p q r r"), 0o644)
+		}
+		return WordSource(r)
+	}
+	if _, buildErr := BuildChainTwoPass(changing, file); buildErr == nil {
+		t.Fatal("a source changing between passes should fail the build")
+	}
+}
@@ -0,0 +1,89 @@
+package chain
+
+import "math/rand"
+
+// unionChain answers lookups by combining its chains as they are made
+type unionChain struct {
+	chains []Weighted
+}
+
+// UnionChain creates a read only chain that behaves as if chains had been
+// merged with MergeWeighted, without copying them. Each lookup combines the
+// links of every chain, so it costs more than a lookup in a merged chain but
+// no memory is spent on the merged transitions. Links that don't expose
+// their counts as CountedLinks are ignored. Tokens can only be enumerated
+// from chains that are IterableChains. Changes to the underlying chains are
+// visible immediately, and the chain is only as safe for concurrent use as
+// they are
+func UnionChain(chains []Weighted) MarkovChain {
+	return &unionChain{chains: append([]Weighted(nil), chains...)}
+}
+
+func (c *unionChain) CalculateNextToken(token string, rand *rand.Rand) (string, bool) {
+	link, ok := c.RetrieveMarkovLink(token)
+	if !ok {
+		return "", false
+	}
+	return link.GetNextToken(rand), true
+}
+
+func (c *unionChain) RetrieveMarkovLink(token string) (MarkovChainLink, bool) {
+	combined := &singleTokenLink{
+		Token:                [1]string{token},
+		NextTokenOccurrences: make(map[string]int),
+	}
+	for _, weighted := range c.chains {
+		link, ok := weighted.Chain.RetrieveMarkovLink(token)
+		if !ok {
+			continue
+		}
+		counted, ok := link.(CountedLink)
+		if !ok {
+			continue
+		}
+		for _, next := range counted.RetrieveNextTokenPossibilities() {
+			count, _ := counted.GetOccurrencesOfToken(next)
+			if scaled := scaleCount(count, weighted.Weight); scaled > 0 {
+				combined.NextTokenOccurrences[next] += scaled
+				combined.Total += scaled
+			}
+		}
+	}
+	if combined.Total == 0 {
+		return nil, false
+	}
+
+	return combined, true
+}
+
+func (c *unionChain) RetrieveTokens() []string {
+	seen := make(map[string]bool)
+	tokens := make([]string, 0)
+	for _, weighted := range c.chains {
+		iterable, ok := weighted.Chain.(IterableChain)
+		if !ok {
+			continue
+		}
+		for _, token := range iterable.RetrieveTokens() {
+			if seen[token] {
+				continue
+			}
+			seen[token] = true
+			// tokens whose counts all scale to zero have no link
+			if _, ok := c.RetrieveMarkovLink(token); ok {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	return tokens
+}
+
+func (c *unionChain) IsEmpty() bool {
+	for _, weighted := range c.chains {
+		if weighted.Weight > 0 && !IsEmpty(weighted.Chain) {
+			return false
+		}
+	}
+	return true
+}
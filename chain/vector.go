@@ -0,0 +1,18 @@
+package chain
+
+// DistributionVector retrieves the probability of each token of vocab
+// following token, aligned to vocab so it can be fed to models that
+// consume dense vectors. Successors missing from vocab are omitted, so the
+// vector needn't sum to one. Returns false if token has no link
+func DistributionVector(chain MarkovChain, token string, vocab []string) ([]float64, bool) {
+	link, ok := chain.RetrieveMarkovLink(token)
+	if !ok {
+		return nil, false
+	}
+
+	vector := make([]float64, len(vocab))
+	for i, next := range vocab {
+		vector[i], _ = link.GetProbabilityOfToken(next)
+	}
+	return vector, true
+}
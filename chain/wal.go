@@ -0,0 +1,138 @@
+package chain
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// TrainerLogOptions configures a TrainerLog
+type TrainerLogOptions struct {
+	// Sync flushes every record to stable storage before it is applied, so
+	// sequences survive power loss as well as crashes at the cost of
+	// throughput
+	Sync bool
+}
+
+// TrainerLog is a write-ahead log of the sequences given to a Trainer, so
+// sequences trained since the chain was last saved can be replayed after a
+// crash. Each record is a line holding a JSON array of tokens. A
+// TrainerLog is safe for concurrent use
+type TrainerLog struct {
+	opts   TrainerLogOptions
+	logTex sync.Mutex
+	file   *os.File
+}
+
+// OpenTrainerLog opens the log at path for appending, creating it if it
+// doesn't exist
+func OpenTrainerLog(path string, opts TrainerLogOptions) (*TrainerLog, error) {
+	file, openErr := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if openErr != nil {
+		return nil, openErr
+	}
+	return &TrainerLog{opts: opts, file: file}, nil
+}
+
+// Append records a sequence, empty sequences are ignored
+func (l *TrainerLog) Append(tokens []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	record, marshalErr := json.Marshal(tokens)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	record = append(record, '\n')
+
+	l.logTex.Lock()
+	defer l.logTex.Unlock()
+	if _, writeErr := l.file.Write(record); writeErr != nil {
+		return writeErr
+	}
+	if l.opts.Sync {
+		return l.file.Sync()
+	}
+	return nil
+}
+
+// Replay trains every sequence in the log, e.g. on startup after loading
+// the last saved chain, and returns how many were replayed. A record left
+// incomplete by a crash is discarded. ErrMalformedChain is returned if a
+// complete record can't be decoded
+func (l *TrainerLog) Replay(trainer Trainer) (int, error) {
+	l.logTex.Lock()
+	defer l.logTex.Unlock()
+
+	if _, seekErr := l.file.Seek(0, io.SeekStart); seekErr != nil {
+		return 0, seekErr
+	}
+	reader := bufio.NewReader(l.file)
+	replayed := 0
+	var offset int64
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr == io.EOF {
+			if len(line) > 0 {
+				// the last write was interrupted, drop it so later records
+				// aren't appended to it
+				return replayed, l.file.Truncate(offset)
+			}
+			return replayed, nil
+		} else if readErr != nil {
+			return replayed, readErr
+		}
+
+		tokens := make([]string, 0)
+		if unmarshalErr := json.Unmarshal(line, &tokens); unmarshalErr != nil {
+			return replayed, malformed("trainer log record at offset %d: %v", offset, unmarshalErr)
+		}
+		if trainErr := trainer.Train(tokens); trainErr != nil {
+			return replayed, trainErr
+		}
+		offset += int64(len(line))
+		replayed++
+	}
+}
+
+// Truncate discards every record, it should be called once the chain the
+// records were trained into has been saved
+func (l *TrainerLog) Truncate() error {
+	l.logTex.Lock()
+	defer l.logTex.Unlock()
+
+	if truncateErr := l.file.Truncate(0); truncateErr != nil {
+		return truncateErr
+	}
+	if l.opts.Sync {
+		return l.file.Sync()
+	}
+	return nil
+}
+
+// Close closes the log file
+func (l *TrainerLog) Close() error {
+	return l.file.Close()
+}
+
+type loggedTrainer struct {
+	trainer Trainer
+	log     *TrainerLog
+}
+
+// MakeLoggedTrainer creates a Trainer that appends each sequence to log
+// before passing it to trainer, so it isn't lost if the process dies before
+// the chain is saved
+func MakeLoggedTrainer(trainer Trainer, log *TrainerLog) Trainer {
+	return &loggedTrainer{trainer: trainer, log: log}
+}
+
+func (t *loggedTrainer) Train(tokens []string) error {
+	if appendErr := t.log.Append(tokens); appendErr != nil {
+		return appendErr
+	}
+	return t.trainer.Train(tokens)
+}
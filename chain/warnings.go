@@ -0,0 +1,161 @@
+package chain
+
+import (
+	"fmt"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WarningKind classifies a BuildWarning
+type WarningKind string
+
+const (
+	// WarningEmptySource is reported for a source that provided no tokens
+	WarningEmptySource WarningKind = "empty_source"
+
+	// WarningLongToken is reported the first time a source provides a token
+	// longer than LongTokenThreshold bytes
+	WarningLongToken WarningKind = "long_token"
+
+	// WarningBinaryInput is reported the first time a source provides a
+	// token that isn't valid UTF-8 or contains control characters
+	WarningBinaryInput WarningKind = "binary_input"
+
+	// WarningDominantToken is reported for a token making up more than half
+	// of all the tokens in a build
+	WarningDominantToken WarningKind = "dominant_token"
+)
+
+// minDominanceTokens is the number of tokens a build needs before a
+// dominant token is reported, as short builds are easily dominated
+const minDominanceTokens = 20
+
+// LongTokenThreshold is the length in bytes above which a token is reported
+// as suspiciously long
+const LongTokenThreshold = 256
+
+// looksBinary reports whether a token is likely to be binary data rather
+// than text
+func looksBinary(token string) bool {
+	if !utf8.ValidString(token) {
+		return true
+	}
+	for _, r := range token {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return true
+		}
+	}
+	return false
+}
+
+type sourceWarnings struct {
+	tokens       int64
+	longWarned   bool
+	binaryWarned bool
+}
+
+// warningTracker checks tokens for suspicious data as they are read, a nil
+// tracker checks nothing. Each source must only be checked by one goroutine
+type warningTracker struct {
+	callback   func(BuildWarning)
+	sources    []sourceWarnings
+	warningTex sync.Mutex
+	warnings   []BuildWarning
+}
+
+func newWarningTracker(callback func(BuildWarning), report *BuildReport, sources int) *warningTracker {
+	if callback == nil && report == nil {
+		return nil
+	}
+
+	return &warningTracker{
+		callback: callback,
+		sources:  make([]sourceWarnings, sources),
+		warnings: make([]BuildWarning, 0),
+	}
+}
+
+func (t *warningTracker) warn(warning BuildWarning) {
+	t.warningTex.Lock()
+	defer t.warningTex.Unlock()
+	t.warnings = append(t.warnings, warning)
+	if t.callback != nil {
+		t.callback(warning)
+	}
+}
+
+// token checks a token read from a source
+func (t *warningTracker) token(source int, token string) {
+	if t == nil {
+		return
+	}
+
+	state := &t.sources[source]
+	state.tokens++
+	if !state.longWarned && len(token) > LongTokenThreshold {
+		state.longWarned = true
+		t.warn(BuildWarning{
+			SourceIndex: source,
+			Kind:        WarningLongToken,
+			Message:     fmt.Sprintf("token of %d bytes", len(token)),
+		})
+	}
+	if !state.binaryWarned && looksBinary(token) {
+		state.binaryWarned = true
+		t.warn(BuildWarning{
+			SourceIndex: source,
+			Kind:        WarningBinaryInput,
+			Message:     fmt.Sprintf("token %q looks like binary data", truncateToken(token)),
+		})
+	}
+}
+
+func truncateToken(token string) string {
+	if len(token) > 32 {
+		return token[:32] + "..."
+	}
+	return token
+}
+
+// sourceDone checks a source once it is exhausted
+func (t *warningTracker) sourceDone(source int) {
+	if t == nil {
+		return
+	}
+
+	if t.sources[source].tokens == 0 {
+		t.warn(BuildWarning{SourceIndex: source, Kind: WarningEmptySource, Message: "no tokens were read"})
+	}
+}
+
+// finish checks the built chain and returns every warning reported
+func (t *warningTracker) finish(chain *singleKeyChain) []BuildWarning {
+	if t == nil {
+		return nil
+	}
+
+	occurrences := make(map[string]int)
+	total := 0
+	for _, link := range chain.Links {
+		for next, count := range link.NextTokenOccurrences {
+			if next != "" {
+				occurrences[next] += count
+				total += count
+			}
+		}
+	}
+	for token, count := range occurrences {
+		if total >= minDominanceTokens && count*2 > total {
+			t.warn(BuildWarning{
+				SourceIndex: -1,
+				Kind:        WarningDominantToken,
+				Message:     fmt.Sprintf("token %q makes up %.0f%% of all tokens", truncateToken(token), 100*float64(count)/float64(total)),
+			})
+		}
+	}
+
+	t.warningTex.Lock()
+	defer t.warningTex.Unlock()
+	return append([]BuildWarning(nil), t.warnings...)
+}